@@ -0,0 +1,251 @@
+// Package api provides the HTTP API used to read notifications, to manage system-wide broadcasts, to manage
+// scheduled notification deliveries, to manage notification templates and payload schemas, to manage notification
+// types and quarantined events, and to manage suppressed email addresses.
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/catalog"
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/event-recorder/payloads"
+	"github.com/cyverse-de/event-recorder/ratelimit"
+	"github.com/cyverse-de/event-recorder/templates"
+	"github.com/cyverse-de/event-recorder/unsubscribe"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "api"})
+
+// API provides the HTTP handlers for the event recorder API.
+type API struct {
+	db          *sql.DB
+	limiter     *ratelimit.Limiter
+	templates   *templates.Renderer
+	unsubscribe *unsubscribe.Signer
+	payloads    *payloads.Validator
+	types       *catalog.Catalog
+	audit       audit.Sink
+	readOnly    bool
+}
+
+// Option represents an optional setting for the API.
+type Option func(*API)
+
+// WithRateLimiter sets the rate limiter whose statistics are reported by the API.
+func WithRateLimiter(limiter *ratelimit.Limiter) Option {
+	return func(a *API) {
+		a.limiter = limiter
+	}
+}
+
+// WithTemplates sets the renderer used to preview stored notification templates. The renderer is reloaded whenever
+// the stored templates are changed through the API.
+func WithTemplates(renderer *templates.Renderer) Option {
+	return func(a *API) {
+		a.templates = renderer
+	}
+}
+
+// WithUnsubscribe sets the signer used to verify the tokens in one-click unsubscribe links.
+func WithUnsubscribe(signer *unsubscribe.Signer) Option {
+	return func(a *API) {
+		a.unsubscribe = signer
+	}
+}
+
+// WithPayloadValidator sets the validator used to check sample payloads. The validator is reloaded whenever the
+// stored payload schemas are changed through the API.
+func WithPayloadValidator(validator *payloads.Validator) Option {
+	return func(a *API) {
+		a.payloads = validator
+	}
+}
+
+// WithNotificationTypes sets the notification type catalog. The catalog is reloaded whenever the stored notification
+// types are changed through the API.
+func WithNotificationTypes(types *catalog.Catalog) Option {
+	return func(a *API) {
+		a.types = types
+	}
+}
+
+// WithAuditSink sets the sink that receives a record of each notification deleted through the API once the deletion
+// has been committed.
+func WithAuditSink(sink audit.Sink) Option {
+	return func(a *API) {
+		a.audit = sink
+	}
+}
+
+// WithReadOnly restricts the API to endpoints that use the GET method, so that it can't be used to modify the
+// database. It's used in shadow mode, where the database is the primary instance's database.
+func WithReadOnly() Option {
+	return func(a *API) {
+		a.readOnly = true
+	}
+}
+
+// New returns a new API instance that uses the given database connection.
+func New(db *sql.DB, opts ...Option) *API {
+	a := &API{db: db}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Handler returns an HTTP handler that routes requests to the API endpoints.
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Service information.
+	a.handle(mux, "GET /{$}", a.getServiceInfo)
+
+	// User notifications.
+	a.handle(mux, "GET /users/{user}/notifications", a.listNotifications)
+	a.handle(mux, "GET /users/{user}/notifications/unread-count", a.countUnreadNotifications)
+	a.handle(mux, "GET /users/{user}/notifications/{id}/history", a.getNotificationHistory)
+	a.handle(mux, "GET /users/{user}/notifications/{id}/emails", a.listEmailDeliveries)
+	a.handle(mux, "POST /users/{user}/broadcasts/{id}/dismiss", a.dismissBroadcast)
+
+	// User email preferences.
+	a.handle(mux, "GET /users/{user}/email-opt-outs", a.listEmailOptOuts)
+	a.handle(mux, "PUT /users/{user}/email-opt-outs/{type}", a.addEmailOptOut)
+	a.handle(mux, "DELETE /users/{user}/email-opt-outs/{type}", a.removeEmailOptOut)
+
+	// User locale preferences.
+	a.handle(mux, "GET /users/{user}/locale", a.getUserLocale)
+	a.handle(mux, "PUT /users/{user}/locale", a.setUserLocale)
+	a.handle(mux, "DELETE /users/{user}/locale", a.deleteUserLocale)
+
+	// User notification threads.
+	a.handle(mux, "GET /users/{user}/threads", a.listThreads)
+	a.handle(mux, "GET /users/{user}/threads/{thread_id}", a.getThread)
+
+	// Broadcast administration.
+	a.handle(mux, "GET /broadcasts", a.listBroadcasts)
+	a.handle(mux, "POST /broadcasts", a.addBroadcast)
+	a.handle(mux, "DELETE /broadcasts/{id}", a.deleteBroadcast)
+
+	// Scheduled delivery administration.
+	a.handle(mux, "GET /scheduled-deliveries", a.listScheduledDeliveries)
+	a.handle(mux, "DELETE /scheduled-deliveries/{schedule_id}", a.cancelScheduledDeliveries)
+
+	// Notification templates.
+	a.handle(mux, "GET /templates", a.listTemplates)
+	a.handle(mux, "POST /templates/validate", a.validateTemplate)
+	a.handle(mux, "POST /templates/preview", a.previewTemplate)
+	a.handle(mux, "PUT /templates/{type}/{locale}", a.saveTemplate)
+	a.handle(mux, "DELETE /templates/{type}/{locale}", a.deleteTemplate)
+	a.handle(mux, "GET /templates/catalogs/{locale}", a.getCatalog)
+	a.handle(mux, "PUT /templates/catalogs/{locale}", a.importCatalog)
+
+	// Payload schemas.
+	a.handle(mux, "GET /payload-schemas", a.listPayloadSchemas)
+	a.handle(mux, "GET /payload-schemas/{type}", a.getPayloadSchema)
+	a.handle(mux, "PUT /payload-schemas/{type}", a.savePayloadSchema)
+	a.handle(mux, "DELETE /payload-schemas/{type}", a.deletePayloadSchema)
+	a.handle(mux, "POST /payload-schemas/{type}/validate", a.validatePayload)
+
+	// Notification type administration.
+	a.handle(mux, "GET /notification-types", a.listNotificationTypes)
+	a.handle(mux, "GET /notification-types/{name}", a.getNotificationType)
+	a.handle(mux, "PUT /notification-types/{name}", a.saveNotificationType)
+	a.handle(mux, "DELETE /notification-types/{name}", a.deleteNotificationType)
+	a.handle(mux, "GET /quarantined-events", a.listQuarantinedEvents)
+	a.handle(mux, "DELETE /quarantined-events/{id}", a.deleteQuarantinedEvent)
+
+	// Email suppression administration.
+	a.handle(mux, "GET /email-suppressions", a.listEmailSuppressions)
+	a.handle(mux, "DELETE /email-suppressions/{address}", a.deleteEmailSuppression)
+
+	// Rate limit statistics.
+	a.handle(mux, "GET /rate-limits", a.getRateLimits)
+
+	return mux
+}
+
+// UnsubscribeHandler returns an HTTP handler for the one-click unsubscribe links in notification emails. The links are
+// followed by email recipients, so the handler is served separately from the administrative endpoints returned by
+// Handler, which must never be exposed publicly.
+func (a *API) UnsubscribeHandler() http.Handler {
+	mux := http.NewServeMux()
+	a.handle(mux, "GET /unsubscribe", a.confirmUnsubscribe)
+	a.handle(mux, "POST /unsubscribe", a.processUnsubscribe)
+	return mux
+}
+
+// handle registers the handler for a pattern unless the pattern's method isn't allowed by the API.
+func (a *API) handle(mux *http.ServeMux, pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	if a.readOnly && !strings.HasPrefix(pattern, http.MethodGet+" ") {
+		return
+	}
+	mux.HandleFunc(pattern, handler)
+}
+
+// errorResponse represents the body of an error response.
+type errorResponse struct {
+	Reason string `json:"reason"`
+}
+
+// writeJSON writes a JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Errorf("unable to write the response body: %s", err.Error())
+	}
+}
+
+// writeError writes an error response with the given status code.
+func writeError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	reason := fmt.Sprintf(format, a...)
+	if status >= http.StatusInternalServerError {
+		log.Error(reason)
+	}
+	writeJSON(w, status, errorResponse{Reason: reason})
+}
+
+// parseID returns the canonical form of a UUID used to identify a resource in a request path. The caller should
+// respond with a 404 if the ID isn't valid, because nothing can be stored under an ID that isn't a UUID.
+func parseID(id string) (string, bool) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// withTx calls a function within a database transaction. The transaction is committed if the function succeeds
+// and rolled back otherwise.
+func (a *API) withTx(ctx context.Context, readOnly bool, f func(*sql.Tx) error) error {
+	tx, err := a.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = f(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getServiceInfo returns basic information about the service.
+func (a *API) getServiceInfo(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"service":     "event-recorder",
+		"description": "Records events that may be of interest to users in the notifications database.",
+	})
+}
@@ -0,0 +1,548 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/payloads"
+	"github.com/cyverse-de/event-recorder/ratelimit"
+	"github.com/cyverse-de/event-recorder/templates"
+	"github.com/cyverse-de/event-recorder/unsubscribe"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestAPI returns an API instance backed by a mock database.
+func newTestAPI(t *testing.T) (*API, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("unable to open the mock database connection: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return New(db), mock
+}
+
+// doRequest sends a request to the API and returns the response.
+func doRequest(a *API, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	a.Handler().ServeHTTP(w, req)
+	return w
+}
+
+// doUnsubscribeRequest sends a request to the unsubscribe handler and returns the response.
+func doUnsubscribeRequest(a *API, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	a.UnsubscribeHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestCountUnreadNotificationsEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectCommit()
+
+	// Send the request and check the response.
+	w := doRequest(a, http.MethodGet, "/users/ipcdev/notifications/unread-count", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"user": "ipcdev", "total": 3}`, w.Body.String())
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestListNotificationsInvalidParams(t *testing.T) {
+	a, _ := newTestAPI(t)
+	w := doRequest(a, http.MethodGet, "/users/ipcdev/notifications?limit=ten", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(a, http.MethodGet, "/users/ipcdev/notifications?sort=subject", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAddBroadcastValidation(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAPI(t)
+
+	// None of these requests should make it to the database.
+	requests := []string{
+		`not json`,
+		`{"message": "down for maintenance", "created_by": "ipcdev"}`,
+		`{"subject": "maintenance", "created_by": "ipcdev"}`,
+		`{"subject": "maintenance", "message": "down for maintenance"}`,
+		`{"subject": "maintenance", "message": "down for maintenance", "created_by": "ipcdev",
+		  "start_time": "2026-01-02T00:00:00Z", "end_time": "2026-01-01T00:00:00Z"}`,
+	}
+	for _, body := range requests {
+		w := doRequest(a, http.MethodPost, "/broadcasts", body)
+		assert.Equal(http.StatusBadRequest, w.Code, "unexpected status for %s", body)
+	}
+}
+
+func TestDeleteBroadcastNotFound(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE broadcasts SET deleted").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Send the request and check the response.
+	w := doRequest(a, http.MethodDelete, "/broadcasts/d3c3b1e2-0d3a-4f4a-9a4b-6c1f3c2d1e0f", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestCancelScheduledDeliveries(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Set up the expectations.
+	created := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH canceled AS \\(UPDATE pending_deliveries").
+		WithArgs(common.PendingDeliveryStatusCanceled, "s1", common.PendingDeliveryStatusPending, true).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "username", "name", "seen", "deleted", "time_created", "expires_at"}).
+				AddRow("n1", "ipcdev", "analysis", false, true, created, nil).
+				AddRow("n2", "sarahr", "analysis", false, true, created, nil),
+		)
+	mock.ExpectCommit()
+
+	// Send the request and check the response.
+	sink := &fakeAuditSink{}
+	WithAuditSink(sink)(a)
+	w := doRequest(a, http.MethodDelete, "/scheduled-deliveries/s1", "")
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+
+	// An audit record should be published for each deleted notification.
+	if assert.Len(sink.records, 2) {
+		for i, user := range []string{"ipcdev", "sarahr"} {
+			assert.Equal(audit.EventDeleted, sink.records[i].Event)
+			assert.Equal(user, sink.records[i].User)
+			assert.True(sink.records[i].Deleted)
+		}
+	}
+}
+
+// fakeAuditSink records the audit records published to it.
+type fakeAuditSink struct {
+	records []audit.Record
+}
+
+// Publish records the audit records.
+func (s *fakeAuditSink) Publish(_ context.Context, records ...audit.Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+// Close does nothing.
+func (s *fakeAuditSink) Close() error {
+	return nil
+}
+
+func TestCancelScheduledDeliveriesNotFound(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH canceled AS \\(UPDATE pending_deliveries").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "username", "name", "seen", "deleted", "time_created", "expires_at"}),
+		)
+	mock.ExpectRollback()
+
+	// Send the request and check the response.
+	w := doRequest(a, http.MethodDelete, "/scheduled-deliveries/s1", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestGetNotificationHistoryNotFound(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Set up the expectations.
+	columns := []string{
+		"id", "name", "username", "subject", "seen", "deleted", "time_created", "routing_key", "outgoing", "grouping_key", "thread_id",
+		"severity",
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notifications n").WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectCommit()
+
+	// Send the request and check the response.
+	w := doRequest(a, http.MethodGet, "/users/ipcdev/notifications/6c1fe0e6-8f5e-11ef-9c2b-0242ac120002/history", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestInvalidIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	// Requests for IDs that aren't UUIDs should never reach the database.
+	for _, tc := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/users/ipcdev/notifications/n1/history"},
+		{http.MethodGet, "/users/ipcdev/notifications/n1/emails"},
+		{http.MethodPost, "/users/ipcdev/broadcasts/b1/dismiss"},
+		{http.MethodDelete, "/broadcasts/1"},
+		{http.MethodDelete, "/quarantined-events/q1"},
+	} {
+		a, mock := newTestAPI(t)
+		w := doRequest(a, tc.method, tc.path, "")
+		assert.Equal(http.StatusNotFound, w.Code, "%s %s", tc.method, tc.path)
+		assert.NoError(mock.ExpectationsWereMet(), "%s %s", tc.method, tc.path)
+	}
+}
+
+func TestMergeMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	outgoing, err := json.Marshal(map[string]interface{}{
+		"type":    "analysis",
+		"seen":    false,
+		"message": map[string]interface{}{"id": "n1", "text": "job completed"},
+	})
+	assert.NoError(err)
+	notifications := []*common.Notification{
+		{ID: "n1", NotificationType: "analysis", User: "ipcdev", Subject: "done", Seen: true, OutgoingMessage: string(outgoing)},
+		{ID: "n2", NotificationType: "data", User: "ipcdev", Subject: "uploaded", TimeCreated: now},
+	}
+	broadcasts := []*common.Broadcast{{ID: "b1", Subject: "maintenance", Message: "down", StartTime: now}}
+
+	// Broadcasts should be pinned to the top of the first page.
+	messages := mergeMessages("ipcdev", notifications, broadcasts, 0)
+	if assert.Len(messages, 3) {
+		assert.Equal("broadcast", messages[0]["type"])
+		assert.Equal("b1", messages[0]["message"].(map[string]interface{})["id"])
+
+		// The seen flag should come from the notification rather than the stored outgoing message.
+		assert.Equal(true, messages[1]["seen"])
+		assert.Equal("job completed", messages[1]["message"].(map[string]interface{})["text"])
+
+		// A message should be generated if no outgoing message was stored.
+		assert.Equal("data", messages[2]["type"])
+		assert.Equal("uploaded", messages[2]["message"].(map[string]interface{})["text"])
+	}
+
+	// Broadcasts should not be included on subsequent pages.
+	assert.Len(mergeMessages("ipcdev", notifications, broadcasts, 10), 2)
+}
+
+func TestGetRateLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	// Rate limiting is disabled by default.
+	a, _ := newTestAPI(t)
+	w := doRequest(a, http.MethodGet, "/rate-limits", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"enabled": false, "throttled": []}`, w.Body.String())
+
+	// The throttled notification counts should be reported if rate limiting is enabled.
+	limiter := ratelimit.New(ratelimit.Settings{Overflow: ratelimit.OverflowCollapse})
+	limiter.RecordThrottled(context.Background(), "analysis", ratelimit.OverflowCollapse)
+	WithRateLimiter(limiter)(a)
+	w = doRequest(a, http.MethodGet, "/rate-limits", "")
+	assert.Equal(http.StatusOK, w.Code)
+	expected := `{
+		"enabled": true,
+		"overflow": "collapse",
+		"throttled": [{"notification_type": "analysis", "action": "collapse", "count": 1}]
+	}`
+	assert.JSONEq(expected, w.Body.String())
+}
+
+func TestValidateTemplate(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAPI(t)
+
+	w := doRequest(a, http.MethodPost, "/templates/validate", `{"subject": "{{.payload.name}}"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"valid": true}`, w.Body.String())
+
+	w = doRequest(a, http.MethodPost, "/templates/validate", `{"subject": "{{.payload.name"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"valid":false`)
+}
+
+func TestPreviewTemplate(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAPI(t)
+
+	// Templates included in the request should be rendered.
+	body := `{"subject": "{{upper .payload.name}}", "body": "Hello, {{.user}}.", ` +
+		`"data": {"user": "ipcdev", "payload": {"name": "test"}}}`
+	w := doRequest(a, http.MethodPost, "/templates/preview", body)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"subject": "TEST", "body": "Hello, ipcdev."}`, w.Body.String())
+
+	// Stored templates should be rendered if no template is included in the request.
+	set, err := templates.NewSet([]*common.NotificationTemplate{{NotificationType: "data", Subject: "{{.user}}"}})
+	if !assert.NoError(err) {
+		return
+	}
+	WithTemplates(templates.NewStaticRenderer(set))(a)
+	w = doRequest(a, http.MethodPost, "/templates/preview", `{"notification_type": "data", "data": {"user": "ipcdev"}}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"subject": "ipcdev", "body": ""}`, w.Body.String())
+	w = doRequest(a, http.MethodPost, "/templates/preview", `{"notification_type": "analysis"}`)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestSaveTemplateValidation(t *testing.T) {
+	a, _ := newTestAPI(t)
+	w := doRequest(a, http.MethodPut, "/templates/analysis/en", `{"subject": "{{"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSetUserLocaleValidation(t *testing.T) {
+	a, _ := newTestAPI(t)
+	w := doRequest(a, http.MethodPut, "/users/ipcdev/locale", `{"locale": "../etc"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(a, http.MethodPut, "/users/ipcdev/locale", `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestImportCatalog(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Invalid catalogs should be rejected.
+	w := doRequest(a, http.MethodPut, "/templates/catalogs/es", `{"templates": {"data": {"subject": "{{"}}}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Valid catalogs should be stored in the locale named in the path.
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO notification_templates").
+		WithArgs("data", "es", "datos", "").
+		WillReturnRows(sqlmock.NewRows([]string{"time_updated"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+	w = doRequest(a, http.MethodPut, "/templates/catalogs/ES", `{"locale": "fr", "templates": {"data": {"subject": "datos"}}}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"locale": "es", "templates": {"data": {"subject": "datos", "body": ""}}}`, w.Body.String())
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestListEmailSuppressionsInvalidParams(t *testing.T) {
+	a, _ := newTestAPI(t)
+	w := doRequest(a, http.MethodGet, "/email-suppressions?suppressed=maybe", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteEmailSuppressionNotFound(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM email_suppressions").
+		WithArgs("ipcdev@example.org").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Send the request and check the response.
+	w := doRequest(a, http.MethodDelete, "/email-suppressions/ipcdev@example.org", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestUnsubscribe(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+	signer, err := unsubscribe.NewSigner(&unsubscribe.Settings{
+		Key:     []byte(strings.Repeat("k", unsubscribe.MinKeyLength)),
+		TTL:     time.Hour,
+		BaseURL: "https://de.example.org/unsubscribe",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	WithUnsubscribe(signer)(a)
+	token := signer.Token("ipcdev", "analysis", time.Now())
+
+	// The unsubscribe endpoint shouldn't be served with the rest of the API.
+	w := doRequest(a, http.MethodGet, "/unsubscribe?token="+token, "")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// Following the link should only display a confirmation form.
+	w = doUnsubscribeRequest(a, http.MethodGet, "/unsubscribe?token="+token, "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `<form method="post">`)
+
+	// Invalid tokens should be rejected.
+	w = doUnsubscribeRequest(a, http.MethodPost, "/unsubscribe?token="+token+"x", "List-Unsubscribe=One-Click")
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// A one-click unsubscribe request should opt the user out.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE username =").
+		WithArgs("ipcdev").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-id"))
+	mock.ExpectExec("INSERT INTO email_opt_outs").
+		WithArgs("user-id", "analysis").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	req := httptest.NewRequest(http.MethodPost, "/unsubscribe?token="+token,
+		strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	a.UnsubscribeHandler().ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "no longer receive analysis notification emails")
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestUnsubscribeDisabled(t *testing.T) {
+	a, _ := newTestAPI(t)
+	w := doUnsubscribeRequest(a, http.MethodPost, "/unsubscribe?token=abc", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSavePayloadSchema(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+
+	// Invalid schemas should be rejected.
+	w := doRequest(a, http.MethodPut, "/payload-schemas/analysis", `{"type": "widget"}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	// Valid schemas should be stored.
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO payload_schemas").
+		WithArgs("analysis", `{"type": "object"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"time_updated"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+	w = doRequest(a, http.MethodPut, "/payload-schemas/Analysis", `{"type": "object"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"notification_type":"analysis"`)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestValidatePayload(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAPI(t)
+
+	// A schema must be loaded for the notification type.
+	w := doRequest(a, http.MethodPost, "/payload-schemas/analysis/validate", `{}`)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	set, err := payloads.NewSet([]*common.PayloadSchema{{
+		NotificationType: "analysis",
+		Schema:           []byte(`{"type": "object", "required": ["analysisname"]}`),
+	}})
+	if !assert.NoError(err) {
+		return
+	}
+	WithPayloadValidator(payloads.NewStaticValidator(set))(a)
+
+	// Valid and invalid payloads should both be reported.
+	w = doRequest(a, http.MethodPost, "/payload-schemas/analysis/validate", `{"analysisname": "word count"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"valid": true}`, w.Body.String())
+	w = doRequest(a, http.MethodPost, "/payload-schemas/analysis/validate", `{}`)
+	assert.Equal(http.StatusOK, w.Code)
+	var resp payloadValidationResponse
+	if assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp)) {
+		assert.False(resp.Valid)
+		if assert.Len(resp.Problems, 1) {
+			assert.Equal("", resp.Problems[0].Path)
+			assert.Contains(resp.Problems[0].Message, "analysisname")
+		}
+	}
+}
+
+func TestSaveNotificationTypeValidation(t *testing.T) {
+	assert := assert.New(t)
+	a, _ := newTestAPI(t)
+
+	// Invalid names, channels, severities, and retention periods should be rejected.
+	w := doRequest(a, http.MethodPut, "/notification-types/bad%20name", `{}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = doRequest(a, http.MethodPut, "/notification-types/analysis", `{"default_channels": ["sms"]}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = doRequest(a, http.MethodPut, "/notification-types/analysis", `{"default_severity": "urgent"}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = doRequest(a, http.MethodPut, "/notification-types/analysis", `{"retention_days": -1}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	w = doRequest(a, http.MethodPut, "/notification-types/analysis", `{"retention_days": "forever"}`)
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteNotificationType(t *testing.T) {
+	assert := assert.New(t)
+	a, mock := newTestAPI(t)
+	columns := []string{
+		"name", "display_name", "description", "default_channels", "default_severity", "retention_days", "owner",
+		"time_updated",
+	}
+
+	// Missing notification types should be reported as not found.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notification_types").WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+	w := doRequest(a, http.MethodDelete, "/notification-types/analysis", "")
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	// Notification types that are still in use should be reported as conflicts.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notification_types").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("analysis", "", "", "{}", "", 0, "", nil))
+	mock.ExpectExec("DELETE FROM notification_types").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	w = doRequest(a, http.MethodDelete, "/notification-types/analysis", "")
+	assert.Equal(http.StatusConflict, w.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestReadOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	if !assert.NoError(err) {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	a := New(db, WithReadOnly())
+
+	// Endpoints that don't use the GET method shouldn't be available.
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/broadcasts"},
+		{http.MethodDelete, "/broadcasts/d3c3b1e2-0d3a-4f4a-9a4b-6c1f3c2d1e0f"},
+		{http.MethodPut, "/templates/analysis/en"},
+		{http.MethodPut, "/notification-types/analysis"},
+		{http.MethodDelete, "/email-suppressions/ipcdev@cyverse.org"},
+	} {
+		w := doRequest(a, req.method, req.path, "{}")
+		assert.Contains([]int{http.StatusNotFound, http.StatusMethodNotAllowed}, w.Code, "%s %s", req.method, req.path)
+	}
+
+	// Endpoints that use the GET method should still be available.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectCommit()
+	w := doRequest(a, http.MethodGet, "/users/ipcdev/notifications/unread-count", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NoError(mock.ExpectationsWereMet())
+
+	// Unsubscribe requests shouldn't be accepted either.
+	w = doUnsubscribeRequest(a, http.MethodPost, "/unsubscribe", "")
+	assert.Contains([]int{http.StatusNotFound, http.StatusMethodNotAllowed}, w.Code)
+}
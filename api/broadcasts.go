@@ -0,0 +1,172 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+)
+
+// errNotFound is used to indicate that a requested resource doesn't exist.
+var errNotFound = errors.New("not found")
+
+// broadcastRequest represents the request body used to create a broadcast.
+type broadcastRequest struct {
+	Subject   string     `json:"subject"`
+	Message   string     `json:"message"`
+	CreatedBy string     `json:"created_by"`
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+}
+
+// broadcastListing represents the response body for a broadcast listing.
+type broadcastListing struct {
+	Broadcasts []*common.Broadcast `json:"broadcasts"`
+}
+
+// validate validates a broadcast request and converts it to a broadcast. The start time defaults to the
+// current time.
+func (req *broadcastRequest) validate(now time.Time) (*common.Broadcast, error) {
+	if req.Subject == "" {
+		return nil, errors.New("a subject is required")
+	}
+	if req.Message == "" {
+		return nil, errors.New("a message is required")
+	}
+	if req.CreatedBy == "" {
+		return nil, errors.New("the name of the user creating the broadcast is required")
+	}
+
+	// Validate the visibility window.
+	startTime := now
+	if req.StartTime != nil {
+		startTime = *req.StartTime
+	}
+	if req.EndTime != nil && !req.EndTime.After(startTime) {
+		return nil, errors.New("the end time must be after the start time")
+	}
+
+	broadcast := &common.Broadcast{
+		Subject:   req.Subject,
+		Message:   req.Message,
+		CreatedBy: req.CreatedBy,
+		StartTime: startTime,
+		EndTime:   req.EndTime,
+	}
+	return broadcast, nil
+}
+
+// listBroadcasts lists all broadcasts that haven't been deleted.
+func (a *API) listBroadcasts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var listing broadcastListing
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.Broadcasts, err = db.ListBroadcasts(ctx, tx)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// addBroadcast creates a new broadcast.
+func (a *API) addBroadcast(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse and validate the request body.
+	var req broadcastRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	broadcast, err := req.validate(time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+
+	// Store the broadcast.
+	err = a.withTx(ctx, false, func(tx *sql.Tx) error {
+		return db.AddBroadcast(ctx, tx, broadcast)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, broadcast)
+}
+
+// deleteBroadcast deletes a broadcast.
+func (a *API) deleteBroadcast(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := parseID(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "broadcast %s not found", r.PathValue("id"))
+		return
+	}
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		deleted, err := db.DeleteBroadcast(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "broadcast %s not found", id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dismissBroadcast records the fact that a user dismissed a broadcast. Dismissed broadcasts are no longer listed
+// for the user and no longer count towards the user's unread notification count.
+func (a *API) dismissBroadcast(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+	id, ok := parseID(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "broadcast %s not found", r.PathValue("id"))
+		return
+	}
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		broadcast, err := db.GetBroadcast(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if broadcast == nil {
+			return errNotFound
+		}
+		return db.DismissBroadcast(ctx, tx, user, id)
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "broadcast %s not found", id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+)
+
+// emailDeliveryListing represents the response body for an email delivery listing.
+type emailDeliveryListing struct {
+	NotificationID string                  `json:"notification_id"`
+	Deliveries     []*common.EmailDelivery `json:"deliveries"`
+}
+
+// listEmailDeliveries lists the attempts to send the email for one of a user's notifications.
+func (a *API) listEmailDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+	notificationID, ok := parseID(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "notification %s not found", r.PathValue("id"))
+		return
+	}
+
+	listing := emailDeliveryListing{NotificationID: notificationID}
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.Deliveries, err = db.ListEmailDeliveries(ctx, tx, user, notificationID)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// emailSuppressionListing represents the response body for an email suppression listing.
+type emailSuppressionListing struct {
+	Suppressions []*common.EmailSuppression `json:"suppressions"`
+}
+
+// listEmailSuppressions lists the email addresses that have hard bounced. Only suppressed addresses are listed if
+// the `suppressed` query parameter is true.
+func (a *API) listEmailSuppressions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse the query parameters.
+	var suppressedOnly bool
+	if value := r.URL.Query().Get("suppressed"); value != "" {
+		var err error
+		suppressedOnly, err = strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid value for suppressed: %s", value)
+			return
+		}
+	}
+
+	var listing emailSuppressionListing
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.Suppressions, err = db.ListEmailSuppressions(ctx, tx, suppressedOnly)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// deleteEmailSuppression clears the hard bounces recorded for an email address so that email is sent to it again.
+func (a *API) deleteEmailSuppression(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	address := r.PathValue("address")
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		deleted, err := db.DeleteEmailSuppression(ctx, tx, address)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "no bounces have been recorded for %s", address)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/cyverse-de/event-recorder/db"
+)
+
+// emailOptOutListing represents the response body for an email opt-out listing.
+type emailOptOutListing struct {
+	User              string   `json:"user"`
+	NotificationTypes []string `json:"notification_types"`
+}
+
+// listEmailOptOuts lists the notification types for which a user has opted out of email.
+func (a *API) listEmailOptOuts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+
+	listing := emailOptOutListing{User: user}
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.NotificationTypes, err = db.ListEmailOptOuts(ctx, tx, user)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// addEmailOptOut opts a user out of email for a notification type. The notification type `*` opts the user out
+// of email for all notification types.
+func (a *API) addEmailOptOut(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+	notificationType := r.PathValue("type")
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		return db.AddEmailOptOut(ctx, tx, user, notificationType)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeEmailOptOut opts a user back in to email for a notification type.
+func (a *API) removeEmailOptOut(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+	notificationType := r.PathValue("type")
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		removed, err := db.RemoveEmailOptOut(ctx, tx, user, notificationType)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "%s has not opted out of %s email", user, notificationType)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/templates"
+)
+
+// userLocale represents the request and response body for a user's preferred locale.
+type userLocale struct {
+	User   string `json:"user"`
+	Locale string `json:"locale"`
+}
+
+// getUserLocale returns the locale that a user prefers to receive notifications in.
+func (a *API) getUserLocale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+
+	response := userLocale{User: user}
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		response.Locale, err = db.GetUserLocale(ctx, tx, user)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	if response.Locale == "" {
+		writeError(w, http.StatusNotFound, "%s has not chosen a locale", user)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// setUserLocale sets the locale that a user prefers to receive notifications in.
+func (a *API) setUserLocale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+
+	// Parse and validate the request body.
+	var req userLocale
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	if req.Locale == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body: a locale is required")
+		return
+	}
+	locale, err := templates.ParseLocale(req.Locale)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+
+	// Store the locale.
+	err = a.withTx(ctx, false, func(tx *sql.Tx) error {
+		return db.SetUserLocale(ctx, tx, user, locale)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userLocale{User: user, Locale: locale})
+}
+
+// deleteUserLocale removes a user's preferred locale.
+func (a *API) deleteUserLocale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		deleted, err := db.DeleteUserLocale(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "%s has not chosen a locale", user)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
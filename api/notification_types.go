@@ -0,0 +1,203 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/catalog"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+)
+
+// errInUse is used to indicate that a resource can't be deleted because other resources refer to it.
+var errInUse = errors.New("in use")
+
+// notificationTypeListing represents the response body for a notification type listing.
+type notificationTypeListing struct {
+	NotificationTypes []*common.NotificationType `json:"notification_types"`
+}
+
+// quarantinedEventListing represents the response body for a quarantined event listing.
+type quarantinedEventListing struct {
+	Events []*common.QuarantinedEvent `json:"events"`
+}
+
+// reloadNotificationTypes reloads the notification type catalog after the stored notification types change, so that
+// the change takes effect immediately on this replica. Other replicas pick up the change when they next reload their
+// catalogs.
+func (a *API) reloadNotificationTypes(r *http.Request) {
+	if a.types == nil {
+		return
+	}
+	err := a.types.Reload(r.Context())
+	if err != nil {
+		log.Errorf("unable to reload the notification types: %s", err.Error())
+	}
+}
+
+// listNotificationTypes lists the registered notification types.
+func (a *API) listNotificationTypes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var listing notificationTypeListing
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.NotificationTypes, err = db.ListNotificationTypes(ctx, tx)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// getNotificationType returns a single registered notification type.
+func (a *API) getNotificationType(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := strings.ToLower(r.PathValue("name"))
+
+	var t *common.NotificationType
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		t, err = db.GetNotificationType(ctx, tx, name)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	if t == nil {
+		writeError(w, http.StatusNotFound, "notification type %s not found", name)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, t)
+}
+
+// saveNotificationType registers a notification type or replaces the metadata of an existing notification type. The
+// name in the path takes precedence over any name in the request body.
+func (a *API) saveNotificationType(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse and validate the request body.
+	var t common.NotificationType
+	err := json.NewDecoder(r.Body).Decode(&t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	t.Name = r.PathValue("name")
+	err = catalog.Validate(&t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	// Store the notification type.
+	err = a.withTx(ctx, false, func(tx *sql.Tx) error {
+		return db.SaveNotificationType(ctx, tx, &t)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.reloadNotificationTypes(r)
+
+	writeJSON(w, http.StatusOK, t)
+}
+
+// deleteNotificationType deletes a notification type. Notification types that are referenced by stored
+// notifications can't be deleted.
+func (a *API) deleteNotificationType(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	name := strings.ToLower(r.PathValue("name"))
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		t, err := db.GetNotificationType(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return errNotFound
+		}
+		deleted, err := db.DeleteNotificationType(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errInUse
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "notification type %s not found", name)
+		return
+	}
+	if errors.Is(err, errInUse) {
+		writeError(w, http.StatusConflict, "notification type %s is still in use", name)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.reloadNotificationTypes(r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listQuarantinedEvents lists the events that were set aside because their notification types weren't registered.
+// Only events of a single notification type are listed if the `notification_type` query parameter is specified.
+func (a *API) listQuarantinedEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	notificationType := strings.ToLower(r.URL.Query().Get("notification_type"))
+
+	var listing quarantinedEventListing
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.Events, err = db.ListQuarantinedEvents(ctx, tx, notificationType)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// deleteQuarantinedEvent discards a quarantined event.
+func (a *API) deleteQuarantinedEvent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := parseID(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "quarantined event %s not found", r.PathValue("id"))
+		return
+	}
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		deleted, err := db.DeleteQuarantinedEvent(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "quarantined event %s not found", id)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+)
+
+// defaultLimit is the default maximum number of notifications to return in a listing.
+const defaultLimit = 50
+
+// notificationListing represents the response body for a notification listing.
+type notificationListing struct {
+	Messages    []map[string]interface{} `json:"messages"`
+	Total       int64                    `json:"total"`
+	UnseenTotal int64                    `json:"unseen_total"`
+}
+
+// notificationHistory represents the response body for a notification history listing.
+type notificationHistory struct {
+	Messages []map[string]interface{} `json:"messages"`
+}
+
+// unreadCount represents the response body for an unread notification count.
+type unreadCount struct {
+	User  string `json:"user"`
+	Total int64  `json:"total"`
+}
+
+// parseUintParam parses an optional non-negative integer query parameter.
+func parseUintParam(r *http.Request, name string, defaultValue uint64) (uint64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// notificationMessage converts a stored notification to the message format used by the UI. The stored outgoing
+// message is used as the basis for the message, but the seen and deleted flags are taken from the notification
+// itself because they may have changed since the outgoing message was stored.
+func notificationMessage(n *common.Notification) map[string]interface{} {
+	msg := make(map[string]interface{})
+	if n.OutgoingMessage != "" {
+		err := json.Unmarshal([]byte(n.OutgoingMessage), &msg)
+		if err != nil {
+			log.Errorf("unable to parse the outgoing message for notification %s: %s", n.ID, err.Error())
+		}
+	}
+	if _, ok := msg["message"]; !ok {
+		msg["message"] = map[string]interface{}{
+			"id":        n.ID,
+			"timestamp": common.FormatTimestamp(n.TimeCreated),
+			"text":      n.Subject,
+			"severity":  n.Severity,
+		}
+	}
+	msg["type"] = firstNonEmpty(msg["type"], n.NotificationType)
+	msg["user"] = n.User
+	msg["subject"] = n.Subject
+	msg["seen"] = n.Seen
+	msg["deleted"] = n.Deleted
+	return msg
+}
+
+// firstNonEmpty returns the existing value if it's a non-empty string, or the fallback value otherwise.
+func firstNonEmpty(existing interface{}, fallback string) string {
+	if s, ok := existing.(string); ok && s != "" {
+		return s
+	}
+	return fallback
+}
+
+// broadcastMessage converts a broadcast to the message format used by the UI.
+func broadcastMessage(b *common.Broadcast, user string) map[string]interface{} {
+	payload := map[string]interface{}{
+		"broadcast":  true,
+		"start_date": common.FormatTimestamp(b.StartTime),
+	}
+	if b.EndTime != nil {
+		payload["end_date"] = common.FormatTimestamp(*b.EndTime)
+	}
+	return map[string]interface{}{
+		"type":    "broadcast",
+		"user":    user,
+		"subject": b.Subject,
+		"seen":    false,
+		"deleted": false,
+		"payload": payload,
+		"message": map[string]interface{}{
+			"id":        b.ID,
+			"timestamp": common.FormatTimestamp(b.StartTime),
+			"text":      b.Message,
+		},
+	}
+}
+
+// mergeMessages merges the visible broadcasts into a page of notifications. Broadcasts are pinned to the top of
+// the first page of notifications.
+func mergeMessages(
+	user string,
+	notifications []*common.Notification,
+	broadcasts []*common.Broadcast,
+	offset uint64,
+) []map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(notifications)+len(broadcasts))
+	if offset == 0 {
+		for _, b := range broadcasts {
+			messages = append(messages, broadcastMessage(b, user))
+		}
+	}
+	for _, n := range notifications {
+		messages = append(messages, notificationMessage(n))
+	}
+	return messages
+}
+
+// listNotifications lists the notifications for a user, including any broadcasts that are currently visible to
+// the user.
+func (a *API) listNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+
+	// Parse the query parameters.
+	limit, err := parseUintParam(r, "limit", defaultLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid limit: %s", err.Error())
+		return
+	}
+	offset, err := parseUintParam(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid offset: %s", err.Error())
+		return
+	}
+	sortOrder := r.URL.Query().Get("sort")
+	if sortOrder == "" {
+		sortOrder = db.SortByTime
+	}
+	if sortOrder != db.SortByTime && sortOrder != db.SortBySeverity {
+		writeError(w, http.StatusBadRequest, "invalid sort order: %s", sortOrder)
+		return
+	}
+
+	// Load the notifications and broadcasts.
+	var listing notificationListing
+	err = a.withTx(ctx, true, func(tx *sql.Tx) error {
+		notifications, err := db.ListNotifications(ctx, tx, user, sortOrder, limit, offset)
+		if err != nil {
+			return err
+		}
+		broadcasts, err := db.ListVisibleBroadcasts(ctx, tx, user, time.Now())
+		if err != nil {
+			return err
+		}
+		total, err := db.CountNotifications(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		unseenTotal, err := db.CountUnreadNotifications(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+
+		listing = notificationListing{
+			Messages:    mergeMessages(user, notifications, broadcasts, offset),
+			Total:       total + int64(len(broadcasts)),
+			UnseenTotal: unseenTotal,
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// countUnreadNotifications returns the number of unread notifications for a user, including any broadcasts that
+// are currently visible to the user.
+func (a *API) countUnreadNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+
+	// Count the unread notifications.
+	var total int64
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		total, err = db.CountUnreadNotifications(ctx, tx, user)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, unreadCount{User: user, Total: total})
+}
+
+// getNotificationHistory lists every notification in the same group as one of a user's notifications, oldest first,
+// so that the full status history of an evolving notification can be displayed.
+func (a *API) getNotificationHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+	id, ok := parseID(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "notification %s not found", r.PathValue("id"))
+		return
+	}
+
+	// Load the notification history.
+	var notifications []*common.Notification
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		notifications, err = db.ListNotificationHistory(ctx, tx, user, id)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	if len(notifications) == 0 {
+		writeError(w, http.StatusNotFound, "notification %s not found", id)
+		return
+	}
+
+	// Format the response body.
+	history := notificationHistory{Messages: make([]map[string]interface{}, len(notifications))}
+	for i, n := range notifications {
+		history.Messages[i] = notificationMessage(n)
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/payloads"
+)
+
+// payloadSchemaListing represents the response body for a payload schema listing.
+type payloadSchemaListing struct {
+	Schemas []*common.PayloadSchema `json:"schemas"`
+}
+
+// payloadValidationResponse represents the response body for a payload validation request.
+type payloadValidationResponse struct {
+	Valid    bool               `json:"valid"`
+	Problems []payloads.Problem `json:"problems,omitempty"`
+}
+
+// reloadPayloadSchemas reloads the schemas used to validate event payloads after the stored schemas change, so that
+// the change takes effect immediately on this replica. Other replicas pick up the change when they next reload their
+// schemas.
+func (a *API) reloadPayloadSchemas(r *http.Request) {
+	if a.payloads == nil {
+		return
+	}
+	err := a.payloads.Reload(r.Context())
+	if err != nil {
+		log.Errorf("unable to reload the payload schemas: %s", err.Error())
+	}
+}
+
+// listPayloadSchemas lists the payload schemas stored in the database.
+func (a *API) listPayloadSchemas(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var listing payloadSchemaListing
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.Schemas, err = db.ListPayloadSchemas(ctx, tx)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// getPayloadSchema returns the payload schema stored for a notification type.
+func (a *API) getPayloadSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	notificationType := strings.ToLower(r.PathValue("type"))
+
+	var ps *common.PayloadSchema
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		ps, err = db.GetPayloadSchema(ctx, tx, notificationType)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	if ps == nil {
+		writeError(w, http.StatusNotFound, "no payload schema for %s", notificationType)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ps)
+}
+
+// savePayloadSchema adds or replaces the payload schema for a notification type. The request body is the schema.
+func (a *API) savePayloadSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse and validate the request body.
+	var schema json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&schema)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	ps := &common.PayloadSchema{NotificationType: strings.ToLower(r.PathValue("type")), Schema: schema}
+	_, err = payloads.Compile(ps)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	// Store the schema.
+	err = a.withTx(ctx, false, func(tx *sql.Tx) error {
+		return db.SavePayloadSchema(ctx, tx, ps)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.reloadPayloadSchemas(r)
+
+	writeJSON(w, http.StatusOK, ps)
+}
+
+// deletePayloadSchema deletes the payload schema for a notification type.
+func (a *API) deletePayloadSchema(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	notificationType := strings.ToLower(r.PathValue("type"))
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		deleted, err := db.DeletePayloadSchema(ctx, tx, notificationType)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "no payload schema for %s", notificationType)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.reloadPayloadSchemas(r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validatePayload checks a sample payload against the schema currently used for a notification type, so that
+// producers can test their events before publishing them. The request body is the payload.
+func (a *API) validatePayload(w http.ResponseWriter, r *http.Request) {
+	notificationType := strings.ToLower(r.PathValue("type"))
+
+	// Find the schema.
+	var schema *payloads.Schema
+	if a.payloads != nil {
+		schema = a.payloads.Lookup(notificationType)
+	}
+	if schema == nil {
+		writeError(w, http.StatusNotFound, "no payload schema for %s", notificationType)
+		return
+	}
+
+	// Validate the payload.
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	err = schema.Validate(payload)
+	var verr *payloads.ValidationError
+	if errors.As(err, &verr) {
+		writeJSON(w, http.StatusOK, payloadValidationResponse{Valid: false, Problems: verr.Problems})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, payloadValidationResponse{Valid: true})
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/cyverse-de/event-recorder/ratelimit"
+)
+
+// rateLimitStatus represents the body of a response to a rate limit statistics request.
+type rateLimitStatus struct {
+	Enabled   bool                        `json:"enabled"`
+	Overflow  string                      `json:"overflow,omitempty"`
+	Throttled []*ratelimit.ThrottledCount `json:"throttled"`
+}
+
+// getRateLimits reports the number of notifications that have exceeded a rate limit on this replica since it
+// started, broken down by notification type and overflow action.
+func (a *API) getRateLimits(w http.ResponseWriter, _ *http.Request) {
+	if a.limiter == nil {
+		writeJSON(w, http.StatusOK, &rateLimitStatus{Throttled: make([]*ratelimit.ThrottledCount, 0)})
+		return
+	}
+	writeJSON(w, http.StatusOK, &rateLimitStatus{
+		Enabled:   true,
+		Overflow:  a.limiter.Overflow(),
+		Throttled: a.limiter.Throttled(),
+	})
+}
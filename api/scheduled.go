@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+)
+
+// scheduledDeliveryListing represents the response body for a scheduled delivery listing.
+type scheduledDeliveryListing struct {
+	Deliveries []*common.PendingDelivery `json:"deliveries"`
+}
+
+// listScheduledDeliveries lists the deliveries that haven't been published yet. The listing can be limited to a
+// single user with the `user` query parameter.
+func (a *API) listScheduledDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.URL.Query().Get("user")
+
+	var listing scheduledDeliveryListing
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.Deliveries, err = db.ListPendingDeliveries(ctx, tx, user)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// cancelScheduledDeliveries cancels all of the pending deliveries with a schedule ID. The notifications for the
+// canceled deliveries are marked as deleted.
+func (a *API) cancelScheduledDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	scheduleID := r.PathValue("schedule_id")
+
+	var deleted []*common.Notification
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		var err error
+		deleted, err = db.CancelPendingDeliveries(ctx, tx, scheduleID)
+		if err != nil {
+			return err
+		}
+		if len(deleted) == 0 {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "no pending deliveries found for schedule %s", scheduleID)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.publishDeletions(ctx, deleted)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishDeletions publishes an audit record for each committed deletion. The deletions have already been
+// committed, so failures are only logged.
+func (a *API) publishDeletions(ctx context.Context, notifications []*common.Notification) {
+	if a.audit == nil || len(notifications) == 0 {
+		return
+	}
+	now := time.Now()
+	records := make([]audit.Record, len(notifications))
+	for i, notification := range notifications {
+		records[i] = audit.NewRecord(audit.EventDeleted, notification, now)
+	}
+	err := a.audit.Publish(ctx, records...)
+	if err != nil {
+		log.Errorf("unable to publish audit records: %s", err.Error())
+	}
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/templates"
+)
+
+// templateListing represents the response body for a notification template listing.
+type templateListing struct {
+	Templates []*common.NotificationTemplate `json:"templates"`
+}
+
+// templateRequest represents the request body used to store a notification template.
+type templateRequest struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// validationResponse represents the response body for a template validation request.
+type validationResponse struct {
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// previewRequest represents the request body for a template preview request. The stored template for the
+// notification type and locale is rendered unless a subject or body template is included in the request.
+type previewRequest struct {
+	NotificationType string                 `json:"notification_type"`
+	Locale           string                 `json:"locale"`
+	Subject          string                 `json:"subject"`
+	Body             string                 `json:"body"`
+	Data             map[string]interface{} `json:"data"`
+}
+
+// reloadTemplates reloads the templates used to render notifications after the stored templates change, so that
+// the change takes effect immediately on this replica. Other replicas pick up the change when they next reload
+// their templates.
+func (a *API) reloadTemplates(r *http.Request) {
+	if a.templates == nil {
+		return
+	}
+	err := a.templates.Reload(r.Context())
+	if err != nil {
+		log.Errorf("unable to reload the notification templates: %s", err.Error())
+	}
+}
+
+// listTemplates lists the notification templates stored in the database.
+func (a *API) listTemplates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var listing templateListing
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		listing.Templates, err = db.ListNotificationTemplates(ctx, tx)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// saveTemplate adds or replaces the notification template for a notification type and locale.
+func (a *API) saveTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse and validate the request body.
+	var req templateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	t := &common.NotificationTemplate{
+		NotificationType: strings.ToLower(r.PathValue("type")),
+		Locale:           templates.NormalizeLocale(r.PathValue("locale")),
+		Subject:          req.Subject,
+		Body:             req.Body,
+	}
+	_, err = templates.Compile(t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template: %s", err.Error())
+		return
+	}
+
+	// Store the template.
+	err = a.withTx(ctx, false, func(tx *sql.Tx) error {
+		return db.SaveNotificationTemplate(ctx, tx, t)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.reloadTemplates(r)
+
+	writeJSON(w, http.StatusOK, t)
+}
+
+// deleteTemplate deletes the notification template for a notification type and locale.
+func (a *API) deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	notificationType := strings.ToLower(r.PathValue("type"))
+	locale := templates.NormalizeLocale(r.PathValue("locale"))
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		deleted, err := db.DeleteNotificationTemplate(ctx, tx, notificationType, locale)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return errNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "no %s template for locale %s", notificationType, locale)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.reloadTemplates(r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateTemplate checks a notification template for errors without storing it.
+func (a *API) validateTemplate(w http.ResponseWriter, r *http.Request) {
+	var req templateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+
+	_, err = templates.Compile(&common.NotificationTemplate{Subject: req.Subject, Body: req.Body})
+	if err != nil {
+		writeJSON(w, http.StatusOK, validationResponse{Valid: false, Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, validationResponse{Valid: true})
+}
+
+// previewTemplate renders a notification template using sample event data.
+func (a *API) previewTemplate(w http.ResponseWriter, r *http.Request) {
+	var req previewRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+
+	// Determine which template to render.
+	var t *templates.Template
+	if req.Subject != "" || req.Body != "" {
+		t, err = templates.Compile(&common.NotificationTemplate{Subject: req.Subject, Body: req.Body})
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid template: %s", err.Error())
+			return
+		}
+	} else {
+		if req.NotificationType == "" {
+			writeError(w, http.StatusBadRequest, "a notification type or a template is required")
+			return
+		}
+		if a.templates != nil {
+			t = a.templates.Lookup(req.NotificationType, req.Locale)
+		}
+		if t == nil {
+			writeError(w, http.StatusNotFound, "no template found for %s", req.NotificationType)
+			return
+		}
+	}
+
+	// Render the template.
+	rendered, err := t.Render(req.Data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rendered)
+}
+
+// getCatalog exports the stored notification templates for a locale as a translation catalog.
+func (a *API) getCatalog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	locale, err := templates.ParseLocale(r.PathValue("locale"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	var stored []*common.NotificationTemplate
+	err = a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		stored, err = db.ListNotificationTemplates(ctx, tx)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, templates.NewCatalog(locale, stored))
+}
+
+// importCatalog stores every template in a translation catalog. Existing templates for the same notification types
+// and locale are replaced; templates for other notification types are left alone. Nothing is stored if any of the
+// templates is invalid.
+func (a *API) importCatalog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Parse and validate the request body. The locale in the path takes precedence.
+	var contents json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&contents)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	catalog, err := templates.ParseCatalog(contents, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: %s", err.Error())
+		return
+	}
+	catalog.Locale, err = templates.ParseLocale(r.PathValue("locale"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+	err = catalog.Validate()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid catalog: %s", err.Error())
+		return
+	}
+
+	// Store the templates.
+	err = a.withTx(ctx, false, func(tx *sql.Tx) error {
+		for _, t := range catalog.NotificationTemplates() {
+			err := db.SaveNotificationTemplate(ctx, tx, t)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	a.reloadTemplates(r)
+
+	writeJSON(w, http.StatusOK, catalog)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+)
+
+// threadSummary represents a single thread in a thread listing.
+type threadSummary struct {
+	*common.Thread
+	LastActivity string                 `json:"last_activity"`
+	Latest       map[string]interface{} `json:"latest"`
+}
+
+// threadListing represents the response body for a thread listing.
+type threadListing struct {
+	Threads     []threadSummary `json:"threads"`
+	Total       int64           `json:"total"`
+	UnseenTotal int64           `json:"unseen_total"`
+}
+
+// listThreads lists a user's notification threads, most recently active first.
+func (a *API) listThreads(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+
+	// Parse the query parameters.
+	limit, err := parseUintParam(r, "limit", defaultLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid limit: %s", err.Error())
+		return
+	}
+	offset, err := parseUintParam(r, "offset", 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid offset: %s", err.Error())
+		return
+	}
+
+	// Load the threads.
+	var threads []*common.Thread
+	var listing threadListing
+	err = a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		threads, err = db.ListThreads(ctx, tx, user, limit, offset)
+		if err != nil {
+			return err
+		}
+		listing.Total, err = db.CountThreads(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		listing.UnseenTotal, err = db.CountUnreadNotifications(ctx, tx, user)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	// Format the response body.
+	listing.Threads = make([]threadSummary, len(threads))
+	for i, thread := range threads {
+		listing.Threads[i] = threadSummary{
+			Thread:       thread,
+			LastActivity: common.FormatTimestamp(thread.LastActivity),
+		}
+		if thread.Latest != nil {
+			listing.Threads[i].Latest = notificationMessage(thread.Latest)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// getThread lists the notifications in one of a user's threads, oldest first.
+func (a *API) getThread(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := r.PathValue("user")
+	threadID := r.PathValue("thread_id")
+
+	// Load the notifications in the thread.
+	var notifications []*common.Notification
+	err := a.withTx(ctx, true, func(tx *sql.Tx) error {
+		var err error
+		notifications, err = db.ListThreadNotifications(ctx, tx, user, threadID)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	if len(notifications) == 0 {
+		writeError(w, http.StatusNotFound, "thread %s not found", threadID)
+		return
+	}
+
+	// Format the response body.
+	messages := make([]map[string]interface{}, len(notifications))
+	for i, n := range notifications {
+		messages[i] = notificationMessage(n)
+	}
+
+	writeJSON(w, http.StatusOK, notificationHistory{Messages: messages})
+}
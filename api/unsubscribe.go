@@ -0,0 +1,116 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/unsubscribe"
+)
+
+// unsubscribePage is the page displayed to recipients who follow an unsubscribe link. Following the link only
+// displays a confirmation form, because mail scanners may follow links in messages; the opt-out is recorded when the
+// form is submitted. Mail clients that support RFC 8058 submit the same request directly.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{- if .Error}}
+<p>{{.Error}}</p>
+{{- else if .Done}}
+<p>{{if .All}}You will no longer receive notification emails.{{else}}You will no longer receive {{.Type}} notification emails.{{end}}</p>
+{{- else}}
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<p>{{if .All}}Stop receiving all notification emails?{{else}}Stop receiving {{.Type}} notification emails?{{end}}</p>
+<button type="submit">Unsubscribe</button>
+</form>
+{{- end}}
+</body>
+</html>
+`))
+
+// unsubscribePageData contains the values used to render the unsubscribe page.
+type unsubscribePageData struct {
+	Token string
+	Type  string
+	All   bool
+	Done  bool
+	Error string
+}
+
+// writeUnsubscribePage writes the unsubscribe page with the given status code.
+func writeUnsubscribePage(w http.ResponseWriter, status int, data *unsubscribePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := unsubscribePage.Execute(w, data)
+	if err != nil {
+		log.Errorf("unable to write the unsubscribe page: %s", err.Error())
+	}
+}
+
+// verifyUnsubscribeToken verifies the token in an unsubscribe request, writing an error page if it's invalid.
+func (a *API) verifyUnsubscribeToken(w http.ResponseWriter, r *http.Request) (*unsubscribe.Claims, string, bool) {
+	if a.unsubscribe == nil {
+		writeUnsubscribePage(w, http.StatusNotFound, &unsubscribePageData{Error: "Unsubscribe links are disabled."})
+		return nil, "", false
+	}
+	token := r.FormValue("token")
+	claims, err := a.unsubscribe.Verify(token, time.Now())
+	if errors.Is(err, unsubscribe.ErrExpiredToken) {
+		writeUnsubscribePage(w, http.StatusBadRequest, &unsubscribePageData{
+			Error: "This unsubscribe link has expired. You can change your email preferences in the Discovery " +
+				"Environment.",
+		})
+		return nil, "", false
+	}
+	if err != nil {
+		writeUnsubscribePage(w, http.StatusBadRequest, &unsubscribePageData{Error: "This unsubscribe link is invalid."})
+		return nil, "", false
+	}
+	return claims, token, true
+}
+
+// confirmUnsubscribe displays a form asking the recipient to confirm that they want to unsubscribe.
+func (a *API) confirmUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	claims, token, ok := a.verifyUnsubscribeToken(w, r)
+	if !ok {
+		return
+	}
+	writeUnsubscribePage(w, http.StatusOK, &unsubscribePageData{
+		Token: token,
+		Type:  claims.NotificationType,
+		All:   claims.NotificationType == db.AllNotificationTypes,
+	})
+}
+
+// processUnsubscribe opts the recipient identified by an unsubscribe token out of email for the notification type in the
+// token. This endpoint implements the one-click unsubscribe request described in RFC 8058.
+func (a *API) processUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, _, ok := a.verifyUnsubscribeToken(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.withTx(ctx, false, func(tx *sql.Tx) error {
+		return db.AddEmailOptOut(ctx, tx, claims.User, claims.NotificationType)
+	})
+	if err != nil {
+		log.Errorf("unable to unsubscribe %s from %s email: %s", claims.User, claims.NotificationType, err.Error())
+		writeUnsubscribePage(w, http.StatusInternalServerError, &unsubscribePageData{
+			Error: "We were unable to update your email preferences. Please try again later.",
+		})
+		return
+	}
+
+	writeUnsubscribePage(w, http.StatusOK, &unsubscribePageData{
+		Type: claims.NotificationType,
+		All:  claims.NotificationType == db.AllNotificationTypes,
+		Done: true,
+	})
+}
@@ -0,0 +1,53 @@
+// Package audit publishes a compact record of each committed change to a stored notification so that the
+// notifications can be analyzed as an event stream. Records are only published after the database transaction that
+// made the change has been committed, so a record is never published for a change that was rolled back. A failure
+// to publish a record doesn't undo the change.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+)
+
+// The kinds of changes described by audit records.
+const (
+	EventCreated = "created"
+	EventDeleted = "deleted"
+)
+
+// Record describes a single change to a stored notification. The seen and deleted flags describe the state of the
+// notification after the change.
+type Record struct {
+	Event            string     `json:"event"`
+	ID               string     `json:"id"`
+	User             string     `json:"user"`
+	NotificationType string     `json:"type"`
+	TimeCreated      time.Time  `json:"time_created"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	Seen             bool       `json:"seen"`
+	Deleted          bool       `json:"deleted"`
+	Timestamp        time.Time  `json:"timestamp"`
+}
+
+// NewRecord returns the record for a change to a notification that was committed at the given time.
+func NewRecord(event string, notification *common.Notification, at time.Time) Record {
+	return Record{
+		Event:            event,
+		ID:               notification.ID,
+		User:             notification.User,
+		NotificationType: notification.NotificationType,
+		TimeCreated:      notification.TimeCreated.UTC(),
+		ExpiresAt:        notification.ExpiresAt,
+		Seen:             notification.Seen,
+		Deleted:          notification.Deleted,
+		Timestamp:        at.UTC(),
+	}
+}
+
+// Sink publishes audit records.
+type Sink interface {
+	Publish(ctx context.Context, records ...Record) error
+	Close() error
+}
@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaSettings contains the settings used to publish audit records to a Kafka topic.
+type KafkaSettings struct {
+	Brokers      []string
+	Topic        string
+	WriteTimeout time.Duration
+}
+
+// messageWriter is the subset of kafka.Writer used by the Kafka sink.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// defaultWriteTimeout is the maximum time allowed to publish a batch of audit records if the settings don't specify
+// one.
+const defaultWriteTimeout = 10 * time.Second
+
+// KafkaSink publishes audit records to a Kafka topic. Each record is keyed by username, so the records for any
+// single user are always stored in the same partition, in the order in which they were published.
+type KafkaSink struct {
+	writer  messageWriter
+	timeout time.Duration
+}
+
+// NewKafkaSink returns a sink that publishes audit records to the Kafka topic described by the settings. Writes
+// are only considered successful once they've been acknowledged by all in-sync replicas. Publishing a batch of
+// records gives up once the write timeout has passed, so an unavailable Kafka cluster can't hold up the callers for
+// long.
+func NewKafkaSink(settings *KafkaSettings) (*KafkaSink, error) {
+	wrapMsg := "unable to create the Kafka audit sink"
+
+	// Validate the settings.
+	if len(settings.Brokers) == 0 {
+		return nil, pkgerrors.Wrap(errors.New("no brokers specified"), wrapMsg)
+	}
+	if settings.Topic == "" {
+		return nil, pkgerrors.Wrap(errors.New("no topic specified"), wrapMsg)
+	}
+
+	timeout := settings.WriteTimeout
+	if timeout <= 0 {
+		timeout = defaultWriteTimeout
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(settings.Brokers...),
+		Topic:        settings.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond,
+		WriteTimeout: timeout,
+	}
+	return &KafkaSink{writer: writer, timeout: timeout}, nil
+}
+
+// Publish publishes a batch of audit records, waiting no longer than the write timeout.
+func (s *KafkaSink) Publish(ctx context.Context, records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	// Build the messages.
+	msgs := make([]kafka.Message, len(records))
+	for i, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return pkgerrors.Wrap(err, "unable to serialize the audit record")
+		}
+		msgs[i] = kafka.Message{Key: []byte(record.User), Value: value, Time: record.Timestamp}
+	}
+
+	// Publish the messages.
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	err := s.writer.WriteMessages(ctx, msgs...)
+	if err != nil {
+		return pkgerrors.Wrap(err, "unable to publish audit records")
+	}
+	return nil
+}
+
+// Close flushes any pending messages and closes the connections to the Kafka brokers.
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWriter records the messages written to it.
+type fakeWriter struct {
+	msgs      []kafka.Message
+	err       error
+	deadlines []time.Time
+}
+
+// WriteMessages records the messages and the deadline of the context.
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	deadline, _ := ctx.Deadline()
+	w.deadlines = append(w.deadlines, deadline)
+	if w.err != nil {
+		return w.err
+	}
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+// Close does nothing.
+func (w *fakeWriter) Close() error {
+	return nil
+}
+
+func TestNewKafkaSink(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewKafkaSink(&KafkaSettings{Topic: "notification-audit"})
+	assert.Error(err)
+	_, err = NewKafkaSink(&KafkaSettings{Brokers: []string{"kafka:9092"}})
+	assert.Error(err)
+
+	sink, err := NewKafkaSink(&KafkaSettings{Brokers: []string{"kafka:9092"}, Topic: "notification-audit"})
+	if assert.NoError(err) {
+		writer := sink.writer.(*kafka.Writer)
+		assert.Equal("notification-audit", writer.Topic)
+		assert.IsType(&kafka.Hash{}, writer.Balancer)
+		assert.Equal(defaultWriteTimeout, sink.timeout)
+		assert.NoError(sink.Close())
+	}
+}
+
+func TestKafkaSinkPublish(t *testing.T) {
+	assert := assert.New(t)
+
+	writer := &fakeWriter{}
+	sink := &KafkaSink{writer: writer, timeout: time.Minute}
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	committed := created.Add(time.Second)
+	notification := &common.Notification{
+		ID:               "n1",
+		User:             "ipcdev",
+		NotificationType: "analysis",
+		TimeCreated:      created,
+	}
+
+	// Nothing should be written if there are no records.
+	assert.NoError(sink.Publish(context.Background()))
+	assert.Empty(writer.msgs)
+
+	// Each record should be keyed by username, and publishing shouldn't take longer than the write timeout.
+	deleted := *notification
+	deleted.Deleted = true
+	start := time.Now()
+	err := sink.Publish(
+		context.Background(),
+		NewRecord(EventCreated, notification, committed),
+		NewRecord(EventDeleted, &deleted, committed),
+	)
+	if !assert.NoError(err) || !assert.Len(writer.msgs, 2) {
+		return
+	}
+	if assert.Len(writer.deadlines, 1) {
+		assert.WithinDuration(start.Add(time.Minute), writer.deadlines[0], 10*time.Second)
+	}
+	assert.Equal("ipcdev", string(writer.msgs[0].Key))
+	assert.Equal(committed, writer.msgs[0].Time)
+	assert.JSONEq(`{
+		"event": "created",
+		"id": "n1",
+		"user": "ipcdev",
+		"type": "analysis",
+		"time_created": "2026-10-18T12:00:00Z",
+		"seen": false,
+		"deleted": false,
+		"timestamp": "2026-10-18T12:00:01Z"
+	}`, string(writer.msgs[0].Value))
+	assert.Contains(string(writer.msgs[1].Value), `"event":"deleted"`)
+	assert.Contains(string(writer.msgs[1].Value), `"deleted":true`)
+
+	// Records for the same user should always be assigned to the same partition.
+	balancer := &kafka.Hash{}
+	partitions := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	assert.Equal(balancer.Balance(writer.msgs[0], partitions...), balancer.Balance(writer.msgs[1], partitions...))
+
+	// Write errors should be returned.
+	writer.err = errors.New("broker unavailable")
+	assert.Error(sink.Publish(context.Background(), NewRecord(EventCreated, notification, committed)))
+}
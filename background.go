@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/cloudevents"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/email"
+	"github.com/cyverse-de/event-recorder/expiry"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/leader"
+	"github.com/cyverse-de/event-recorder/scheduler"
+	"github.com/spf13/viper"
+)
+
+// startBackgroundJobs starts the enabled background jobs: publishing scheduled notifications and deleting expired
+// notifications. Every replica runs an elector for each job, but only the replica that holds the job's lock does
+// any work. Notification messages are published as CloudEvents if CloudEvents settings are provided, email requests
+// are sent using the sender if one is provided, and deletions are recorded in the audit sink if one is provided. The
+// returned function stops the jobs.
+func startBackgroundJobs(
+	ctx context.Context,
+	cfg *viper.Viper,
+	db *sql.DB,
+	amqpSettings *common.AMQPSettings,
+	cloudEvents *cloudevents.Settings,
+	emailSender email.Sender,
+	auditSink audit.Sink,
+) (func(), error) {
+	schedulerEnabled := cfg.GetBool("event_recorder.scheduler.enabled")
+	expiryEnabled := cfg.GetBool("event_recorder.expiry.enabled")
+	if !schedulerEnabled && !expiryEnabled {
+		return func() {}, nil
+	}
+
+	// The background jobs share a messaging client.
+	publisher, err := newPublisher(cfg, amqpSettings)
+	if err != nil {
+		return nil, err
+	}
+	messagingClient := handlers.NewOutputClient(publisher, cloudEvents)
+	if emailSender != nil {
+		messagingClient = handlers.NewDirectEmailClient(messagingClient, emailSender)
+	}
+
+	// Run each enabled job whenever this replica is the job's leader.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	run := func(name string, lockKey int64, retryInterval time.Duration, f func(context.Context)) {
+		elector := leader.NewElector(db, name, lockKey, retryInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			elector.Run(ctx, f)
+		}()
+	}
+	if schedulerEnabled {
+		settings := &scheduler.Settings{
+			PollInterval: cfg.GetDuration("event_recorder.scheduler.poll_interval"),
+			BatchSize:    cfg.GetUint64("event_recorder.scheduler.batch_size"),
+		}
+		lockKey := cfg.GetInt64("event_recorder.scheduler.lock_key")
+		run("scheduler", lockKey, settings.PollInterval, scheduler.New(db, messagingClient, settings).Run)
+	}
+	if expiryEnabled {
+		settings := &expiry.Settings{
+			PollInterval: cfg.GetDuration("event_recorder.expiry.poll_interval"),
+			BatchSize:    cfg.GetUint64("event_recorder.expiry.batch_size"),
+		}
+		lockKey := cfg.GetInt64("event_recorder.expiry.lock_key")
+		var opts []expiry.Option
+		if auditSink != nil {
+			opts = append(opts, expiry.WithAuditSink(auditSink))
+		}
+		run("expiry", lockKey, settings.PollInterval, expiry.New(db, messagingClient, settings, opts...).Run)
+	}
+
+	stop := func() {
+		cancel()
+		wg.Wait()
+		publisher.Close()
+	}
+	return stop, nil
+}
@@ -0,0 +1,193 @@
+// Package catalog maintains the catalog of registered notification types and their metadata: display names,
+// descriptions, default delivery channels, default severity levels, retention periods and owning services. The
+// catalog also determines what happens to events whose notification type isn't registered.
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "catalog"})
+
+// The ways in which events of unregistered notification types can be handled.
+const (
+	// ModeOpen registers unknown notification types automatically.
+	ModeOpen = "open"
+
+	// ModeReject rejects events of unknown notification types as unrecoverable errors.
+	ModeReject = "reject"
+
+	// ModeQuarantine sets events of unknown notification types aside for an administrator to review.
+	ModeQuarantine = "quarantine"
+)
+
+// ParseMode normalizes the way in which events of unregistered notification types are handled, returning an error
+// if it isn't supported. An empty string is treated as ModeOpen.
+func ParseMode(mode string) (string, error) {
+	switch normalized := strings.ToLower(strings.TrimSpace(mode)); normalized {
+	case "":
+		return ModeOpen, nil
+	case ModeOpen, ModeReject, ModeQuarantine:
+		return normalized, nil
+	default:
+		return "", fmt.Errorf("unsupported unknown notification type mode: %s", mode)
+	}
+}
+
+// validName matches the notification type names that can be registered through the catalog. Names appear in
+// routing keys, so they can't contain dots or wildcards.
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// maxRetentionDays is the maximum retention period that can be assigned to a notification type.
+const maxRetentionDays = 3650
+
+// Validate normalizes the metadata for a notification type and checks it for errors.
+func Validate(t *common.NotificationType) error {
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	if !validName.MatchString(t.Name) {
+		return fmt.Errorf("invalid notification type name: %q", t.Name)
+	}
+
+	// Validate the default channels.
+	channels := make([]string, 0, len(t.DefaultChannels))
+	seen := make(map[string]bool, len(t.DefaultChannels))
+	for _, channel := range t.DefaultChannels {
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		if channel != common.ChannelUI && channel != common.ChannelEmail {
+			return fmt.Errorf("unsupported channel: %s", channel)
+		}
+		if !seen[channel] {
+			seen[channel] = true
+			channels = append(channels, channel)
+		}
+	}
+	t.DefaultChannels = channels
+
+	// Validate the default severity.
+	if t.DefaultSeverity != "" {
+		severity, err := common.ParseSeverity(t.DefaultSeverity)
+		if err != nil {
+			return err
+		}
+		t.DefaultSeverity = severity
+	}
+
+	// Validate the retention period.
+	if t.RetentionDays < 0 || t.RetentionDays > maxRetentionDays {
+		return fmt.Errorf("the retention period must be between 0 and %d days", maxRetentionDays)
+	}
+
+	return nil
+}
+
+// Source loads notification types from a backing store.
+type Source interface {
+	Load(ctx context.Context) ([]*common.NotificationType, error)
+}
+
+// DatabaseSource loads notification types from the notifications database.
+type DatabaseSource struct {
+	db *sql.DB
+}
+
+// NewDatabaseSource returns a source that loads notification types from the notifications database.
+func NewDatabaseSource(db *sql.DB) *DatabaseSource {
+	return &DatabaseSource{db: db}
+}
+
+// Load loads the notification types from the database.
+func (s *DatabaseSource) Load(ctx context.Context) ([]*common.NotificationType, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to begin a database transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+	return db.ListNotificationTypes(ctx, tx)
+}
+
+// Catalog contains the notification types loaded from a source, which are reloaded periodically.
+type Catalog struct {
+	source Source
+	mutex  sync.RWMutex
+	types  map[string]*common.NotificationType
+}
+
+// New loads the notification types from a source and returns a catalog that contains them. An error is returned if
+// the notification types can't be loaded.
+func New(ctx context.Context, source Source) (*Catalog, error) {
+	c := &Catalog{source: source}
+	err := c.Reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewStatic returns a catalog that contains a fixed set of notification types.
+func NewStatic(types []*common.NotificationType) *Catalog {
+	c := &Catalog{}
+	c.set(types)
+	return c
+}
+
+// set replaces the notification types in the catalog.
+func (c *Catalog) set(types []*common.NotificationType) {
+	byName := make(map[string]*common.NotificationType, len(types))
+	for _, t := range types {
+		byName[t.Name] = t
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.types = byName
+}
+
+// Reload loads the notification types from the source. The current notification types are retained if the new
+// ones can't be loaded.
+func (c *Catalog) Reload(ctx context.Context) error {
+	if c.source == nil {
+		return nil
+	}
+	types, err := c.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	c.set(types)
+	return nil
+}
+
+// Watch reloads the notification types at the given interval until the context is canceled.
+func (c *Catalog) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := c.Reload(ctx)
+			if err != nil {
+				log.Errorf("keeping the current notification types: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Lookup returns the metadata for a notification type, or nil if the notification type isn't registered.
+func (c *Catalog) Lookup(name string) *common.NotificationType {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.types[name]
+}
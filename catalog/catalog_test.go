@@ -0,0 +1,84 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMode(t *testing.T) {
+	assert := assert.New(t)
+
+	mode, err := ParseMode("")
+	assert.NoError(err)
+	assert.Equal(ModeOpen, mode)
+	mode, err = ParseMode(" Quarantine ")
+	assert.NoError(err)
+	assert.Equal(ModeQuarantine, mode)
+	_, err = ParseMode("closed")
+	assert.Error(err)
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	// Valid metadata should be normalized.
+	notificationType := &common.NotificationType{
+		Name:            " Analysis ",
+		DefaultChannels: []string{"UI", "email", "ui"},
+		DefaultSeverity: "Warning",
+		RetentionDays:   30,
+	}
+	if assert.NoError(Validate(notificationType)) {
+		assert.Equal("analysis", notificationType.Name)
+		assert.Equal([]string{"ui", "email"}, notificationType.DefaultChannels)
+		assert.Equal(common.SeverityWarning, notificationType.DefaultSeverity)
+	}
+
+	// Invalid metadata should be rejected.
+	assert.Error(Validate(&common.NotificationType{Name: "events.*"}))
+	assert.Error(Validate(&common.NotificationType{Name: ""}))
+	assert.Error(Validate(&common.NotificationType{Name: "data", DefaultChannels: []string{"sms"}}))
+	assert.Error(Validate(&common.NotificationType{Name: "data", DefaultSeverity: "urgent"}))
+	assert.Error(Validate(&common.NotificationType{Name: "data", RetentionDays: -1}))
+}
+
+// testSource is a notification type source that returns a fixed result.
+type testSource struct {
+	types []*common.NotificationType
+	err   error
+}
+
+// Load returns the source's notification types or error.
+func (s *testSource) Load(_ context.Context) ([]*common.NotificationType, error) {
+	return s.types, s.err
+}
+
+func TestCatalog(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	source := &testSource{types: []*common.NotificationType{{Name: "analysis", DisplayName: "Analyses"}}}
+	c, err := New(ctx, source)
+	if !assert.NoError(err) {
+		return
+	}
+	if assert.NotNil(c.Lookup("analysis")) {
+		assert.Equal("Analyses", c.Lookup("analysis").DisplayName)
+	}
+	assert.Nil(c.Lookup("data"))
+
+	// The current notification types should be retained if they can't be reloaded.
+	source.err = errors.New("the database is unavailable")
+	assert.Error(c.Reload(ctx))
+	assert.NotNil(c.Lookup("analysis"))
+
+	// Reloading should pick up new notification types.
+	source.types, source.err = []*common.NotificationType{{Name: "data"}}, nil
+	assert.NoError(c.Reload(ctx))
+	assert.Nil(c.Lookup("analysis"))
+	assert.NotNil(c.Lookup("data"))
+}
@@ -0,0 +1,154 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// headerPrefixes lists the prefixes of the message headers that contain event attributes in the binary content mode.
+// The AMQP protocol binding uses `cloudEvents:`; some clients use `cloudEvents_` instead because their brokers or
+// client libraries don't allow colons in header names.
+var headerPrefixes = []string{"cloudEvents:", "cloudEvents_"}
+
+// FromDelivery extracts the CloudEvent carried by an AMQP delivery. A nil event is returned if the delivery doesn't
+// carry a CloudEvent. An error is returned if the delivery carries a CloudEvent that can't be decoded or is invalid.
+func FromDelivery(delivery amqp.Delivery) (*Event, error) {
+	var event *Event
+	var err error
+
+	// Determine the content mode.
+	mediaType, _, _ := mime.ParseMediaType(delivery.ContentType)
+	switch {
+	case mediaType == ContentType:
+		event, err = fromStructuredDelivery(delivery)
+	case mediaType == batchContentType:
+		return nil, fmt.Errorf("batches of CloudEvents aren't supported")
+	case hasBinaryHeaders(delivery.Headers):
+		event, err = fromBinaryDelivery(delivery)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the event.
+	err = event.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// fromStructuredDelivery decodes an event sent in the structured content mode.
+func fromStructuredDelivery(delivery amqp.Delivery) (*Event, error) {
+	var event Event
+	err := json.Unmarshal(delivery.Body, &event)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the CloudEvent: %s", err.Error())
+	}
+	return &event, nil
+}
+
+// attributeName returns the name of the event attribute stored in a message header, or an empty string if the
+// header doesn't contain an event attribute.
+func attributeName(header string) string {
+	for _, prefix := range headerPrefixes {
+		if strings.HasPrefix(header, prefix) {
+			return strings.TrimPrefix(header, prefix)
+		}
+	}
+	return ""
+}
+
+// HeaderAttribute returns the value of an event attribute stored in the headers of a message sent in the binary
+// content mode.
+func HeaderAttribute(headers amqp.Table, name string) (interface{}, bool) {
+	for _, prefix := range headerPrefixes {
+		if value, ok := headers[prefix+name]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// hasBinaryHeaders returns true if the message headers contain the specversion attribute, which identifies
+// messages that carry events in the binary content mode.
+func hasBinaryHeaders(headers amqp.Table) bool {
+	for header := range headers {
+		if attributeName(header) == attrSpecVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// fromBinaryDelivery decodes an event sent in the binary content mode. The message's content type is the content
+// type of the event data.
+func fromBinaryDelivery(delivery amqp.Delivery) (*Event, error) {
+	event := &Event{DataContentType: delivery.ContentType, Data: delivery.Body}
+	stringAttrs := map[string]*string{
+		attrSpecVersion: &event.SpecVersion,
+		attrID:          &event.ID,
+		attrSource:      &event.Source,
+		attrType:        &event.Type,
+		attrSubject:     &event.Subject,
+		attrDataSchema:  &event.DataSchema,
+	}
+
+	for header, value := range delivery.Headers {
+		name := attributeName(header)
+		if name == "" {
+			continue
+		}
+
+		// Store the context attributes.
+		if dest, ok := stringAttrs[name]; ok {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("CloudEvents %s attribute must be a string", name)
+			}
+			*dest = s
+			continue
+		}
+		if name == attrTime {
+			t, err := parseTimeHeader(value)
+			if err != nil {
+				return nil, err
+			}
+			event.Time = &t
+			continue
+		}
+
+		// Everything else is an extension attribute.
+		if event.Extensions == nil {
+			event.Extensions = make(map[string]interface{})
+		}
+		event.Extensions[name] = value
+	}
+
+	return event, nil
+}
+
+// parseTimeHeader parses the value of the header containing the time attribute, which may be either an RFC 3339
+// timestamp or an AMQP timestamp.
+func parseTimeHeader(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid CloudEvents time attribute: %s", err.Error())
+		}
+		return t, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid CloudEvents time attribute: %v", value)
+	}
+}
@@ -0,0 +1,222 @@
+// Package cloudevents reads and writes CloudEvents 1.0 events carried in AMQP messages. Incoming events may use either
+// the structured content mode, in which the message body is the entire event encoded as JSON, or the binary content
+// mode, in which the event attributes are stored in message headers and the message body is the event data.
+// Outgoing events always use the structured content mode.
+package cloudevents
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SpecVersion is the version of the CloudEvents specification that is supported.
+const SpecVersion = "1.0"
+
+// ContentType is the media type of events encoded in the structured content mode.
+const ContentType = "application/cloudevents+json"
+
+// batchContentType is the media type of batches of events, which aren't supported.
+const batchContentType = "application/cloudevents-batch+json"
+
+// The attributes defined by the CloudEvents specification. Any other attribute is an extension attribute.
+const (
+	attrSpecVersion     = "specversion"
+	attrID              = "id"
+	attrSource          = "source"
+	attrType            = "type"
+	attrSubject         = "subject"
+	attrTime            = "time"
+	attrDataContentType = "datacontenttype"
+	attrDataSchema      = "dataschema"
+	attrData            = "data"
+	attrDataBase64      = "data_base64"
+)
+
+// validExtensionName matches the names that extension attributes may have.
+var validExtensionName = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+// Event is a single CloudEvent.
+type Event struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            *time.Time
+	DataContentType string
+	DataSchema      string
+
+	// Data contains the event data exactly as it was encoded. JSON data is kept as raw JSON.
+	Data []byte
+
+	// Extensions contains the extension attributes, keyed by name.
+	Extensions map[string]interface{}
+}
+
+// Validate returns an error if the event is missing a required attribute or has an invalid extension attribute.
+func (e *Event) Validate() error {
+	if e.SpecVersion != SpecVersion {
+		return fmt.Errorf("unsupported CloudEvents specversion: %q", e.SpecVersion)
+	}
+	for name, value := range map[string]string{attrID: e.ID, attrSource: e.Source, attrType: e.Type} {
+		if value == "" {
+			return fmt.Errorf("CloudEvent has no %s attribute", name)
+		}
+	}
+	for name := range e.Extensions {
+		if !validExtensionName.MatchString(name) {
+			return fmt.Errorf("invalid CloudEvents extension attribute name: %q", name)
+		}
+	}
+	return nil
+}
+
+// HasJSONData returns true if the event's data is encoded as JSON. Data without a content type is assumed to be
+// JSON.
+func (e *Event) HasJSONData() bool {
+	return isJSONMediaType(e.DataContentType)
+}
+
+// Extension returns the value of an extension attribute as a string. Values that aren't strings are formatted
+// using their default formats. An empty string is returned if the event doesn't have the extension attribute.
+func (e *Event) Extension(name string) string {
+	value, ok := e.Extensions[name]
+	if !ok || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// isJSONMediaType returns true if a media type describes JSON data. An empty media type is treated as JSON.
+func isJSONMediaType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// MarshalJSON encodes the event in the structured content mode. JSON data is included as is; any other data is
+// base64 encoded.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	attrs := make(map[string]interface{}, len(e.Extensions)+9)
+	for name, value := range e.Extensions {
+		attrs[name] = value
+	}
+	attrs[attrSpecVersion] = e.SpecVersion
+	attrs[attrID] = e.ID
+	attrs[attrSource] = e.Source
+	attrs[attrType] = e.Type
+	optional := map[string]string{
+		attrSubject:         e.Subject,
+		attrDataContentType: e.DataContentType,
+		attrDataSchema:      e.DataSchema,
+	}
+	for name, value := range optional {
+		if value != "" {
+			attrs[name] = value
+		}
+	}
+	if e.Time != nil {
+		attrs[attrTime] = e.Time.Format(time.RFC3339Nano)
+	}
+	if len(e.Data) > 0 {
+		if e.HasJSONData() {
+			attrs[attrData] = json.RawMessage(e.Data)
+		} else {
+			attrs[attrDataBase64] = base64.StdEncoding.EncodeToString(e.Data)
+		}
+	}
+	return json.Marshal(attrs)
+}
+
+// UnmarshalJSON decodes an event encoded in the structured content mode.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var attrs map[string]json.RawMessage
+	err := json.Unmarshal(data, &attrs)
+	if err != nil {
+		return err
+	}
+
+	// Extract the context attributes, all of which are strings.
+	*e = Event{}
+	stringAttrs := map[string]*string{
+		attrSpecVersion:     &e.SpecVersion,
+		attrID:              &e.ID,
+		attrSource:          &e.Source,
+		attrType:            &e.Type,
+		attrSubject:         &e.Subject,
+		attrDataContentType: &e.DataContentType,
+		attrDataSchema:      &e.DataSchema,
+	}
+	for name, dest := range stringAttrs {
+		if raw, ok := attrs[name]; ok {
+			err = json.Unmarshal(raw, dest)
+			if err != nil {
+				return fmt.Errorf("invalid CloudEvents %s attribute: %s", name, err.Error())
+			}
+		}
+	}
+	if raw, ok := attrs[attrTime]; ok {
+		var t time.Time
+		err = json.Unmarshal(raw, &t)
+		if err != nil {
+			return fmt.Errorf("invalid CloudEvents time attribute: %s", err.Error())
+		}
+		e.Time = &t
+	}
+
+	// Extract the data.
+	if raw, ok := attrs[attrData]; ok && !bytes.Equal(raw, []byte("null")) {
+		if !e.HasJSONData() {
+			var s string
+			err = json.Unmarshal(raw, &s)
+			if err != nil {
+				return fmt.Errorf("CloudEvents data of type %s must be a string", e.DataContentType)
+			}
+			raw = []byte(s)
+		}
+		e.Data = raw
+	}
+	if raw, ok := attrs[attrDataBase64]; ok {
+		var encoded string
+		err = json.Unmarshal(raw, &encoded)
+		if err != nil {
+			return fmt.Errorf("invalid CloudEvents data_base64 attribute: %s", err.Error())
+		}
+		e.Data, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("invalid CloudEvents data_base64 attribute: %s", err.Error())
+		}
+	}
+
+	// Everything else is an extension attribute.
+	for name, raw := range attrs {
+		if _, ok := stringAttrs[name]; ok || name == attrTime || name == attrData || name == attrDataBase64 {
+			continue
+		}
+		var value interface{}
+		err = json.Unmarshal(raw, &value)
+		if err != nil {
+			return fmt.Errorf("invalid CloudEvents %s attribute: %s", name, err.Error())
+		}
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]interface{})
+		}
+		e.Extensions[name] = value
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStructuredDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	delivery := amqp.Delivery{
+		ContentType: "application/cloudevents+json; charset=utf-8",
+		Body: []byte(`{
+			"specversion": "1.0",
+			"id": "e1",
+			"source": "/apps",
+			"type": "org.cyverse.analysis.completed",
+			"subject": "word count completed",
+			"time": "2026-10-18T12:00:00Z",
+			"user": "ipcdev",
+			"email": true,
+			"data": {"analysisname": "word count"}
+		}`),
+	}
+	event, err := FromDelivery(delivery)
+	if !assert.NoError(err) || !assert.NotNil(event) {
+		return
+	}
+	assert.Equal("e1", event.ID)
+	assert.Equal("/apps", event.Source)
+	assert.Equal("org.cyverse.analysis.completed", event.Type)
+	assert.Equal("word count completed", event.Subject)
+	if assert.NotNil(event.Time) {
+		assert.True(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC).Equal(*event.Time))
+	}
+	assert.Equal("ipcdev", event.Extension("user"))
+	assert.Equal("true", event.Extension("email"))
+	assert.Equal("", event.Extension("missing"))
+	assert.True(event.HasJSONData())
+	assert.JSONEq(`{"analysisname": "word count"}`, string(event.Data))
+
+	// Base64 encoded data should be decoded.
+	delivery.Body = []byte(`{"specversion": "1.0", "id": "e2", "source": "/apps", "type": "t",
+		"datacontenttype": "text/plain", "data_base64": "aGVsbG8="}`)
+	event, err = FromDelivery(delivery)
+	if assert.NoError(err) {
+		assert.False(event.HasJSONData())
+		assert.Equal("hello", string(event.Data))
+	}
+}
+
+func TestBinaryDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	published := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	delivery := amqp.Delivery{
+		ContentType: "application/json",
+		Headers: amqp.Table{
+			"cloudEvents:specversion": "1.0",
+			"cloudEvents:id":          "e1",
+			"cloudEvents:source":      "/apps",
+			"cloudEvents:type":        "org.cyverse.analysis.completed",
+			"cloudEvents:time":        published,
+			"cloudEvents_user":        "ipcdev",
+			"traceparent":             "ignored",
+		},
+		Body: []byte(`{"analysisname": "word count"}`),
+	}
+	event, err := FromDelivery(delivery)
+	if !assert.NoError(err) || !assert.NotNil(event) {
+		return
+	}
+	assert.Equal("e1", event.ID)
+	assert.Equal("application/json", event.DataContentType)
+	if assert.NotNil(event.Time) {
+		assert.True(published.Equal(*event.Time))
+	}
+	assert.Equal(map[string]interface{}{"user": "ipcdev"}, event.Extensions)
+	assert.Equal(`{"analysisname": "word count"}`, string(event.Data))
+	user, ok := HeaderAttribute(delivery.Headers, "user")
+	assert.True(ok)
+	assert.Equal("ipcdev", user)
+}
+
+func TestFromDeliveryErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	// Deliveries that don't carry CloudEvents should be ignored.
+	event, err := FromDelivery(amqp.Delivery{ContentType: "application/json", Body: []byte(`{"user": "ipcdev"}`)})
+	assert.NoError(err)
+	assert.Nil(event)
+
+	// Invalid events should be rejected.
+	for _, delivery := range []amqp.Delivery{
+		{ContentType: ContentType, Body: []byte(`not json`)},
+		{ContentType: ContentType, Body: []byte(`{"specversion": "0.3", "id": "e1", "source": "/a", "type": "t"}`)},
+		{ContentType: ContentType, Body: []byte(`{"specversion": "1.0", "source": "/a", "type": "t"}`)},
+		{ContentType: ContentType, Body: []byte(`{"specversion": "1.0", "id": "e1", "source": "/a", "type": "t",
+			"Bad_Name": 1}`)},
+		{ContentType: batchContentType, Body: []byte(`[]`)},
+		{Headers: amqp.Table{"cloudEvents:specversion": "1.0", "cloudEvents:id": 1}},
+		{Headers: amqp.Table{"cloudEvents:specversion": "1.0", "cloudEvents:time": "yesterday"}},
+	} {
+		_, err = FromDelivery(delivery)
+		assert.Error(err, string(delivery.Body))
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	published := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	event := &Event{
+		SpecVersion: SpecVersion,
+		ID:          "e1",
+		Source:      "/event-recorder",
+		Type:        "t",
+		Time:        &published,
+		Data:        []byte(`{"total": 1}`),
+		Extensions:  map[string]interface{}{"user": "ipcdev"},
+	}
+	body, err := json.Marshal(event)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.JSONEq(`{
+		"specversion": "1.0",
+		"id": "e1",
+		"source": "/event-recorder",
+		"type": "t",
+		"time": "2026-10-18T12:00:00Z",
+		"user": "ipcdev",
+		"data": {"total": 1}
+	}`, string(body))
+
+	// Data that isn't JSON should be base64 encoded.
+	event.DataContentType = "text/plain"
+	event.Data = []byte("hello")
+	body, err = json.Marshal(event)
+	if assert.NoError(err) {
+		assert.Contains(string(body), `"data_base64":"aGVsbG8="`)
+	}
+}
+
+// mockPublisher records the messages published through it.
+type mockPublisher struct {
+	key  string
+	body []byte
+	opts *messaging.PublishingOpts
+}
+
+// PublishEmailRequestContext does nothing.
+func (p *mockPublisher) PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error {
+	return nil
+}
+
+// PublishContextOpts records the published message.
+func (p *mockPublisher) PublishContextOpts(
+	_ context.Context,
+	key string,
+	body []byte,
+	opts *messaging.PublishingOpts,
+) error {
+	p.key, p.body, p.opts = key, body, opts
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	assert := assert.New(t)
+
+	publisher := &mockPublisher{}
+	client := NewClient(publisher, Settings{Source: "/event-recorder", TypePrefix: "org.cyverse.de.notification."})
+	msg := &messaging.WrappedNotificationMessage{
+		Total:   3,
+		Message: &messaging.NotificationMessage{Type: "tool request", User: "ipcdev", Subject: "s"},
+	}
+	err := client.PublishNotificationMessageContext(context.Background(), msg)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// The notification message should be published as a structured CloudEvent with the usual routing key.
+	assert.Equal("notification.ipcdev", publisher.key)
+	assert.Equal(ContentType, publisher.opts.ContentType)
+	event, err := FromDelivery(amqp.Delivery{ContentType: publisher.opts.ContentType, Body: publisher.body})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("/event-recorder", event.Source)
+	assert.Equal("org.cyverse.de.notification.tool_request", event.Type)
+	assert.Equal("ipcdev", event.Subject)
+	assert.NotEmpty(event.ID)
+	assert.NotNil(event.Time)
+	var data messaging.WrappedNotificationMessage
+	if assert.NoError(json.Unmarshal(event.Data, &data)) {
+		assert.Equal(int64(3), data.Total)
+		assert.Equal("s", data.Message.Subject)
+	}
+}
@@ -0,0 +1,86 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher is the subset of messaging.Client used to publish outgoing messages.
+type Publisher interface {
+	PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error
+	PublishContextOpts(context.Context, string, []byte, *messaging.PublishingOpts) error
+}
+
+// Settings describes the events produced for outgoing notification messages.
+type Settings struct {
+	// Source identifies the event recorder as the source of the events.
+	Source string
+
+	// TypePrefix is prepended to the notification type to produce the event type.
+	TypePrefix string
+}
+
+// publishingOpts are the options used to publish events in the structured content mode.
+var publishingOpts = &messaging.PublishingOpts{
+	DeliveryMode: amqp.Persistent,
+	ContentType:  ContentType,
+}
+
+// Client is a messaging client that publishes notification messages as CloudEvents in the structured content mode.
+// The routing keys are the same as those used for plain notification messages. Email requests are published
+// unchanged.
+type Client struct {
+	Publisher
+	settings Settings
+}
+
+// NewClient returns a messaging client that publishes notification messages as CloudEvents using the given
+// publisher.
+func NewClient(publisher Publisher, settings Settings) *Client {
+	return &Client{Publisher: publisher, settings: settings}
+}
+
+// NotificationEvent returns the event that represents an outgoing notification message. The subject of the event is
+// the recipient's username, and the data is the notification message. Spaces in the notification type, which are
+// added for display, are converted back to underscores in the event type.
+func (c *Client) NotificationEvent(msg *messaging.WrappedNotificationMessage) (*Event, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &Event{
+		SpecVersion:     SpecVersion,
+		ID:              uuid.NewString(),
+		Source:          c.settings.Source,
+		Type:            c.settings.TypePrefix + strings.ReplaceAll(msg.Message.Type, " ", "_"),
+		Subject:         msg.Message.User,
+		Time:            &now,
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// PublishNotificationMessageContext publishes a notification message as a CloudEvent.
+func (c *Client) PublishNotificationMessageContext(
+	ctx context.Context,
+	msg *messaging.WrappedNotificationMessage,
+) error {
+	event, err := c.NotificationEvent(msg)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	routingKey := fmt.Sprintf("notification.%s", msg.Message.User)
+	return c.PublishContextOpts(ctx, routingKey, body, publishingOpts)
+}
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/mcnijman/go-emailaddress"
@@ -13,6 +16,78 @@ type AMQPSettings struct {
 	ExchangeType string
 }
 
+// The supported message broker transports.
+const (
+	TransportAMQP = "amqp"
+	TransportNATS = "nats"
+)
+
+// NATSSettings represents the settings used to connect to a NATS server, to consume incoming events from a
+// JetStream stream and to publish outgoing messages to another. The durable consumer's filter subjects are the
+// queue's binding keys.
+type NATSSettings struct {
+	URL                  string
+	Credentials          string
+	Stream               string
+	StreamSubjects       []string
+	Consumer             string
+	AckWait              time.Duration
+	MaxDeliver           int
+	OutputStream         string
+	OutputStreamSubjects []string
+}
+
+// ConsumerSettings represents the settings that determine how incoming events are consumed.
+type ConsumerSettings struct {
+	// Workers is the number of events that can be processed concurrently. Events for any single user are
+	// always processed sequentially, in the order in which they were received.
+	Workers int
+
+	// Prefetch is the maximum number of unacknowledged deliveries that the broker will send to us.
+	Prefetch int
+}
+
+// Supported values for the queue type setting.
+const (
+	QueueTypeClassic = "classic"
+	QueueTypeQuorum  = "quorum"
+)
+
+// QueueSettings represents the settings used to declare the queue that incoming events are consumed from
+// and to bind that queue to the exchange.
+type QueueSettings struct {
+	Name        string
+	BindingKeys []string
+	Durable     bool
+	Type        string
+	Exclusive   bool
+	AutoDelete  bool
+	Arguments   map[string]interface{}
+}
+
+// Validate returns an error if the queue settings are incomplete or inconsistent.
+func (s *QueueSettings) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("no queue name specified")
+	}
+	if len(s.BindingKeys) == 0 {
+		return fmt.Errorf("no binding keys specified for queue %s", s.Name)
+	}
+
+	// Validate the queue type. Quorum queues are always durable and can't be exclusive or auto-deleted.
+	switch s.Type {
+	case "", QueueTypeClassic:
+	case QueueTypeQuorum:
+		if !s.Durable || s.Exclusive || s.AutoDelete {
+			return fmt.Errorf("quorum queue %s must be durable, non-exclusive and not auto-deleted", s.Name)
+		}
+	default:
+		return fmt.Errorf("unsupported type for queue %s: %s", s.Name, s.Type)
+	}
+
+	return nil
+}
+
 // Notification represents a single notification to be recorded in the database.
 type Notification struct {
 	ID               string
@@ -24,6 +99,38 @@ type Notification struct {
 	TimeCreated      time.Time
 	Message          string
 	RoutingKey       string
+	OutgoingMessage  string
+	ExpiresAt        *time.Time
+	GroupingKey      string
+	ThreadID         string
+	Severity         string
+}
+
+// Thread summarizes the notifications in a single conversation thread. Notifications that don't belong to a thread
+// are summarized as threads of their own, identified by the notification ID.
+type Thread struct {
+	ID           string        `json:"thread_id"`
+	Total        int64         `json:"total"`
+	Unread       int64         `json:"unread"`
+	LastActivity time.Time     `json:"-"`
+	Latest       *Notification `json:"-"`
+}
+
+// Broadcast represents a system-wide announcement that is visible to every user between its start and end
+// times. Broadcasts are stored once rather than once per user.
+type Broadcast struct {
+	ID          string     `json:"id"`
+	Subject     string     `json:"subject"`
+	Message     string     `json:"message"`
+	CreatedBy   string     `json:"created_by"`
+	TimeCreated time.Time  `json:"time_created"`
+	StartTime   time.Time  `json:"start_time"`
+	EndTime     *time.Time `json:"end_time,omitempty"`
+}
+
+// VisibleAt returns true if the broadcast is visible at the given time.
+func (b *Broadcast) VisibleAt(t time.Time) bool {
+	return !t.Before(b.StartTime) && (b.EndTime == nil || t.Before(*b.EndTime))
 }
 
 // ValidateEmailAddress returns an error if the format of an email address is invalid.
@@ -31,3 +138,134 @@ func ValidateEmailAddress(emailAddress string) error {
 	_, err := emailaddress.Parse(emailAddress)
 	return err
 }
+
+// The possible statuses of a pending delivery.
+const (
+	PendingDeliveryStatusPending   = "pending"
+	PendingDeliveryStatusDelivered = "delivered"
+	PendingDeliveryStatusCanceled  = "canceled"
+	PendingDeliveryStatusFailed    = "failed"
+)
+
+// PendingDelivery represents a notification that has been stored, but whose UI message and email won't be
+// published until a later time. All of the pending deliveries created from a single event share the same schedule
+// ID, which can be used to cancel them.
+type PendingDelivery struct {
+	ID              string    `json:"id"`
+	ScheduleID      string    `json:"schedule_id"`
+	NotificationID  string    `json:"notification_id"`
+	User            string    `json:"user"`
+	DeliverAt       time.Time `json:"deliver_at"`
+	Status          string    `json:"status"`
+	EmailRequest    string    `json:"-"`
+	OutgoingMessage string    `json:"-"`
+}
+
+// NotificationTemplate contains the templates used to render the subject and message text of notifications of a
+// single type in a single locale. The templates use the Go text/template syntax and are executed with the incoming
+// event body as their data.
+type NotificationTemplate struct {
+	NotificationType string    `json:"notification_type" yaml:"notification_type"`
+	Locale           string    `json:"locale" yaml:"locale"`
+	Subject          string    `json:"subject" yaml:"subject"`
+	Body             string    `json:"body" yaml:"body"`
+	TimeUpdated      time.Time `json:"time_updated,omitempty" yaml:"-"`
+}
+
+// The channels that notifications can be delivered through.
+const (
+	ChannelUI    = "ui"
+	ChannelEmail = "email"
+)
+
+// NotificationType describes a registered notification type. Types that were registered automatically when an event
+// of that type was first received have no metadata.
+type NotificationType struct {
+	Name            string     `json:"name" yaml:"name"`
+	DisplayName     string     `json:"display_name" yaml:"display_name"`
+	Description     string     `json:"description" yaml:"description"`
+	DefaultChannels []string   `json:"default_channels" yaml:"default_channels"`
+	DefaultSeverity string     `json:"default_severity" yaml:"default_severity"`
+	RetentionDays   int        `json:"retention_days" yaml:"retention_days"`
+	Owner           string     `json:"owner" yaml:"owner"`
+	TimeUpdated     *time.Time `json:"time_updated,omitempty" yaml:"-"`
+}
+
+// HasDefaultChannel returns true if notifications of the type are delivered through the given channel by default.
+func (t *NotificationType) HasDefaultChannel(channel string) bool {
+	for _, c := range t.DefaultChannels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// QuarantinedEvent is an incoming event that was set aside because its notification type isn't registered.
+type QuarantinedEvent struct {
+	ID               string    `json:"id"`
+	NotificationType string    `json:"notification_type"`
+	RoutingKey       string    `json:"routing_key"`
+	Body             string    `json:"body"`
+	Reason           string    `json:"reason"`
+	TimeReceived     time.Time `json:"time_received"`
+}
+
+// PayloadSchema contains the JSON Schema used to validate the payloads of incoming events of a single notification
+// type.
+type PayloadSchema struct {
+	NotificationType string          `json:"notification_type"`
+	Schema           json.RawMessage `json:"schema"`
+	TimeUpdated      time.Time       `json:"time_updated,omitempty"`
+}
+
+// The statuses of an email delivery attempt.
+const (
+	EmailStatusQueued  = "queued"
+	EmailStatusSent    = "sent"
+	EmailStatusBounced = "bounced"
+	EmailStatusFailed  = "failed"
+)
+
+// emailStatusRanks maps each email delivery status to its rank. A delivery only moves to a status with a higher rank,
+// so a late report that a message was sent can't overwrite a bounce, and bounces and failures are final.
+var emailStatusRanks = map[string]int{
+	EmailStatusQueued:  1,
+	EmailStatusSent:    2,
+	EmailStatusBounced: 3,
+	EmailStatusFailed:  3,
+}
+
+// EmailStatusesBefore returns the statuses from which an email delivery may move to the given status, sorted by name.
+func EmailStatusesBefore(status string) []string {
+	statuses := make([]string, 0, len(emailStatusRanks))
+	for candidate, rank := range emailStatusRanks {
+		if rank < emailStatusRanks[status] {
+			statuses = append(statuses, candidate)
+		}
+	}
+	sort.Strings(statuses)
+	return statuses
+}
+
+// EmailDelivery records an attempt to send the email for a notification. Email requests published for the email
+// service are queued until a delivery status event reports their outcome.
+type EmailDelivery struct {
+	ID             string    `json:"id"`
+	NotificationID string    `json:"notification_id"`
+	Address        string    `json:"address"`
+	Template       string    `json:"template"`
+	Status         string    `json:"status"`
+	Detail         string    `json:"detail,omitempty"`
+	TimeCreated    time.Time `json:"time_created"`
+	TimeUpdated    time.Time `json:"time_updated"`
+}
+
+// EmailSuppression records the hard bounces for an email address. Email is no longer sent to a suppressed address.
+type EmailSuppression struct {
+	Address     string    `json:"address"`
+	HardBounces int       `json:"hard_bounces"`
+	Suppressed  bool      `json:"suppressed"`
+	LastBounce  time.Time `json:"last_bounce"`
+	Reason      string    `json:"reason,omitempty"`
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestQueueSettings returns a valid set of queue settings for testing.
+func getTestQueueSettings() *QueueSettings {
+	return &QueueSettings{
+		Name:        "event_listener",
+		BindingKeys: []string{"events.*.update.*"},
+		Durable:     true,
+		Type:        QueueTypeClassic,
+	}
+}
+
+func TestValidateQueueSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	// The default settings should be valid.
+	settings := getTestQueueSettings()
+	assert.NoError(settings.Validate())
+
+	// An empty queue type should be treated as a classic queue.
+	settings.Type = ""
+	assert.NoError(settings.Validate())
+
+	// A durable quorum queue should be valid.
+	settings.Type = QueueTypeQuorum
+	assert.NoError(settings.Validate())
+}
+
+func TestValidateQueueSettingsErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	// The queue name is required.
+	settings := getTestQueueSettings()
+	settings.Name = ""
+	assert.Error(settings.Validate())
+
+	// At least one binding key is required.
+	settings = getTestQueueSettings()
+	settings.BindingKeys = nil
+	assert.Error(settings.Validate())
+
+	// Unknown queue types aren't supported.
+	settings = getTestQueueSettings()
+	settings.Type = "lazy"
+	assert.Error(settings.Validate())
+
+	// Quorum queues must be durable.
+	settings = getTestQueueSettings()
+	settings.Type = QueueTypeQuorum
+	settings.Durable = false
+	assert.Error(settings.Validate())
+
+	// Quorum queues can't be exclusive.
+	settings = getTestQueueSettings()
+	settings.Type = QueueTypeQuorum
+	settings.Exclusive = true
+	assert.Error(settings.Validate())
+
+	// Quorum queues can't be automatically deleted.
+	settings = getTestQueueSettings()
+	settings.Type = QueueTypeQuorum
+	settings.AutoDelete = true
+	assert.Error(settings.Validate())
+}
+
+func TestEmailStatusesBefore(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Empty(EmailStatusesBefore(EmailStatusQueued))
+	assert.Equal([]string{EmailStatusQueued}, EmailStatusesBefore(EmailStatusSent))
+	assert.Equal([]string{EmailStatusQueued, EmailStatusSent}, EmailStatusesBefore(EmailStatusBounced))
+	assert.Equal([]string{EmailStatusQueued, EmailStatusSent}, EmailStatusesBefore(EmailStatusFailed))
+}
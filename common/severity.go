@@ -0,0 +1,49 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The supported notification severity levels, from least to most severe.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityError    = "error"
+	SeverityCritical = "critical"
+)
+
+// severityRanks maps each severity level to its rank. Higher ranks are more severe.
+var severityRanks = map[string]int{
+	SeverityInfo:     1,
+	SeverityWarning:  2,
+	SeverityError:    3,
+	SeverityCritical: 4,
+}
+
+// ParseSeverity normalizes a severity level, returning an error if it isn't supported. An empty string is
+// treated as the info level.
+func ParseSeverity(severity string) (string, error) {
+	if severity == "" {
+		return SeverityInfo, nil
+	}
+	normalized := strings.ToLower(severity)
+	if _, ok := severityRanks[normalized]; !ok {
+		return "", fmt.Errorf("unsupported severity: %s", severity)
+	}
+	return normalized, nil
+}
+
+// SeverityAtLeast returns true if a severity level is at least as severe as a threshold. It returns false if the
+// threshold is empty or either level isn't supported.
+func SeverityAtLeast(severity, threshold string) bool {
+	rank, ok := severityRanks[severity]
+	if !ok {
+		return false
+	}
+	thresholdRank, ok := severityRanks[threshold]
+	if !ok {
+		return false
+	}
+	return rank >= thresholdRank
+}
@@ -0,0 +1,31 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeverity(t *testing.T) {
+	assert := assert.New(t)
+
+	severity, err := ParseSeverity("")
+	assert.NoError(err)
+	assert.Equal(SeverityInfo, severity)
+
+	severity, err = ParseSeverity("Critical")
+	assert.NoError(err)
+	assert.Equal(SeverityCritical, severity)
+
+	_, err = ParseSeverity("urgent")
+	assert.Error(err)
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(SeverityAtLeast(SeverityCritical, SeverityCritical))
+	assert.True(SeverityAtLeast(SeverityCritical, SeverityError))
+	assert.False(SeverityAtLeast(SeverityWarning, SeverityError))
+	assert.False(SeverityAtLeast(SeverityCritical, ""))
+	assert.False(SeverityAtLeast("urgent", SeverityInfo))
+}
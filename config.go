@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/catalog"
+	"github.com/cyverse-de/event-recorder/cloudevents"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/email"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/payloads"
+	"github.com/cyverse-de/event-recorder/profiles"
+	"github.com/cyverse-de/event-recorder/ratelimit"
+	"github.com/cyverse-de/event-recorder/templates"
+	"github.com/cyverse-de/event-recorder/transport"
+	"github.com/cyverse-de/event-recorder/unsubscribe"
+	"github.com/spf13/viper"
+)
+
+// defaultQueueName is the name of the queue that the primary instance consumes events from by default.
+const defaultQueueName = "event_listener"
+
+// defaultSchedulerLockKey is the default key of the advisory lock used to elect the replica that publishes
+// scheduled notifications.
+const defaultSchedulerLockKey = 0x65766e7473636864
+
+// defaultExpiryLockKey is the default key of the advisory lock used to elect the replica that deletes expired
+// notifications.
+const defaultExpiryLockKey = 0x65766e7465787079
+
+// setConfigDefaults sets default values for event-recorder specific settings that aren't included in the
+// shared job services configuration defaults.
+func setConfigDefaults(cfg *viper.Viper) {
+	cfg.SetDefault("event_recorder.api.listen", ":60000")
+	cfg.SetDefault("event_recorder.consumer.workers", 10)
+	cfg.SetDefault("event_recorder.consumer.prefetch", 100)
+	cfg.SetDefault("event_recorder.queue.name", defaultQueueName)
+	cfg.SetDefault("event_recorder.queue.binding_keys", []string{"events.*.update.*"})
+	cfg.SetDefault("event_recorder.queue.durable", true)
+	cfg.SetDefault("event_recorder.queue.type", common.QueueTypeClassic)
+	cfg.SetDefault("event_recorder.queue.exclusive", false)
+	cfg.SetDefault("event_recorder.queue.auto_delete", false)
+	cfg.SetDefault("event_recorder.queue.arguments", map[string]interface{}{})
+	cfg.SetDefault("event_recorder.directory.path", "")
+	cfg.SetDefault("event_recorder.fanout.batch_size", handlers.DefaultBatchSize)
+	cfg.SetDefault("event_recorder.fanout.max_recipients", handlers.DefaultMaxRecipients)
+	cfg.SetDefault("event_recorder.shadow.enabled", false)
+	cfg.SetDefault("event_recorder.shadow.primary_queue", defaultQueueName)
+	cfg.SetDefault("event_recorder.shadow.scratch_db.uri", "")
+	cfg.SetDefault("event_recorder.shadow.report_path", "/tmp/event-recorder-shadow-report.jsonl")
+	cfg.SetDefault("event_recorder.shadow.lookup_attempts", 5)
+	cfg.SetDefault("event_recorder.shadow.lookup_delay", "2s")
+	cfg.SetDefault("event_recorder.shadow.summary_interval", "5m")
+	cfg.SetDefault("event_recorder.scheduler.enabled", true)
+	cfg.SetDefault("event_recorder.scheduler.poll_interval", "15s")
+	cfg.SetDefault("event_recorder.scheduler.batch_size", 100)
+	cfg.SetDefault("event_recorder.scheduler.lock_key", defaultSchedulerLockKey)
+	cfg.SetDefault("event_recorder.expiry.enabled", true)
+	cfg.SetDefault("event_recorder.expiry.poll_interval", "1m")
+	cfg.SetDefault("event_recorder.expiry.batch_size", 100)
+	cfg.SetDefault("event_recorder.expiry.lock_key", defaultExpiryLockKey)
+	cfg.SetDefault("event_recorder.severity.email_override", common.SeverityCritical)
+	cfg.SetDefault("event_recorder.rules.path", "")
+	cfg.SetDefault("event_recorder.rules.reload_interval", "30s")
+	cfg.SetDefault("event_recorder.rate_limit.enabled", false)
+	cfg.SetDefault("event_recorder.rate_limit.user.interval", "6s")
+	cfg.SetDefault("event_recorder.rate_limit.user.burst", 60)
+	cfg.SetDefault("event_recorder.rate_limit.type.interval", "12s")
+	cfg.SetDefault("event_recorder.rate_limit.type.burst", 30)
+	cfg.SetDefault("event_recorder.rate_limit.overflow", ratelimit.OverflowCollapse)
+	cfg.SetDefault("event_recorder.templates.source", "")
+	cfg.SetDefault("event_recorder.templates.path", "")
+	cfg.SetDefault("event_recorder.templates.reload_interval", "1m")
+	cfg.SetDefault("event_recorder.error_alerts.interval", "15m")
+	cfg.SetDefault("event_recorder.error_alerts.immediate_threshold", 25)
+	cfg.SetDefault("event_recorder.error_alerts.max_samples", 3)
+	cfg.SetDefault("event_recorder.email.backend", emailBackendAMQP)
+	cfg.SetDefault("event_recorder.email.smtp.host", "")
+	cfg.SetDefault("event_recorder.email.smtp.port", 587)
+	cfg.SetDefault("event_recorder.email.smtp.username", "")
+	cfg.SetDefault("event_recorder.email.smtp.password", "")
+	cfg.SetDefault("event_recorder.email.smtp.from_address", "")
+	cfg.SetDefault("event_recorder.email.smtp.from_name", "")
+	cfg.SetDefault("event_recorder.email.smtp.tls", email.TLSModeStartTLS)
+	cfg.SetDefault("event_recorder.email.smtp.timeout", "30s")
+	cfg.SetDefault("event_recorder.email.templates_dir", "")
+	cfg.SetDefault("event_recorder.email.bounce_threshold", handlers.DefaultBounceThreshold)
+	cfg.SetDefault("event_recorder.unsubscribe.secret", "")
+	cfg.SetDefault("event_recorder.unsubscribe.base_url", "")
+	cfg.SetDefault("event_recorder.unsubscribe.ttl", "720h")
+	cfg.SetDefault("event_recorder.unsubscribe.listen", ":60001")
+	cfg.SetDefault("event_recorder.profiles.source", "")
+	cfg.SetDefault("event_recorder.profiles.path", "")
+	cfg.SetDefault("event_recorder.profiles.url", "")
+	cfg.SetDefault("event_recorder.profiles.email_field", "email")
+	cfg.SetDefault("event_recorder.profiles.timeout", "10s")
+	cfg.SetDefault("event_recorder.profiles.cache_ttl", "5m")
+	cfg.SetDefault("event_recorder.profiles.payload_fallback", false)
+	cfg.SetDefault("event_recorder.payload_schemas.source", "")
+	cfg.SetDefault("event_recorder.payload_schemas.path", "")
+	cfg.SetDefault("event_recorder.payload_schemas.reload_interval", "1m")
+	cfg.SetDefault("event_recorder.notification_types.mode", catalog.ModeOpen)
+	cfg.SetDefault("event_recorder.notification_types.reload_interval", "1m")
+	cfg.SetDefault("event_recorder.cloudevents.output", false)
+	cfg.SetDefault("event_recorder.cloudevents.source", "/event-recorder")
+	cfg.SetDefault("event_recorder.cloudevents.type_prefix", "org.cyverse.de.notification.")
+	cfg.SetDefault("event_recorder.transport", common.TransportAMQP)
+	cfg.SetDefault("event_recorder.nats.url", "nats://localhost:4222")
+	cfg.SetDefault("event_recorder.nats.credentials", "")
+	cfg.SetDefault("event_recorder.nats.stream", "EVENTS")
+	cfg.SetDefault("event_recorder.nats.stream_subjects", []string{"events.>"})
+	cfg.SetDefault("event_recorder.nats.consumer", "")
+	cfg.SetDefault("event_recorder.nats.ack_wait", "30s")
+	cfg.SetDefault("event_recorder.nats.max_deliver", 0)
+	cfg.SetDefault("event_recorder.nats.output_stream", "NOTIFICATIONS")
+	cfg.SetDefault("event_recorder.nats.output_stream_subjects", []string{"notification.>", "email.requests"})
+	cfg.SetDefault("event_recorder.audit.kafka.enabled", false)
+	cfg.SetDefault("event_recorder.audit.kafka.brokers", []string{})
+	cfg.SetDefault("event_recorder.audit.kafka.topic", "notification-audit")
+	cfg.SetDefault("event_recorder.audit.kafka.write_timeout", "10s")
+}
+
+// rateLimit returns the rate limit described by the configuration settings with the given prefix. One token is
+// added to the bucket at each interval.
+func rateLimit(cfg *viper.Viper, prefix string) ratelimit.Limit {
+	var rate float64
+	if interval := cfg.GetDuration(prefix + ".interval"); interval > 0 {
+		rate = float64(time.Second) / float64(interval)
+	}
+	return ratelimit.Limit{Rate: rate, Burst: cfg.GetInt(prefix + ".burst")}
+}
+
+// newRateLimiter creates the rate limiter described by the configuration settings.
+func newRateLimiter(cfg *viper.Viper) (*ratelimit.Limiter, error) {
+	overflow, err := ratelimit.ParseOverflow(cfg.GetString("event_recorder.rate_limit.overflow"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Deferred notifications are delivered by the scheduler, so they'd never be delivered without it.
+	if overflow == ratelimit.OverflowDefer && !cfg.GetBool("event_recorder.scheduler.enabled") {
+		return nil, fmt.Errorf("the %s rate limit overflow policy requires the scheduler to be enabled", overflow)
+	}
+	return ratelimit.New(ratelimit.Settings{
+		User:     rateLimit(cfg, "event_recorder.rate_limit.user"),
+		Type:     rateLimit(cfg, "event_recorder.rate_limit.type"),
+		Overflow: overflow,
+	}), nil
+}
+
+// The supported sources of notification templates.
+const (
+	templateSourceDirectory = "directory"
+	templateSourceDatabase  = "database"
+)
+
+// newTemplateRenderer creates the notification template renderer described by the configuration settings. A nil
+// renderer is returned if notification templates are disabled.
+func newTemplateRenderer(ctx context.Context, cfg *viper.Viper, db *sql.DB) (*templates.Renderer, error) {
+	var source templates.Source
+	switch sourceType := cfg.GetString("event_recorder.templates.source"); sourceType {
+	case "":
+		return nil, nil
+	case templateSourceDirectory:
+		source = templates.NewDirectorySource(cfg.GetString("event_recorder.templates.path"))
+	case templateSourceDatabase:
+		source = templates.NewDatabaseSource(db)
+	default:
+		return nil, fmt.Errorf("unsupported notification template source: %s", sourceType)
+	}
+	return templates.NewRenderer(ctx, source)
+}
+
+// The supported sources of payload schemas.
+const (
+	payloadSchemaSourceDirectory = "directory"
+	payloadSchemaSourceDatabase  = "database"
+)
+
+// newPayloadValidator creates the event payload validator described by the configuration settings. A nil validator
+// is returned if payload validation is disabled.
+func newPayloadValidator(ctx context.Context, cfg *viper.Viper, db *sql.DB) (*payloads.Validator, error) {
+	var source payloads.Source
+	switch sourceType := cfg.GetString("event_recorder.payload_schemas.source"); sourceType {
+	case "":
+		return nil, nil
+	case payloadSchemaSourceDirectory:
+		source = payloads.NewDirectorySource(cfg.GetString("event_recorder.payload_schemas.path"))
+	case payloadSchemaSourceDatabase:
+		source = payloads.NewDatabaseSource(db)
+	default:
+		return nil, fmt.Errorf("unsupported payload schema source: %s", sourceType)
+	}
+	return payloads.NewValidator(ctx, source)
+}
+
+// newNotificationTypes loads the notification type catalog and determines how events of unregistered notification
+// types are handled.
+func newNotificationTypes(ctx context.Context, cfg *viper.Viper, db *sql.DB) (*catalog.Catalog, string, error) {
+	mode, err := catalog.ParseMode(cfg.GetString("event_recorder.notification_types.mode"))
+	if err != nil {
+		return nil, "", err
+	}
+	types, err := catalog.New(ctx, catalog.NewDatabaseSource(db))
+	if err != nil {
+		return nil, "", err
+	}
+	return types, mode, nil
+}
+
+// cloudEventsSettings returns the settings used to publish outgoing notification messages as CloudEvents. Nil
+// settings are returned if notification messages should be published in their plain format.
+func cloudEventsSettings(cfg *viper.Viper) *cloudevents.Settings {
+	if !cfg.GetBool("event_recorder.cloudevents.output") {
+		return nil
+	}
+	return &cloudevents.Settings{
+		Source:     cfg.GetString("event_recorder.cloudevents.source"),
+		TypePrefix: cfg.GetString("event_recorder.cloudevents.type_prefix"),
+	}
+}
+
+// natsSettings returns the settings used to connect to the NATS server. The durable consumer is named after the
+// queue unless a consumer name is configured.
+func natsSettings(cfg *viper.Viper) *common.NATSSettings {
+	consumer := cfg.GetString("event_recorder.nats.consumer")
+	if consumer == "" {
+		consumer = cfg.GetString("event_recorder.queue.name")
+	}
+	return &common.NATSSettings{
+		URL:                  cfg.GetString("event_recorder.nats.url"),
+		Credentials:          cfg.GetString("event_recorder.nats.credentials"),
+		Stream:               cfg.GetString("event_recorder.nats.stream"),
+		StreamSubjects:       cfg.GetStringSlice("event_recorder.nats.stream_subjects"),
+		Consumer:             consumer,
+		AckWait:              cfg.GetDuration("event_recorder.nats.ack_wait"),
+		MaxDeliver:           cfg.GetInt("event_recorder.nats.max_deliver"),
+		OutputStream:         cfg.GetString("event_recorder.nats.output_stream"),
+		OutputStreamSubjects: cfg.GetStringSlice("event_recorder.nats.output_stream_subjects"),
+	}
+}
+
+// newPublisher creates a publisher for outgoing messages using the configured message broker transport.
+func newPublisher(cfg *viper.Viper, amqpSettings *common.AMQPSettings) (transport.Publisher, error) {
+	switch name := cfg.GetString("event_recorder.transport"); name {
+	case common.TransportAMQP:
+		return transport.NewAMQPPublisher(amqpSettings)
+	case common.TransportNATS:
+		return transport.NewNATSPublisher(natsSettings(cfg))
+	default:
+		return nil, fmt.Errorf("unsupported message broker transport: %s", name)
+	}
+}
+
+// newConsumer creates a consumer for incoming events using the configured message broker transport.
+func newConsumer(
+	cfg *viper.Viper,
+	amqpSettings *common.AMQPSettings,
+	queueSettings *common.QueueSettings,
+	consumerSettings *common.ConsumerSettings,
+) (transport.Consumer, error) {
+	switch name := cfg.GetString("event_recorder.transport"); name {
+	case common.TransportAMQP:
+		return transport.NewAMQPConsumer(amqpSettings, queueSettings, consumerSettings.Prefetch)
+	case common.TransportNATS:
+		return transport.NewNATSConsumer(natsSettings(cfg), queueSettings.BindingKeys, consumerSettings.Prefetch)
+	default:
+		return nil, fmt.Errorf("unsupported message broker transport: %s", name)
+	}
+}
+
+// newAuditSink creates the sink that receives a record of each committed change to a stored notification. A nil
+// sink is returned if audit records shouldn't be published.
+func newAuditSink(cfg *viper.Viper) (audit.Sink, error) {
+	if !cfg.GetBool("event_recorder.audit.kafka.enabled") {
+		return nil, nil
+	}
+	return audit.NewKafkaSink(&audit.KafkaSettings{
+		Brokers:      cfg.GetStringSlice("event_recorder.audit.kafka.brokers"),
+		Topic:        cfg.GetString("event_recorder.audit.kafka.topic"),
+		WriteTimeout: cfg.GetDuration("event_recorder.audit.kafka.write_timeout"),
+	})
+}
+
+// The supported email delivery backends.
+const (
+	emailBackendAMQP = "amqp"
+	emailBackendSMTP = "smtp"
+)
+
+// newEmailSender creates the email sender described by the configuration settings. A nil sender is returned if
+// email requests should be published for the email service.
+func newEmailSender(cfg *viper.Viper) (email.Sender, error) {
+	switch backend := cfg.GetString("event_recorder.email.backend"); backend {
+	case emailBackendAMQP:
+		return nil, nil
+	case emailBackendSMTP:
+		emailTemplates, err := email.LoadTemplates(cfg.GetString("event_recorder.email.templates_dir"))
+		if err != nil {
+			return nil, err
+		}
+		return email.NewSMTPSender(&email.SMTPSettings{
+			Host:        cfg.GetString("event_recorder.email.smtp.host"),
+			Port:        cfg.GetInt("event_recorder.email.smtp.port"),
+			Username:    cfg.GetString("event_recorder.email.smtp.username"),
+			Password:    cfg.GetString("event_recorder.email.smtp.password"),
+			FromAddress: cfg.GetString("event_recorder.email.smtp.from_address"),
+			FromName:    cfg.GetString("event_recorder.email.smtp.from_name"),
+			TLSMode:     cfg.GetString("event_recorder.email.smtp.tls"),
+			Timeout:     cfg.GetDuration("event_recorder.email.smtp.timeout"),
+		}, emailTemplates)
+	default:
+		return nil, fmt.Errorf("unsupported email backend: %s", backend)
+	}
+}
+
+// newUnsubscribeSigner creates the signer used for one-click unsubscribe links. A nil signer is returned if
+// unsubscribe links are disabled, which is the case unless a signing secret is configured.
+func newUnsubscribeSigner(cfg *viper.Viper) (*unsubscribe.Signer, error) {
+	secret := cfg.GetString("event_recorder.unsubscribe.secret")
+	if secret == "" {
+		return nil, nil
+	}
+	return unsubscribe.NewSigner(&unsubscribe.Settings{
+		Key:     []byte(secret),
+		TTL:     cfg.GetDuration("event_recorder.unsubscribe.ttl"),
+		BaseURL: cfg.GetString("event_recorder.unsubscribe.base_url"),
+	})
+}
+
+// The supported sources of user profiles.
+const (
+	profileSourceFile = "file"
+	profileSourceHTTP = "http"
+)
+
+// newProfiles creates the user profile lookup described by the configuration settings. A nil lookup is returned if
+// email addresses should be taken from event payloads.
+func newProfiles(cfg *viper.Viper) (profiles.Profiles, error) {
+	var source profiles.Profiles
+	var err error
+	switch sourceType := cfg.GetString("event_recorder.profiles.source"); sourceType {
+	case "":
+		return nil, nil
+	case profileSourceFile:
+		source, err = profiles.NewFileProfiles(cfg.GetString("event_recorder.profiles.path"))
+	case profileSourceHTTP:
+		source, err = profiles.NewHTTPProfiles(
+			cfg.GetString("event_recorder.profiles.url"),
+			cfg.GetString("event_recorder.profiles.email_field"),
+			cfg.GetDuration("event_recorder.profiles.timeout"),
+		)
+	default:
+		return nil, fmt.Errorf("unsupported user profile source: %s", sourceType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the profiles if a cache TTL is configured.
+	if ttl := cfg.GetDuration("event_recorder.profiles.cache_ttl"); ttl > 0 {
+		return profiles.NewCachedProfiles(source, ttl), nil
+	}
+	return source, nil
+}
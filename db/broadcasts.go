@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// broadcastColumns lists the columns selected when broadcasts are retrieved from the database.
+var broadcastColumns = []string{
+	"b.id", "b.subject", "b.message", "b.created_by", "b.time_created", "b.start_time", "b.end_time",
+}
+
+// visibleAt returns a condition that selects broadcasts that are visible at the given time.
+func visibleAt(t time.Time) sq.Sqlizer {
+	return sq.And{
+		sq.Eq{"b.deleted": false},
+		sq.LtOrEq{"b.start_time": t},
+		sq.Or{sq.Eq{"b.end_time": nil}, sq.Gt{"b.end_time": t}},
+	}
+}
+
+// notDismissedBy returns a condition that excludes broadcasts that have been dismissed by a user.
+func notDismissedBy(user string) sq.Sqlizer {
+	return sq.Expr(
+		"NOT EXISTS (SELECT 1 FROM broadcast_dismissals d JOIN users du ON d.user_id = du.id "+
+			"WHERE d.broadcast_id = b.id AND du.username = ?)",
+		user,
+	)
+}
+
+// scanBroadcasts scans broadcasts from a set of rows selected using broadcastColumns.
+func scanBroadcasts(rows *sql.Rows) ([]*common.Broadcast, error) {
+	broadcasts := make([]*common.Broadcast, 0)
+	for rows.Next() {
+		var b common.Broadcast
+		var endTime sql.NullTime
+		err := rows.Scan(&b.ID, &b.Subject, &b.Message, &b.CreatedBy, &b.TimeCreated, &b.StartTime, &endTime)
+		if err != nil {
+			return nil, err
+		}
+		if endTime.Valid {
+			b.EndTime = &endTime.Time
+		}
+		broadcasts = append(broadcasts, &b)
+	}
+	return broadcasts, rows.Err()
+}
+
+// queryBroadcasts executes a query that selects broadcasts using broadcastColumns.
+func queryBroadcasts(ctx context.Context, tx *sql.Tx, builder sq.SelectBuilder) ([]*common.Broadcast, error) {
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	return scanBroadcasts(rows)
+}
+
+// AddBroadcast adds a new broadcast to the database, filling in the ID and creation time.
+func AddBroadcast(ctx context.Context, tx *sql.Tx, broadcast *common.Broadcast) error {
+	wrapMsg := "unable to add the broadcast"
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("broadcasts").
+		Columns("subject", "message", "created_by", "start_time", "end_time").
+		Values(broadcast.Subject, broadcast.Message, broadcast.CreatedBy, broadcast.StartTime, broadcast.EndTime).
+		Suffix("RETURNING id, time_created").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&broadcast.ID, &broadcast.TimeCreated)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// GetBroadcast retrieves a single broadcast. A nil broadcast is returned if the broadcast doesn't exist or
+// has been deleted.
+func GetBroadcast(ctx context.Context, tx *sql.Tx, id string) (*common.Broadcast, error) {
+	wrapMsg := fmt.Sprintf("unable to get broadcast %s", id)
+
+	// Look up the broadcast.
+	builder := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(broadcastColumns...).
+		From("broadcasts b").
+		Where(sq.Eq{"b.id": id}).
+		Where(sq.Eq{"b.deleted": false})
+	broadcasts, err := queryBroadcasts(ctx, tx, builder)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	if len(broadcasts) == 0 {
+		return nil, nil
+	}
+
+	return broadcasts[0], nil
+}
+
+// ListBroadcasts lists all broadcasts that haven't been deleted, including ones that aren't currently visible.
+func ListBroadcasts(ctx context.Context, tx *sql.Tx) ([]*common.Broadcast, error) {
+	builder := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(broadcastColumns...).
+		From("broadcasts b").
+		Where(sq.Eq{"b.deleted": false}).
+		OrderBy("b.start_time DESC")
+	broadcasts, err := queryBroadcasts(ctx, tx, builder)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list broadcasts")
+	}
+	return broadcasts, nil
+}
+
+// ListVisibleBroadcasts lists the broadcasts that are visible to a user at the given time. Broadcasts that the
+// user has dismissed are not included.
+func ListVisibleBroadcasts(ctx context.Context, tx *sql.Tx, user string, at time.Time) ([]*common.Broadcast, error) {
+	builder := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(broadcastColumns...).
+		From("broadcasts b").
+		Where(visibleAt(at)).
+		Where(notDismissedBy(user)).
+		OrderBy("b.start_time DESC")
+	broadcasts, err := queryBroadcasts(ctx, tx, builder)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list visible broadcasts for `%s`", user)
+	}
+	return broadcasts, nil
+}
+
+// DeleteBroadcast marks a broadcast as deleted. It returns false if the broadcast doesn't exist.
+func DeleteBroadcast(ctx context.Context, tx *sql.Tx, id string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to delete broadcast %s", id)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Update("broadcasts").
+		Set("deleted", true).
+		Where(sq.Eq{"id": id}).
+		Where(sq.Eq{"deleted": false}).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// DismissBroadcast records the fact that a user has dismissed a broadcast. Dismissing a broadcast more than once
+// has no effect.
+func DismissBroadcast(ctx context.Context, tx *sql.Tx, user, id string) error {
+	wrapMsg := fmt.Sprintf("unable to dismiss broadcast %s for `%s`", id, user)
+
+	// Get the user ID.
+	userID, err := GetUserID(ctx, tx, user)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("broadcast_dismissals").
+		Columns("broadcast_id", "user_id").
+		Values(id, userID).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddBroadcast(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	testID := "8a2e7b4c-0b6f-11eb-9a8b-62f4bd4ba6d0"
+	timeCreated := time.Now()
+	startTime := timeCreated.Add(time.Hour)
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"id", "time_created"}).AddRow(testID, timeCreated)
+	mock.ExpectQuery("INSERT INTO broadcasts \\(subject,message,created_by,start_time,end_time\\)").
+		WithArgs("maintenance", "The DE will be down.", "ipcdev", startTime, nil).
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Add the broadcast.
+	broadcast := &common.Broadcast{
+		Subject:   "maintenance",
+		Message:   "The DE will be down.",
+		CreatedBy: "ipcdev",
+		StartTime: startTime,
+	}
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	err = AddBroadcast(ctx, tx, broadcast)
+	assert.NoError(err, "unexpected error occurred while adding the broadcast")
+	assert.Equal(testID, broadcast.ID)
+	assert.Equal(timeCreated, broadcast.TimeCreated)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestListVisibleBroadcasts(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	endTime := now.Add(time.Hour)
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows(broadcastColumns).
+		AddRow("1", "first", "first message", "ipcdev", now, now, endTime).
+		AddRow("2", "second", "second message", "ipcdev", now, now, nil)
+	mock.ExpectQuery("SELECT .* FROM broadcasts b WHERE .* AND NOT EXISTS").
+		WithArgs(false, now, now, "ipcdev").
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// List the broadcasts.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	broadcasts, err := ListVisibleBroadcasts(ctx, tx, "ipcdev", now)
+	assert.NoError(err, "unexpected error occurred while listing broadcasts")
+	if assert.Len(broadcasts, 2) {
+		assert.Equal(endTime, *broadcasts[0].EndTime)
+		assert.Nil(broadcasts[1].EndTime)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestDismissBroadcast(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	userID := "c3b5a4a6-0b70-11eb-9a8b-62f4bd4ba6d0"
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE username =").
+		WithArgs("ipcdev").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
+	mock.ExpectExec("INSERT INTO broadcast_dismissals \\(broadcast_id,user_id\\) .* ON CONFLICT DO NOTHING").
+		WithArgs("1", userID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	// Dismiss the broadcast.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	err = DismissBroadcast(ctx, tx, "ipcdev", "1")
+	assert.NoError(err, "unexpected error occurred while dismissing the broadcast")
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// SaveEmailDelivery records an attempt to send the email for a notification, filling in the times that the record
+// was created and updated. The caller assigns the delivery ID so that it can be included in the email request.
+func SaveEmailDelivery(ctx context.Context, tx *sql.Tx, delivery *common.EmailDelivery) error {
+	wrapMsg := fmt.Sprintf("unable to record the email delivery for notification %s", delivery.NotificationID)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("email_deliveries").
+		Columns("id", "notification_id", "address", "template", "status", "detail").
+		Values(
+			delivery.ID,
+			delivery.NotificationID,
+			delivery.Address,
+			delivery.Template,
+			delivery.Status,
+			sql.NullString{String: delivery.Detail, Valid: delivery.Detail != ""},
+		).
+		Suffix("RETURNING time_created, time_updated").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&delivery.TimeCreated, &delivery.TimeUpdated)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// UpdateEmailDeliveryStatus updates the status of an email delivery. A delivery never moves back to an earlier status,
+// and a delivery that already has the given status isn't updated again. It returns the address that the email was
+// sent to, or an empty string if there's no delivery with the given ID, along with a flag indicating whether the
+// status was changed.
+func UpdateEmailDeliveryStatus(ctx context.Context, tx *sql.Tx, id, status, detail string) (string, bool, error) {
+	wrapMsg := fmt.Sprintf("unable to update the status of email delivery %s", id)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Update("email_deliveries").
+		Set("status", status).
+		Set("detail", sql.NullString{String: detail, Valid: detail != ""}).
+		Set("time_updated", sq.Expr("now()")).
+		Where(sq.Eq{"id": id}).
+		Where(sq.Eq{"status": common.EmailStatusesBefore(status)}).
+		Suffix("RETURNING address").
+		ToSql()
+	if err != nil {
+		return "", false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	var address string
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&address)
+	if err == nil {
+		return address, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", false, errors.Wrap(err, wrapMsg)
+	}
+
+	// The delivery either doesn't exist or already has the same or a later status.
+	err = tx.QueryRowContext(ctx, "SELECT address FROM email_deliveries WHERE id = $1", id).Scan(&address)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrap(err, wrapMsg)
+	}
+
+	return address, false, nil
+}
+
+// ListEmailDeliveries lists the attempts to send the email for one of a user's notifications, oldest first.
+func ListEmailDeliveries(ctx context.Context, tx *sql.Tx, user, notificationID string) ([]*common.EmailDelivery, error) {
+	wrapMsg := fmt.Sprintf("unable to list the email deliveries for notification %s", notificationID)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(
+			"d.id",
+			"d.notification_id",
+			"d.address",
+			"d.template",
+			"d.status",
+			"COALESCE(d.detail, '')",
+			"d.time_created",
+			"d.time_updated",
+		).
+		From("email_deliveries d").
+		Join("notifications n ON d.notification_id = n.id").
+		Join("users u ON n.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"d.notification_id": notificationID}).
+		OrderBy("d.time_created").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Load the deliveries.
+	deliveries := make([]*common.EmailDelivery, 0)
+	for rows.Next() {
+		var d common.EmailDelivery
+		err = rows.Scan(
+			&d.ID, &d.NotificationID, &d.Address, &d.Template, &d.Status, &d.Detail, &d.TimeCreated, &d.TimeUpdated,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return deliveries, nil
+}
+
+// RecordHardBounce records a hard bounce for an email address. The address is suppressed once it has bounced at
+// least threshold times. It returns true if the address is suppressed.
+func RecordHardBounce(ctx context.Context, tx *sql.Tx, address, reason string, threshold int) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to record a hard bounce for %s", address)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("email_suppressions").
+		Columns("address", "hard_bounces", "suppressed", "reason").
+		Values(strings.ToLower(address), 1, threshold <= 1, sql.NullString{String: reason, Valid: reason != ""}).
+		Suffix("ON CONFLICT (address) DO UPDATE SET "+
+			"hard_bounces = email_suppressions.hard_bounces + 1, "+
+			"suppressed = email_suppressions.suppressed OR email_suppressions.hard_bounces + 1 >= ?, "+
+			"last_bounce = now(), reason = EXCLUDED.reason "+
+			"RETURNING suppressed", threshold).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	var suppressed bool
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&suppressed)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return suppressed, nil
+}
+
+// EmailSuppressed returns true if email may no longer be sent to an address because of repeated hard bounces.
+func EmailSuppressed(ctx context.Context, tx *sql.Tx, address string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to determine whether email to %s is suppressed", address)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select().
+		Column(sq.Expr("EXISTS (?)", sq.Select("1").
+			From("email_suppressions").
+			Where(sq.Eq{"address": strings.ToLower(address)}).
+			Where("suppressed"))).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var suppressed bool
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&suppressed)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return suppressed, nil
+}
+
+// ListEmailSuppressions lists the email addresses that have hard bounced, most recent bounce first. Only suppressed
+// addresses are listed if suppressedOnly is true.
+func ListEmailSuppressions(ctx context.Context, tx *sql.Tx, suppressedOnly bool) ([]*common.EmailSuppression, error) {
+	wrapMsg := "unable to list the email suppressions"
+
+	// Build the query.
+	builder := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("address", "hard_bounces", "suppressed", "last_bounce", "COALESCE(reason, '')").
+		From("email_suppressions").
+		OrderBy("last_bounce DESC", "address")
+	if suppressedOnly {
+		builder = builder.Where("suppressed")
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Load the suppressions.
+	suppressions := make([]*common.EmailSuppression, 0)
+	for rows.Next() {
+		var s common.EmailSuppression
+		err = rows.Scan(&s.Address, &s.HardBounces, &s.Suppressed, &s.LastBounce, &s.Reason)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		suppressions = append(suppressions, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return suppressions, nil
+}
+
+// DeleteEmailSuppression clears the hard bounces recorded for an email address, allowing email to be sent to it
+// again. It returns false if no bounces were recorded for the address.
+func DeleteEmailSuppression(ctx context.Context, tx *sql.Tx, address string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to delete the email suppression for %s", address)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Delete("email_suppressions").
+		Where(sq.Eq{"address": strings.ToLower(address)}).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return deleted > 0, nil
+}
@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveEmailDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO email_deliveries \\(id,notification_id,address,template,status,detail\\) "+
+		"VALUES \\(\\$1,\\$2,\\$3,\\$4,\\$5,\\$6\\) RETURNING time_created, time_updated").
+		WithArgs("delivery-id", "notification-id", "ipcdev@example.org", "analysis_status_change",
+			common.EmailStatusQueued, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"time_created", "time_updated"}).AddRow(now, now))
+	mock.ExpectRollback()
+
+	// Save the delivery.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	delivery := &common.EmailDelivery{
+		ID:             "delivery-id",
+		NotificationID: "notification-id",
+		Address:        "ipcdev@example.org",
+		Template:       "analysis_status_change",
+		Status:         common.EmailStatusQueued,
+	}
+	err = SaveEmailDelivery(ctx, tx, delivery)
+	assert.NoError(err, "unexpected error occurred while saving the email delivery")
+	assert.Equal(now, delivery.TimeCreated)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestUpdateEmailDeliveryStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE email_deliveries SET status = \\$1, detail = \\$2, time_updated = now\\(\\) "+
+		"WHERE id = \\$3 AND status IN \\(\\$4,\\$5\\) RETURNING address").
+		WithArgs(common.EmailStatusBounced, "550 no such user", "delivery-id", common.EmailStatusQueued,
+			common.EmailStatusSent).
+		WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("ipcdev@example.org"))
+	mock.ExpectQuery("UPDATE email_deliveries SET status").
+		WithArgs(common.EmailStatusSent, nil, "delivery-id", common.EmailStatusQueued).
+		WillReturnRows(sqlmock.NewRows([]string{"address"}))
+	mock.ExpectQuery("SELECT address FROM email_deliveries WHERE id = \\$1").
+		WithArgs("delivery-id").
+		WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("ipcdev@example.org"))
+	mock.ExpectQuery("UPDATE email_deliveries SET status").
+		WithArgs(common.EmailStatusSent, nil, "missing-id", common.EmailStatusQueued).
+		WillReturnRows(sqlmock.NewRows([]string{"address"}))
+	mock.ExpectQuery("SELECT address FROM email_deliveries").
+		WithArgs("missing-id").
+		WillReturnRows(sqlmock.NewRows([]string{"address"}))
+	mock.ExpectRollback()
+
+	// Update the deliveries.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	address, updated, err := UpdateEmailDeliveryStatus(
+		ctx, tx, "delivery-id", common.EmailStatusBounced, "550 no such user",
+	)
+	assert.NoError(err, "unexpected error occurred while updating the email delivery")
+	assert.Equal("ipcdev@example.org", address)
+	assert.True(updated)
+
+	// A bounced delivery shouldn't be marked as sent.
+	address, updated, err = UpdateEmailDeliveryStatus(ctx, tx, "delivery-id", common.EmailStatusSent, "")
+	assert.NoError(err, "unexpected error occurred while updating the email delivery")
+	assert.Equal("ipcdev@example.org", address)
+	assert.False(updated)
+
+	// Missing deliveries shouldn't be updated.
+	address, updated, err = UpdateEmailDeliveryStatus(ctx, tx, "missing-id", common.EmailStatusSent, "")
+	assert.NoError(err, "unexpected error occurred while updating a missing email delivery")
+	assert.Empty(address)
+	assert.False(updated)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestListEmailDeliveries(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	columns := []string{
+		"id", "notification_id", "address", "template", "status", "detail", "time_created", "time_updated",
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT d.id, .* FROM email_deliveries d "+
+		"JOIN notifications n ON d.notification_id = n.id JOIN users u ON n.user_id = u.id "+
+		"WHERE u.username = \\$1 AND d.notification_id = \\$2 ORDER BY d.time_created").
+		WithArgs("ipcdev", "notification-id").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("delivery-id", "notification-id", "ipcdev@example.org", "analysis", "sent", "", now, now))
+	mock.ExpectRollback()
+
+	// List the deliveries.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	deliveries, err := ListEmailDeliveries(ctx, tx, "ipcdev", "notification-id")
+	assert.NoError(err, "unexpected error occurred while listing the email deliveries")
+	if assert.Len(deliveries, 1) {
+		assert.Equal("delivery-id", deliveries[0].ID)
+		assert.Equal(common.EmailStatusSent, deliveries[0].Status)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestRecordHardBounce(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO email_suppressions \\(address,hard_bounces,suppressed,reason\\) "+
+		"VALUES \\(\\$1,\\$2,\\$3,\\$4\\) ON CONFLICT \\(address\\) DO UPDATE SET .* "+
+		"email_suppressions.hard_bounces \\+ 1 >= \\$5, .* RETURNING suppressed").
+		WithArgs("ipcdev@example.org", 1, false, "550 no such user", 3).
+		WillReturnRows(sqlmock.NewRows([]string{"suppressed"}).AddRow(true))
+	mock.ExpectRollback()
+
+	// Record the bounce.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	suppressed, err := RecordHardBounce(ctx, tx, "IPCDev@Example.org", "550 no such user", 3)
+	assert.NoError(err, "unexpected error occurred while recording the bounce")
+	assert.True(suppressed)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestEmailSuppressed(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM email_suppressions WHERE address = \\$1 AND suppressed\\)").
+		WithArgs("ipcdev@example.org").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	// Check the address.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	suppressed, err := EmailSuppressed(ctx, tx, "ipcdev@example.org")
+	assert.NoError(err, "unexpected error occurred while checking the suppression")
+	assert.True(suppressed)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestListAndDeleteEmailSuppressions(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT address, hard_bounces, suppressed, last_bounce, COALESCE\\(reason, ''\\) " +
+		"FROM email_suppressions WHERE suppressed ORDER BY last_bounce DESC, address").
+		WillReturnRows(sqlmock.NewRows([]string{"address", "hard_bounces", "suppressed", "last_bounce", "reason"}).
+			AddRow("ipcdev@example.org", 3, true, now, "550 no such user"))
+	mock.ExpectExec("DELETE FROM email_suppressions WHERE address = \\$1").
+		WithArgs("ipcdev@example.org").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	// List and delete the suppressions.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	suppressions, err := ListEmailSuppressions(ctx, tx, true)
+	assert.NoError(err, "unexpected error occurred while listing the suppressions")
+	if assert.Len(suppressions, 1) {
+		assert.Equal(3, suppressions[0].HardBounces)
+	}
+	deleted, err := DeleteEmailSuppression(ctx, tx, "IPCDEV@example.org")
+	assert.NoError(err, "unexpected error occurred while deleting the suppression")
+	assert.True(deleted)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// AllNotificationTypes is the notification type used to opt out of email for every notification type.
+const AllNotificationTypes = "*"
+
+// EmailOptedOut returns true if a user has opted out of email for a notification type, either specifically or by
+// opting out of email for all notification types.
+func EmailOptedOut(ctx context.Context, tx *sql.Tx, user, notificationType string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to determine whether `%s` opted out of %s email", user, notificationType)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select().
+		Column(sq.Expr("EXISTS (?)", sq.Select("1").
+			From("email_opt_outs o").
+			Join("users u ON o.user_id = u.id").
+			Where(sq.Eq{"u.username": user}).
+			Where(sq.Eq{"o.notification_type": []string{notificationType, AllNotificationTypes}}))).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var optedOut bool
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&optedOut)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return optedOut, nil
+}
+
+// ListEmailOptOuts lists the notification types for which a user has opted out of email.
+func ListEmailOptOuts(ctx context.Context, tx *sql.Tx, user string) ([]string, error) {
+	wrapMsg := fmt.Sprintf("unable to list the email opt-outs for `%s`", user)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("o.notification_type").
+		From("email_opt_outs o").
+		Join("users u ON o.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		OrderBy("o.notification_type").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Load the notification types.
+	notificationTypes := make([]string, 0)
+	for rows.Next() {
+		var notificationType string
+		err = rows.Scan(&notificationType)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		notificationTypes = append(notificationTypes, notificationType)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return notificationTypes, nil
+}
+
+// AddEmailOptOut opts a user out of email for a notification type.
+func AddEmailOptOut(ctx context.Context, tx *sql.Tx, user, notificationType string) error {
+	wrapMsg := fmt.Sprintf("unable to opt `%s` out of %s email", user, notificationType)
+
+	// Get the user ID.
+	userID, err := GetUserID(ctx, tx, user)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("email_opt_outs").
+		Columns("user_id", "notification_type").
+		Values(userID, notificationType).
+		Suffix("ON CONFLICT DO NOTHING").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// RemoveEmailOptOut opts a user back in to email for a notification type. It returns false if the user hadn't
+// opted out of email for the notification type.
+func RemoveEmailOptOut(ctx context.Context, tx *sql.Tx, user, notificationType string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to opt `%s` back in to %s email", user, notificationType)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Delete("email_opt_outs").
+		Where("user_id = (SELECT id FROM users WHERE username = ?)", user).
+		Where(sq.Eq{"notification_type": notificationType}).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return removed > 0, nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailOptedOut(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM email_opt_outs o .* o.notification_type IN \\(\\$2,\\$3\\)\\)").
+		WithArgs("ipcdev", "analysis", AllNotificationTypes).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	// Check the opt-out.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	optedOut, err := EmailOptedOut(ctx, tx, "ipcdev", "analysis")
+	assert.NoError(err, "unexpected error occurred while checking the opt-out")
+	assert.True(optedOut)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestRemoveEmailOptOut(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM email_opt_outs WHERE user_id = \\(SELECT id FROM users WHERE username = \\$1\\)").
+		WithArgs("ipcdev", "analysis").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Remove the opt-out.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	removed, err := RemoveEmailOptOut(ctx, tx, "ipcdev", "analysis")
+	assert.NoError(err, "unexpected error occurred while removing the opt-out")
+	assert.False(removed)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
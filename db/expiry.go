@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// ExpireNotifications marks up to `limit` notifications that expired at or before the given time as deleted. Any
+// pending deliveries for the expired notifications are canceled. Notifications that users may have already seen are
+// returned separately from notifications whose delivery was canceled, because only the former need to be removed from
+// the UI. Together, the two lists contain every notification that was marked as deleted. The notification type, seen
+// flag, creation time and expiration time of each returned notification are included.
+func ExpireNotifications(
+	ctx context.Context,
+	tx *sql.Tx,
+	at time.Time,
+	limit uint64,
+) (expired, canceled []*common.Notification, err error) {
+	wrapMsg := "unable to expire notifications"
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(
+			"e.id",
+			"e.username",
+			"COALESCE(e.outgoing_json::text, '')",
+			"t.name",
+			"e.seen",
+			"e.time_created",
+			"e.expires_at",
+			"c.notification_id IS NOT NULL",
+		).
+		Prefix(
+			"WITH expired AS ("+
+				"UPDATE notifications n SET deleted = true FROM users u "+
+				"WHERE n.user_id = u.id AND n.id IN ("+
+				"SELECT id FROM notifications WHERE expires_at <= ? AND deleted = false "+
+				"ORDER BY expires_at LIMIT ? FOR UPDATE SKIP LOCKED) "+
+				"RETURNING n.id, u.username, n.outgoing_json, n.notification_type_id, n.seen, n.time_created, "+
+				"n.expires_at), "+
+				"canceled AS ("+
+				"UPDATE pending_deliveries p SET status = ?, time_updated = now() FROM expired e "+
+				"WHERE p.notification_id = e.id AND p.status = ? RETURNING p.notification_id)",
+			at,
+			limit,
+			common.PendingDeliveryStatusCanceled,
+			common.PendingDeliveryStatusPending,
+		).
+		From("expired e").
+		Join("notification_types t ON e.notification_type_id = t.id").
+		LeftJoin("(SELECT DISTINCT notification_id FROM canceled) c ON e.id = c.notification_id").
+		OrderBy("e.username").
+		ToSql()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the query.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Build the lists of expired notifications.
+	expired = make([]*common.Notification, 0)
+	canceled = make([]*common.Notification, 0)
+	for rows.Next() {
+		var deliveryCanceled bool
+		notification := &common.Notification{Deleted: true}
+		err = rows.Scan(
+			&notification.ID,
+			&notification.User,
+			&notification.OutgoingMessage,
+			&notification.NotificationType,
+			&notification.Seen,
+			&notification.TimeCreated,
+			&notification.ExpiresAt,
+			&deliveryCanceled,
+		)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, wrapMsg)
+		}
+		if deliveryCanceled {
+			canceled = append(canceled, notification)
+		} else {
+			expired = append(expired, notification)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return expired, canceled, nil
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpireNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	mock.ExpectBegin()
+	created := now.Add(-time.Hour)
+	rows := sqlmock.NewRows(
+		[]string{"id", "username", "outgoing_json", "name", "seen", "time_created", "expires_at", "canceled"},
+	).
+		AddRow("n1", "ipcdev", `{"type": "analysis"}`, "analysis", true, created, now, false).
+		AddRow("n2", "sarahr", "", "data", false, created, now, false).
+		AddRow("n3", "sarahr", "", "data", false, created, now, true)
+	mock.ExpectQuery("WITH expired AS \\(UPDATE notifications n SET deleted = true .* FROM expired e").
+		WithArgs(now, 10, common.PendingDeliveryStatusCanceled, common.PendingDeliveryStatusPending).
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Expire the notifications.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	notifications, canceled, err := ExpireNotifications(ctx, tx, now, 10)
+	assert.NoError(err, "unexpected error occurred while expiring notifications")
+	if assert.Len(notifications, 2) {
+		assert.Equal("ipcdev", notifications[0].User)
+		assert.Equal(`{"type": "analysis"}`, notifications[0].OutgoingMessage)
+		assert.Equal("analysis", notifications[0].NotificationType)
+		assert.True(notifications[0].Seen)
+		assert.Equal(created, notifications[0].TimeCreated)
+		if assert.NotNil(notifications[0].ExpiresAt) {
+			assert.Equal(now, *notifications[0].ExpiresAt)
+		}
+		assert.True(notifications[1].Deleted)
+		assert.False(notifications[1].Seen)
+	}
+	if assert.Len(canceled, 1) {
+		assert.Equal("n3", canceled[0].ID)
+		assert.True(canceled[0].Deleted)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
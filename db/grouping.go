@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// notSuperseded returns a condition that excludes notifications that have been superseded by newer notifications
+// with the same grouping key.
+func notSuperseded() sq.Sqlizer {
+	return sq.Eq{"n.superseded_by": nil}
+}
+
+// SupersedeNotifications marks the user's current notification with the given grouping key as superseded by a new
+// notification, and returns the notifications that were superseded. The notification type, seen and deleted flags,
+// creation time and expiration time of each returned notification are included.
+func SupersedeNotifications(
+	ctx context.Context,
+	tx *sql.Tx,
+	user, groupingKey, id string,
+) ([]*common.Notification, error) {
+	wrapMsg := fmt.Sprintf("unable to supersede notifications in group `%s` for `%s`", groupingKey, user)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Update("notifications n").
+		Set("superseded_by", id).
+		From("users u, notification_types t").
+		Where("n.user_id = u.id").
+		Where("n.notification_type_id = t.id").
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.grouping_key": groupingKey}).
+		Where(notSuperseded()).
+		Where(sq.NotEq{"n.id": id}).
+		Suffix("RETURNING " + strings.Join(changedNotificationColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	rows, err := tx.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Collect the superseded notifications.
+	superseded, err := scanChangedNotifications(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return superseded, nil
+}
+
+// ListNotificationHistory lists the notifications in the same group as the user's notification with the given ID,
+// oldest first. The list contains only the notification itself if it doesn't belong to a group. An empty list is
+// returned if the user has no notification with the given ID.
+func ListNotificationHistory(ctx context.Context, tx *sql.Tx, user, id string) ([]*common.Notification, error) {
+	wrapMsg := fmt.Sprintf("unable to list the history of notification %s for `%s`", id, user)
+
+	// Build the subquery to find the group that the notification belongs to.
+	group := sq.Select("g.grouping_key").
+		From("notifications g").
+		Where("g.user_id = n.user_id").
+		Where(sq.Eq{"g.id": id})
+
+	// Build the query.
+	query, args, err := notificationQuery().
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.deleted": false}).
+		Where(sq.Or{
+			sq.Eq{"n.id": id},
+			sq.Expr("n.grouping_key = (?)", group),
+		}).
+		OrderBy("n.time_created").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	notifications, err := queryNotifications(ctx, tx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return notifications, nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// notificationColumns lists the columns selected by notification queries.
+var notificationColumns = []string{
+	"id", "name", "username", "subject", "seen", "deleted", "time_created", "routing_key", "outgoing",
+	"grouping_key", "thread_id", "severity",
+}
+
+func TestSupersedeNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	created := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("UPDATE notifications n SET superseded_by = \\$1 FROM users u, notification_types t "+
+		"WHERE n.user_id = u.id AND n.notification_type_id = t.id .* "+
+		"AND n.superseded_by IS NULL AND n.id <> \\$4 RETURNING n.id, u.username, t.name").
+		WithArgs("n2", "ipcdev", "analysis:a1", "n2").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "username", "name", "seen", "deleted", "time_created", "expires_at"}).
+				AddRow("n1", "ipcdev", "analysis", true, false, created, nil),
+		)
+	mock.ExpectRollback()
+
+	// Supersede the notifications.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	superseded, err := SupersedeNotifications(ctx, tx, "ipcdev", "analysis:a1", "n2")
+	assert.NoError(err, "unexpected error occurred while superseding notifications")
+	if assert.Len(superseded, 1) {
+		assert.Equal("n1", superseded[0].ID)
+		assert.Equal("ipcdev", superseded[0].User)
+		assert.Equal("analysis", superseded[0].NotificationType)
+		assert.True(superseded[0].Seen)
+		assert.True(created.Equal(superseded[0].TimeCreated))
+		assert.Nil(superseded[0].ExpiresAt)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestListNotificationHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	rows := sqlmock.NewRows(notificationColumns).
+		AddRow("n1", "analysis", "ipcdev", "running", true, false, now.Add(-time.Hour), "", "", "a", "", "info").
+		AddRow("n2", "analysis", "ipcdev", "completed", false, false, now, "", "", "a", "", "info")
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notifications n .* n.grouping_key = \\(SELECT g.grouping_key FROM notifications g "+
+		"WHERE g.user_id = n.user_id AND g.id = \\$4\\)\\) ORDER BY n.time_created").
+		WithArgs("ipcdev", false, "n2", "n2").
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// List the history.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	history, err := ListNotificationHistory(ctx, tx, "ipcdev", "n2")
+	assert.NoError(err, "unexpected error occurred while listing the notification history")
+	if assert.Len(history, 2) {
+		assert.Equal("running", history[0].Subject)
+		assert.Equal("a", history[1].GroupingKey)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
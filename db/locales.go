@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// GetUserLocale returns the locale that a user prefers to receive notifications in. An empty string is returned if
+// the user hasn't chosen a locale.
+func GetUserLocale(ctx context.Context, tx *sql.Tx, user string) (string, error) {
+	wrapMsg := fmt.Sprintf("unable to get the preferred locale for `%s`", user)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("l.locale").
+		From("user_locales l").
+		Join("users u ON l.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		ToSql()
+	if err != nil {
+		return "", errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var locale string
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&locale)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, wrapMsg)
+	}
+
+	return locale, nil
+}
+
+// SetUserLocale sets the locale that a user prefers to receive notifications in.
+func SetUserLocale(ctx context.Context, tx *sql.Tx, user, locale string) error {
+	wrapMsg := fmt.Sprintf("unable to set the preferred locale for `%s`", user)
+
+	// Get the user ID.
+	userID, err := GetUserID(ctx, tx, user)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("user_locales").
+		Columns("user_id", "locale").
+		Values(userID, locale).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET locale = EXCLUDED.locale, time_updated = now()").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// DeleteUserLocale removes a user's preferred locale. It returns false if the user hadn't chosen a locale.
+func DeleteUserLocale(ctx context.Context, tx *sql.Tx, user string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to remove the preferred locale for `%s`", user)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Delete("user_locales").
+		Where("user_id = (SELECT id FROM users WHERE username = ?)", user).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return deleted > 0, nil
+}
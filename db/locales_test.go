@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUserLocale(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT l.locale FROM user_locales l JOIN users u ON l.user_id = u.id WHERE u.username = \\$1").
+		WithArgs("ipcdev").
+		WillReturnRows(sqlmock.NewRows([]string{"locale"}).AddRow("es"))
+	mock.ExpectQuery("SELECT l.locale FROM user_locales l").
+		WithArgs("sarahr").
+		WillReturnRows(sqlmock.NewRows([]string{"locale"}))
+	mock.ExpectRollback()
+
+	// Look up the locales.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	locale, err := GetUserLocale(ctx, tx, "ipcdev")
+	assert.NoError(err, "unexpected error occurred while getting the locale")
+	assert.Equal("es", locale)
+	locale, err = GetUserLocale(ctx, tx, "sarahr")
+	assert.NoError(err, "unexpected error occurred while getting a missing locale")
+	assert.Empty(locale)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestSetUserLocale(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM users WHERE username =").
+		WithArgs("ipcdev").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-id"))
+	mock.ExpectExec("INSERT INTO user_locales \\(user_id,locale\\) VALUES \\(\\$1,\\$2\\) ON CONFLICT \\(user_id\\) DO UPDATE").
+		WithArgs("user-id", "pt-br").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	// Set the locale.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	err = SetUserLocale(ctx, tx, "ipcdev", "pt-br")
+	assert.NoError(err, "unexpected error occurred while setting the locale")
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 
 	sq "github.com/Masterminds/squirrel"
@@ -61,3 +64,156 @@ func RegisterNotificationType(ctx context.Context, tx *sql.Tx, notificationType
 
 	return nil
 }
+
+// notificationTypeColumns lists the columns selected when notification types are retrieved.
+var notificationTypeColumns = []string{
+	"name",
+	"display_name",
+	"description",
+	"default_channels",
+	"default_severity",
+	"retention_days",
+	"owner",
+	"time_updated",
+}
+
+// queryNotificationTypes executes a query that selects notification types using notificationTypeColumns.
+func queryNotificationTypes(
+	ctx context.Context,
+	tx *sql.Tx,
+	builder sq.SelectBuilder,
+) ([]*common.NotificationType, error) {
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	types := make([]*common.NotificationType, 0)
+	for rows.Next() {
+		var t common.NotificationType
+		var channels pq.StringArray
+		var timeUpdated sql.NullTime
+		err = rows.Scan(
+			&t.Name, &t.DisplayName, &t.Description, &channels, &t.DefaultSeverity, &t.RetentionDays, &t.Owner,
+			&timeUpdated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.DefaultChannels = []string(channels)
+		if timeUpdated.Valid {
+			t.TimeUpdated = &timeUpdated.Time
+		}
+		types = append(types, &t)
+	}
+	return types, rows.Err()
+}
+
+// ListNotificationTypes lists the registered notification types, ordered by name.
+func ListNotificationTypes(ctx context.Context, tx *sql.Tx) ([]*common.NotificationType, error) {
+	builder := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(notificationTypeColumns...).
+		From("notification_types").
+		OrderBy("name")
+	types, err := queryNotificationTypes(ctx, tx, builder)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list the notification types")
+	}
+	return types, nil
+}
+
+// GetNotificationType retrieves a single notification type. A nil type is returned if the notification type isn't
+// registered.
+func GetNotificationType(ctx context.Context, tx *sql.Tx, name string) (*common.NotificationType, error) {
+	builder := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(notificationTypeColumns...).
+		From("notification_types").
+		Where(sq.Eq{"name": name})
+	types, err := queryNotificationTypes(ctx, tx, builder)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get the notification type, `%s`", name)
+	}
+	if len(types) == 0 {
+		return nil, nil
+	}
+	return types[0], nil
+}
+
+// SaveNotificationType registers a notification type or replaces the metadata of an existing notification type,
+// filling in the time that it was updated.
+func SaveNotificationType(ctx context.Context, tx *sql.Tx, t *common.NotificationType) error {
+	wrapMsg := fmt.Sprintf("unable to save the notification type, `%s`", t.Name)
+
+	// Build the statement.
+	channels := pq.StringArray(t.DefaultChannels)
+	if channels == nil {
+		channels = pq.StringArray{}
+	}
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("notification_types").
+		Columns(
+			"name", "display_name", "description", "default_channels", "default_severity", "retention_days", "owner",
+			"time_updated",
+		).
+		Values(
+			t.Name, t.DisplayName, t.Description, channels, t.DefaultSeverity, t.RetentionDays, t.Owner,
+			sq.Expr("now()"),
+		).
+		Suffix("ON CONFLICT (name) DO UPDATE SET " +
+			"display_name = EXCLUDED.display_name, description = EXCLUDED.description, " +
+			"default_channels = EXCLUDED.default_channels, default_severity = EXCLUDED.default_severity, " +
+			"retention_days = EXCLUDED.retention_days, owner = EXCLUDED.owner, time_updated = now() " +
+			"RETURNING time_updated").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	var timeUpdated time.Time
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&timeUpdated)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+	t.TimeUpdated = &timeUpdated
+
+	return nil
+}
+
+// DeleteNotificationType deletes a notification type. Notification types that are referenced by stored
+// notifications can't be deleted. It returns false if the notification type wasn't deleted, either because it
+// doesn't exist or because it's still in use.
+func DeleteNotificationType(ctx context.Context, tx *sql.Tx, name string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to delete the notification type, `%s`", name)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Delete("notification_types t").
+		Where(sq.Eq{"t.name": name}).
+		Where("NOT EXISTS (SELECT 1 FROM notifications n WHERE n.notification_type_id = t.id)").
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return deleted > 0, nil
+}
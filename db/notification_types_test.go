@@ -3,8 +3,10 @@ package db
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -65,3 +67,110 @@ func TestRegisterNotificationType(t *testing.T) {
 	err = mock.ExpectationsWereMet()
 	assert.NoError(err, "not all mock expectaions were met")
 }
+
+func TestListNotificationTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Now()
+	columns := []string{
+		"name", "display_name", "description", "default_channels", "default_severity", "retention_days", "owner",
+		"time_updated",
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name, display_name, .* FROM notification_types ORDER BY name").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("analysis", "Analyses", "Analysis status", "{ui,email}", "info", 30, "apps", updated).
+			AddRow("data", "", "", "{}", "", 0, "", nil))
+	mock.ExpectRollback()
+
+	// List the notification types.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	types, err := ListNotificationTypes(ctx, tx)
+	assert.NoError(err, "unexpected error occurred while listing the notification types")
+	if assert.Len(types, 2) {
+		assert.Equal([]string{"ui", "email"}, types[0].DefaultChannels)
+		assert.Equal(30, types[0].RetentionDays)
+		assert.Equal(updated, *types[0].TimeUpdated)
+		assert.Empty(types[1].DefaultChannels)
+		assert.Nil(types[1].TimeUpdated)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestSaveNotificationType(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO notification_types .* ON CONFLICT \\(name\\) DO UPDATE").
+		WithArgs("analysis", "Analyses", "", sqlmock.AnyArg(), "warning", 7, "apps").
+		WillReturnRows(sqlmock.NewRows([]string{"time_updated"}).AddRow(updated))
+	mock.ExpectRollback()
+
+	// Save the notification type.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	notificationType := &common.NotificationType{
+		Name:            "analysis",
+		DisplayName:     "Analyses",
+		DefaultChannels: []string{"ui"},
+		DefaultSeverity: "warning",
+		RetentionDays:   7,
+		Owner:           "apps",
+	}
+	err = SaveNotificationType(ctx, tx, notificationType)
+	assert.NoError(err, "unexpected error occurred while saving the notification type")
+	if assert.NotNil(notificationType.TimeUpdated) {
+		assert.Equal(updated, *notificationType.TimeUpdated)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestDeleteNotificationType(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM notification_types t WHERE t.name = \\$1 AND NOT EXISTS").
+		WithArgs("analysis").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	// Delete the notification type.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	deleted, err := DeleteNotificationType(ctx, tx, "analysis")
+	assert.NoError(err, "unexpected error occurred while deleting the notification type")
+	assert.True(deleted)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/cyverse-de/event-recorder/common"
 	"github.com/cyverse-de/messaging/v12"
@@ -13,20 +14,34 @@ import (
 	sq "github.com/Masterminds/squirrel"
 )
 
-// CountUnreadNotifications counts the number of notifications for the user that haven't been marked as read.
+// CountUnreadNotifications counts the number of notifications for the user that haven't been marked as read. Broadcasts
+// that are currently visible and haven't been dismissed by the user are included in the count. Notifications that are
+// waiting to be delivered or that have been superseded by newer notifications are not included.
 func CountUnreadNotifications(ctx context.Context, tx *sql.Tx, user string) (int64, error) {
 	wrapMsg := "unable to count unread notifications"
 	var total int64
 
-	// Build the statement to count the unread notifications.
-	statement, args, err := sq.StatementBuilder.
-		PlaceholderFormat(sq.Dollar).
-		Select("count(*)").
+	// Build the subquery to count the unread notifications.
+	notificationCount := sq.Select("count(*)").
 		From("notifications n").
 		Join("users u ON n.user_id = u.id").
 		Where(sq.Eq{"u.username": user}).
 		Where(sq.Eq{"n.deleted": false}).
 		Where(sq.Eq{"n.seen": false}).
+		Where(notPending()).
+		Where(notSuperseded())
+
+	// Build the subquery to count the broadcasts that the user hasn't dismissed yet.
+	broadcastCount := sq.Select("count(*)").
+		From("broadcasts b").
+		Where(visibleAt(time.Now())).
+		Where(notDismissedBy(user))
+
+	// Build the statement to count the unread notifications.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select().
+		Column(sq.Expr("(?) + (?)", notificationCount, broadcastCount)).
 		ToSql()
 	if err != nil {
 		return 0, errors.Wrap(err, wrapMsg)
@@ -69,7 +84,11 @@ func SaveNotification(ctx context.Context, tx *sql.Tx, notification *common.Noti
 			"deleted",
 			"time_created",
 			"incoming_json",
-			"routing_key").
+			"routing_key",
+			"expires_at",
+			"grouping_key",
+			"thread_id",
+			"severity").
 		Values(
 			notificationTypeID,
 			userID,
@@ -78,7 +97,11 @@ func SaveNotification(ctx context.Context, tx *sql.Tx, notification *common.Noti
 			notification.Deleted,
 			notification.TimeCreated,
 			notification.Message,
-			notification.RoutingKey).
+			notification.RoutingKey,
+			notification.ExpiresAt,
+			nullIfEmpty(notification.GroupingKey),
+			nullIfEmpty(notification.ThreadID),
+			severityOrDefault(notification.Severity)).
 		Suffix("RETURNING id").
 		ToSql()
 	if err != nil {
@@ -131,3 +154,278 @@ func SaveOutgoingNotification(ctx context.Context, tx *sql.Tx, outgoingNotificat
 
 	return nil
 }
+
+// GetOutgoingNotificationJSON looks up the outgoing notification JSON for the most recent notification that was
+// created from an incoming message with the given routing key and body. A nil slice is returned if no matching
+// notification is found or if the outgoing JSON hasn't been recorded.
+func GetOutgoingNotificationJSON(ctx context.Context, tx *sql.Tx, routingKey, incomingJSON string) ([]byte, error) {
+	wrapMsg := "unable to look up outgoing notification JSON"
+
+	// Build the query. The incoming JSON is compared as jsonb so that formatting differences don't matter.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("outgoing_json::text").
+		From("notifications").
+		Where(sq.Eq{"routing_key": routingKey}).
+		Where("incoming_json::jsonb = ?::jsonb", incomingJSON).
+		OrderBy("time_created DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var outgoingJSON sql.NullString
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&outgoingJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	if !outgoingJSON.Valid {
+		return nil, nil
+	}
+
+	return []byte(outgoingJSON.String), nil
+}
+
+// NotificationExists returns true if a notification created from an incoming message with the given routing key
+// and body has already been stored for a user. This is used to avoid storing duplicate notifications when a message
+// that was partially processed is redelivered.
+func NotificationExists(ctx context.Context, tx *sql.Tx, user, routingKey, incomingJSON string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to determine whether a notification exists for `%s`", user)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("count(*) > 0").
+		From("notifications n").
+		Join("users u ON n.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.routing_key": routingKey}).
+		Where("n.incoming_json::jsonb = ?::jsonb", incomingJSON).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var exists bool
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return exists, nil
+}
+
+// CloudEventNotificationExists returns true if a notification created from the CloudEvent with the given source and
+// ID has already been stored for a user. The source and ID are included in the notification request that the event
+// is converted to, and together they identify the event.
+func CloudEventNotificationExists(ctx context.Context, tx *sql.Tx, user, routingKey, source, id string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to determine whether a notification exists for `%s`", user)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("count(*) > 0").
+		From("notifications n").
+		Join("users u ON n.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.routing_key": routingKey}).
+		Where("n.incoming_json::jsonb ->> 'event_source' = ?", source).
+		Where("n.incoming_json::jsonb ->> 'event_id' = ?", id).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var exists bool
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return exists, nil
+}
+
+// changedNotificationColumns lists the columns returned by statements that change stored notifications, so that the
+// changes can be described by audit records. The statements must join the users and notification types tables as u
+// and t.
+var changedNotificationColumns = []string{
+	"n.id", "u.username", "t.name", "n.seen", "n.deleted", "n.time_created", "n.expires_at",
+}
+
+// scanChangedNotifications collects the notifications returned by a statement that changes stored notifications
+// using changedNotificationColumns.
+func scanChangedNotifications(rows *sql.Rows) ([]*common.Notification, error) {
+	notifications := make([]*common.Notification, 0)
+	for rows.Next() {
+		var notification common.Notification
+		err := rows.Scan(
+			&notification.ID,
+			&notification.User,
+			&notification.NotificationType,
+			&notification.Seen,
+			&notification.Deleted,
+			&notification.TimeCreated,
+			&notification.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &notification)
+	}
+	return notifications, rows.Err()
+}
+
+// notificationQuery returns a query builder that selects notifications along with their types and users.
+func notificationQuery() sq.SelectBuilder {
+	return sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(
+			"n.id",
+			"t.name",
+			"u.username",
+			"n.subject",
+			"n.seen",
+			"n.deleted",
+			"n.time_created",
+			"n.routing_key",
+			"COALESCE(n.outgoing_json::text, '')",
+			"COALESCE(n.grouping_key, '')",
+			"COALESCE(n.thread_id, '')",
+			"n.severity").
+		From("notifications n").
+		Join("users u ON n.user_id = u.id").
+		Join("notification_types t ON n.notification_type_id = t.id")
+}
+
+// queryNotifications executes a query built by notificationQuery.
+func queryNotifications(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]*common.Notification, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	notifications := make([]*common.Notification, 0)
+	for rows.Next() {
+		var n common.Notification
+		err = rows.Scan(
+			&n.ID,
+			&n.NotificationType,
+			&n.User,
+			&n.Subject,
+			&n.Seen,
+			&n.Deleted,
+			&n.TimeCreated,
+			&n.RoutingKey,
+			&n.OutgoingMessage,
+			&n.GroupingKey,
+			&n.ThreadID,
+			&n.Severity,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, &n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// The supported sort orders for notification listings.
+const (
+	SortByTime     = "time"
+	SortBySeverity = "severity"
+)
+
+// severityRank is the expression used to sort notifications by severity.
+const severityRank = "CASE n.severity WHEN 'critical' THEN 4 WHEN 'error' THEN 3 WHEN 'warning' THEN 2 ELSE 1 END"
+
+// orderBy returns the ORDER BY clauses for a sort order. Notifications are sorted by time, most recent first, unless
+// they're sorted by severity, in which case notifications with the same severity are sorted by time.
+func orderBy(sortOrder string) []string {
+	if sortOrder == SortBySeverity {
+		return []string{severityRank + " DESC", "n.time_created DESC"}
+	}
+	return []string{"n.time_created DESC"}
+}
+
+// severityOrDefault returns the severity to store for a notification.
+func severityOrDefault(severity string) string {
+	if severity == "" {
+		return common.SeverityInfo
+	}
+	return severity
+}
+
+// ListNotifications lists the notifications for a user that haven't been deleted in the given sort order.
+// Notifications that are waiting to be delivered or that have been superseded by newer notifications are not listed.
+func ListNotifications(
+	ctx context.Context,
+	tx *sql.Tx,
+	user string,
+	sortOrder string,
+	limit, offset uint64,
+) ([]*common.Notification, error) {
+	wrapMsg := fmt.Sprintf("unable to list notifications for `%s`", user)
+
+	// Build the query.
+	builder := notificationQuery().
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.deleted": false}).
+		Where(notPending()).
+		Where(notSuperseded()).
+		OrderBy(orderBy(sortOrder)...).
+		Offset(offset)
+	if limit > 0 {
+		builder = builder.Limit(limit)
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	notifications, err := queryNotifications(ctx, tx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return notifications, nil
+}
+
+// CountNotifications counts the number of notifications for a user that haven't been deleted. Notifications that
+// are waiting to be delivered or that have been superseded by newer notifications are not counted.
+func CountNotifications(ctx context.Context, tx *sql.Tx, user string) (int64, error) {
+	wrapMsg := fmt.Sprintf("unable to count notifications for `%s`", user)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("count(*)").
+		From("notifications n").
+		Join("users u ON n.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.deleted": false}).
+		Where(notPending()).
+		Where(notSuperseded()).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var total int64
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&total)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOutgoingNotificationJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	outgoingJSON := `{"type": "analysis"}`
+	rows := sqlmock.NewRows([]string{"outgoing_json"}).AddRow(outgoingJSON)
+	mock.ExpectQuery("SELECT outgoing_json::text FROM notifications WHERE routing_key = .* AND incoming_json::jsonb =").
+		WithArgs("events.notification.update.analysis", "{}").
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Look up the outgoing JSON.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	actual, err := GetOutgoingNotificationJSON(ctx, tx, "events.notification.update.analysis", "{}")
+	assert.NoError(err, "unexpected error occurred while looking up the outgoing JSON")
+	assert.Equal(outgoingJSON, string(actual))
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestGetOutgoingNotificationJSONNotFound(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT outgoing_json::text FROM notifications").
+		WillReturnRows(sqlmock.NewRows([]string{"outgoing_json"}))
+	mock.ExpectRollback()
+
+	// Look up the outgoing JSON.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	actual, err := GetOutgoingNotificationJSON(ctx, tx, "events.notification.update.analysis", "{}")
+	assert.NoError(err, "unexpected error occurred while looking up the outgoing JSON")
+	assert.Nil(actual)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestNotificationExists(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"exists"}).AddRow(true)
+	mock.ExpectQuery("SELECT count\\(\\*\\) > 0 FROM notifications n JOIN users u ON n.user_id = u.id").
+		WithArgs("ipcdev", "events.notification.update.analysis", "{}").
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Determine whether the notification exists.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	exists, err := NotificationExists(ctx, tx, "ipcdev", "events.notification.update.analysis", "{}")
+	assert.NoError(err, "unexpected error occurred while checking for the notification")
+	assert.True(exists)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestCloudEventNotificationExists(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"exists"}).AddRow(false)
+	mock.ExpectQuery("SELECT count\\(\\*\\) > 0 FROM notifications n JOIN users u ON n.user_id = u.id WHERE .* "+
+		"AND n.incoming_json::jsonb ->> 'event_source' = \\$3 AND n.incoming_json::jsonb ->> 'event_id' = \\$4").
+		WithArgs("ipcdev", "events.notification.update.analysis", "/apps", "e1").
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Determine whether the notification exists.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	exists, err := CloudEventNotificationExists(ctx, tx, "ipcdev", "events.notification.update.analysis", "/apps", "e1")
+	assert.NoError(err, "unexpected error occurred while checking for the notification")
+	assert.False(exists)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestCountUnreadNotifications(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations. Both notifications and broadcasts should be counted.
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"count"}).AddRow(7)
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n .*\\) \\+ \\(SELECT count\\(\\*\\) FROM broadcasts b").
+		WithArgs("ipcdev", false, false, "pending", false, sqlmock.AnyArg(), sqlmock.AnyArg(), "ipcdev").
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Count the unread notifications.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	count, err := CountUnreadNotifications(ctx, tx, "ipcdev")
+	assert.NoError(err, "unexpected error occurred while counting unread notifications")
+	assert.Equal(int64(7), count)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
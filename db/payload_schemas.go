@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// ListPayloadSchemas lists the payload schemas stored in the database, ordered by notification type.
+func ListPayloadSchemas(ctx context.Context, tx *sql.Tx) ([]*common.PayloadSchema, error) {
+	wrapMsg := "unable to list the payload schemas"
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("notification_type", "schema", "time_updated").
+		From("payload_schemas").
+		OrderBy("notification_type").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Load the schemas.
+	schemas := make([]*common.PayloadSchema, 0)
+	for rows.Next() {
+		var ps common.PayloadSchema
+		err = rows.Scan(&ps.NotificationType, &ps.Schema, &ps.TimeUpdated)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		schemas = append(schemas, &ps)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return schemas, nil
+}
+
+// GetPayloadSchema returns the payload schema for a notification type. A nil schema is returned if there's no schema
+// for the notification type.
+func GetPayloadSchema(ctx context.Context, tx *sql.Tx, notificationType string) (*common.PayloadSchema, error) {
+	wrapMsg := fmt.Sprintf("unable to get the %s payload schema", notificationType)
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("notification_type", "schema", "time_updated").
+		From("payload_schemas").
+		Where(sq.Eq{"notification_type": notificationType}).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var ps common.PayloadSchema
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&ps.NotificationType, &ps.Schema, &ps.TimeUpdated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return &ps, nil
+}
+
+// SavePayloadSchema adds or replaces the payload schema for a notification type, filling in the time that it was
+// updated.
+func SavePayloadSchema(ctx context.Context, tx *sql.Tx, ps *common.PayloadSchema) error {
+	wrapMsg := fmt.Sprintf("unable to save the %s payload schema", ps.NotificationType)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("payload_schemas").
+		Columns("notification_type", "schema").
+		Values(ps.NotificationType, string(ps.Schema)).
+		Suffix("ON CONFLICT (notification_type) DO UPDATE " +
+			"SET schema = EXCLUDED.schema, time_updated = now() " +
+			"RETURNING time_updated").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&ps.TimeUpdated)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// DeletePayloadSchema deletes the payload schema for a notification type. It returns false if there was no such
+// schema.
+func DeletePayloadSchema(ctx context.Context, tx *sql.Tx, notificationType string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to delete the %s payload schema", notificationType)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Delete("payload_schemas").
+		Where(sq.Eq{"notification_type": notificationType}).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return deleted > 0, nil
+}
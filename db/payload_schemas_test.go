@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSavePayloadSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO payload_schemas \\(notification_type,schema\\) .* "+
+		"ON CONFLICT \\(notification_type\\) DO UPDATE").
+		WithArgs("analysis", `{"type": "object"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"time_updated"}).AddRow(updated))
+	mock.ExpectRollback()
+
+	// Save the schema.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	ps := &common.PayloadSchema{NotificationType: "analysis", Schema: []byte(`{"type": "object"}`)}
+	err = SavePayloadSchema(ctx, tx, ps)
+	assert.NoError(err, "unexpected error occurred while saving the schema")
+	assert.Equal(updated, ps.TimeUpdated)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestGetPayloadSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Now()
+	columns := []string{"notification_type", "schema", "time_updated"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT notification_type, schema, time_updated FROM payload_schemas " +
+		"WHERE notification_type = \\$1").
+		WithArgs("analysis").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("analysis", []byte(`{"type": "object"}`), updated))
+	mock.ExpectQuery("SELECT notification_type, schema, time_updated FROM payload_schemas").
+		WithArgs("data").
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectRollback()
+
+	// Get a schema that exists.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	ps, err := GetPayloadSchema(ctx, tx, "analysis")
+	assert.NoError(err, "unexpected error occurred while getting the schema")
+	if assert.NotNil(ps) {
+		assert.JSONEq(`{"type": "object"}`, string(ps.Schema))
+	}
+
+	// Get a schema that doesn't exist.
+	ps, err = GetPayloadSchema(ctx, tx, "data")
+	assert.NoError(err, "unexpected error occurred while getting the schema")
+	assert.Nil(ps)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestDeletePayloadSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM payload_schemas WHERE notification_type = \\$1").
+		WithArgs("analysis").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Delete a schema that doesn't exist.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	deleted, err := DeletePayloadSchema(ctx, tx, "analysis")
+	assert.NoError(err, "unexpected error occurred while deleting the schema")
+	assert.False(deleted)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// notPending returns a condition that excludes notifications that are waiting to be delivered.
+func notPending() sq.Sqlizer {
+	return sq.Expr(
+		"NOT EXISTS (SELECT 1 FROM pending_deliveries p WHERE p.notification_id = n.id AND p.status = ?)",
+		common.PendingDeliveryStatusPending,
+	)
+}
+
+// nullIfEmpty returns nil if a string is empty so that an empty string can be stored as NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// SchedulePendingDelivery records a notification that should be delivered at a later time.
+func SchedulePendingDelivery(ctx context.Context, tx *sql.Tx, delivery *common.PendingDelivery) error {
+	wrapMsg := fmt.Sprintf("unable to schedule the delivery of notification %s", delivery.NotificationID)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("pending_deliveries").
+		Columns("schedule_id", "notification_id", "deliver_at", "email_request").
+		Values(delivery.ScheduleID, delivery.NotificationID, delivery.DeliverAt, nullIfEmpty(delivery.EmailRequest)).
+		Suffix("RETURNING id, status").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&delivery.ID, &delivery.Status)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// pendingDeliveryQuery returns a query builder that selects pending deliveries along with the information needed to
+// deliver them.
+func pendingDeliveryQuery() sq.SelectBuilder {
+	return sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select(
+			"p.id",
+			"p.schedule_id",
+			"p.notification_id",
+			"u.username",
+			"p.deliver_at",
+			"p.status",
+			"COALESCE(p.email_request::text, '')",
+			"COALESCE(n.outgoing_json::text, '')").
+		From("pending_deliveries p").
+		Join("notifications n ON p.notification_id = n.id").
+		Join("users u ON n.user_id = u.id")
+}
+
+// queryPendingDeliveries executes a query built by pendingDeliveryQuery.
+func queryPendingDeliveries(ctx context.Context, tx *sql.Tx, builder sq.SelectBuilder) ([]*common.PendingDelivery, error) {
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	deliveries := make([]*common.PendingDelivery, 0)
+	for rows.Next() {
+		var d common.PendingDelivery
+		err = rows.Scan(
+			&d.ID,
+			&d.ScheduleID,
+			&d.NotificationID,
+			&d.User,
+			&d.DeliverAt,
+			&d.Status,
+			&d.EmailRequest,
+			&d.OutgoingMessage,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// ClaimDuePendingDeliveries returns up to `limit` pending deliveries that are due at the given time. The rows are
+// locked until the transaction ends, and rows that are already locked by another transaction are skipped.
+func ClaimDuePendingDeliveries(ctx context.Context, tx *sql.Tx, at time.Time, limit uint64) ([]*common.PendingDelivery, error) {
+	builder := pendingDeliveryQuery().
+		Where(sq.Eq{"p.status": common.PendingDeliveryStatusPending}).
+		Where(sq.LtOrEq{"p.deliver_at": at}).
+		OrderBy("p.deliver_at").
+		Limit(limit).
+		Suffix("FOR UPDATE OF p SKIP LOCKED")
+	deliveries, err := queryPendingDeliveries(ctx, tx, builder)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to claim due pending deliveries")
+	}
+	return deliveries, nil
+}
+
+// ListPendingDeliveries lists the deliveries that are still pending. If a user is specified, only deliveries for
+// that user are listed.
+func ListPendingDeliveries(ctx context.Context, tx *sql.Tx, user string) ([]*common.PendingDelivery, error) {
+	builder := pendingDeliveryQuery().
+		Where(sq.Eq{"p.status": common.PendingDeliveryStatusPending}).
+		OrderBy("p.deliver_at")
+	if user != "" {
+		builder = builder.Where(sq.Eq{"u.username": user})
+	}
+	deliveries, err := queryPendingDeliveries(ctx, tx, builder)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list pending deliveries")
+	}
+	return deliveries, nil
+}
+
+// SetPendingDeliveryStatus updates the status of a pending delivery.
+func SetPendingDeliveryStatus(ctx context.Context, tx *sql.Tx, id, status string) error {
+	wrapMsg := fmt.Sprintf("unable to update the status of pending delivery %s", id)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Update("pending_deliveries").
+		Set("status", status).
+		Set("time_updated", sq.Expr("now()")).
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	_, err = tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// CancelPendingDeliveries cancels all of the pending deliveries with the given schedule ID and marks the associated
+// notifications as deleted so that they never become visible. It returns the notifications that were deleted. The
+// notification type, seen and deleted flags, creation time and expiration time of each notification are included.
+func CancelPendingDeliveries(ctx context.Context, tx *sql.Tx, scheduleID string) ([]*common.Notification, error) {
+	wrapMsg := fmt.Sprintf("unable to cancel pending deliveries for schedule %s", scheduleID)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Update("notifications n").
+		Prefix(
+			"WITH canceled AS (UPDATE pending_deliveries SET status = ?, time_updated = now() "+
+				"WHERE schedule_id = ? AND status = ? RETURNING notification_id)",
+			common.PendingDeliveryStatusCanceled,
+			scheduleID,
+			common.PendingDeliveryStatusPending,
+		).
+		Set("deleted", true).
+		From("users u, notification_types t").
+		Where("n.user_id = u.id").
+		Where("n.notification_type_id = t.id").
+		Where("n.id IN (SELECT notification_id FROM canceled)").
+		Suffix("RETURNING " + strings.Join(changedNotificationColumns, ", ")).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	rows, err := tx.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Collect the deleted notifications.
+	deleted, err := scanChangedNotifications(rows)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedulePendingDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	testID := "5b1c9e3a-1d2f-11eb-9a8b-62f4bd4ba6d0"
+	deliverAt := time.Now().Add(time.Hour)
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"id", "status"}).AddRow(testID, common.PendingDeliveryStatusPending)
+	mock.ExpectQuery("INSERT INTO pending_deliveries \\(schedule_id,notification_id,deliver_at,email_request\\)").
+		WithArgs("s1", "n1", deliverAt, nil).
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Schedule the delivery.
+	delivery := &common.PendingDelivery{ScheduleID: "s1", NotificationID: "n1", DeliverAt: deliverAt}
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	err = SchedulePendingDelivery(ctx, tx, delivery)
+	assert.NoError(err, "unexpected error occurred while scheduling the delivery")
+	assert.Equal(testID, delivery.ID)
+	assert.Equal(common.PendingDeliveryStatusPending, delivery.Status)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestClaimDuePendingDeliveries(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows(
+		[]string{"id", "schedule_id", "notification_id", "username", "deliver_at", "status", "email", "outgoing"},
+	).AddRow("p1", "s1", "n1", "ipcdev", now, common.PendingDeliveryStatusPending, "", `{"type": "analysis"}`)
+	mock.ExpectQuery("SELECT .* FROM pending_deliveries p .* LIMIT 10 FOR UPDATE OF p SKIP LOCKED").
+		WithArgs(common.PendingDeliveryStatusPending, now).
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Claim the deliveries.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	deliveries, err := ClaimDuePendingDeliveries(ctx, tx, now, 10)
+	assert.NoError(err, "unexpected error occurred while claiming deliveries")
+	if assert.Len(deliveries, 1) {
+		assert.Equal("ipcdev", deliveries[0].User)
+		assert.Equal(`{"type": "analysis"}`, deliveries[0].OutgoingMessage)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestCancelPendingDeliveries(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	created := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "username", "name", "seen", "deleted", "time_created", "expires_at"}).
+		AddRow("n1", "ipcdev", "analysis", false, true, created, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH canceled AS \\(UPDATE pending_deliveries .* RETURNING notification_id\\) "+
+		"UPDATE notifications n SET deleted = \\$4 FROM users u, notification_types t .* "+
+		"RETURNING n.id, u.username, t.name").
+		WithArgs(common.PendingDeliveryStatusCanceled, "s1", common.PendingDeliveryStatusPending, true).
+		WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	// Cancel the deliveries.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	deleted, err := CancelPendingDeliveries(ctx, tx, "s1")
+	assert.NoError(err, "unexpected error occurred while canceling the deliveries")
+	if assert.Len(deleted, 1) {
+		assert.Equal("n1", deleted[0].ID)
+		assert.Equal("ipcdev", deleted[0].User)
+		assert.Equal("analysis", deleted[0].NotificationType)
+		assert.True(deleted[0].Deleted)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
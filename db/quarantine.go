@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// QuarantineEvent stores an incoming event that was set aside, filling in the time that it was received.
+func QuarantineEvent(ctx context.Context, tx *sql.Tx, event *common.QuarantinedEvent) error {
+	wrapMsg := fmt.Sprintf("unable to quarantine the %s event", event.NotificationType)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("quarantined_events").
+		Columns("id", "notification_type", "routing_key", "body", "reason").
+		Values(event.ID, event.NotificationType, event.RoutingKey, event.Body, event.Reason).
+		Suffix("RETURNING time_received").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&event.TimeReceived)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// ListQuarantinedEvents lists the quarantined events, optionally limited to a single notification type, from the
+// most recently received to the least recently received.
+func ListQuarantinedEvents(ctx context.Context, tx *sql.Tx, notificationType string) ([]*common.QuarantinedEvent, error) {
+	wrapMsg := "unable to list the quarantined events"
+
+	// Build the query.
+	builder := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("id", "notification_type", "routing_key", "body", "reason", "time_received").
+		From("quarantined_events").
+		OrderBy("time_received DESC")
+	if notificationType != "" {
+		builder = builder.Where(sq.Eq{"notification_type": notificationType})
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Load the events.
+	events := make([]*common.QuarantinedEvent, 0)
+	for rows.Next() {
+		var e common.QuarantinedEvent
+		err = rows.Scan(&e.ID, &e.NotificationType, &e.RoutingKey, &e.Body, &e.Reason, &e.TimeReceived)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		events = append(events, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return events, nil
+}
+
+// DeleteQuarantinedEvent deletes a quarantined event. It returns false if there was no such event.
+func DeleteQuarantinedEvent(ctx context.Context, tx *sql.Tx, id string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to delete quarantined event %s", id)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Delete("quarantined_events").
+		Where(sq.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return deleted > 0, nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantineEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	received := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO quarantined_events \\(id,notification_type,routing_key,body,reason\\)").
+		WithArgs("id", "widget", "events.notification.update.widget", "{}", "unknown").
+		WillReturnRows(sqlmock.NewRows([]string{"time_received"}).AddRow(received))
+	mock.ExpectRollback()
+
+	// Quarantine the event.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	event := &common.QuarantinedEvent{
+		ID:               "id",
+		NotificationType: "widget",
+		RoutingKey:       "events.notification.update.widget",
+		Body:             "{}",
+		Reason:           "unknown",
+	}
+	err = QuarantineEvent(ctx, tx, event)
+	assert.NoError(err, "unexpected error occurred while quarantining the event")
+	assert.Equal(received, event.TimeReceived)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestListQuarantinedEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	columns := []string{"id", "notification_type", "routing_key", "body", "reason", "time_received"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM quarantined_events WHERE notification_type = \\$1 ORDER BY time_received DESC").
+		WithArgs("widget").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("id", "widget", "key", "{}", "unknown", time.Now()))
+	mock.ExpectRollback()
+
+	// List the events.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	events, err := ListQuarantinedEvents(ctx, tx, "widget")
+	assert.NoError(err, "unexpected error occurred while listing the quarantined events")
+	if assert.Len(events, 1) {
+		assert.Equal("id", events[0].ID)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// ListNotificationTemplates lists the notification templates stored in the database, ordered by notification type
+// and locale.
+func ListNotificationTemplates(ctx context.Context, tx *sql.Tx) ([]*common.NotificationTemplate, error) {
+	wrapMsg := "unable to list the notification templates"
+
+	// Build the query.
+	query, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select("notification_type", "locale", "subject", "body", "time_updated").
+		From("notification_templates").
+		OrderBy("notification_type", "locale").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Load the templates.
+	templates := make([]*common.NotificationTemplate, 0)
+	for rows.Next() {
+		var t common.NotificationTemplate
+		err = rows.Scan(&t.NotificationType, &t.Locale, &t.Subject, &t.Body, &t.TimeUpdated)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		templates = append(templates, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return templates, nil
+}
+
+// SaveNotificationTemplate adds or replaces the notification template for a notification type and locale, filling
+// in the time that it was updated.
+func SaveNotificationTemplate(ctx context.Context, tx *sql.Tx, t *common.NotificationTemplate) error {
+	wrapMsg := fmt.Sprintf("unable to save the %s notification template for locale %s", t.NotificationType, t.Locale)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Insert("notification_templates").
+		Columns("notification_type", "locale", "subject", "body").
+		Values(t.NotificationType, t.Locale, t.Subject, t.Body).
+		Suffix("ON CONFLICT (notification_type, locale) DO UPDATE " +
+			"SET subject = EXCLUDED.subject, body = EXCLUDED.body, time_updated = now() " +
+			"RETURNING time_updated").
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	err = tx.QueryRowContext(ctx, statement, args...).Scan(&t.TimeUpdated)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// DeleteNotificationTemplate deletes the notification template for a notification type and locale. It returns
+// false if there was no such template.
+func DeleteNotificationTemplate(ctx context.Context, tx *sql.Tx, notificationType, locale string) (bool, error) {
+	wrapMsg := fmt.Sprintf("unable to delete the %s notification template for locale %s", notificationType, locale)
+
+	// Build the statement.
+	statement, args, err := sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Delete("notification_templates").
+		Where(sq.Eq{"notification_type": notificationType}).
+		Where(sq.Eq{"locale": locale}).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	// Execute the statement.
+	result, err := tx.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, wrapMsg)
+	}
+
+	return deleted > 0, nil
+}
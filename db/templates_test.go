@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveNotificationTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO notification_templates \\(notification_type,locale,subject,body\\) .* "+
+		"ON CONFLICT \\(notification_type, locale\\) DO UPDATE").
+		WithArgs("analysis", "en", "subject", "body").
+		WillReturnRows(sqlmock.NewRows([]string{"time_updated"}).AddRow(updated))
+	mock.ExpectRollback()
+
+	// Save the template.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	template := &common.NotificationTemplate{
+		NotificationType: "analysis",
+		Locale:           "en",
+		Subject:          "subject",
+		Body:             "body",
+	}
+	err = SaveNotificationTemplate(ctx, tx, template)
+	assert.NoError(err, "unexpected error occurred while saving the template")
+	assert.Equal(updated, template.TimeUpdated)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestListNotificationTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Now()
+	columns := []string{"notification_type", "locale", "subject", "body", "time_updated"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT notification_type, locale, subject, body, time_updated FROM notification_templates " +
+		"ORDER BY notification_type, locale").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("analysis", "en", "subject", "", updated).
+			AddRow("analysis", "es", "asunto", "", updated))
+	mock.ExpectRollback()
+
+	// List the templates.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	templates, err := ListNotificationTemplates(ctx, tx)
+	assert.NoError(err, "unexpected error occurred while listing the templates")
+	if assert.Len(templates, 2) {
+		assert.Equal("es", templates[1].Locale)
+		assert.Equal("asunto", templates[1].Subject)
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestDeleteNotificationTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM notification_templates WHERE notification_type = \\$1 AND locale = \\$2").
+		WithArgs("analysis", "en").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	// Delete the template.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	deleted, err := DeleteNotificationTemplate(ctx, tx, "analysis", "en")
+	assert.NoError(err, "unexpected error occurred while deleting the template")
+	assert.True(deleted)
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
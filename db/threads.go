@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// threadKey is the expression used to identify the thread that a notification belongs to. Notifications without a
+// thread ID are treated as threads of their own.
+const threadKey = "COALESCE(n.thread_id, n.id::text)"
+
+// visibleNotifications returns a query builder that selects from the notifications that are currently visible to a
+// user, without selecting any columns.
+func visibleNotifications(user string) sq.SelectBuilder {
+	return sq.StatementBuilder.
+		PlaceholderFormat(sq.Dollar).
+		Select().
+		From("notifications n").
+		Join("users u ON n.user_id = u.id").
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.deleted": false}).
+		Where(notPending()).
+		Where(notSuperseded())
+}
+
+// ListThreads lists a user's notification threads, most recently active first. Each thread includes the number of
+// notifications in the thread, the number of unread notifications in the thread, and the most recent notification.
+func ListThreads(ctx context.Context, tx *sql.Tx, user string, limit, offset uint64) ([]*common.Thread, error) {
+	wrapMsg := fmt.Sprintf("unable to list notification threads for `%s`", user)
+
+	// Build the query.
+	builder := visibleNotifications(user).
+		Columns(
+			threadKey,
+			"count(*)",
+			"count(*) FILTER (WHERE NOT n.seen)",
+			"max(n.time_created)",
+			"(array_agg(n.id ORDER BY n.time_created DESC))[1]").
+		GroupBy(threadKey).
+		OrderBy("max(n.time_created) DESC").
+		Offset(offset)
+	if limit > 0 {
+		builder = builder.Limit(limit)
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Load the thread summaries.
+	threads := make([]*common.Thread, 0)
+	latestIDs := make([]string, 0)
+	for rows.Next() {
+		var thread common.Thread
+		var latestID string
+		err = rows.Scan(&thread.ID, &thread.Total, &thread.Unread, &thread.LastActivity, &latestID)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		threads = append(threads, &thread)
+		latestIDs = append(latestIDs, latestID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	if len(threads) == 0 {
+		return threads, nil
+	}
+
+	// Load the most recent notification in each thread.
+	query, args, err = notificationQuery().Where(sq.Eq{"n.id": latestIDs}).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	notifications, err := queryNotifications(ctx, tx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	notificationByID := make(map[string]*common.Notification, len(notifications))
+	for _, n := range notifications {
+		notificationByID[n.ID] = n
+	}
+	for i, thread := range threads {
+		thread.Latest = notificationByID[latestIDs[i]]
+	}
+
+	return threads, nil
+}
+
+// CountThreads counts the number of notification threads for a user.
+func CountThreads(ctx context.Context, tx *sql.Tx, user string) (int64, error) {
+	wrapMsg := fmt.Sprintf("unable to count notification threads for `%s`", user)
+
+	// Build the query.
+	query, args, err := visibleNotifications(user).
+		Columns(fmt.Sprintf("count(DISTINCT %s)", threadKey)).
+		ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	var total int64
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&total)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	return total, nil
+}
+
+// ListThreadNotifications lists the notifications in one of a user's threads, oldest first.
+func ListThreadNotifications(ctx context.Context, tx *sql.Tx, user, threadID string) ([]*common.Notification, error) {
+	wrapMsg := fmt.Sprintf("unable to list the notifications in thread `%s` for `%s`", threadID, user)
+
+	// Build the query.
+	query, args, err := notificationQuery().
+		Where(sq.Eq{"u.username": user}).
+		Where(sq.Eq{"n.deleted": false}).
+		Where(notPending()).
+		Where(notSuperseded()).
+		Where(threadKey+" = ?", threadID).
+		OrderBy("n.time_created").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Query the database.
+	notifications, err := queryNotifications(ctx, tx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return notifications, nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListThreads(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	mock.ExpectBegin()
+	summaries := sqlmock.NewRows([]string{"thread", "total", "unread", "last_activity", "latest"}).
+		AddRow("analysis:a1", 3, 1, now, "n3").
+		AddRow("n4", 1, 0, now.Add(-time.Hour), "n4")
+	mock.ExpectQuery("SELECT COALESCE\\(n.thread_id, n.id::text\\), count\\(\\*\\), .* "+
+		"GROUP BY COALESCE\\(n.thread_id, n.id::text\\) ORDER BY max\\(n.time_created\\) DESC LIMIT 10 OFFSET 0").
+		WithArgs("ipcdev", false, common.PendingDeliveryStatusPending).
+		WillReturnRows(summaries)
+	latest := sqlmock.NewRows(notificationColumns).
+		AddRow("n4", "data", "ipcdev", "uploaded", true, false, now.Add(-time.Hour), "", "", "", "", "info").
+		AddRow("n3", "analysis", "ipcdev", "completed", false, false, now, "", "", "", "analysis:a1", "error")
+	mock.ExpectQuery("SELECT .* FROM notifications n .* WHERE n.id IN \\(\\$1,\\$2\\)").
+		WithArgs("n3", "n4").
+		WillReturnRows(latest)
+	mock.ExpectRollback()
+
+	// List the threads.
+	tx, err := db.Begin()
+	assert.NoError(err, "unable to begin a transaction")
+	threads, err := ListThreads(ctx, tx, "ipcdev", 10, 0)
+	assert.NoError(err, "unexpected error occurred while listing threads")
+	if assert.Len(threads, 2) {
+		assert.Equal("analysis:a1", threads[0].ID)
+		assert.Equal(int64(3), threads[0].Total)
+		assert.Equal(int64(1), threads[0].Unread)
+		if assert.NotNil(threads[0].Latest) {
+			assert.Equal("completed", threads[0].Latest.Subject)
+		}
+		if assert.NotNil(threads[1].Latest) {
+			assert.Equal("n4", threads[1].Latest.ID)
+		}
+	}
+	_ = tx.Rollback()
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
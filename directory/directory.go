@@ -0,0 +1,72 @@
+// Package directory provides a way to resolve named groups of users so that events can be addressed to
+// a group rather than to individual users.
+package directory
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// GroupNotFoundError is returned when a group doesn't exist in the directory.
+type GroupNotFoundError struct {
+	Group string
+}
+
+// Error returns the error message for a GroupNotFoundError.
+func (e GroupNotFoundError) Error() string {
+	return fmt.Sprintf("group not found: %s", e.Group)
+}
+
+// Directory describes the interface used to look up the members of a named group.
+type Directory interface {
+	GroupMembers(ctx context.Context, group string) ([]string, error)
+}
+
+// FileDirectory is a Directory whose groups are defined in a YAML file. The file contains a single map
+// from group name to a list of usernames:
+//
+//	groups:
+//	  de-admins:
+//	    - ipcdev
+//	    - sarahr
+type FileDirectory struct {
+	groups map[string][]string
+}
+
+// NewFileDirectory loads a group directory from a YAML file.
+func NewFileDirectory(path string) (*FileDirectory, error) {
+	wrapMsg := fmt.Sprintf("unable to load the group directory from %s", path)
+
+	// Read the file.
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Parse the file contents.
+	var file struct {
+		Groups map[string][]string `yaml:"groups"`
+	}
+	err = yaml.Unmarshal(contents, &file)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	if file.Groups == nil {
+		file.Groups = make(map[string][]string)
+	}
+
+	return &FileDirectory{groups: file.Groups}, nil
+}
+
+// GroupMembers returns the usernames of the members of a group.
+func (d *FileDirectory) GroupMembers(_ context.Context, group string) ([]string, error) {
+	members, ok := d.groups[group]
+	if !ok {
+		return nil, GroupNotFoundError{Group: group}
+	}
+	return append([]string(nil), members...), nil
+}
@@ -0,0 +1,58 @@
+package directory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestDirectory writes a directory file to a temporary directory and returns its path.
+func writeTestDirectory(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "groups.yml")
+	err := os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the directory file: %s", err.Error())
+	}
+	return path
+}
+
+func TestFileDirectory(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// Load the directory.
+	path := writeTestDirectory(t, "groups:\n  de-admins:\n    - ipcdev\n    - sarahr\n  empty: []\n")
+	dir, err := NewFileDirectory(path)
+	if err != nil {
+		t.Fatalf("unable to load the directory: %s", err.Error())
+	}
+
+	// Look up a group with members.
+	members, err := dir.GroupMembers(ctx, "de-admins")
+	assert.NoError(err)
+	assert.Equal([]string{"ipcdev", "sarahr"}, members)
+
+	// Look up a group without members.
+	members, err = dir.GroupMembers(ctx, "empty")
+	assert.NoError(err)
+	assert.Empty(members)
+
+	// Look up a group that doesn't exist.
+	_, err = dir.GroupMembers(ctx, "nobody")
+	assert.Equal(GroupNotFoundError{Group: "nobody"}, err)
+}
+
+func TestFileDirectoryErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	// The file must exist.
+	_, err := NewFileDirectory(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(err)
+
+	// The file must be valid YAML.
+	_, err = NewFileDirectory(writeTestDirectory(t, "groups: ["))
+	assert.Error(err)
+}
@@ -0,0 +1,132 @@
+// Package email sends the email requests generated by the service directly, rather than publishing them for the
+// separate DE email service. This is useful for small deployments that don't run the email service.
+package email
+
+import (
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "email"})
+
+// Sender sends email requests.
+type Sender interface {
+	Send(ctx context.Context, request *messaging.EmailRequest) error
+}
+
+// permanentError indicates that an email message can never be sent, so retrying it would be pointless.
+type permanentError struct {
+	error
+}
+
+// Unwrap returns the underlying error.
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// IsPermanent returns true if an error returned by a sender indicates that the message can never be sent, either
+// because it can't be rendered or because the mail server rejected it permanently.
+func IsPermanent(err error) bool {
+	var pe permanentError
+	if errors.As(err, &pe) {
+		return true
+	}
+	var te *textproto.Error
+	return errors.As(err, &te) && te.Code >= 500
+}
+
+// Rendered contains the plain text and HTML bodies of an email message. Either body may be empty, but not both.
+type Rendered struct {
+	Text string
+	HTML string
+}
+
+// Templates renders the bodies of email messages from the templates in a directory. Each email template consists of
+// a plain text template named `<template>.txt`, an HTML template named `<template>.html`, or both. The plain text
+// templates use text/template and the HTML templates use html/template, so values are escaped automatically in HTML
+// messages. Both are executed with the email request's template values as their data.
+type Templates struct {
+	text map[string]*texttemplate.Template
+	html map[string]*htmltemplate.Template
+}
+
+// LoadTemplates loads the email templates from a directory.
+func LoadTemplates(dir string) (*Templates, error) {
+	wrapMsg := fmt.Sprintf("unable to load the email templates from %s", dir)
+
+	// List the files in the directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Parse each template.
+	t := &Templates{
+		text: make(map[string]*texttemplate.Template),
+		html: make(map[string]*htmltemplate.Template),
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		path := filepath.Join(dir, entry.Name())
+		switch ext {
+		case ".txt":
+			t.text[name], err = texttemplate.New(entry.Name()).Option("missingkey=zero").ParseFiles(path)
+		case ".html":
+			t.html[name], err = htmltemplate.New(entry.Name()).Option("missingkey=zero").ParseFiles(path)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+	}
+
+	return t, nil
+}
+
+// Render renders the bodies of the email message for an email request.
+func (t *Templates) Render(request *messaging.EmailRequest) (*Rendered, error) {
+	wrapMsg := fmt.Sprintf("unable to render the %s email template", request.TemplateName)
+
+	textTemplate, hasText := t.text[request.TemplateName]
+	htmlTemplate, hasHTML := t.html[request.TemplateName]
+	if !hasText && !hasHTML {
+		return nil, fmt.Errorf("%s: template not found", wrapMsg)
+	}
+
+	// Render the bodies.
+	var rendered Rendered
+	if hasText {
+		var buf strings.Builder
+		err := textTemplate.Execute(&buf, request.TemplateValues)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		rendered.Text = buf.String()
+	}
+	if hasHTML {
+		var buf strings.Builder
+		err := htmlTemplate.Execute(&buf, request.TemplateValues)
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		rendered.HTML = buf.String()
+	}
+
+	return &rendered, nil
+}
@@ -0,0 +1,74 @@
+package email
+
+import (
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTemplates writes email templates to a temporary directory and returns the path to the directory.
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+	return dir
+}
+
+func TestRender(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := writeTemplates(t, map[string]string{
+		"analysis.txt":  "Analysis {{.name}} is {{.status}}.",
+		"analysis.html": "<p>Analysis {{.name}} is {{.status}}.</p>",
+		"text_only.txt": "Hello, {{.user}}.",
+		"README.md":     "ignored",
+	})
+	templates, err := LoadTemplates(dir)
+	require.NoError(t, err)
+
+	// Both bodies should be rendered, with values escaped in the HTML body.
+	rendered, err := templates.Render(&messaging.EmailRequest{
+		TemplateName:   "analysis",
+		TemplateValues: map[string]interface{}{"name": "<script>", "status": "Completed"},
+	})
+	require.NoError(t, err)
+	assert.Equal("Analysis <script> is Completed.", rendered.Text)
+	assert.Equal("<p>Analysis &lt;script&gt; is Completed.</p>", rendered.HTML)
+
+	// Templates with only one body should be rendered.
+	rendered, err = templates.Render(&messaging.EmailRequest{
+		TemplateName:   "text_only",
+		TemplateValues: map[string]interface{}{"user": "ipcdev"},
+	})
+	require.NoError(t, err)
+	assert.Equal("Hello, ipcdev.", rendered.Text)
+	assert.Empty(rendered.HTML)
+
+	// Unknown templates should be rejected.
+	_, err = templates.Render(&messaging.EmailRequest{TemplateName: "README"})
+	assert.Error(err)
+}
+
+func TestLoadTemplatesInvalid(t *testing.T) {
+	_, err := LoadTemplates(writeTemplates(t, map[string]string{"broken.txt": "{{.name"}))
+	assert.Error(t, err)
+
+	_, err = LoadTemplates(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestIsPermanent(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(IsPermanent(permanentError{errors.New("unable to render")}))
+	assert.True(IsPermanent(errors.Wrap(&textproto.Error{Code: 550, Msg: "no such user"}, "unable to send")))
+	assert.False(IsPermanent(errors.Wrap(&textproto.Error{Code: 451, Msg: "try again"}, "unable to send")))
+	assert.False(IsPermanent(errors.New("connection refused")))
+}
@@ -0,0 +1,291 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/unsubscribe"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// The supported ways of securing connections to the SMTP server.
+const (
+	TLSModeNone     = "none"
+	TLSModeStartTLS = "starttls"
+	TLSModeImplicit = "tls"
+)
+
+// SMTPSettings contains the settings used to connect to an SMTP server. The from address and name are used for email
+// requests that don't specify a sender. If a username is specified, the sender authenticates using PLAIN
+// authentication, which is only permitted over TLS or to the local host.
+type SMTPSettings struct {
+	Host        string
+	Port        int
+	Username    string
+	Password    string
+	FromAddress string
+	FromName    string
+	TLSMode     string
+	Timeout     time.Duration
+}
+
+// SMTPSender sends email messages using an SMTP server.
+type SMTPSender struct {
+	settings  SMTPSettings
+	templates *Templates
+}
+
+// NewSMTPSender returns a new sender that renders email messages using the given templates and sends them using an
+// SMTP server.
+func NewSMTPSender(settings *SMTPSettings, templates *Templates) (*SMTPSender, error) {
+	wrapMsg := "invalid SMTP settings"
+
+	if settings.Host == "" {
+		return nil, fmt.Errorf("%s: no host specified", wrapMsg)
+	}
+	if settings.Port <= 0 {
+		return nil, fmt.Errorf("%s: invalid port: %d", wrapMsg, settings.Port)
+	}
+	if _, err := mail.ParseAddress(settings.FromAddress); err != nil {
+		return nil, errors.Wrapf(err, "%s: invalid from address", wrapMsg)
+	}
+	switch settings.TLSMode {
+	case TLSModeNone, TLSModeStartTLS, TLSModeImplicit:
+	default:
+		return nil, fmt.Errorf("%s: unsupported TLS mode: %s", wrapMsg, settings.TLSMode)
+	}
+
+	return &SMTPSender{settings: *settings, templates: templates}, nil
+}
+
+// sender returns the address that an email message is sent from.
+func (s *SMTPSender) sender(request *messaging.EmailRequest) *mail.Address {
+	if request.FromAddress != "" {
+		return &mail.Address{Name: request.FromName, Address: request.FromAddress}
+	}
+	return &mail.Address{Name: s.settings.FromName, Address: s.settings.FromAddress}
+}
+
+// validateAddress returns an error if an address can't be used as the sender or recipient of a message. The SMTP
+// client rejects addresses that contain line breaks without contacting the server, so the addresses are validated
+// before connecting to distinguish invalid addresses from connection failures.
+func validateAddress(address string) error {
+	if strings.ContainsAny(address, "\r\n") {
+		return fmt.Errorf("invalid address %q: addresses must not contain line breaks", address)
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid address %q", address)
+	}
+	if parsed.Address != address {
+		return fmt.Errorf("invalid address %q: only bare addresses are supported", address)
+	}
+	return nil
+}
+
+// Send renders and sends the email message for an email request.
+func (s *SMTPSender) Send(ctx context.Context, request *messaging.EmailRequest) error {
+	wrapMsg := fmt.Sprintf("unable to send email to %s", request.ToAddress)
+
+	// Validate the addresses. A message with an invalid address can never be sent.
+	from := s.sender(request)
+	recipients := []string{request.ToAddress}
+	if request.CourtesyCopyAddress != "" {
+		recipients = append(recipients, request.CourtesyCopyAddress)
+	}
+	for _, address := range append([]string{from.Address}, recipients...) {
+		if err := validateAddress(address); err != nil {
+			return permanentError{errors.Wrap(err, wrapMsg)}
+		}
+	}
+
+	// Render the message.
+	rendered, err := s.templates.Render(request)
+	if err != nil {
+		return permanentError{errors.Wrap(err, wrapMsg)}
+	}
+	message, err := buildMessage(from, request, rendered, time.Now())
+	if err != nil {
+		return permanentError{errors.Wrap(err, wrapMsg)}
+	}
+
+	// Send the message.
+	err = s.deliver(ctx, from.Address, recipients, message)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	log.Debugf("sent the %s email to %s", request.TemplateName, request.ToAddress)
+	return nil
+}
+
+// deliver connects to the SMTP server and sends a message.
+func (s *SMTPSender) deliver(ctx context.Context, from string, recipients []string, message []byte) error {
+	if s.settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.settings.Timeout)
+		defer cancel()
+	}
+
+	// Connect to the server.
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.settings.Host, strconv.Itoa(s.settings.Port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: s.settings.Host, MinVersion: tls.VersionTLS12}
+	if s.settings.TLSMode == TLSModeImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, s.settings.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = client.Close() }()
+
+	// Secure the connection and authenticate if necessary.
+	if s.settings.TLSMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("the SMTP server doesn't support STARTTLS")
+		}
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if s.settings.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", s.settings.Username, s.settings.Password, s.settings.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Send the message.
+	err = client.Mail(from)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(message)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// writePart writes a quoted-printable encoded body part.
+func writePart(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(body))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// buildMessage builds an email message. Messages with both plain text and HTML bodies are sent as
+// multipart/alternative messages so that mail clients can display whichever they prefer. Messages that include an
+// unsubscribe link advertise it using the headers described in RFC 8058, so that mail clients can offer a one-click
+// unsubscribe button.
+func buildMessage(
+	from *mail.Address,
+	request *messaging.EmailRequest,
+	rendered *Rendered,
+	now time.Time,
+) ([]byte, error) {
+	var buf bytes.Buffer
+
+	// Write the message headers.
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + request.ToAddress,
+	}
+	if request.CourtesyCopyAddress != "" {
+		headers = append(headers, "Cc: "+request.CourtesyCopyAddress)
+	}
+	headers = append(headers,
+		"Subject: "+mime.QEncoding.Encode("utf-8", request.Subject),
+		"Date: "+now.Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@event-recorder>", uuid.NewString()),
+		"MIME-Version: 1.0",
+	)
+	if link, ok := request.TemplateValues[unsubscribe.URLKey].(string); ok && link != "" {
+		headers = append(headers,
+			"List-Unsubscribe: <"+link+">",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+		)
+	}
+	for _, header := range headers {
+		buf.WriteString(header + "\r\n")
+	}
+
+	// Write a single part message if there's only one body.
+	const textType = "text/plain; charset=utf-8"
+	const htmlType = "text/html; charset=utf-8"
+	if rendered.Text == "" || rendered.HTML == "" {
+		contentType, body := textType, rendered.Text
+		if rendered.HTML != "" {
+			contentType, body = htmlType, rendered.HTML
+		}
+		buf.WriteString("Content-Type: " + contentType + "\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err := writePart(&buf, body)
+		return buf.Bytes(), err
+	}
+
+	// Write a multipart message with the plain text body first, since mail clients prefer the last part.
+	mw := multipart.NewWriter(&buf)
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+	for _, part := range []struct{ contentType, body string }{{textType, rendered.Text}, {htmlType, rendered.HTML}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = writePart(w, part.body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,254 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/unsubscribe"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTPServer is a minimal local SMTP server that records the messages that it receives.
+type fakeSMTPServer struct {
+	listener   net.Listener
+	rejectRcpt bool
+	mutex      sync.Mutex
+	auth       string
+	from       string
+	recipients []string
+	data       string
+}
+
+// newFakeSMTPServer starts a fake SMTP server that accepts a single connection.
+func newFakeSMTPServer(t *testing.T, rejectRcpt bool) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeSMTPServer{listener: listener, rejectRcpt: rejectRcpt}
+	t.Cleanup(func() { _ = listener.Close() })
+	go s.serve()
+	return s
+}
+
+// port returns the port that the server is listening on.
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// serve handles a single SMTP session.
+func (s *fakeSMTPServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = fmt.Fprintf(conn, "%s\r\n", line) }
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		s.mutex.Lock()
+		switch command {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = line
+			reply("235 authenticated")
+		case "MAIL":
+			s.from = line
+			reply("250 ok")
+		case "RCPT":
+			if s.rejectRcpt {
+				reply("550 no such user")
+			} else {
+				s.recipients = append(s.recipients, line)
+				reply("250 ok")
+			}
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := r.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.data = data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			s.mutex.Unlock()
+			return
+		default:
+			reply("250 ok")
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// newTestSender creates a sender that sends messages to the fake SMTP server.
+func newTestSender(t *testing.T, server *fakeSMTPServer) *SMTPSender {
+	templates, err := LoadTemplates(writeTemplates(t, map[string]string{
+		"analysis.txt":  "Analysis {{.name}} is {{.status}}.",
+		"analysis.html": "<p>Analysis {{.name}} is {{.status}}.</p>",
+	}))
+	require.NoError(t, err)
+
+	sender, err := NewSMTPSender(&SMTPSettings{
+		Host:        "127.0.0.1",
+		Port:        server.port(),
+		Username:    "notifications",
+		Password:    "secret",
+		FromAddress: "noreply@example.org",
+		FromName:    "Discovery Environment",
+		TLSMode:     TLSModeNone,
+		Timeout:     5 * time.Second,
+	}, templates)
+	require.NoError(t, err)
+	return sender
+}
+
+func TestSMTPSend(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newFakeSMTPServer(t, false)
+	sender := newTestSender(t, server)
+	err := sender.Send(context.Background(), &messaging.EmailRequest{
+		Subject:             "Análisis completo",
+		ToAddress:           "ipcdev@example.org",
+		CourtesyCopyAddress: "support@example.org",
+		TemplateName:        "analysis",
+		TemplateValues: map[string]interface{}{
+			"name":             "word count",
+			"status":           "Completed",
+			unsubscribe.URLKey: "https://de.example.org/unsubscribe?token=abc",
+		},
+	})
+	require.NoError(t, err)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	// Verify the envelope.
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00notifications\x00secret"))
+	assert.Equal("AUTH PLAIN "+credentials, server.auth)
+	assert.Equal("MAIL FROM:<noreply@example.org>", strings.SplitN(server.from, " BODY", 2)[0])
+	assert.Equal([]string{"RCPT TO:<ipcdev@example.org>", "RCPT TO:<support@example.org>"}, server.recipients)
+
+	// Verify the headers.
+	message, err := mail.ReadMessage(strings.NewReader(server.data))
+	require.NoError(t, err)
+	assert.Equal(`"Discovery Environment" <noreply@example.org>`, message.Header.Get("From"))
+	assert.Equal("ipcdev@example.org", message.Header.Get("To"))
+	assert.Equal("support@example.org", message.Header.Get("Cc"))
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal("Análisis completo", subject)
+	assert.NotEmpty(message.Header.Get("Message-ID"))
+	assert.Equal("<https://de.example.org/unsubscribe?token=abc>", message.Header.Get("List-Unsubscribe"))
+	assert.Equal("List-Unsubscribe=One-Click", message.Header.Get("List-Unsubscribe-Post"))
+	_, err = message.Header.Date()
+	assert.NoError(err)
+
+	// Verify the bodies.
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal("multipart/alternative", mediaType)
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	expected := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "Analysis word count is Completed."},
+		{"text/html; charset=utf-8", "<p>Analysis word count is Completed.</p>"},
+	}
+	for _, e := range expected {
+		part, err := reader.NextRawPart()
+		require.NoError(t, err)
+		assert.Equal(e.contentType, part.Header.Get("Content-Type"))
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		require.NoError(t, err)
+		assert.Equal(e.body, string(body))
+	}
+	_, err = reader.NextPart()
+	assert.Equal(io.EOF, err)
+}
+
+func TestSMTPSendRejected(t *testing.T) {
+	server := newFakeSMTPServer(t, true)
+	sender := newTestSender(t, server)
+	err := sender.Send(context.Background(), &messaging.EmailRequest{
+		ToAddress:    "nobody@example.org",
+		TemplateName: "analysis",
+	})
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+}
+
+func TestSMTPSendInvalidAddress(t *testing.T) {
+	server := newFakeSMTPServer(t, false)
+	sender := newTestSender(t, server)
+	for _, request := range []*messaging.EmailRequest{
+		{ToAddress: "ipcdev@example.org\r\nRCPT TO:<victim@example.org>", TemplateName: "analysis"},
+		{ToAddress: "not an address", TemplateName: "analysis"},
+		{ToAddress: "ipcdev@example.org", CourtesyCopyAddress: "Someone <cc@example.org>", TemplateName: "analysis"},
+		{ToAddress: "ipcdev@example.org", FromAddress: "noreply@example.org\n", TemplateName: "analysis"},
+	} {
+		err := sender.Send(context.Background(), request)
+		require.Error(t, err)
+		assert.True(t, IsPermanent(err), err.Error())
+	}
+
+	// Nothing should have been sent to the server.
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	assert.Empty(t, server.recipients)
+}
+
+func TestSMTPSendUnknownTemplate(t *testing.T) {
+	server := newFakeSMTPServer(t, false)
+	sender := newTestSender(t, server)
+	err := sender.Send(context.Background(), &messaging.EmailRequest{
+		ToAddress:    "ipcdev@example.org",
+		TemplateName: "unknown",
+	})
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+}
+
+func TestNewSMTPSenderInvalid(t *testing.T) {
+	valid := SMTPSettings{Host: "localhost", Port: 25, FromAddress: "noreply@example.org", TLSMode: TLSModeNone}
+	tests := map[string]func(*SMTPSettings){
+		"missing host":     func(s *SMTPSettings) { s.Host = "" },
+		"invalid port":     func(s *SMTPSettings) { s.Port = 0 },
+		"invalid from":     func(s *SMTPSettings) { s.FromAddress = "not an address" },
+		"invalid TLS mode": func(s *SMTPSettings) { s.TLSMode = "ssl" },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			settings := valid
+			modify(&settings)
+			_, err := NewSMTPSender(&settings, &Templates{})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Package expiry deletes notifications that have passed their expiration times and tells the UI about the
+// deletions. Only one replica of the service should run the sweeper at any given time; see the leader package.
+package expiry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "expiry"})
+
+// Settings represents the settings that determine how often the sweeper looks for expired notifications and how
+// many notifications are expired in a single database transaction.
+type Settings struct {
+	PollInterval time.Duration
+	BatchSize    uint64
+}
+
+// Sweeper periodically deletes expired notifications.
+type Sweeper struct {
+	db              *sql.DB
+	messagingClient handlers.MessagingClient
+	settings        *Settings
+	audit           audit.Sink
+}
+
+// Option represents an optional setting for a sweeper.
+type Option func(*Sweeper)
+
+// WithAuditSink sets the sink that receives a record of each deletion once it has been committed.
+func WithAuditSink(sink audit.Sink) Option {
+	return func(s *Sweeper) {
+		s.audit = sink
+	}
+}
+
+// New returns a new sweeper.
+func New(db *sql.DB, messagingClient handlers.MessagingClient, settings *Settings, opts ...Option) *Sweeper {
+	s := &Sweeper{
+		db:              db,
+		messagingClient: messagingClient,
+		settings:        settings,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run deletes expired notifications every poll interval until the context is canceled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.settings.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.sweepAll(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepAll deletes batches of notifications that expired at or before the given time until none remain.
+func (s *Sweeper) sweepAll(ctx context.Context, at time.Time) {
+	for ctx.Err() == nil {
+		count, err := s.sweep(ctx, at)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if count < s.settings.BatchSize {
+			return
+		}
+	}
+}
+
+// sweep deletes a single batch of notifications that expired at or before the given time and returns the number
+// of notifications that were deleted. A message containing the updated unread notification count is published for
+// each deleted notification that the user may have seen.
+func (s *Sweeper) sweep(ctx context.Context, at time.Time) (uint64, error) {
+	wrapMsg := "unable to delete expired notifications"
+
+	// Begin a database transaction.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Mark the expired notifications as deleted.
+	notifications, canceled, err := db.ExpireNotifications(ctx, tx, at, s.settings.BatchSize)
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Publish the deletions, counting the unread notifications only once for each user.
+	unreadCounts := make(map[string]int64)
+	for _, notification := range notifications {
+		total, ok := unreadCounts[notification.User]
+		if !ok {
+			total, err = db.CountUnreadNotifications(ctx, tx, notification.User)
+			if err != nil {
+				return 0, errors.Wrap(err, wrapMsg)
+			}
+			unreadCounts[notification.User] = total
+		}
+		err = s.publishDeletion(ctx, notification, total)
+		if err != nil {
+			return 0, errors.Wrap(err, wrapMsg)
+		}
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrap(err, wrapMsg)
+	}
+
+	// Every deleted notification counts toward the batch, even if nothing was published for it.
+	count := len(notifications) + len(canceled)
+	if count > 0 {
+		log.Infof("deleted %d expired notifications", count)
+	}
+	s.publishAuditRecords(ctx, append(notifications, canceled...))
+
+	return uint64(count), nil
+}
+
+// publishAuditRecords publishes an audit record for each committed deletion. The deletions have already been
+// committed, so failures are only logged.
+func (s *Sweeper) publishAuditRecords(ctx context.Context, notifications []*common.Notification) {
+	if s.audit == nil || len(notifications) == 0 {
+		return
+	}
+	now := time.Now()
+	records := make([]audit.Record, len(notifications))
+	for i, notification := range notifications {
+		records[i] = audit.NewRecord(audit.EventDeleted, notification, now)
+	}
+	err := s.audit.Publish(ctx, records...)
+	if err != nil {
+		log.Errorf("unable to publish audit records: %s", err.Error())
+	}
+}
+
+// publishDeletion publishes the outgoing message for an expired notification, flagged as deleted, along with the
+// user's updated unread notification count.
+func (s *Sweeper) publishDeletion(ctx context.Context, notification *common.Notification, total int64) error {
+	notificationMessage := messaging.NotificationMessage{User: notification.User}
+
+	// Notifications saved by older versions of the service may not have an outgoing message.
+	if notification.OutgoingMessage != "" {
+		err := json.Unmarshal([]byte(notification.OutgoingMessage), &notificationMessage)
+		if err != nil {
+			return errors.Wrapf(err, "unable to parse the outgoing message for notification %s", notification.ID)
+		}
+	}
+	if notificationMessage.Message == nil {
+		notificationMessage.Message = map[string]interface{}{"id": notification.ID}
+	}
+	notificationMessage.Deleted = true
+
+	// Publish the message.
+	wrappedNotificationMessage := &messaging.WrappedNotificationMessage{
+		Message: &notificationMessage,
+		Total:   total,
+	}
+	return s.messagingClient.PublishNotificationMessageContext(ctx, wrappedNotificationMessage)
+}
@@ -0,0 +1,136 @@
+package expiry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockMessagingClient records the notification messages that were published.
+type mockMessagingClient struct {
+	notificationMessages []*messaging.WrappedNotificationMessage
+}
+
+// PublishEmailRequestContext does nothing; the sweeper never sends email.
+func (c *mockMessagingClient) PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error {
+	return nil
+}
+
+// PublishNotificationMessageContext records the notification message.
+func (c *mockMessagingClient) PublishNotificationMessageContext(
+	_ context.Context,
+	m *messaging.WrappedNotificationMessage,
+) error {
+	c.notificationMessages = append(c.notificationMessages, m)
+	return nil
+}
+
+// mockAuditSink records the audit records that were published.
+type mockAuditSink struct {
+	records []audit.Record
+}
+
+// Publish records the audit records.
+func (s *mockAuditSink) Publish(_ context.Context, records ...audit.Record) error {
+	s.records = append(s.records, records...)
+	return nil
+}
+
+// Close does nothing.
+func (s *mockAuditSink) Close() error {
+	return nil
+}
+
+func TestSweep(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations. Unread notifications should only be counted once per user.
+	created := time.Now().Add(-time.Hour)
+	rows := sqlmock.NewRows(
+		[]string{"id", "username", "outgoing_json", "name", "seen", "time_created", "expires_at", "canceled"},
+	).
+		AddRow("n1", "ipcdev", `{"type": "analysis", "user": "ipcdev", "message": {"id": "n1"}}`, "analysis", true,
+			created, created, false).
+		AddRow("n2", "ipcdev", "", "data", false, created, created, false).
+		AddRow("n3", "ipcdev", "", "data", false, created, created, true)
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH expired AS").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectCommit()
+
+	// Sweep the expired notifications.
+	mc := &mockMessagingClient{}
+	sink := &mockAuditSink{}
+	s := New(db, mc, &Settings{PollInterval: time.Minute, BatchSize: 10}, WithAuditSink(sink))
+	count, err := s.sweep(context.Background(), time.Now())
+	assert.NoError(err, "unexpected error occurred while sweeping notifications")
+	assert.Equal(uint64(3), count)
+
+	// Verify that a deletion was published for each notification that wasn't canceled.
+	if assert.Len(mc.notificationMessages, 2) {
+		for i, id := range []string{"n1", "n2"} {
+			msg := mc.notificationMessages[i]
+			assert.True(msg.Message.Deleted)
+			assert.Equal("ipcdev", msg.Message.User)
+			assert.Equal(id, msg.Message.Message["id"])
+			assert.Equal(int64(2), msg.Total)
+		}
+	}
+
+	// Verify that an audit record was published for each deletion, including canceled deliveries.
+	if assert.Len(sink.records, 3) {
+		for i, id := range []string{"n1", "n2", "n3"} {
+			record := sink.records[i]
+			assert.Equal(audit.EventDeleted, record.Event)
+			assert.Equal(id, record.ID)
+			assert.Equal("ipcdev", record.User)
+			assert.True(record.Deleted)
+		}
+		assert.Equal("analysis", sink.records[0].NotificationType)
+		assert.True(sink.records[0].Seen)
+		assert.False(sink.records[1].Seen)
+	}
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestSweepAllCountsCanceledDeliveries(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The first batch only contains a notification whose delivery was canceled, so it's still a full batch.
+	columns := []string{"id", "username", "outgoing_json", "name", "seen", "time_created", "expires_at", "canceled"}
+	created := time.Now().Add(-time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH expired AS").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("n1", "ipcdev", "", "data", false, created, created, true))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH expired AS").WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectCommit()
+
+	// Sweep until no expired notifications remain.
+	mc := &mockMessagingClient{}
+	s := New(db, mc, &Settings{PollInterval: time.Minute, BatchSize: 1})
+	s.sweepAll(context.Background(), time.Now())
+	assert.Empty(mc.notificationMessages)
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
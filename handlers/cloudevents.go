@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/cloudevents"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// CloudEventDecoder is implemented by message handlers that accept CloudEvents. DecodeCloudEvent converts an
+// incoming event to a message body in the handler's native format. Errors should be UnrecoverableErrors, because
+// decoding the same event again won't succeed.
+type CloudEventDecoder interface {
+	DecodeCloudEvent(updateType string, event *cloudevents.Event, delivery amqp.Delivery) ([]byte, error)
+}
+
+// splitList splits a comma-separated extension attribute value into its elements, ignoring empty elements.
+func splitList(value string) []string {
+	var elements []string
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// DecodeCloudEvent converts a CloudEvent to a legacy notification request. The event data, which must be a JSON
+// object, becomes the payload, the subject attribute becomes the notification subject, and the time attribute becomes
+// the timestamp. The time that the delivery was published is used if the event has no time attribute, and the
+// timestamp is left empty if that isn't known either, so that the same event is always converted to the same
+// request. The event's source and ID are included so that duplicate events can be detected. The remaining request
+// fields are taken from extension attributes, whose names are the field names without underscores.
+func (lh *Legacy) DecodeCloudEvent(updateType string, event *cloudevents.Event, delivery amqp.Delivery) ([]byte, error) {
+	request := LegacyRequest{
+		RequestType:   updateType,
+		User:          event.Extension("user"),
+		Users:         splitList(event.Extension("users")),
+		Group:         event.Extension("group"),
+		Subject:       event.Subject,
+		EmailTemplate: event.Extension("emailtemplate"),
+		Message:       event.Extension("message"),
+		DeliverAt:     event.Extension("deliverat"),
+		ScheduleID:    event.Extension("scheduleid"),
+		ExpiresAt:     event.Extension("expiresat"),
+		GroupingKey:   event.Extension("groupingkey"),
+		ThreadID:      event.Extension("threadid"),
+		Priority:      event.Extension("priority"),
+		Tags:          splitList(event.Extension("tags")),
+		Severity:      event.Extension("severity"),
+		Locale:        event.Extension("locale"),
+		EventSource:   event.Source,
+		EventID:       event.ID,
+	}
+
+	// Determine when the event occurred.
+	if event.Time != nil {
+		request.Timestamp = event.Time.Format(time.RFC3339Nano)
+	} else if !delivery.Timestamp.IsZero() {
+		request.Timestamp = delivery.Timestamp.Format(time.RFC3339Nano)
+	}
+
+	// Determine whether email was requested.
+	if value := event.Extension("email"); value != "" {
+		email, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, NewUnrecoverableError("invalid value for the CloudEvents email attribute: %s", value)
+		}
+		request.Email = &email
+	}
+
+	// The event data is the payload.
+	if len(event.Data) > 0 {
+		if !event.HasJSONData() {
+			return nil, NewUnrecoverableError("unsupported CloudEvents data content type: %s", event.DataContentType)
+		}
+		err := json.Unmarshal(event.Data, &request.Payload)
+		if err != nil {
+			return nil, NewUnrecoverableError("CloudEvents data must be a JSON object: %s", err.Error())
+		}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, NewUnrecoverableError("unable to encode the notification request: %s", err.Error())
+	}
+	return body, nil
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/cloudevents"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCloudEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	handler := NewLegacy(databaseClient, messagingClient)
+
+	// The event should be converted to a legacy request.
+	published := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	event := &cloudevents.Event{
+		SpecVersion: cloudevents.SpecVersion,
+		ID:          "e1",
+		Source:      "/apps",
+		Type:        "org.cyverse.analysis.completed",
+		Subject:     "some job status changed",
+		Time:        &published,
+		Data:        []byte(`{"analysisname": "some job", "email_address": "sarahr@cyverse.org"}`),
+		Extensions: map[string]interface{}{
+			"user":          "sarahr",
+			"email":         true,
+			"emailtemplate": "analysis_status_change",
+			"severity":      "warning",
+			"tags":          "a, b",
+		},
+	}
+	body, err := handler.DecodeCloudEvent("analysis", event, amqp.Delivery{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	// The converted request should be handled like any other.
+	delivery := amqp.Delivery{Body: body, RoutingKey: FakeRoutingKey}
+	err = handler.HandleMessage(context.Background(), "analysis", delivery)
+	if !assert.NoError(err) {
+		return
+	}
+	notification := databaseClient.SavedNotification
+	assert.Equal("sarahr", notification.User)
+	assert.Equal("some job status changed", notification.Subject)
+	assert.Equal("warning", notification.Severity)
+	assert.True(published.Equal(notification.TimeCreated))
+	if assert.NotNil(messagingClient.PublishedEmailRequest) {
+		assert.Equal("sarahr@cyverse.org", messagingClient.PublishedEmailRequest.ToAddress)
+	}
+	if assert.NotNil(messagingClient.PublishedNotificationMessage) {
+		payload := messagingClient.PublishedNotificationMessage.Message.Payload.(map[string]interface{})
+		assert.Equal("some job", payload["analysisname"])
+	}
+
+	assert.Contains(string(body), `"event_source":"/apps"`)
+	assert.Contains(string(body), `"event_id":"e1"`)
+
+	// The delivery timestamp should be used if the event has no time attribute.
+	event.Time = nil
+	body, err = handler.DecodeCloudEvent("analysis", event, amqp.Delivery{Timestamp: published.Add(time.Hour)})
+	if assert.NoError(err) {
+		assert.Contains(string(body), `"timestamp":"2026-10-18T13:00:00Z"`)
+	}
+
+	// The same event should always be converted to the same request, even if nothing says when it occurred.
+	body, err = handler.DecodeCloudEvent("analysis", event, amqp.Delivery{})
+	if assert.NoError(err) {
+		assert.Contains(string(body), `"timestamp":""`)
+		again, err := handler.DecodeCloudEvent("analysis", event, amqp.Delivery{})
+		assert.NoError(err)
+		assert.Equal(string(body), string(again))
+	}
+
+	// Requests without a timestamp should be timestamped when they're handled.
+	before := time.Now()
+	err = handler.HandleMessage(context.Background(), "analysis", amqp.Delivery{Body: body, RoutingKey: FakeRoutingKey})
+	if assert.NoError(err) {
+		assert.False(databaseClient.SavedNotification.TimeCreated.Before(before))
+	}
+
+	// Events whose data isn't a JSON object should be rejected.
+	event.Data = []byte(`["not", "an", "object"]`)
+	_, err = handler.DecodeCloudEvent("analysis", event, amqp.Delivery{})
+	assert.IsType(UnrecoverableError{}, err)
+	event.DataContentType = "text/plain"
+	event.Data = []byte("hello")
+	_, err = handler.DecodeCloudEvent("analysis", event, amqp.Delivery{})
+	assert.IsType(UnrecoverableError{}, err)
+
+	// Invalid email flags should be rejected.
+	event.Data = nil
+	event.Extensions["email"] = "sometimes"
+	_, err = handler.DecodeCloudEvent("analysis", event, amqp.Delivery{})
+	assert.IsType(UnrecoverableError{}, err)
+}
+
+func TestDuplicateCloudEvent(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(42)
+	handler := NewLegacy(databaseClient, NewMockMessagingClient())
+	event := &cloudevents.Event{
+		SpecVersion: cloudevents.SpecVersion,
+		ID:          "e1",
+		Source:      "/apps",
+		Type:        "org.cyverse.analysis.completed",
+		Subject:     "some job status changed",
+		Extensions:  map[string]interface{}{"users": "ipcdev,sarahr"},
+	}
+	body, err := handler.DecodeCloudEvent("analysis", event, amqp.Delivery{})
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Recipients that already have a notification for the event should be skipped even if the event wasn't
+	// redelivered by the broker.
+	databaseClient.ExistingNotifications["sarahr"] = true
+	err = handler.HandleMessage(context.Background(), "analysis", amqp.Delivery{Body: body, RoutingKey: FakeRoutingKey})
+	if assert.NoError(err) {
+		assert.Equal([]string{"ipcdev"}, savedUsers(databaseClient))
+	}
+}
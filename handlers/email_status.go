@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// The types of bounces reported by delivery status events.
+const (
+	BounceTypeHard = "hard"
+	BounceTypeSoft = "soft"
+)
+
+// DefaultBounceThreshold is the default number of hard bounces after which email is no longer sent to an address.
+const DefaultBounceThreshold = 3
+
+// EmailStatusRequest represents a deserialized delivery status event for an email. The delivery ID is the value of
+// the `email_delivery_id` template value in the email request. The address is only required for bounces of email
+// that the service doesn't know about. The status defaults to the update type in the routing key.
+type EmailStatusRequest struct {
+	DeliveryID string `json:"delivery_id"`
+	Address    string `json:"address"`
+	Status     string `json:"status"`
+	BounceType string `json:"bounce_type"`
+	Detail     string `json:"detail"`
+}
+
+// EmailStatus is a message handler for email delivery status events.
+type EmailStatus struct {
+	dbc             DatabaseClient
+	bounceThreshold int
+}
+
+// NewEmailStatus returns a new email delivery status event handler. Addresses are suppressed after bounceThreshold
+// hard bounces; a non-positive threshold means that the default threshold is used.
+func NewEmailStatus(dbc DatabaseClient, bounceThreshold int) *EmailStatus {
+	if bounceThreshold <= 0 {
+		bounceThreshold = DefaultBounceThreshold
+	}
+	return &EmailStatus{dbc: dbc, bounceThreshold: bounceThreshold}
+}
+
+// parseEmailStatus validates the status reported by a delivery status event.
+func parseEmailStatus(status string) (string, error) {
+	status = strings.ToLower(status)
+	switch status {
+	case common.EmailStatusSent, common.EmailStatusBounced, common.EmailStatusFailed:
+		return status, nil
+	default:
+		return "", NewUnrecoverableError("unsupported email delivery status: %s", status)
+	}
+}
+
+// HandleMessage handles a single email delivery status event. The status of the email delivery is updated, and hard
+// bounces are counted against the address that the email was sent to.
+func (h *EmailStatus) HandleMessage(ctx context.Context, updateType string, delivery amqp.Delivery) error {
+	var err error
+
+	// Parse the message body.
+	var request EmailStatusRequest
+	err = json.Unmarshal(delivery.Body, &request)
+	if err != nil {
+		return NewUnrecoverableError("unable to parse message body: %s", err.Error())
+	}
+	if request.Status == "" {
+		request.Status = updateType
+	}
+	status, err := parseEmailStatus(request.Status)
+	if err != nil {
+		return err
+	}
+	if request.DeliveryID == "" && request.Address == "" {
+		return NewUnrecoverableError("the delivery status event identifies neither a delivery nor an address")
+	}
+
+	// Begin a database transaction.
+	tx, err := h.dbc.Begin()
+	if err != nil {
+		return NewRecoverableError("unable to begin a database transaction: %s", err.Error())
+	}
+	defer func() {
+		_ = h.dbc.Rollback(tx)
+	}()
+
+	// Update the status of the delivery. A bounce is only counted the first time that it's reported for a known
+	// delivery, so redelivered status events don't count against the address more than once.
+	address := request.Address
+	countBounce := true
+	if request.DeliveryID != "" {
+		deliveryAddress, updated, err := h.dbc.UpdateEmailDeliveryStatus(
+			ctx, tx, request.DeliveryID, status, request.Detail,
+		)
+		if err != nil {
+			return NewRecoverableError("unable to update the email delivery status: %s", err.Error())
+		}
+		if deliveryAddress == "" {
+			log.Warnf("received a %s status for unknown email delivery %s", status, request.DeliveryID)
+		} else if !updated {
+			log.Debugf("ignoring a %s status for email delivery %s: the status can't change", status, request.DeliveryID)
+			countBounce = false
+		}
+		if address == "" {
+			address = deliveryAddress
+		}
+	}
+
+	// Count hard bounces against the address.
+	hardBounce := status == common.EmailStatusBounced && strings.EqualFold(request.BounceType, BounceTypeHard)
+	if hardBounce && countBounce && address != "" {
+		suppressed, err := h.dbc.RecordHardBounce(ctx, tx, address, request.Detail, h.bounceThreshold)
+		if err != nil {
+			return NewRecoverableError("unable to record the hard bounce: %s", err.Error())
+		}
+		if suppressed {
+			log.Warnf("email to %s is suppressed because of repeated hard bounces", address)
+		}
+	}
+
+	// Commit the transaction.
+	err = h.dbc.Commit(tx)
+	if err != nil {
+		return NewRecoverableError("unable to commit the database transaction: %s", err.Error())
+	}
+
+	return nil
+}
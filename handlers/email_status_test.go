@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cyverse-de/event-recorder/common"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// handleEmailStatus sends a delivery status event to an email status handler.
+func handleEmailStatus(databaseClient *MockDatabaseClient, updateType, body string) error {
+	handler := NewEmailStatus(databaseClient, 2)
+	delivery := amqp.Delivery{RoutingKey: "events.email_status.update." + updateType, Body: []byte(body)}
+	return handler.HandleMessage(context.Background(), updateType, delivery)
+}
+
+func TestEmailStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	databaseClient := NewMockDatabaseClient(0)
+	databaseClient.EmailDeliveries = []*common.EmailDelivery{
+		{ID: "d1", Address: "ipcdev@example.org", Status: common.EmailStatusQueued},
+		{ID: "d2", Address: "ipcdev@example.org", Status: common.EmailStatusSent},
+		{ID: "d3", Address: "ipcdev@example.org", Status: common.EmailStatusQueued},
+	}
+
+	// The status should default to the update type.
+	err := handleEmailStatus(databaseClient, "sent", `{"delivery_id": "d1"}`)
+	assert.NoError(err)
+	assert.Equal(common.EmailStatusSent, databaseClient.EmailDeliveries[0].Status)
+	assert.True(databaseClient.CommitCalled)
+
+	// Soft bounces shouldn't count against the address.
+	err = handleEmailStatus(databaseClient, "bounced", `{"delivery_id": "d1", "bounce_type": "soft"}`)
+	assert.NoError(err)
+	assert.Equal(common.EmailStatusBounced, databaseClient.EmailDeliveries[0].Status)
+	assert.Zero(databaseClient.HardBounces["ipcdev@example.org"])
+
+	// A bounce that's reported again for the same delivery shouldn't be counted twice.
+	body := `{"delivery_id": "d2", "bounce_type": "hard", "detail": "550 no such user"}`
+	assert.NoError(handleEmailStatus(databaseClient, "bounced", body))
+	assert.NoError(handleEmailStatus(databaseClient, "bounced", body))
+	assert.Equal(1, databaseClient.HardBounces["ipcdev@example.org"])
+	assert.False(databaseClient.SuppressedAddresses["ipcdev@example.org"])
+	assert.Equal("550 no such user", databaseClient.EmailDeliveries[1].Detail)
+
+	// A late report that the message was sent shouldn't overwrite the bounce.
+	assert.NoError(handleEmailStatus(databaseClient, "sent", `{"delivery_id": "d2"}`))
+	assert.Equal(common.EmailStatusBounced, databaseClient.EmailDeliveries[1].Status)
+	assert.Equal("550 no such user", databaseClient.EmailDeliveries[1].Detail)
+
+	// Repeated hard bounces should suppress the address, using the delivery's address if none is given.
+	body = `{"delivery_id": "d3", "bounce_type": "hard", "detail": "550 no such user"}`
+	assert.NoError(handleEmailStatus(databaseClient, "bounced", body))
+	assert.True(databaseClient.SuppressedAddresses["ipcdev@example.org"])
+
+	// Bounces for unknown deliveries should still be counted if they include an address.
+	body = `{"delivery_id": "unknown", "address": "sarahr@example.org", "status": "bounced", "bounce_type": "hard"}`
+	assert.NoError(handleEmailStatus(databaseClient, "status", body))
+	assert.Equal(1, databaseClient.HardBounces["sarahr@example.org"])
+}
+
+func TestEmailStatusInvalid(t *testing.T) {
+	tests := map[string]struct{ updateType, body string }{
+		"malformed body":     {"sent", `{`},
+		"unsupported status": {"opened", `{"delivery_id": "d1"}`},
+		"queued status":      {"queued", `{"delivery_id": "d1"}`},
+		"no identifier":      {"bounced", `{"bounce_type": "hard"}`},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := handleEmailStatus(NewMockDatabaseClient(0), test.updateType, test.body)
+			assert.IsType(t, UnrecoverableError{}, err)
+		})
+	}
+}
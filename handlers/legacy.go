@@ -2,13 +2,25 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/catalog"
 	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/directory"
+	"github.com/cyverse-de/event-recorder/email"
+	"github.com/cyverse-de/event-recorder/payloads"
+	"github.com/cyverse-de/event-recorder/profiles"
+	"github.com/cyverse-de/event-recorder/ratelimit"
+	"github.com/cyverse-de/event-recorder/templates"
+	"github.com/cyverse-de/event-recorder/unsubscribe"
 	"github.com/cyverse-de/messaging/v12"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -17,50 +29,314 @@ import (
 type LegacyRequest struct {
 	RequestType   string                 `json:"type"`
 	User          string                 `json:"user"`
+	Users         []string               `json:"users"`
+	Group         string                 `json:"group"`
 	Subject       string                 `json:"subject"`
 	Timestamp     string                 `json:"timestamp"`
-	Email         bool                   `json:"email"`
+	Email         *bool                  `json:"email"`
 	EmailTemplate string                 `json:"email_template"`
 	Payload       map[string]interface{} `json:"payload"`
 	Message       string                 `json:"message"`
+	DeliverAt     string                 `json:"deliver_at"`
+	ScheduleID    string                 `json:"schedule_id"`
+	ExpiresAt     string                 `json:"expires_at"`
+	GroupingKey   string                 `json:"grouping_key"`
+	ThreadID      string                 `json:"thread_id"`
+	Priority      string                 `json:"priority"`
+	Tags          []string               `json:"tags"`
+	Severity      string                 `json:"severity"`
+	Locale        string                 `json:"locale"`
+	EventSource   string                 `json:"event_source,omitempty"`
+	EventID       string                 `json:"event_id,omitempty"`
 }
 
+// emailRequested returns true if the request asks for email to be sent.
+func (r *LegacyRequest) emailRequested() bool {
+	return r.Email != nil && *r.Email
+}
+
+// DefaultBatchSize is the default maximum number of recipients whose notifications are stored in a single
+// database transaction.
+const DefaultBatchSize = 100
+
+// DefaultMaxRecipients is the default maximum number of recipients that a single event may be addressed to.
+const DefaultMaxRecipients = 10000
+
 // Legacy is a message handler for events published by the backwards compatible HTTP API.
 type Legacy struct {
 	dbc             DatabaseClient
 	messagingClient MessagingClient
+	directory       directory.Directory
+	batchSize       int
+	maxRecipients   int
+	emailOverride   string
+	limiter         *ratelimit.Limiter
+	templates       *templates.Renderer
+	unsubscribe     *unsubscribe.Signer
+	profiles        profiles.Profiles
+	payloadFallback bool
+	validator       *payloads.Validator
+	types           *catalog.Catalog
+	typeMode        string
+	audit           audit.Sink
+	immediate       bool
+}
+
+// LegacyOption represents an optional setting for a legacy event handler.
+type LegacyOption func(*Legacy)
+
+// WithDirectory sets the directory used to resolve the members of groups that events are addressed to.
+func WithDirectory(dir directory.Directory) LegacyOption {
+	return func(lh *Legacy) {
+		lh.directory = dir
+	}
+}
+
+// WithFanOutLimits sets the maximum number of recipients whose notifications are stored in a single database
+// transaction and the maximum number of recipients that a single event may be addressed to. Non-positive values
+// leave the corresponding limit unchanged.
+func WithFanOutLimits(batchSize, maxRecipients int) LegacyOption {
+	return func(lh *Legacy) {
+		if batchSize > 0 {
+			lh.batchSize = batchSize
+		}
+		if maxRecipients > 0 {
+			lh.maxRecipients = maxRecipients
+		}
+	}
+}
+
+// WithEmailOverride sets the minimum severity at which email requests are sent even if the recipient has opted out
+// of email for the notification type. An empty severity means that opt-outs are always honored.
+func WithEmailOverride(severity string) LegacyOption {
+	return func(lh *Legacy) {
+		lh.emailOverride = severity
+	}
+}
+
+// WithRateLimiter sets the limiter used to protect recipients from floods of notifications. Notifications that
+// exceed the recipient's rate limits are handled according to the limiter's overflow action.
+func WithRateLimiter(limiter *ratelimit.Limiter) LegacyOption {
+	return func(lh *Legacy) {
+		lh.limiter = limiter
+	}
+}
+
+// WithTemplates sets the renderer used to produce the subject and message text of notifications for events that
+// don't include them.
+func WithTemplates(renderer *templates.Renderer) LegacyOption {
+	return func(lh *Legacy) {
+		lh.templates = renderer
+	}
+}
+
+// WithEmailSender causes email requests to be sent directly using the given sender rather than being published for
+// the email service.
+func WithEmailSender(sender email.Sender) LegacyOption {
+	return func(lh *Legacy) {
+		lh.messagingClient = NewDirectEmailClient(lh.messagingClient, sender)
+	}
+}
+
+// WithUnsubscribeLinks causes signed one-click unsubscribe links to be included in the template values of email
+// requests.
+func WithUnsubscribeLinks(signer *unsubscribe.Signer) LegacyOption {
+	return func(lh *Legacy) {
+		lh.unsubscribe = signer
+	}
+}
+
+// WithProfiles sets the user profile lookup used to resolve the email addresses of recipients. The address in the
+// event payload is ignored unless payloadFallback is true, in which case it's used for single recipient events when
+// the recipient has no profile.
+func WithProfiles(p profiles.Profiles, payloadFallback bool) LegacyOption {
+	return func(lh *Legacy) {
+		lh.profiles = p
+		lh.payloadFallback = payloadFallback
+	}
+}
+
+// WithPayloadValidator sets the validator used to check the payloads of incoming events against the schema for their
+// notification type. Events with invalid payloads are rejected.
+func WithPayloadValidator(validator *payloads.Validator) LegacyOption {
+	return func(lh *Legacy) {
+		lh.validator = validator
+	}
+}
+
+// WithNotificationTypes sets the catalog of registered notification types, whose defaults are applied to events that
+// don't specify their own settings, and the way in which events of unregistered notification types are handled.
+func WithNotificationTypes(types *catalog.Catalog, mode string) LegacyOption {
+	return func(lh *Legacy) {
+		lh.types = types
+		lh.typeMode = mode
+	}
+}
+
+// WithAuditSink sets the sink that receives a record of each notification once it has been committed.
+func WithAuditSink(sink audit.Sink) LegacyOption {
+	return func(lh *Legacy) {
+		lh.audit = sink
+	}
+}
+
+// WithImmediateDelivery causes notifications to be delivered immediately even if the event requests a later
+// delivery time. It's used when the scheduler that would deliver them later is disabled.
+func WithImmediateDelivery() LegacyOption {
+	return func(lh *Legacy) {
+		lh.immediate = true
+	}
 }
 
 // NewLegacy returns a new legacy event handler.
-func NewLegacy(dbc DatabaseClient, messagingClient MessagingClient) *Legacy {
-	return &Legacy{
+func NewLegacy(dbc DatabaseClient, messagingClient MessagingClient, opts ...LegacyOption) *Legacy {
+	lh := &Legacy{
 		dbc:             dbc,
 		messagingClient: messagingClient,
+		batchSize:       DefaultBatchSize,
+		maxRecipients:   DefaultMaxRecipients,
+		typeMode:        catalog.ModeOpen,
+	}
+	for _, opt := range opts {
+		opt(lh)
 	}
+	return lh
 }
 
-// sendEmailRequest sends the email request for a single notification request.
-func (lh *Legacy) sendEmailRequest(ctx context.Context, request *LegacyRequest) error {
+// resolveRecipients determines the list of users that a notification request is addressed to. A request may name
+// a single user, a list of users, a group, or any combination of the three. Duplicate recipients are removed, and
+// the order in which the recipients were first listed is preserved.
+func (lh *Legacy) resolveRecipients(ctx context.Context, request *LegacyRequest) ([]string, error) {
+	wrapMsg := "unable to resolve the notification recipients"
+
+	// Start with the individually listed users.
+	candidates := append([]string{request.User}, request.Users...)
+
+	// Add the members of the group if one was specified.
+	if request.Group != "" {
+		if lh.directory == nil {
+			return nil, NewUnrecoverableError("%s: no group directory is configured", wrapMsg)
+		}
+		members, err := lh.directory.GroupMembers(ctx, request.Group)
+		if err != nil {
+			if _, ok := err.(directory.GroupNotFoundError); ok {
+				return nil, NewUnrecoverableError("%s: %s", wrapMsg, err.Error())
+			}
+			return nil, NewRecoverableError("%s: %s", wrapMsg, err.Error())
+		}
+		candidates = append(candidates, members...)
+	}
+
+	// Remove blank and duplicate usernames.
+	seen := make(map[string]bool, len(candidates))
+	recipients := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		recipients = append(recipients, candidate)
+	}
+
+	// Validate the number of recipients.
+	if len(recipients) == 0 {
+		return nil, NewUnrecoverableError("%s: no recipients specified", wrapMsg)
+	}
+	if len(recipients) > lh.maxRecipients {
+		return nil, NewUnrecoverableError(
+			"%s: too many recipients: %d (maximum %d)", wrapMsg, len(recipients), lh.maxRecipients,
+		)
+	}
+
+	return recipients, nil
+}
+
+// unsubscribeValues adds the unsubscribe tokens and links for a recipient to a copy of an email request's template
+// values. The values are returned unchanged if unsubscribe links are disabled.
+func (lh *Legacy) unsubscribeValues(
+	values map[string]interface{},
+	recipient, notificationType string,
+) map[string]interface{} {
+	if lh.unsubscribe == nil {
+		return values
+	}
+	now := time.Now()
+	token := lh.unsubscribe.Token(recipient, notificationType, now)
+	allToken := lh.unsubscribe.Token(recipient, db.AllNotificationTypes, now)
+
+	result := make(map[string]interface{}, len(values)+4)
+	for k, v := range values {
+		result[k] = v
+	}
+	result[unsubscribe.TokenKey] = token
+	result[unsubscribe.URLKey] = lh.unsubscribe.Link(token)
+	result[unsubscribe.AllTokenKey] = allToken
+	result[unsubscribe.AllURLKey] = lh.unsubscribe.Link(allToken)
+	return result
+}
+
+// payloadEmailAddress extracts the email address from a notification request payload.
+func payloadEmailAddress(request *LegacyRequest) (string, error) {
+	emailAddress, ok := request.Payload["email_address"].(string)
+	if !ok {
+		return "", NewUnrecoverableError(
+			"unable to send the email request: no email address provided or invalid data type in request",
+		)
+	}
+	return emailAddress, nil
+}
+
+// resolveEmailAddress determines the email address of a recipient. If user profiles are configured, the address is
+// taken from the recipient's profile, and the address in the payload is only used if fallback is enabled and the
+// recipient has no profile. An empty address is returned if the recipient has no address.
+func (lh *Legacy) resolveEmailAddress(ctx context.Context, e *event, recipient string) (string, error) {
+	if lh.profiles == nil {
+		return payloadEmailAddress(e.request)
+	}
+
+	// Look up the recipient's profile.
+	profile, err := lh.profiles.Profile(ctx, recipient)
+	var notFound profiles.NotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		return "", NewRecoverableError("unable to send the email request: %s", err.Error())
+	}
+	if err == nil && profile.Email != "" {
+		return profile.Email, nil
+	}
+
+	// Fall back to the payload if permitted. The payload only describes the recipient of single recipient events.
+	if lh.payloadFallback && e.singleRecipient {
+		return payloadEmailAddress(e.request)
+	}
+	log.Warnf("not sending a %s email to %s: no email address found in the user's profile", e.updateType, recipient)
+	return "", nil
+}
+
+// buildEmailRequest builds the email request for a single recipient of a notification request. A nil request is
+// returned if the recipient has no email address.
+func (lh *Legacy) buildEmailRequest(ctx context.Context, e *event, recipient string) (*messaging.EmailRequest, error) {
 	wrapMsg := "unable to send the email request"
+	request := e.request
 
-	// Extract the email address from the notification request payload.
-	var emailAddress string
-	switch str := request.Payload["email_address"].(type) {
-	case string:
-		emailAddress = str
-	default:
-		return NewUnrecoverableError("%s: %s", wrapMsg, "no email address provided or invalid data type in request")
+	// Determine the recipient's email address.
+	emailAddress, err := lh.resolveEmailAddress(ctx, e, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if emailAddress == "" {
+		return nil, nil
 	}
 
 	// Validate the email address.
-	err := common.ValidateEmailAddress(emailAddress)
+	err = common.ValidateEmailAddress(emailAddress)
 	if err != nil {
-		return NewUnrecoverableError("%s: %s", wrapMsg, err.Error())
+		return nil, NewUnrecoverableError("%s: %s", wrapMsg, err.Error())
 	}
 
 	// Validate the template name.
 	if request.EmailTemplate == "" {
-		return NewUnrecoverableError("%s: %s", wrapMsg, "no email template provided")
+		return nil, NewUnrecoverableError("%s: %s", wrapMsg, "no email template provided")
 	}
 
 	// Create the email request body.
@@ -68,14 +344,46 @@ func (lh *Legacy) sendEmailRequest(ctx context.Context, request *LegacyRequest)
 		Subject:        request.Subject,
 		ToAddress:      emailAddress,
 		TemplateName:   request.EmailTemplate,
-		TemplateValues: request.Payload,
+		TemplateValues: lh.unsubscribeValues(request.Payload, recipient, e.updateType),
 	}
-	err = lh.messagingClient.PublishEmailRequestContext(ctx, emailRequest)
+
+	return emailRequest, nil
+}
+
+// deliverEmailRequest publishes the email request for a notification and records the attempt. Email isn't sent to
+// addresses that have been suppressed because of repeated hard bounces. If email is sent directly, the message that
+// should be sent once the transaction has been committed is returned.
+func (lh *Legacy) deliverEmailRequest(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	emailRequest *messaging.EmailRequest,
+) (*DirectEmail, error) {
+	suppressed, err := lh.dbc.EmailSuppressed(ctx, tx, emailRequest.ToAddress)
 	if err != nil {
-		return NewRecoverableError("%s: %s", wrapMsg, err.Error())
+		return nil, NewRecoverableError("unable to check for email suppression: %s", err.Error())
 	}
 
-	return nil
+	// Send the email request unless the address has been suppressed.
+	var delivery *common.EmailDelivery
+	var directEmail *DirectEmail
+	if suppressed {
+		log.Infof("not sending a %s email to suppressed address %s", emailRequest.TemplateName, emailRequest.ToAddress)
+		delivery = SuppressedEmailDelivery(notificationID, emailRequest)
+	} else {
+		delivery, directEmail, err = DeliverEmailRequest(ctx, lh.messagingClient, notificationID, emailRequest)
+		if err != nil {
+			return nil, NewRecoverableError("unable to send the email request: %s", err.Error())
+		}
+	}
+
+	// Record the attempt.
+	err = lh.dbc.SaveEmailDelivery(ctx, tx, delivery)
+	if err != nil {
+		return nil, NewRecoverableError("unable to record the email delivery: %s", err.Error())
+	}
+
+	return directEmail, nil
 }
 
 // fixTimestamp fixes a timestamp stored as a string in a map.
@@ -152,7 +460,7 @@ func (lh *Legacy) buildNotificationMessage(
 	// Build the notification message.
 	notificationMessage := &messaging.NotificationMessage{
 		Deleted:       request.Deleted,
-		Email:         payload.Email,
+		Email:         payload.emailRequested(),
 		EmailTemplate: payload.EmailTemplate,
 		Message:       outgoingMessage,
 		Payload:       payload.Payload,
@@ -165,7 +473,240 @@ func (lh *Legacy) buildNotificationMessage(
 	return notificationMessage, nil
 }
 
-// HandleMessage handles a single AMQP delivery.
+// event represents a parsed incoming event along with the information needed to store and publish the
+// notifications for each of its recipients.
+type event struct {
+	updateType      string
+	delivery        amqp.Delivery
+	request         *LegacyRequest
+	timeCreated     time.Time
+	sendEmail       bool
+	singleRecipient bool
+	deliverAt       *time.Time
+	scheduleID      string
+	expiresAt       *time.Time
+	groupingKey     string
+	threadID        string
+	severity        string
+	templateData    map[string]interface{}
+}
+
+// scheduled returns true if the delivery of the event's notifications should be deferred.
+func (e *event) scheduled() bool {
+	return e.deliverAt != nil
+}
+
+// parseDeliveryTime parses the requested delivery time for an event. A nil time is returned if the event should be
+// delivered immediately, either because no delivery time was requested or because the requested time has passed.
+func parseDeliveryTime(request *LegacyRequest, now time.Time) (*time.Time, error) {
+	if request.DeliverAt == "" {
+		return nil, nil
+	}
+	deliverAt, err := time.Parse(time.RFC3339Nano, request.DeliverAt)
+	if err != nil {
+		return nil, NewUnrecoverableError("unable to parse the delivery time: %s", err.Error())
+	}
+	if !deliverAt.After(now) {
+		return nil, nil
+	}
+	return &deliverAt, nil
+}
+
+// parseExpiryTime parses the time after which the notifications for an event should be deleted automatically. A
+// nil time is returned if the notifications should never expire.
+func parseExpiryTime(request *LegacyRequest) (*time.Time, error) {
+	if request.ExpiresAt == "" {
+		return nil, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, request.ExpiresAt)
+	if err != nil {
+		return nil, NewUnrecoverableError("unable to parse the expiration time: %s", err.Error())
+	}
+	return &expiresAt, nil
+}
+
+// groupingKey determines the key used to collapse related notifications into a single evolving notification. An
+// explicit grouping key in the request takes precedence. Otherwise, analysis notifications are grouped by analysis
+// ID. An empty string is returned if the notification doesn't belong to a group.
+func groupingKey(updateType string, request *LegacyRequest) string {
+	if request.GroupingKey != "" {
+		return request.GroupingKey
+	}
+	if updateType == "analysis" {
+		if id, ok := request.Payload["id"].(string); ok && id != "" {
+			return "analysis:" + id
+		}
+	}
+	return ""
+}
+
+// threadIDFields maps notification types to the payload fields that identify the subject of a conversation for
+// events that don't include a thread ID.
+var threadIDFields = map[string]string{
+	"analysis":             "id",
+	"tool_request":         "id",
+	"permanent_id_request": "id",
+	"team":                 "team_name",
+}
+
+// threadID determines the thread that a notification belongs to. An explicit thread ID in the request takes
+// precedence. Otherwise, the thread ID is derived from a known payload field for the notification type, if there
+// is one. An empty string is returned if the notification doesn't belong to a thread.
+func threadID(updateType string, request *LegacyRequest) string {
+	if request.ThreadID != "" {
+		return request.ThreadID
+	}
+	if field, ok := threadIDFields[updateType]; ok {
+		if value, ok := request.Payload[field].(string); ok && value != "" {
+			return updateType + ":" + value
+		}
+	}
+	return ""
+}
+
+// templateData decodes the event body for use as the data for the notification text templates. It returns nil if
+// the text doesn't need to be rendered, either because templates aren't configured or because the event includes
+// both a subject and a message.
+func (lh *Legacy) templateData(request *LegacyRequest, body []byte) (map[string]interface{}, error) {
+	if lh.templates == nil || (request.Subject != "" && request.Message != "") {
+		return nil, nil
+	}
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, NewUnrecoverableError("unable to parse message body: %s", err.Error())
+	}
+	return data, nil
+}
+
+// localize fills in the subject and message text that an event doesn't include for a single recipient, using the
+// template for the notification type in the recipient's preferred locale. The locale requested by the event is used
+// if the recipient hasn't chosen one. The event is returned unchanged if there's nothing to render.
+func (lh *Legacy) localize(ctx context.Context, tx *sql.Tx, e *event, recipient string) (*event, error) {
+	if e.templateData == nil {
+		return e, nil
+	}
+
+	// Determine the recipient's locale.
+	locale, err := lh.dbc.GetUserLocale(ctx, tx, recipient)
+	if err != nil {
+		return nil, NewRecoverableError("unable to determine the recipient's locale: %s", err.Error())
+	}
+	if locale == "" {
+		locale = e.request.Locale
+	}
+
+	// Render the text.
+	rendered, err := lh.templates.Render(e.updateType, locale, e.templateData)
+	if err != nil {
+		return nil, NewUnrecoverableError("unable to render the notification text: %s", err.Error())
+	}
+	if rendered == nil {
+		return e, nil
+	}
+	request := *e.request
+	if request.Subject == "" {
+		request.Subject = rendered.Subject
+	}
+	if request.Message == "" {
+		request.Message = rendered.Body
+	}
+	localized := *e
+	localized.request = &request
+
+	return &localized, nil
+}
+
+// lookupNotificationType returns the catalog entry for a notification type, or nil if the notification type isn't
+// registered or no catalog is configured.
+func (lh *Legacy) lookupNotificationType(updateType string) *common.NotificationType {
+	if lh.types == nil {
+		return nil
+	}
+	return lh.types.Lookup(updateType)
+}
+
+// handleUnknownType handles an event whose notification type isn't registered when unknown notification types
+// aren't registered automatically. The event is either rejected or quarantined for an administrator to review.
+func (lh *Legacy) handleUnknownType(ctx context.Context, updateType string, delivery amqp.Delivery) error {
+	reason := fmt.Sprintf("unknown notification type: %s", updateType)
+	if lh.typeMode != catalog.ModeQuarantine {
+		return NewUnrecoverableError("%s", reason)
+	}
+
+	// Begin a database transaction.
+	tx, err := lh.dbc.Begin()
+	if err != nil {
+		return NewRecoverableError("unable to begin a database transaction: %s", err.Error())
+	}
+	defer func() {
+		_ = lh.dbc.Rollback(tx)
+	}()
+
+	// Quarantine the event.
+	quarantined := &common.QuarantinedEvent{
+		ID:               uuid.NewString(),
+		NotificationType: updateType,
+		RoutingKey:       delivery.RoutingKey,
+		Body:             string(delivery.Body),
+		Reason:           reason,
+	}
+	err = lh.dbc.QuarantineEvent(ctx, tx, quarantined)
+	if err != nil {
+		return NewRecoverableError("unable to quarantine the event: %s", err.Error())
+	}
+
+	// Commit the transaction.
+	err = lh.dbc.Commit(tx)
+	if err != nil {
+		return NewRecoverableError("unable to commit the database transaction: %s", err.Error())
+	}
+
+	log.Warnf("quarantined event %s: %s", quarantined.ID, reason)
+	return nil
+}
+
+// applyTypeDefaults fills in the settings that an event doesn't specify using the defaults for its notification
+// type. Email is only requested by default if the event names an email template.
+func applyTypeDefaults(request *LegacyRequest, t *common.NotificationType) {
+	if t == nil {
+		return
+	}
+	if request.Severity == "" {
+		request.Severity = t.DefaultSeverity
+	}
+	if request.Email == nil {
+		email := t.HasDefaultChannel(common.ChannelEmail) && request.EmailTemplate != ""
+		request.Email = &email
+	}
+}
+
+// validatePayload checks the payload of an event against the schema for its notification type.
+func (lh *Legacy) validatePayload(updateType string, body []byte) error {
+	if lh.validator == nil {
+		return nil
+	}
+
+	// Extract the raw payload so that numbers are validated exactly as they were sent.
+	var raw struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	err := json.Unmarshal(body, &raw)
+	if err != nil {
+		return NewUnrecoverableError("unable to parse message body: %s", err.Error())
+	}
+
+	err = lh.validator.Validate(updateType, raw.Payload)
+	if err != nil {
+		return NewUnrecoverableError("%s", err.Error())
+	}
+	return nil
+}
+
+// HandleMessage handles a single AMQP delivery. One notification is stored and published for each recipient of
+// the event. Recipients are processed in batches, each of which is stored in a single database transaction. If the
+// event requests delivery at a later time, the notifications are stored immediately but their delivery is scheduled
+// rather than published.
 func (lh *Legacy) HandleMessage(ctx context.Context, updateType string, delivery amqp.Delivery) error {
 	var err error
 	updateType = strings.ToLower(updateType)
@@ -177,67 +718,475 @@ func (lh *Legacy) HandleMessage(ctx context.Context, updateType string, delivery
 		return NewUnrecoverableError("unable to parse message body: %s", err.Error())
 	}
 
-	// Parse the timestamp.
-	timeCreated, err := time.Parse(time.RFC3339Nano, request.Timestamp)
+	// Parse the timestamp. CloudEvents that don't say when they occurred are timestamped when they're handled.
+	timeCreated := time.Now()
+	if request.Timestamp != "" || request.EventID == "" {
+		timeCreated, err = time.Parse(time.RFC3339Nano, request.Timestamp)
+		if err != nil {
+			return NewUnrecoverableError("unable to parse timestamp: %s", err.Error())
+		}
+	}
+
+	// Look up the notification type, applying its defaults to the event.
+	typeInfo := lh.lookupNotificationType(updateType)
+	if typeInfo == nil && lh.typeMode != catalog.ModeOpen {
+		return lh.handleUnknownType(ctx, updateType, delivery)
+	}
+	applyTypeDefaults(&request, typeInfo)
+
+	// Validate the payload.
+	err = lh.validatePayload(updateType, delivery.Body)
+	if err != nil {
+		return err
+	}
+
+	// Prepare to render the notification text if the event doesn't include it.
+	templateData, err := lh.templateData(&request, delivery.Body)
+	if err != nil {
+		return err
+	}
+
+	// Determine who the notification should be sent to.
+	recipients, err := lh.resolveRecipients(ctx, &request)
+	if err != nil {
+		return err
+	}
+
+	// Email addresses are only included in the payload for single recipient events, so email can only be sent to
+	// multiple recipients if their addresses can be looked up.
+	sendEmail := request.emailRequested()
+	if sendEmail && len(recipients) > 1 && lh.profiles == nil {
+		log.Warnf("not sending email requests for an event with %d recipients", len(recipients))
+		sendEmail = false
+	}
+
+	// Determine when the notifications should be delivered.
+	deliverAt, err := parseDeliveryTime(&request, time.Now())
+	if err != nil {
+		return err
+	}
+	if deliverAt != nil && lh.immediate {
+		log.Warnf("delivering a %s event scheduled for %s immediately because the scheduler is disabled",
+			updateType, request.DeliverAt)
+		deliverAt = nil
+	}
+	scheduleID := request.ScheduleID
+	if deliverAt != nil && scheduleID == "" {
+		scheduleID = uuid.NewString()
+	}
+
+	// Determine the severity of the notifications.
+	severity, err := common.ParseSeverity(request.Severity)
 	if err != nil {
-		return NewUnrecoverableError("unable to parse timestamp: %s", err.Error())
+		return NewUnrecoverableError("unable to determine the notification severity: %s", err.Error())
 	}
 
+	// Determine when the notifications should expire. Notifications that don't have an explicit expiration time
+	// expire at the end of their notification type's retention period, if it has one.
+	expiresAt, err := parseExpiryTime(&request)
+	if err != nil {
+		return err
+	}
+	if expiresAt == nil && typeInfo != nil && typeInfo.RetentionDays > 0 {
+		retainUntil := timeCreated.AddDate(0, 0, typeInfo.RetentionDays)
+		expiresAt = &retainUntil
+	}
+
+	// Process the recipients in batches.
+	e := &event{
+		updateType:      updateType,
+		delivery:        delivery,
+		request:         &request,
+		timeCreated:     timeCreated,
+		sendEmail:       sendEmail,
+		singleRecipient: len(recipients) == 1,
+		deliverAt:       deliverAt,
+		scheduleID:      scheduleID,
+		expiresAt:       expiresAt,
+		threadID:        threadID(updateType, &request),
+		severity:        severity,
+		templateData:    templateData,
+	}
+
+	// Scheduled notifications aren't collapsed because they'd hide the notifications they supersede until they're
+	// delivered.
+	if !e.scheduled() {
+		e.groupingKey = groupingKey(updateType, &request)
+	}
+	for start := 0; start < len(recipients); start += lh.batchSize {
+		end := min(start+lh.batchSize, len(recipients))
+		err = lh.handleBatch(ctx, e, recipients[start:end])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notificationExists returns true if a notification has already been stored for a recipient of an event. CloudEvents
+// are identified by their source and ID, so duplicates are detected even if they weren't redelivered by the broker.
+// Other messages are only checked if they were redelivered, by comparing their bodies.
+func (lh *Legacy) notificationExists(ctx context.Context, tx *sql.Tx, e *event, recipient string) (bool, error) {
+	if e.request.EventID != "" {
+		return lh.dbc.CloudEventNotificationExists(
+			ctx, tx, recipient, e.delivery.RoutingKey, e.request.EventSource, e.request.EventID,
+		)
+	}
+	if !e.delivery.Redelivered {
+		return false, nil
+	}
+	return lh.dbc.NotificationExists(ctx, tx, recipient, e.delivery.RoutingKey, string(e.delivery.Body))
+}
+
+// handleBatch stores and publishes the notifications for a batch of recipients in a single database transaction.
+// If the delivery has been redelivered, recipients that already have the notification are skipped because the
+// batch containing them was committed before the delivery failed.
+func (lh *Legacy) handleBatch(ctx context.Context, e *event, recipients []string) error {
+	var err error
+
 	// Begin a database transaction.
 	tx, err := lh.dbc.Begin()
 	if err != nil {
 		return NewRecoverableError("uanble to begin a database transaction: %s", err.Error())
 	}
 	defer func() {
-		err = lh.dbc.Rollback(tx)
+		_ = lh.dbc.Rollback(tx)
 	}()
 
 	// Register the notification type in case it doesn't exist in the database yet.
-	err = lh.dbc.RegisterNotificationType(ctx, tx, updateType)
+	err = lh.dbc.RegisterNotificationType(ctx, tx, e.updateType)
 	if err != nil {
 		return NewUnrecoverableError("unable to register the notification type: %s", err.Error())
 	}
 
+	// Rate limit tokens are taken before the notifications are stored, so they're refunded if the transaction isn't
+	// committed. Otherwise, a delivery that's rolled back and redelivered would count against the limits twice.
+	var effects batchEffects
+	committed := false
+	defer func() {
+		if !committed {
+			lh.refundRateLimits(e, effects.charged)
+		}
+	}()
+
+	stored := make([]*common.Notification, 0, len(recipients))
+	for _, recipient := range recipients {
+
+		// Skip recipients that were handled during a previous delivery attempt.
+		exists, err := lh.notificationExists(ctx, tx, e, recipient)
+		if err != nil {
+			return NewRecoverableError("unable to check for an existing notification: %s", err.Error())
+		}
+		if exists {
+			continue
+		}
+
+		// Store and publish the notification for this recipient.
+		notification, err := lh.handleRecipient(ctx, tx, e, recipient, &effects)
+		if err != nil {
+			return err
+		}
+		if notification != nil {
+			stored = append(stored, notification)
+		}
+	}
+
+	// Commit the transaction.
+	err = lh.dbc.Commit(tx)
+	if err != nil {
+		return NewRecoverableError("unable to commit the database transaction: %s", err.Error())
+	}
+	committed = true
+
+	lh.sendDirectEmails(ctx, effects.emails)
+	lh.publishAuditRecords(ctx, stored, effects.superseded)
+	return nil
+}
+
+// sendDirectEmails sends the email messages whose deliveries were recorded in a committed transaction and records the
+// outcome of each attempt.
+func (lh *Legacy) sendDirectEmails(ctx context.Context, emails []*DirectEmail) {
+	for _, directEmail := range emails {
+		status, detail := directEmail.Send(ctx, lh.messagingClient)
+		err := lh.updateEmailDeliveryStatus(ctx, directEmail.Delivery.ID, status, detail)
+		if err != nil {
+			log.Errorf("unable to record the status of email delivery %s: %s", directEmail.Delivery.ID, err.Error())
+		}
+	}
+}
+
+// updateEmailDeliveryStatus updates the status of an email delivery in its own transaction.
+func (lh *Legacy) updateEmailDeliveryStatus(ctx context.Context, id, status, detail string) error {
+	tx, err := lh.dbc.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = lh.dbc.Rollback(tx)
+	}()
+
+	_, _, err = lh.dbc.UpdateEmailDeliveryStatus(ctx, tx, id, status, detail)
+	if err != nil {
+		return err
+	}
+
+	return lh.dbc.Commit(tx)
+}
+
+// publishAuditRecords publishes an audit record for each committed notification and for each notification that was
+// superseded by one of them. Superseded notifications are no longer visible, so they're reported as deleted. The
+// changes have already been committed, so failures are logged rather than causing the delivery to be retried.
+func (lh *Legacy) publishAuditRecords(ctx context.Context, created, superseded []*common.Notification) {
+	if lh.audit == nil || len(created)+len(superseded) == 0 {
+		return
+	}
+	now := time.Now()
+	records := make([]audit.Record, 0, len(created)+len(superseded))
+	for _, notification := range superseded {
+		deleted := *notification
+		deleted.Deleted = true
+		records = append(records, audit.NewRecord(audit.EventDeleted, &deleted, now))
+	}
+	for _, notification := range created {
+		records = append(records, audit.NewRecord(audit.EventCreated, notification, now))
+	}
+	err := lh.audit.Publish(ctx, records...)
+	if err != nil {
+		log.Errorf("unable to publish audit records: %s", err.Error())
+	}
+}
+
+// emailAllowed determines whether an email request may be sent to a recipient. Recipients who have opted out of
+// email for the notification type don't receive email unless the notification is severe enough to override the
+// opt-out.
+func (lh *Legacy) emailAllowed(ctx context.Context, tx *sql.Tx, e *event, recipient string) (bool, error) {
+	if common.SeverityAtLeast(e.severity, lh.emailOverride) {
+		return true, nil
+	}
+	optedOut, err := lh.dbc.EmailOptedOut(ctx, tx, recipient, e.updateType)
+	if err != nil {
+		return false, NewRecoverableError("unable to check the recipient's email preferences: %s", err.Error())
+	}
+	return !optedOut, nil
+}
+
+// refundRateLimits returns the rate limit tokens taken for recipients whose notifications were never committed.
+func (lh *Legacy) refundRateLimits(e *event, recipients []string) {
+	now := time.Now()
+	for _, recipient := range recipients {
+		lh.limiter.Refund(recipient, e.updateType, now)
+	}
+}
+
+// rateLimit applies the recipient's rate limits to an event. It returns the event that should be stored for the
+// recipient, or nil if the notification should be dropped, along with a flag indicating whether tokens were taken
+// from the recipient's buckets. Notifications that exceed the limits are either dropped, scheduled for delivery once
+// the limits allow it, or collapsed into a single summary notification that doesn't trigger an email. Scheduled
+// notifications aren't rate limited.
+func (lh *Legacy) rateLimit(ctx context.Context, e *event, recipient string) (*event, bool) {
+	if lh.limiter == nil || e.scheduled() {
+		return e, false
+	}
+	now := time.Now()
+
+	// Deferred notifications reserve the next available delivery time.
+	overflow := lh.limiter.Overflow()
+	if overflow == ratelimit.OverflowDefer {
+		deliverAt := lh.limiter.Reserve(recipient, e.updateType, now)
+		if !deliverAt.After(now) {
+			return e, true
+		}
+		lh.limiter.RecordThrottled(ctx, e.updateType, overflow)
+		deferred := *e
+		deferred.deliverAt = &deliverAt
+		deferred.scheduleID = uuid.NewString()
+		deferred.groupingKey = ""
+		return &deferred, true
+	}
+
+	// Determine whether the notification may be delivered now.
+	decision := lh.limiter.Allow(recipient, e.updateType, now)
+	if decision.Allowed {
+		return e, true
+	}
+	lh.limiter.RecordThrottled(ctx, e.updateType, overflow)
+	if overflow == ratelimit.OverflowDrop {
+		log.Debugf("dropping a %s notification for %s: rate limit exceeded", e.updateType, recipient)
+		return nil, false
+	}
+
+	// Each throttled notification replaces the previous one in the current run, and its subject records the number
+	// of notifications that it stands for.
+	request := *e.request
+	if decision.Throttled > 1 {
+		request.Subject = fmt.Sprintf("%s (and %d similar notifications)", request.Subject, decision.Throttled-1)
+		request.Message = request.Subject
+	}
+	collapsed := *e
+	collapsed.request = &request
+	collapsed.sendEmail = false
+	collapsed.groupingKey = fmt.Sprintf("rate-limit:%s:%d", e.updateType, decision.Since.UnixNano())
+	return &collapsed, false
+}
+
+// batchEffects records the effects of handling a batch of recipients that can't be completed until the batch's
+// transaction has been committed or rolled back.
+type batchEffects struct {
+
+	// charged lists the recipients whose rate limit tokens were taken.
+	charged []string
+
+	// emails lists the email messages that should be sent directly once the transaction has been committed.
+	emails []*DirectEmail
+
+	// superseded lists the notifications that were superseded by the notifications in the batch.
+	superseded []*common.Notification
+}
+
+// handleRecipient stores and publishes the notification for a single recipient. The stored notification is returned,
+// or nil if the notification was dropped. Anything that must wait until the transaction ends is added to the effects.
+func (lh *Legacy) handleRecipient(
+	ctx context.Context,
+	tx *sql.Tx,
+	e *event,
+	recipient string,
+	effects *batchEffects,
+) (*common.Notification, error) {
+	var err error
+
+	// Render the notification text in the recipient's language if necessary.
+	e, err = lh.localize(ctx, tx, e, recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the recipient's rate limits.
+	e, tokensTaken := lh.rateLimit(ctx, e, recipient)
+	if tokensTaken {
+		effects.charged = append(effects.charged, recipient)
+	}
+	if e == nil {
+		return nil, nil
+	}
+	request := e.request
+
 	// Store the message in the database.
 	storableRequest := &common.Notification{
-		NotificationType: updateType,
-		User:             request.User,
+		NotificationType: e.updateType,
+		User:             recipient,
 		Subject:          request.Subject,
 		Seen:             false,
 		Deleted:          false,
-		TimeCreated:      timeCreated,
-		Message:          string(delivery.Body),
-		RoutingKey:       delivery.RoutingKey,
+		TimeCreated:      e.timeCreated,
+		Message:          string(e.delivery.Body),
+		RoutingKey:       e.delivery.RoutingKey,
+		ExpiresAt:        e.expiresAt,
+		GroupingKey:      e.groupingKey,
+		ThreadID:         e.threadID,
+		Severity:         e.severity,
 	}
 	err = lh.dbc.SaveNotification(ctx, tx, storableRequest)
 	if err != nil {
-		return NewUnrecoverableError("unable to save the notification: %s", err.Error())
+		return nil, NewUnrecoverableError("unable to save the notification: %s", err.Error())
 	}
 
-	// Send the email request.
-	if request.Email {
-		err = lh.sendEmailRequest(ctx, &request)
+	// Replace the previous notification in the same group, if there is one.
+	var supersededIDs []string
+	if e.groupingKey != "" {
+		superseded, err := lh.dbc.SupersedeNotifications(ctx, tx, recipient, e.groupingKey, storableRequest.ID)
 		if err != nil {
-			return err
+			return nil, NewRecoverableError("unable to supersede earlier notifications: %s", err.Error())
+		}
+		for _, notification := range superseded {
+			supersededIDs = append(supersededIDs, notification.ID)
 		}
+		effects.superseded = append(effects.superseded, superseded...)
 	}
 
-	// Build the notification message.
-	notificationMessage, err := lh.buildNotificationMessage(storableRequest, &request)
+	// Determine whether the recipient should receive an email.
+	sendEmail := e.sendEmail
+	if sendEmail {
+		sendEmail, err = lh.emailAllowed(ctx, tx, e, recipient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Build the email request. Scheduled email requests are serialized now because building the notification
+	// message modifies the payload.
+	var emailRequest *messaging.EmailRequest
+	var emailRequestJSON []byte
+	if sendEmail {
+		emailRequest, err = lh.buildEmailRequest(ctx, e, recipient)
+		if err != nil {
+			return nil, err
+		}
+		if emailRequest != nil && e.scheduled() {
+			emailRequestJSON, err = json.Marshal(emailRequest)
+			if err != nil {
+				return nil, NewUnrecoverableError("unable to serialize the email request: %s", err.Error())
+			}
+		}
+	}
+
+	// Send the email request now if the notification isn't scheduled for later delivery.
+	if emailRequest != nil && !e.scheduled() {
+		directEmail, err := lh.deliverEmailRequest(ctx, tx, storableRequest.ID, emailRequest)
+		if err != nil {
+			return nil, err
+		}
+		if directEmail != nil {
+			effects.emails = append(effects.emails, directEmail)
+		}
+	}
+
+	// Build the notification message, identifying the notifications that it replaces so that the UI can remove them,
+	// the thread that it belongs to, its severity, and any priority or tags assigned to it.
+	notificationMessage, err := lh.buildNotificationMessage(storableRequest, request)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(supersededIDs) > 0 {
+		notificationMessage.Message["supersedes"] = supersededIDs
+	}
+	if e.threadID != "" {
+		notificationMessage.Message["thread_id"] = e.threadID
+	}
+	notificationMessage.Message["severity"] = e.severity
+	if request.Priority != "" {
+		notificationMessage.Message["priority"] = request.Priority
+	}
+	if len(request.Tags) > 0 {
+		notificationMessage.Message["tags"] = request.Tags
 	}
 
 	// Save the outgoing notificaiton in the database.
 	err = lh.dbc.SaveOutgoingNotification(ctx, tx, notificationMessage)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Schedule the delivery if the notification shouldn't be published yet.
+	if e.scheduled() {
+		pendingDelivery := &common.PendingDelivery{
+			ScheduleID:     e.scheduleID,
+			NotificationID: storableRequest.ID,
+			User:           recipient,
+			DeliverAt:      *e.deliverAt,
+			EmailRequest:   string(emailRequestJSON),
+		}
+		err = lh.dbc.SchedulePendingDelivery(ctx, tx, pendingDelivery)
+		if err != nil {
+			return nil, NewRecoverableError("unable to schedule the notification delivery: %s", err.Error())
+		}
+		return storableRequest, nil
 	}
 
 	// Count the number of unread notifications.
-	unreadNotificationCount, err := lh.dbc.CountUnreadNotifications(ctx, tx, request.User)
+	unreadNotificationCount, err := lh.dbc.CountUnreadNotifications(ctx, tx, recipient)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Add the wrapper around the notification message.
@@ -249,14 +1198,8 @@ func (lh *Legacy) HandleMessage(ctx context.Context, updateType string, delivery
 	// Publish the outgoing notification message.
 	err = lh.messagingClient.PublishNotificationMessageContext(ctx, wrappedNotificationMessage)
 	if err != nil {
-		return err
-	}
-
-	// Commit the transaction.
-	err = lh.dbc.Commit(tx)
-	if err != nil {
-		return NewRecoverableError("unable to commit the database transaction: %s", err.Error())
+		return nil, err
 	}
 
-	return err
+	return storableRequest, nil
 }
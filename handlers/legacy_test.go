@@ -4,10 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"net/textproto"
 	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cyverse-de/event-recorder/audit"
+	"github.com/cyverse-de/event-recorder/catalog"
 	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/directory"
+	"github.com/cyverse-de/event-recorder/payloads"
+	"github.com/cyverse-de/event-recorder/profiles"
+	"github.com/cyverse-de/event-recorder/ratelimit"
+	"github.com/cyverse-de/event-recorder/templates"
+	"github.com/cyverse-de/event-recorder/unsubscribe"
 	"github.com/cyverse-de/messaging/v12"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
@@ -15,13 +28,15 @@ import (
 
 // MockMessagingClient provides mock implementations of the functions we need from messaging.Client.
 type MockMessagingClient struct {
-	PublishedNotificationMessage *messaging.WrappedNotificationMessage
-	PublishedEmailRequest        *messaging.EmailRequest
+	PublishedNotificationMessage  *messaging.WrappedNotificationMessage
+	PublishedNotificationMessages []*messaging.WrappedNotificationMessage
+	PublishedEmailRequest         *messaging.EmailRequest
 }
 
 // PublishNotificationMessage simply stores a copy of the notification message for later inspection.
 func (c *MockMessagingClient) PublishNotificationMessageContext(_ context.Context, msg *messaging.WrappedNotificationMessage) error {
 	c.PublishedNotificationMessage = msg
+	c.PublishedNotificationMessages = append(c.PublishedNotificationMessages, msg)
 	return nil
 }
 
@@ -50,10 +65,23 @@ const FakeRoutingKey = "events.notification.update.foo"
 // database.
 type MockDatabaseClient struct {
 	BeginCalled                bool
+	BeginCount                 int
 	CommitCalled               bool
+	CommitCount                int
+	CommitError                error
 	RollbackCalled             bool
 	RegisteredNotificationType string
 	SavedNotification          *common.Notification
+	SavedNotifications         []*common.Notification
+	ExistingNotifications      map[string]bool
+	PendingDeliveries          []*common.PendingDelivery
+	GroupedNotifications       map[string]string
+	EmailOptOuts               map[string]bool
+	UserLocales                map[string]string
+	SuppressedAddresses        map[string]bool
+	EmailDeliveries            []*common.EmailDelivery
+	HardBounces                map[string]int
+	QuarantinedEvents          []*common.QuarantinedEvent
 	savedOutgoingMessage       *messaging.NotificationMessage
 	unreadMessageCount         int64
 }
@@ -61,13 +89,15 @@ type MockDatabaseClient struct {
 // Begin records the fact that it was called.
 func (c *MockDatabaseClient) Begin() (*sql.Tx, error) {
 	c.BeginCalled = true
+	c.BeginCount++
 	return nil, nil
 }
 
-// Commit records the fact that it was called.
+// Commit records the fact that it was called and returns CommitError.
 func (c *MockDatabaseClient) Commit(*sql.Tx) error {
 	c.CommitCalled = true
-	return nil
+	c.CommitCount++
+	return c.CommitError
 }
 
 // Rollback records the fact that it was called.
@@ -86,6 +116,7 @@ func (c *MockDatabaseClient) RegisterNotificationType(_ context.Context, tx *sql
 func (c *MockDatabaseClient) SaveNotification(_ context.Context, tx *sql.Tx, notification *common.Notification) error {
 	notification.ID = FakeNotificationID
 	c.SavedNotification = notification
+	c.SavedNotifications = append(c.SavedNotifications, notification)
 	return nil
 }
 
@@ -105,15 +136,117 @@ func (c *MockDatabaseClient) CountUnreadNotifications(_ context.Context, tx *sql
 	return c.unreadMessageCount, nil
 }
 
+// NotificationExists returns true if the user is listed in the set of users who already have the notification.
+func (c *MockDatabaseClient) NotificationExists(_ context.Context, _ *sql.Tx, user, _, _ string) (bool, error) {
+	return c.ExistingNotifications[user], nil
+}
+
+// CloudEventNotificationExists returns true if the user is listed in the set of users who already have the
+// notification.
+func (c *MockDatabaseClient) CloudEventNotificationExists(
+	_ context.Context,
+	_ *sql.Tx,
+	user, _, _, _ string,
+) (bool, error) {
+	return c.ExistingNotifications[user], nil
+}
+
+// SchedulePendingDelivery records the pending delivery.
+func (c *MockDatabaseClient) SchedulePendingDelivery(_ context.Context, _ *sql.Tx, d *common.PendingDelivery) error {
+	c.PendingDeliveries = append(c.PendingDeliveries, d)
+	return nil
+}
+
+// SupersedeNotifications replaces the current notification in the group with the new notification.
+func (c *MockDatabaseClient) SupersedeNotifications(
+	_ context.Context,
+	_ *sql.Tx,
+	user, key, id string,
+) ([]*common.Notification, error) {
+	previous, ok := c.GroupedNotifications[key]
+	c.GroupedNotifications[key] = id
+	if !ok {
+		return nil, nil
+	}
+	return []*common.Notification{{ID: previous, User: user, NotificationType: "analysis"}}, nil
+}
+
+// EmailOptedOut returns true if the user is listed in the set of users who opted out of email.
+func (c *MockDatabaseClient) EmailOptedOut(_ context.Context, _ *sql.Tx, user, _ string) (bool, error) {
+	return c.EmailOptOuts[user], nil
+}
+
+// GetUserLocale returns the locale listed for the user, if there is one.
+func (c *MockDatabaseClient) GetUserLocale(_ context.Context, _ *sql.Tx, user string) (string, error) {
+	return c.UserLocales[user], nil
+}
+
+// EmailSuppressed returns true if the address has been marked as suppressed.
+func (c *MockDatabaseClient) EmailSuppressed(_ context.Context, _ *sql.Tx, address string) (bool, error) {
+	return c.SuppressedAddresses[address], nil
+}
+
+// SaveEmailDelivery records the email delivery.
+func (c *MockDatabaseClient) SaveEmailDelivery(_ context.Context, _ *sql.Tx, delivery *common.EmailDelivery) error {
+	c.EmailDeliveries = append(c.EmailDeliveries, delivery)
+	return nil
+}
+
+// UpdateEmailDeliveryStatus updates the status of a recorded email delivery unless the delivery would move back to an
+// earlier status.
+func (c *MockDatabaseClient) UpdateEmailDeliveryStatus(
+	_ context.Context,
+	_ *sql.Tx,
+	id, status, detail string,
+) (string, bool, error) {
+	for _, delivery := range c.EmailDeliveries {
+		if delivery.ID == id {
+			if !slices.Contains(common.EmailStatusesBefore(status), delivery.Status) {
+				return delivery.Address, false, nil
+			}
+			delivery.Status = status
+			delivery.Detail = detail
+			return delivery.Address, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// RecordHardBounce counts the hard bounces for an address, suppressing it once the threshold is reached.
+func (c *MockDatabaseClient) RecordHardBounce(
+	_ context.Context,
+	_ *sql.Tx,
+	address, _ string,
+	threshold int,
+) (bool, error) {
+	c.HardBounces[address]++
+	if c.HardBounces[address] >= threshold {
+		c.SuppressedAddresses[address] = true
+	}
+	return c.SuppressedAddresses[address], nil
+}
+
+// QuarantineEvent records the quarantined event for later inspection.
+func (c *MockDatabaseClient) QuarantineEvent(_ context.Context, _ *sql.Tx, event *common.QuarantinedEvent) error {
+	c.QuarantinedEvents = append(c.QuarantinedEvents, event)
+	return nil
+}
+
 // NewMockDatabaseClient creates a new mock database client for testing.
 func NewMockDatabaseClient(unreadMessageCount int64) *MockDatabaseClient {
 	return &MockDatabaseClient{
-		BeginCalled:          false,
-		CommitCalled:         false,
-		RollbackCalled:       false,
-		SavedNotification:    nil,
-		savedOutgoingMessage: nil,
-		unreadMessageCount:   unreadMessageCount,
+		BeginCalled:           false,
+		CommitCalled:          false,
+		RollbackCalled:        false,
+		SavedNotification:     nil,
+		ExistingNotifications: make(map[string]bool),
+		GroupedNotifications:  make(map[string]string),
+		EmailOptOuts:          make(map[string]bool),
+		UserLocales:           make(map[string]string),
+		SuppressedAddresses:   make(map[string]bool),
+		HardBounces:           make(map[string]int),
+		savedOutgoingMessage:  nil,
+		unreadMessageCount:    unreadMessageCount,
 	}
 }
 
@@ -389,3 +522,1007 @@ func TestNotificationWithUpperCaseUpdateType(t *testing.T) {
 	}
 	assert.Equal("analysis", notification.Message.Type, "incorrect notification type")
 }
+
+// MockDirectory provides a mock implementation of the group directory.
+type MockDirectory struct {
+	groups map[string][]string
+}
+
+// GroupMembers returns the members of a group in the mock directory.
+func (d *MockDirectory) GroupMembers(_ context.Context, group string) ([]string, error) {
+	members, ok := d.groups[group]
+	if !ok {
+		return nil, directory.GroupNotFoundError{Group: group}
+	}
+	return members, nil
+}
+
+// savedUsers returns the list of users that notifications were saved for.
+func savedUsers(databaseClient *MockDatabaseClient) []string {
+	users := make([]string, len(databaseClient.SavedNotifications))
+	for i, notification := range databaseClient.SavedNotifications {
+		users[i] = notification.User
+	}
+	return users
+}
+
+// handleTestRequest passes a request to a legacy handler and returns the error returned by the handler.
+func handleTestRequest(
+	databaseClient *MockDatabaseClient,
+	messagingClient *MockMessagingClient,
+	req map[string]interface{},
+	redelivered bool,
+	opts ...LegacyOption,
+) error {
+	requestBody, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	delivery := amqp.Delivery{Body: requestBody, RoutingKey: FakeRoutingKey, Redelivered: redelivered}
+	handler := NewLegacy(databaseClient, messagingClient, opts...)
+	return handler.HandleMessage(context.Background(), "analysis", delivery)
+}
+
+func TestNotificationMultipleRecipients(t *testing.T) {
+	assert := assert.New(t)
+
+	// Address the notification to a list of users, including a duplicate of the primary user.
+	req := getLegacyNotificationRequest()
+	req["users"] = []string{"ipcdev", "sarahr", "psarando", ""}
+
+	// Pass the request to the handler.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+
+	// One notification should be stored and published for each distinct recipient.
+	assert.Equal([]string{"sarahr", "ipcdev", "psarando"}, savedUsers(databaseClient))
+	assert.Len(messagingClient.PublishedNotificationMessages, 3)
+	for i, msg := range messagingClient.PublishedNotificationMessages {
+		assert.Equal(databaseClient.SavedNotifications[i].User, msg.Message.User)
+	}
+
+	// Email requests aren't sent for events with multiple recipients.
+	assert.Nil(messagingClient.PublishedEmailRequest)
+}
+
+func TestNotificationGroupRecipients(t *testing.T) {
+	assert := assert.New(t)
+
+	// Address the notification to a group.
+	req := getLegacyNotificationRequest()
+	req["user"] = ""
+	req["group"] = "de-admins"
+	dir := &MockDirectory{groups: map[string][]string{"de-admins": {"ipcdev", "tedgin", "ipcdev"}}}
+
+	// Pass the request to the handler.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false, WithDirectory(dir))
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+
+	// One notification should be stored for each distinct group member.
+	assert.Equal([]string{"ipcdev", "tedgin"}, savedUsers(databaseClient))
+}
+
+func TestNotificationGroupErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	req := getLegacyNotificationRequest()
+	req["group"] = "nobody"
+
+	// Groups can't be resolved without a directory.
+	err := handleTestRequest(NewMockDatabaseClient(0), NewMockMessagingClient(), req, false)
+	assert.IsType(UnrecoverableError{}, err)
+
+	// Unknown groups can't be resolved.
+	dir := &MockDirectory{groups: map[string][]string{}}
+	err = handleTestRequest(NewMockDatabaseClient(0), NewMockMessagingClient(), req, false, WithDirectory(dir))
+	assert.IsType(UnrecoverableError{}, err)
+}
+
+func TestNotificationRecipientLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	// At least one recipient is required.
+	req := getLegacyNotificationRequest()
+	req["user"] = ""
+	err := handleTestRequest(NewMockDatabaseClient(0), NewMockMessagingClient(), req, false)
+	assert.IsType(UnrecoverableError{}, err)
+
+	// The number of recipients is limited.
+	req = getLegacyNotificationRequest()
+	req["users"] = []string{"ipcdev", "tedgin"}
+	err = handleTestRequest(
+		NewMockDatabaseClient(0), NewMockMessagingClient(), req, false, WithFanOutLimits(0, 2),
+	)
+	assert.IsType(UnrecoverableError{}, err)
+}
+
+func TestNotificationBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	// Address the notification to five users.
+	req := getLegacyNotificationRequest()
+	req["users"] = []string{"ipcdev", "tedgin", "psarando", "sriram"}
+
+	// Process the recipients two at a time.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false, WithFanOutLimits(2, 0))
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+
+	// Each batch should have been stored in its own transaction.
+	assert.Equal(3, databaseClient.BeginCount)
+	assert.Equal(3, databaseClient.CommitCount)
+	assert.Len(databaseClient.SavedNotifications, 5)
+}
+
+func TestNotificationRedelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	// Address the notification to three users, one of whom already has the notification.
+	req := getLegacyNotificationRequest()
+	req["users"] = []string{"ipcdev", "tedgin"}
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.ExistingNotifications["sarahr"] = true
+
+	// Existing notifications are only checked for redelivered messages.
+	err := handleTestRequest(databaseClient, NewMockMessagingClient(), req, true)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal([]string{"ipcdev", "tedgin"}, savedUsers(databaseClient))
+}
+
+func TestScheduledNotification(t *testing.T) {
+	assert := assert.New(t)
+
+	// Request delivery an hour from now.
+	deliverAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	req := getLegacyNotificationRequest()
+	req["deliver_at"] = deliverAt.Format(time.RFC3339)
+
+	// Pass the request to the handler.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+
+	// The notification should be stored, but nothing should be published.
+	assert.Len(databaseClient.SavedNotifications, 1)
+	assert.NotNil(databaseClient.savedOutgoingMessage)
+	assert.Nil(messagingClient.PublishedEmailRequest)
+	assert.Empty(messagingClient.PublishedNotificationMessages)
+
+	// The delivery should be scheduled along with the email request.
+	if assert.Len(databaseClient.PendingDeliveries, 1) {
+		pendingDelivery := databaseClient.PendingDeliveries[0]
+		assert.NotEmpty(pendingDelivery.ScheduleID)
+		assert.Equal("sarahr", pendingDelivery.User)
+		assert.True(deliverAt.Equal(pendingDelivery.DeliverAt))
+		var emailRequest messaging.EmailRequest
+		assert.NoError(json.Unmarshal([]byte(pendingDelivery.EmailRequest), &emailRequest))
+		assert.Equal("sarahr@cyverse.org", emailRequest.ToAddress)
+	}
+}
+
+func TestScheduledNotificationInThePast(t *testing.T) {
+	assert := assert.New(t)
+
+	// Delivery times that have already passed should be ignored.
+	req := getLegacyNotificationRequest()
+	req["deliver_at"] = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Empty(databaseClient.PendingDeliveries)
+	assert.Len(messagingClient.PublishedNotificationMessages, 1)
+
+	// Invalid delivery times should be rejected.
+	req["deliver_at"] = "tomorrow"
+	err = handleTestRequest(NewMockDatabaseClient(42), NewMockMessagingClient(), req, false)
+	assert.Error(err)
+}
+
+func TestScheduledNotificationWithoutScheduler(t *testing.T) {
+	assert := assert.New(t)
+
+	// Delivery times should be ignored if the scheduler is disabled.
+	req := getLegacyNotificationRequest()
+	req["deliver_at"] = time.Now().Add(time.Hour).Format(time.RFC3339)
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false, WithImmediateDelivery())
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Empty(databaseClient.PendingDeliveries)
+	assert.Len(messagingClient.PublishedNotificationMessages, 1)
+	assert.NotNil(messagingClient.PublishedEmailRequest)
+}
+
+func TestNotificationExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	// The expiration time should be stored with the notification.
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	req := getLegacyNotificationRequest()
+	req["expires_at"] = expiresAt.Format(time.RFC3339)
+	databaseClient := NewMockDatabaseClient(42)
+	err := handleTestRequest(databaseClient, NewMockMessagingClient(), req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	if assert.NotNil(databaseClient.SavedNotification.ExpiresAt) {
+		assert.True(expiresAt.Equal(*databaseClient.SavedNotification.ExpiresAt))
+	}
+
+	// Invalid expiration times should be rejected.
+	req["expires_at"] = "later"
+	err = handleTestRequest(NewMockDatabaseClient(42), NewMockMessagingClient(), req, false)
+	assert.Error(err)
+}
+
+func TestNotificationGrouping(t *testing.T) {
+	assert := assert.New(t)
+
+	// Analysis notifications should be grouped by analysis ID.
+	req := getLegacyNotificationRequest()
+	req["payload"].(map[string]interface{})["id"] = "a1"
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.GroupedNotifications["analysis:a1"] = "previous"
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal("analysis:a1", databaseClient.SavedNotification.GroupingKey)
+	assert.Equal(FakeNotificationID, databaseClient.GroupedNotifications["analysis:a1"])
+
+	// The published message should identify the notification that it replaces.
+	if assert.Len(messagingClient.PublishedNotificationMessages, 1) {
+		msg := messagingClient.PublishedNotificationMessages[0].Message
+		assert.Equal([]string{"previous"}, msg.Message["supersedes"])
+	}
+
+	// An explicit grouping key should take precedence.
+	req["grouping_key"] = "custom"
+	databaseClient = NewMockDatabaseClient(42)
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal("custom", databaseClient.SavedNotification.GroupingKey)
+}
+
+func TestNotificationWithoutGroup(t *testing.T) {
+	assert := assert.New(t)
+
+	// Notifications without a grouping key shouldn't supersede anything.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, getLegacyNotificationRequest(), false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Empty(databaseClient.SavedNotification.GroupingKey)
+	assert.Empty(databaseClient.GroupedNotifications)
+	if assert.Len(messagingClient.PublishedNotificationMessages, 1) {
+		assert.NotContains(messagingClient.PublishedNotificationMessages[0].Message.Message, "supersedes")
+	}
+}
+
+func TestThreadID(t *testing.T) {
+	assert := assert.New(t)
+
+	// Thread IDs should be derived from known payload fields.
+	request := &LegacyRequest{Payload: map[string]interface{}{"id": "a1", "team_name": "ipcdev:team"}}
+	assert.Equal("analysis:a1", threadID("analysis", request))
+	assert.Equal("tool_request:a1", threadID("tool_request", request))
+	assert.Equal("team:ipcdev:team", threadID("team", request))
+	assert.Equal("", threadID("data", request))
+
+	// An explicit thread ID should take precedence.
+	request.ThreadID = "custom"
+	assert.Equal("custom", threadID("analysis", request))
+	assert.Equal("custom", threadID("data", request))
+}
+
+func TestNotificationThread(t *testing.T) {
+	assert := assert.New(t)
+
+	// The thread ID should be stored and included in the published message.
+	req := getLegacyNotificationRequest()
+	req["thread_id"] = "support:1234"
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal("support:1234", databaseClient.SavedNotification.ThreadID)
+	if assert.Len(messagingClient.PublishedNotificationMessages, 1) {
+		assert.Equal("support:1234", messagingClient.PublishedNotificationMessages[0].Message.Message["thread_id"])
+	}
+}
+
+func TestNotificationSeverity(t *testing.T) {
+	assert := assert.New(t)
+
+	// The severity should default to info.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, getLegacyNotificationRequest(), false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal(common.SeverityInfo, databaseClient.SavedNotification.Severity)
+	assert.Equal(common.SeverityInfo, messagingClient.PublishedNotificationMessage.Message.Message["severity"])
+
+	// The severity should be normalized and stored.
+	req := getLegacyNotificationRequest()
+	req["severity"] = "Warning"
+	databaseClient = NewMockDatabaseClient(42)
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal(common.SeverityWarning, databaseClient.SavedNotification.Severity)
+
+	// Unsupported severities should be rejected.
+	req["severity"] = "urgent"
+	err = handleTestRequest(NewMockDatabaseClient(42), NewMockMessagingClient(), req, false)
+	assert.Error(err)
+}
+
+func TestEmailOptOut(t *testing.T) {
+	assert := assert.New(t)
+
+	// Recipients who opted out shouldn't receive email.
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.EmailOptOuts["sarahr"] = true
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, getLegacyNotificationRequest(), false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Nil(messagingClient.PublishedEmailRequest)
+	assert.NotNil(messagingClient.PublishedNotificationMessage)
+
+	// Severe notifications should override the opt-out.
+	req := getLegacyNotificationRequest()
+	req["severity"] = common.SeverityCritical
+	messagingClient = NewMockMessagingClient()
+	err = handleTestRequest(databaseClient, messagingClient, req, false, WithEmailOverride(common.SeverityCritical))
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.NotNil(messagingClient.PublishedEmailRequest)
+
+	// Opt-outs should always be honored if there's no override.
+	messagingClient = NewMockMessagingClient()
+	err = handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Nil(messagingClient.PublishedEmailRequest)
+}
+
+// newTestRateLimiter returns a rate limiter that allows one notification per user and then throttles the rest.
+func newTestRateLimiter(overflow string) *ratelimit.Limiter {
+	return ratelimit.New(ratelimit.Settings{
+		User:     ratelimit.Limit{Rate: 1.0 / 3600, Burst: 1},
+		Overflow: overflow,
+	})
+}
+
+func TestRateLimitDrop(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newTestRateLimiter(ratelimit.OverflowDrop)
+	opt := WithRateLimiter(limiter)
+
+	// The first notification should be delivered.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(databaseClient, messagingClient, getLegacyNotificationRequest(), false, opt)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Len(databaseClient.SavedNotifications, 1)
+	assert.NotNil(messagingClient.PublishedEmailRequest)
+
+	// The second notification should be dropped.
+	messagingClient = NewMockMessagingClient()
+	err = handleTestRequest(databaseClient, messagingClient, getLegacyNotificationRequest(), false, opt)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Len(databaseClient.SavedNotifications, 1)
+	assert.Nil(messagingClient.PublishedEmailRequest)
+	assert.Empty(messagingClient.PublishedNotificationMessages)
+	expected := []*ratelimit.ThrottledCount{{NotificationType: "analysis", Action: ratelimit.OverflowDrop, Count: 1}}
+	assert.Equal(expected, limiter.Throttled())
+}
+
+func TestRateLimitRefundedOnRollback(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newTestRateLimiter(ratelimit.OverflowDrop)
+	opt := WithRateLimiter(limiter)
+
+	// The first delivery can't be committed, so it shouldn't count against the limit.
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.CommitError = errors.New("connection reset by peer")
+	err := handleTestRequest(databaseClient, NewMockMessagingClient(), getLegacyNotificationRequest(), false, opt)
+	assert.IsType(RecoverableError{}, err)
+
+	// The redelivered notification should still be allowed.
+	databaseClient.CommitError = nil
+	messagingClient := NewMockMessagingClient()
+	err = handleTestRequest(databaseClient, messagingClient, getLegacyNotificationRequest(), true, opt)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.NotNil(messagingClient.PublishedEmailRequest)
+	assert.Empty(limiter.Throttled())
+}
+
+func TestRateLimitCollapse(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newTestRateLimiter(ratelimit.OverflowCollapse)
+	opt := WithRateLimiter(limiter)
+	databaseClient := NewMockDatabaseClient(42)
+
+	// Send three notifications, the last two of which exceed the limit.
+	var messagingClients []*MockMessagingClient
+	for i := 0; i < 3; i++ {
+		messagingClient := NewMockMessagingClient()
+		err := handleTestRequest(databaseClient, messagingClient, getLegacyNotificationRequest(), false, opt)
+		if err != nil {
+			t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+		}
+		messagingClients = append(messagingClients, messagingClient)
+	}
+
+	// Every notification should be stored, but the throttled notifications should share a group.
+	if !assert.Len(databaseClient.SavedNotifications, 3) {
+		return
+	}
+	assert.Empty(databaseClient.SavedNotifications[0].GroupingKey)
+	groupingKey := databaseClient.SavedNotifications[1].GroupingKey
+	assert.True(strings.HasPrefix(groupingKey, "rate-limit:analysis:"))
+	assert.Equal(groupingKey, databaseClient.SavedNotifications[2].GroupingKey)
+	assert.Equal("some job status changed", databaseClient.SavedNotifications[1].Subject)
+	assert.Equal("some job status changed (and 1 similar notifications)", databaseClient.SavedNotifications[2].Subject)
+
+	// Only the first notification should trigger an email.
+	assert.NotNil(messagingClients[0].PublishedEmailRequest)
+	assert.Nil(messagingClients[1].PublishedEmailRequest)
+	assert.Nil(messagingClients[2].PublishedEmailRequest)
+
+	// The summary should replace the previous throttled notification.
+	if assert.Len(messagingClients[2].PublishedNotificationMessages, 1) {
+		msg := messagingClients[2].PublishedNotificationMessages[0].Message
+		assert.Equal([]string{FakeNotificationID}, msg.Message["supersedes"])
+	}
+}
+
+func TestRateLimitDefer(t *testing.T) {
+	assert := assert.New(t)
+	limiter := newTestRateLimiter(ratelimit.OverflowDefer)
+	opt := WithRateLimiter(limiter)
+	databaseClient := NewMockDatabaseClient(42)
+
+	// The first notification should be delivered immediately and the second should be deferred.
+	for i := 0; i < 2; i++ {
+		err := handleTestRequest(databaseClient, NewMockMessagingClient(), getLegacyNotificationRequest(), false, opt)
+		if err != nil {
+			t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+		}
+	}
+	assert.Len(databaseClient.SavedNotifications, 2)
+	if assert.Len(databaseClient.PendingDeliveries, 1) {
+		pendingDelivery := databaseClient.PendingDeliveries[0]
+		assert.NotEmpty(pendingDelivery.ScheduleID)
+		assert.WithinDuration(time.Now().Add(time.Hour), pendingDelivery.DeliverAt, time.Minute)
+		assert.NotEmpty(pendingDelivery.EmailRequest)
+	}
+}
+
+func TestNotificationTemplates(t *testing.T) {
+	assert := assert.New(t)
+
+	set, err := templates.NewSet([]*common.NotificationTemplate{
+		{
+			NotificationType: "analysis",
+			Subject:          "{{.payload.analysisname}} {{lower .payload.analysisstatus}}",
+			Body:             "Your analysis, {{.payload.analysisname}}, has {{lower .payload.analysisstatus}}.",
+		},
+		{NotificationType: "analysis", Locale: "es", Subject: "{{.payload.analysisname}} terminado"},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	opt := WithTemplates(templates.NewStaticRenderer(set))
+
+	// The text should be rendered from the template if the event doesn't include it.
+	req := getLegacyNotificationRequest()
+	delete(req, "subject")
+	delete(req, "message")
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err = handleTestRequest(databaseClient, messagingClient, req, false, opt)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal("some job completed", databaseClient.SavedNotification.Subject)
+	assert.Equal("some job completed", messagingClient.PublishedEmailRequest.Subject)
+	assert.Equal("Your analysis, some job, has completed.", databaseClient.savedOutgoingMessage.Message["text"])
+
+	// The template for the requested locale should be used, and text included in the event should take precedence.
+	req = getLegacyNotificationRequest()
+	delete(req, "subject")
+	req["locale"] = "es-MX"
+	databaseClient = NewMockDatabaseClient(42)
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, opt)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal("some job terminado", databaseClient.SavedNotification.Subject)
+	assert.Equal("This is a test message", databaseClient.savedOutgoingMessage.Message["text"])
+}
+
+func TestNotificationLocalization(t *testing.T) {
+	assert := assert.New(t)
+
+	set, err := templates.NewSet([]*common.NotificationTemplate{
+		{NotificationType: "analysis", Subject: "{{.payload.analysisname}} finished"},
+		{NotificationType: "analysis", Locale: "es", Subject: "{{.payload.analysisname}} terminado"},
+		{NotificationType: "analysis", Locale: "fr", Subject: "{{.payload.analysisname}} terminé"},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	opt := WithTemplates(templates.NewStaticRenderer(set))
+
+	// Each recipient should receive the notification in their preferred locale, falling back to the locale
+	// requested by the event.
+	req := getLegacyNotificationRequest()
+	delete(req, "subject")
+	req["users"] = []string{"ipcdev", "ipctest"}
+	req["locale"] = "fr"
+	databaseClient := NewMockDatabaseClient(42)
+	databaseClient.UserLocales["ipcdev"] = "es"
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, opt)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	if assert.Len(databaseClient.SavedNotifications, 3) {
+		assert.Equal("some job terminé", databaseClient.SavedNotifications[0].Subject)
+		assert.Equal("some job terminado", databaseClient.SavedNotifications[1].Subject)
+		assert.Equal("some job terminé", databaseClient.SavedNotifications[2].Subject)
+	}
+}
+
+// fakeEmailSender records the email requests that it's asked to send.
+type fakeEmailSender struct {
+	sent []*messaging.EmailRequest
+	err  error
+}
+
+// Send records an email request and returns the configured error.
+func (s *fakeEmailSender) Send(_ context.Context, request *messaging.EmailRequest) error {
+	s.sent = append(s.sent, request)
+	return s.err
+}
+
+func TestLegacyEmailSender(t *testing.T) {
+	assert := assert.New(t)
+
+	// Email requests should be sent directly, but notification messages should still be published.
+	sender := &fakeEmailSender{}
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	req := getLegacyNotificationRequest()
+	err := handleTestRequest(databaseClient, messagingClient, req, false, WithEmailSender(sender))
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Len(sender.sent, 1)
+	assert.Nil(messagingClient.PublishedEmailRequest)
+	assert.NotNil(messagingClient.PublishedNotificationMessage)
+	if assert.Len(databaseClient.EmailDeliveries, 1) {
+		assert.Equal(common.EmailStatusSent, databaseClient.EmailDeliveries[0].Status)
+	}
+
+	// Messages that can never be sent should be recorded as failed rather than retried.
+	sender = &fakeEmailSender{err: &textproto.Error{Code: 550, Msg: "no such user"}}
+	databaseClient = NewMockDatabaseClient(42)
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, WithEmailSender(sender))
+	assert.NoError(err)
+	if assert.Len(databaseClient.EmailDeliveries, 1) {
+		assert.Equal(common.EmailStatusFailed, databaseClient.EmailDeliveries[0].Status)
+	}
+
+	// Messages are only sent once the notification has been committed, so other failures are recorded rather than
+	// causing the event to be redelivered.
+	sender = &fakeEmailSender{err: &textproto.Error{Code: 451, Msg: "try again later"}}
+	databaseClient = NewMockDatabaseClient(42)
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, WithEmailSender(sender))
+	assert.NoError(err)
+	if assert.Len(databaseClient.EmailDeliveries, 1) {
+		assert.Equal(common.EmailStatusFailed, databaseClient.EmailDeliveries[0].Status)
+		assert.Contains(databaseClient.EmailDeliveries[0].Detail, "try again later")
+	}
+
+	// Nothing should be sent if the transaction can't be committed.
+	sender = &fakeEmailSender{}
+	databaseClient = NewMockDatabaseClient(42)
+	databaseClient.CommitError = errors.New("connection reset by peer")
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, WithEmailSender(sender))
+	assert.IsType(RecoverableError{}, err)
+	assert.Empty(sender.sent)
+}
+
+func TestLegacyEmailTracking(t *testing.T) {
+	assert := assert.New(t)
+
+	// Published email requests should be recorded as queued and identify their delivery.
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	req := getLegacyNotificationRequest()
+	err := handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	if assert.Len(databaseClient.EmailDeliveries, 1) {
+		delivery := databaseClient.EmailDeliveries[0]
+		assert.Equal(common.EmailStatusQueued, delivery.Status)
+		assert.Equal(FakeNotificationID, delivery.NotificationID)
+		assert.Equal(delivery.ID, messagingClient.PublishedEmailRequest.TemplateValues[EmailDeliveryIDKey])
+	}
+
+	// Email shouldn't be sent to suppressed addresses, but the attempt should be recorded.
+	databaseClient = NewMockDatabaseClient(42)
+	databaseClient.SuppressedAddresses["sarahr@cyverse.org"] = true
+	messagingClient = NewMockMessagingClient()
+	err = handleTestRequest(databaseClient, messagingClient, req, false)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Nil(messagingClient.PublishedEmailRequest)
+	assert.NotNil(messagingClient.PublishedNotificationMessage)
+	if assert.Len(databaseClient.EmailDeliveries, 1) {
+		assert.Equal(common.EmailStatusFailed, databaseClient.EmailDeliveries[0].Status)
+	}
+}
+
+func TestLegacyUnsubscribeLinks(t *testing.T) {
+	assert := assert.New(t)
+
+	signer, err := unsubscribe.NewSigner(&unsubscribe.Settings{
+		Key:     []byte(strings.Repeat("k", unsubscribe.MinKeyLength)),
+		TTL:     time.Hour,
+		BaseURL: "https://de.example.org/unsubscribe",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+
+	// The email request should include tokens for the notification type and for all notification types.
+	messagingClient := NewMockMessagingClient()
+	req := getLegacyNotificationRequest()
+	err = handleTestRequest(NewMockDatabaseClient(42), messagingClient, req, false, WithUnsubscribeLinks(signer))
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	values := messagingClient.PublishedEmailRequest.TemplateValues
+	claims, err := signer.Verify(values[unsubscribe.TokenKey].(string), time.Now())
+	if assert.NoError(err) {
+		assert.Equal("sarahr", claims.User)
+		assert.Equal("analysis", claims.NotificationType)
+	}
+	claims, err = signer.Verify(values[unsubscribe.AllTokenKey].(string), time.Now())
+	if assert.NoError(err) {
+		assert.Equal("*", claims.NotificationType)
+	}
+	assert.Contains(values[unsubscribe.URLKey], "https://de.example.org/unsubscribe?token=")
+	assert.Contains(values[unsubscribe.AllURLKey], "https://de.example.org/unsubscribe?token=")
+}
+
+// MockProfiles provides a mock implementation of the user profile lookup.
+type MockProfiles struct {
+	addresses map[string]string
+}
+
+// Profile returns the profile of a user in the mock profile lookup.
+func (p *MockProfiles) Profile(_ context.Context, user string) (*profiles.Profile, error) {
+	address, ok := p.addresses[user]
+	if !ok {
+		return nil, profiles.NotFoundError{User: user}
+	}
+	return &profiles.Profile{Username: user, Email: address}, nil
+}
+
+func TestLegacyProfileEmailAddresses(t *testing.T) {
+	assert := assert.New(t)
+
+	userProfiles := &MockProfiles{
+		addresses: map[string]string{"sarahr": "sarahr@example.org", "ipcdev": "ipcdev@example.org"},
+	}
+
+	// The address in the user's profile should be used instead of the address in the payload.
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(
+		NewMockDatabaseClient(42), messagingClient, getLegacyNotificationRequest(), false,
+		WithProfiles(userProfiles, false),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	if assert.NotNil(messagingClient.PublishedEmailRequest) {
+		assert.Equal("sarahr@example.org", messagingClient.PublishedEmailRequest.ToAddress)
+	}
+
+	// Every recipient of a multi-recipient event should receive email, except for users without profiles.
+	databaseClient := NewMockDatabaseClient(42)
+	req := getLegacyNotificationRequest()
+	req["users"] = []string{"ipcdev", "psarando"}
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, WithProfiles(userProfiles, true))
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Equal([]string{"sarahr", "ipcdev", "psarando"}, savedUsers(databaseClient))
+	var addresses []string
+	for _, delivery := range databaseClient.EmailDeliveries {
+		addresses = append(addresses, delivery.Address)
+	}
+	assert.Equal([]string{"sarahr@example.org", "ipcdev@example.org"}, addresses)
+}
+
+func TestLegacyProfilePayloadFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	userProfiles := &MockProfiles{addresses: map[string]string{}}
+
+	// The payload address shouldn't be used unless fallback is enabled.
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(
+		NewMockDatabaseClient(42), messagingClient, getLegacyNotificationRequest(), false,
+		WithProfiles(userProfiles, false),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	assert.Nil(messagingClient.PublishedEmailRequest)
+
+	// The payload address should be used for users without profiles if fallback is enabled.
+	messagingClient = NewMockMessagingClient()
+	err = handleTestRequest(
+		NewMockDatabaseClient(42), messagingClient, getLegacyNotificationRequest(), false,
+		WithProfiles(userProfiles, true),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	if assert.NotNil(messagingClient.PublishedEmailRequest) {
+		assert.Equal("sarahr@cyverse.org", messagingClient.PublishedEmailRequest.ToAddress)
+	}
+}
+
+func TestNotificationPayloadValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	set, err := payloads.NewSet([]*common.PayloadSchema{{
+		NotificationType: "analysis",
+		Schema:           []byte(`{"type": "object", "properties": {"analysisstatus": {"enum": ["Completed"]}}}`),
+	}})
+	if err != nil {
+		t.Fatalf("unable to compile the payload schema: %s", err.Error())
+	}
+	validator := payloads.NewStaticValidator(set)
+
+	// Valid payloads should be accepted.
+	databaseClient := NewMockDatabaseClient(42)
+	err = handleTestRequest(
+		databaseClient, NewMockMessagingClient(), getLegacyNotificationRequest(), false,
+		WithPayloadValidator(validator),
+	)
+	assert.NoError(err)
+	assert.Len(databaseClient.SavedNotifications, 1)
+
+	// Invalid payloads should be rejected before anything is stored.
+	req := getLegacyNotificationRequest()
+	req["payload"].(map[string]interface{})["analysisstatus"] = "Failed"
+	databaseClient = NewMockDatabaseClient(42)
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, WithPayloadValidator(validator))
+	if assert.IsType(UnrecoverableError{}, err) {
+		assert.Contains(err.Error(), "/analysisstatus")
+	}
+	assert.Empty(databaseClient.SavedNotifications)
+}
+
+func TestUnknownNotificationTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	types := catalog.NewStatic([]*common.NotificationType{{Name: "data"}})
+
+	// Unknown notification types should be registered automatically in open mode.
+	databaseClient := NewMockDatabaseClient(42)
+	err := handleTestRequest(
+		databaseClient, NewMockMessagingClient(), getLegacyNotificationRequest(), false,
+		WithNotificationTypes(types, catalog.ModeOpen),
+	)
+	assert.NoError(err)
+	assert.Equal("analysis", databaseClient.RegisteredNotificationType)
+	assert.Len(databaseClient.SavedNotifications, 1)
+
+	// Unknown notification types should be rejected in reject mode.
+	databaseClient = NewMockDatabaseClient(42)
+	err = handleTestRequest(
+		databaseClient, NewMockMessagingClient(), getLegacyNotificationRequest(), false,
+		WithNotificationTypes(types, catalog.ModeReject),
+	)
+	assert.IsType(UnrecoverableError{}, err)
+	assert.Empty(databaseClient.RegisteredNotificationType)
+	assert.Empty(databaseClient.SavedNotifications)
+
+	// Unknown notification types should be set aside in quarantine mode.
+	databaseClient = NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err = handleTestRequest(
+		databaseClient, messagingClient, getLegacyNotificationRequest(), false,
+		WithNotificationTypes(types, catalog.ModeQuarantine),
+	)
+	assert.NoError(err)
+	assert.Empty(databaseClient.SavedNotifications)
+	assert.Empty(messagingClient.PublishedNotificationMessages)
+	assert.True(databaseClient.CommitCalled)
+	if assert.Len(databaseClient.QuarantinedEvents, 1) {
+		event := databaseClient.QuarantinedEvents[0]
+		assert.Equal("analysis", event.NotificationType)
+		assert.Equal(FakeRoutingKey, event.RoutingKey)
+		assert.Contains(event.Body, "some job status changed")
+	}
+}
+
+func TestNotificationTypeDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	types := catalog.NewStatic([]*common.NotificationType{{
+		Name:            "analysis",
+		DefaultChannels: []string{common.ChannelUI, common.ChannelEmail},
+		DefaultSeverity: common.SeverityWarning,
+		RetentionDays:   30,
+	}})
+
+	// The type's defaults should be used when the event doesn't specify its own settings.
+	req := getLegacyNotificationRequest()
+	delete(req, "email")
+	databaseClient := NewMockDatabaseClient(42)
+	messagingClient := NewMockMessagingClient()
+	err := handleTestRequest(
+		databaseClient, messagingClient, req, false, WithNotificationTypes(types, catalog.ModeReject),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	notification := databaseClient.SavedNotification
+	assert.Equal(common.SeverityWarning, notification.Severity)
+	assert.NotNil(messagingClient.PublishedEmailRequest)
+	if assert.NotNil(notification.ExpiresAt) {
+		assert.True(notification.TimeCreated.AddDate(0, 0, 30).Equal(*notification.ExpiresAt))
+	}
+
+	// Settings in the event should take precedence.
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	req["email"] = false
+	req["severity"] = "error"
+	req["expires_at"] = expiresAt.Format(time.RFC3339)
+	databaseClient = NewMockDatabaseClient(42)
+	messagingClient = NewMockMessagingClient()
+	err = handleTestRequest(
+		databaseClient, messagingClient, req, false, WithNotificationTypes(types, catalog.ModeReject),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error returned by legacy handler: %s", err.Error())
+	}
+	notification = databaseClient.SavedNotification
+	assert.Equal(common.SeverityError, notification.Severity)
+	assert.Nil(messagingClient.PublishedEmailRequest)
+	if assert.NotNil(notification.ExpiresAt) {
+		assert.True(expiresAt.Equal(*notification.ExpiresAt))
+	}
+
+	// Email shouldn't be sent by default if the type doesn't list it as a default channel.
+	types = catalog.NewStatic([]*common.NotificationType{{Name: "analysis", DefaultChannels: []string{"ui"}}})
+	delete(req, "email")
+	messagingClient = NewMockMessagingClient()
+	err = handleTestRequest(
+		NewMockDatabaseClient(42), messagingClient, req, false, WithNotificationTypes(types, catalog.ModeOpen),
+	)
+	assert.NoError(err)
+	assert.Nil(messagingClient.PublishedEmailRequest)
+}
+
+// fakeAuditSink records the audit records published to it.
+type fakeAuditSink struct {
+	records []audit.Record
+	err     error
+}
+
+// Publish records the audit records and returns the configured error.
+func (s *fakeAuditSink) Publish(_ context.Context, records ...audit.Record) error {
+	s.records = append(s.records, records...)
+	return s.err
+}
+
+// Close does nothing.
+func (s *fakeAuditSink) Close() error {
+	return nil
+}
+
+func TestLegacyAuditRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	// A record should be published for each committed notification.
+	sink := &fakeAuditSink{}
+	databaseClient := NewMockDatabaseClient(42)
+	req := getLegacyNotificationRequest()
+	req["users"] = []string{"sarahr", "ipcdev"}
+	err := handleTestRequest(databaseClient, NewMockMessagingClient(), req, false, WithAuditSink(sink))
+	if !assert.NoError(err) || !assert.Len(sink.records, 2) {
+		return
+	}
+	for i, user := range []string{"sarahr", "ipcdev"} {
+		record := sink.records[i]
+		assert.Equal(audit.EventCreated, record.Event)
+		assert.Equal(FakeNotificationID, record.ID)
+		assert.Equal(user, record.User)
+		assert.Equal("analysis", record.NotificationType)
+		assert.True(databaseClient.SavedNotifications[i].TimeCreated.Equal(record.TimeCreated))
+		assert.False(record.Seen)
+		assert.False(record.Deleted)
+		assert.False(record.Timestamp.IsZero())
+	}
+
+	// Superseded notifications should be reported as deleted.
+	sink = &fakeAuditSink{}
+	databaseClient = NewMockDatabaseClient(42)
+	databaseClient.GroupedNotifications["custom"] = "previous"
+	grouped := getLegacyNotificationRequest()
+	grouped["grouping_key"] = "custom"
+	err = handleTestRequest(databaseClient, NewMockMessagingClient(), grouped, false, WithAuditSink(sink))
+	if assert.NoError(err) && assert.Len(sink.records, 2) {
+		assert.Equal(audit.EventDeleted, sink.records[0].Event)
+		assert.Equal("previous", sink.records[0].ID)
+		assert.Equal("sarahr", sink.records[0].User)
+		assert.True(sink.records[0].Deleted)
+		assert.Equal(audit.EventCreated, sink.records[1].Event)
+		assert.Equal(FakeNotificationID, sink.records[1].ID)
+	}
+
+	// Failures to publish audit records shouldn't cause the delivery to be retried.
+	sink = &fakeAuditSink{err: errors.New("broker unavailable")}
+	err = handleTestRequest(NewMockDatabaseClient(42), NewMockMessagingClient(), req, false, WithAuditSink(sink))
+	assert.NoError(err)
+
+	// No records should be published for events that are rejected.
+	sink = &fakeAuditSink{}
+	req["timestamp"] = "yesterday"
+	err = handleTestRequest(NewMockDatabaseClient(42), NewMockMessagingClient(), req, false, WithAuditSink(sink))
+	assert.Error(err)
+	assert.Empty(sink.records)
+}
@@ -4,14 +4,21 @@ import (
 	"context"
 	"database/sql"
 
+	"github.com/cyverse-de/event-recorder/cloudevents"
 	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/email"
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/event-recorder/transport"
 
 	"github.com/cyverse-de/event-recorder/common"
 	"github.com/cyverse-de/messaging/v12"
-	"github.com/pkg/errors"
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
 )
 
+var log = logging.Log.WithFields(logrus.Fields{"package": "handlers"})
+
 // MessageHandler describes the interface used to handle AMQP messages.
 type MessageHandler interface {
 	HandleMessage(ctx context.Context, updateType string, delivery amqp.Delivery) error
@@ -25,6 +32,106 @@ type MessagingClient interface {
 	PublishNotificationMessageContext(context.Context, *messaging.WrappedNotificationMessage) error
 }
 
+// directEmailClient is a messaging client that sends email requests directly rather than publishing them for the
+// email service. Notification messages are still published.
+type directEmailClient struct {
+	MessagingClient
+	sender email.Sender
+}
+
+// NewDirectEmailClient returns a messaging client that publishes notification messages using an existing client but
+// sends email requests using the given sender.
+func NewDirectEmailClient(messagingClient MessagingClient, sender email.Sender) MessagingClient {
+	return &directEmailClient{MessagingClient: messagingClient, sender: sender}
+}
+
+// PublishEmailRequestContext sends an email request.
+func (c *directEmailClient) PublishEmailRequestContext(ctx context.Context, request *messaging.EmailRequest) error {
+	return c.sender.Send(ctx, request)
+}
+
+// EmailDeliveryIDKey is the template value that identifies the email delivery that an email request belongs to, so
+// that delivery status events can refer to it.
+const EmailDeliveryIDKey = "email_delivery_id"
+
+// DirectEmail is an email request that should be sent directly once the transaction that recorded its delivery has
+// been committed. Sending the message before then could send it more than once, because a transaction that's rolled
+// back causes the event to be redelivered.
+type DirectEmail struct {
+	Delivery *common.EmailDelivery
+	Request  *messaging.EmailRequest
+}
+
+// Send sends the email message and returns the resulting status of its delivery along with a description of any
+// failure. The delivery has already been committed, so a message that can't be sent is recorded as failed rather than
+// being retried.
+func (d *DirectEmail) Send(ctx context.Context, messagingClient MessagingClient) (string, string) {
+	err := messagingClient.PublishEmailRequestContext(ctx, d.Request)
+	if err == nil {
+		return common.EmailStatusSent, ""
+	}
+	if email.IsPermanent(err) {
+		log.Errorf("discarding an email message that can't be sent: %s", err.Error())
+	} else {
+		log.Errorf("unable to send an email message: %s", err.Error())
+	}
+	return common.EmailStatusFailed, err.Error()
+}
+
+// DeliverEmailRequest publishes the email request for a notification and returns a record of the attempt. The ID of
+// the delivery is added to a copy of the request's template values. If the messaging client sends email directly,
+// nothing is sent yet; instead, the delivery is recorded as queued, and the message that the caller must send once
+// its transaction has been committed is returned.
+func DeliverEmailRequest(
+	ctx context.Context,
+	messagingClient MessagingClient,
+	notificationID string,
+	request *messaging.EmailRequest,
+) (*common.EmailDelivery, *DirectEmail, error) {
+	delivery := &common.EmailDelivery{
+		ID:             uuid.NewString(),
+		NotificationID: notificationID,
+		Address:        request.ToAddress,
+		Template:       request.TemplateName,
+		Status:         common.EmailStatusQueued,
+	}
+
+	// Identify the delivery in the template values.
+	values := make(map[string]interface{}, len(request.TemplateValues)+1)
+	for k, v := range request.TemplateValues {
+		values[k] = v
+	}
+	values[EmailDeliveryIDKey] = delivery.ID
+	tracked := *request
+	tracked.TemplateValues = values
+
+	// Defer direct delivery until the transaction has been committed.
+	if _, direct := messagingClient.(*directEmailClient); direct {
+		return delivery, &DirectEmail{Delivery: delivery, Request: &tracked}, nil
+	}
+
+	// Publish the request.
+	err := messagingClient.PublishEmailRequestContext(ctx, &tracked)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return delivery, nil, nil
+}
+
+// SuppressedEmailDelivery returns the record of an email request that wasn't sent because the recipient's address
+// has been suppressed.
+func SuppressedEmailDelivery(notificationID string, request *messaging.EmailRequest) *common.EmailDelivery {
+	return &common.EmailDelivery{
+		ID:             uuid.NewString(),
+		NotificationID: notificationID,
+		Address:        request.ToAddress,
+		Template:       request.TemplateName,
+		Status:         common.EmailStatusFailed,
+		Detail:         "the address has been suppressed because of repeated hard bounces",
+	}
+}
+
 // DatabaseClient provides a wrapper around functions that handlers might call in order to interact
 // with the database.
 type DatabaseClient interface {
@@ -35,6 +142,17 @@ type DatabaseClient interface {
 	SaveNotification(context.Context, *sql.Tx, *common.Notification) error
 	SaveOutgoingNotification(context.Context, *sql.Tx, *messaging.NotificationMessage) error
 	CountUnreadNotifications(context.Context, *sql.Tx, string) (int64, error)
+	NotificationExists(context.Context, *sql.Tx, string, string, string) (bool, error)
+	CloudEventNotificationExists(context.Context, *sql.Tx, string, string, string, string) (bool, error)
+	SchedulePendingDelivery(context.Context, *sql.Tx, *common.PendingDelivery) error
+	SupersedeNotifications(context.Context, *sql.Tx, string, string, string) ([]*common.Notification, error)
+	EmailOptedOut(context.Context, *sql.Tx, string, string) (bool, error)
+	GetUserLocale(context.Context, *sql.Tx, string) (string, error)
+	EmailSuppressed(context.Context, *sql.Tx, string) (bool, error)
+	SaveEmailDelivery(context.Context, *sql.Tx, *common.EmailDelivery) error
+	UpdateEmailDeliveryStatus(context.Context, *sql.Tx, string, string, string) (string, bool, error)
+	RecordHardBounce(context.Context, *sql.Tx, string, string, int) (bool, error)
+	QuarantineEvent(context.Context, *sql.Tx, *common.QuarantinedEvent) error
 }
 
 // DatabaseClientImpl provides the default implementation of DatabaseClient.
@@ -82,48 +200,128 @@ func (c *DatabaseClientImpl) CountUnreadNotifications(ctx context.Context, tx *s
 	return db.CountUnreadNotifications(ctx, tx, user)
 }
 
+// NotificationExists returns true if a notification created from an incoming message with the given routing key
+// and body has already been stored for a user.
+func (c *DatabaseClientImpl) NotificationExists(
+	ctx context.Context,
+	tx *sql.Tx,
+	user, routingKey, incomingJSON string,
+) (bool, error) {
+	return db.NotificationExists(ctx, tx, user, routingKey, incomingJSON)
+}
+
+// CloudEventNotificationExists returns true if a notification created from the CloudEvent with the given source and
+// ID has already been stored for a user.
+func (c *DatabaseClientImpl) CloudEventNotificationExists(
+	ctx context.Context,
+	tx *sql.Tx,
+	user, routingKey, source, id string,
+) (bool, error) {
+	return db.CloudEventNotificationExists(ctx, tx, user, routingKey, source, id)
+}
+
+// SchedulePendingDelivery records a notification that should be delivered at a later time.
+func (c *DatabaseClientImpl) SchedulePendingDelivery(
+	ctx context.Context,
+	tx *sql.Tx,
+	pendingDelivery *common.PendingDelivery,
+) error {
+	return db.SchedulePendingDelivery(ctx, tx, pendingDelivery)
+}
+
+// SupersedeNotifications marks the user's current notification in a group as superseded by a new notification, and
+// returns the notifications that were superseded.
+func (c *DatabaseClientImpl) SupersedeNotifications(
+	ctx context.Context,
+	tx *sql.Tx,
+	user, groupingKey, id string,
+) ([]*common.Notification, error) {
+	return db.SupersedeNotifications(ctx, tx, user, groupingKey, id)
+}
+
+// EmailOptedOut returns true if a user has opted out of email for a notification type.
+func (c *DatabaseClientImpl) EmailOptedOut(ctx context.Context, tx *sql.Tx, user, notificationType string) (bool, error) {
+	return db.EmailOptedOut(ctx, tx, user, notificationType)
+}
+
+// GetUserLocale returns the locale that a user prefers to receive notifications in, or an empty string if the user
+// hasn't chosen one.
+func (c *DatabaseClientImpl) GetUserLocale(ctx context.Context, tx *sql.Tx, user string) (string, error) {
+	return db.GetUserLocale(ctx, tx, user)
+}
+
+// EmailSuppressed returns true if email may no longer be sent to an address because of repeated hard bounces.
+func (c *DatabaseClientImpl) EmailSuppressed(ctx context.Context, tx *sql.Tx, address string) (bool, error) {
+	return db.EmailSuppressed(ctx, tx, address)
+}
+
+// SaveEmailDelivery records an attempt to send the email for a notification.
+func (c *DatabaseClientImpl) SaveEmailDelivery(ctx context.Context, tx *sql.Tx, delivery *common.EmailDelivery) error {
+	return db.SaveEmailDelivery(ctx, tx, delivery)
+}
+
+// UpdateEmailDeliveryStatus updates the status of an email delivery unless it would move the delivery back to an
+// earlier status. It returns the address that the email was sent to, or an empty string if the delivery doesn't
+// exist, along with a flag indicating whether the status was changed.
+func (c *DatabaseClientImpl) UpdateEmailDeliveryStatus(
+	ctx context.Context,
+	tx *sql.Tx,
+	id, status, detail string,
+) (string, bool, error) {
+	return db.UpdateEmailDeliveryStatus(ctx, tx, id, status, detail)
+}
+
+// RecordHardBounce records a hard bounce for an email address, returning true if the address is now suppressed.
+func (c *DatabaseClientImpl) RecordHardBounce(
+	ctx context.Context,
+	tx *sql.Tx,
+	address, reason string,
+	threshold int,
+) (bool, error) {
+	return db.RecordHardBounce(ctx, tx, address, reason, threshold)
+}
+
+// QuarantineEvent stores an event whose notification type isn't registered.
+func (c *DatabaseClientImpl) QuarantineEvent(ctx context.Context, tx *sql.Tx, event *common.QuarantinedEvent) error {
+	return db.QuarantineEvent(ctx, tx, event)
+}
+
 // NewDatabaseClient creates a new default database client implementation.
 func NewDatabaseClient(db *sql.DB) DatabaseClient {
 	return &DatabaseClientImpl{db: db}
 }
 
-// createMessagingClient creates a new AMQP messaging client and sets up publishing on that client.
-func createMessagingClient(amqpSettings *common.AMQPSettings) (*messaging.Client, error) {
-	wrapMsg := "unable to create the messaging client"
-
-	// Create the messaging client.
-	client, err := messaging.NewClient(amqpSettings.URI, false)
-	if err != nil {
-		return nil, errors.Wrap(err, wrapMsg)
+// NewOutputClient returns the messaging client used to publish outgoing messages with a transport publisher.
+// Notification messages are published as CloudEvents if CloudEvents settings are provided.
+func NewOutputClient(publisher transport.Publisher, cloudEvents *cloudevents.Settings) MessagingClient {
+	if cloudEvents == nil {
+		return publisher
 	}
-
-	// Set up publishing on the messaging client.
-	err = client.SetupPublishing(amqpSettings.ExchangeName)
-	if err != nil {
-		client.Close()
-		return nil, errors.Wrap(err, wrapMsg)
-	}
-
-	return client, nil
+	return cloudevents.NewClient(publisher, *cloudEvents)
 }
 
-// InitMessageHandlers returns a map from category name to message handler.
-func InitMessageHandlers(db *sql.DB, amqpSettings *common.AMQPSettings) (map[string]MessageHandler, error) {
-	wrapMsg := "unable to initialize message handlers"
+// InitMessageHandlers returns a map from category name to message handler. Outgoing messages are published using the
+// publisher, and email addresses are suppressed after bounceThreshold hard bounces. Outgoing notification messages
+// are published as CloudEvents if CloudEvents settings are provided.
+func InitMessageHandlers(
+	db *sql.DB,
+	publisher transport.Publisher,
+	cloudEvents *cloudevents.Settings,
+	bounceThreshold int,
+	opts ...LegacyOption,
+) map[string]MessageHandler {
 
 	// Create the database client.
 	databaseClient := NewDatabaseClient(db)
 
 	// Create the messaging client.
-	messagingClient, err := createMessagingClient(amqpSettings)
-	if err != nil {
-		return nil, errors.Wrap(err, wrapMsg)
-	}
+	messagingClient := NewOutputClient(publisher, cloudEvents)
 
 	// Create the message handlers.
 	messageHandlers := map[string]MessageHandler{
-		"notification": NewLegacy(databaseClient, messagingClient),
+		"notification": NewLegacy(databaseClient, messagingClient, opts...),
+		"email_status": NewEmailStatus(databaseClient, bounceThreshold),
 	}
 
-	return messageHandlers, nil
+	return messageHandlers
 }
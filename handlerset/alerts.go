@@ -0,0 +1,219 @@
+package handlerset
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+)
+
+// maxSampleLength is the maximum number of bytes of a discarded message body included in a summary email.
+const maxSampleLength = 4096
+
+// AlertSettings determines how the support team is alerted to deliveries that are discarded because of
+// unrecoverable errors. Alerts are collected and sent in a summary email at each interval. If the same error occurs
+// for the same routing key at least ImmediateThreshold times during an interval, an alert for that error is sent
+// immediately as well. A threshold of zero disables immediate alerts.
+type AlertSettings struct {
+	Interval           time.Duration
+	ImmediateThreshold int
+	MaxSamples         int
+}
+
+// emailPublisher is the subset of the messaging client used to send alert emails.
+type emailPublisher interface {
+	PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error
+}
+
+// alertKey identifies a group of discarded deliveries.
+type alertKey struct {
+	cause      string
+	routingKey string
+}
+
+// alertGroup summarizes the deliveries discarded because of the same error for the same routing key.
+type alertGroup struct {
+	key       alertKey
+	count     int
+	samples   []string
+	firstSeen time.Time
+	lastSeen  time.Time
+	alerted   bool
+}
+
+// alertAggregator collects discarded deliveries and sends periodic summary emails to the support address.
+type alertAggregator struct {
+	settings     AlertSettings
+	supportEmail string
+	publisher    emailPublisher
+	mutex        sync.Mutex
+	groups       map[alertKey]*alertGroup
+	windowStart  time.Time
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+// newAlertAggregator creates a new alert aggregator.
+func newAlertAggregator(settings AlertSettings, supportEmail string, publisher emailPublisher) *alertAggregator {
+	return &alertAggregator{
+		settings:     settings,
+		supportEmail: supportEmail,
+		publisher:    publisher,
+		groups:       make(map[alertKey]*alertGroup),
+		windowStart:  time.Now(),
+	}
+}
+
+// record records a discarded delivery. An immediate alert is sent if the delivery causes its group to reach the
+// immediate alert threshold.
+func (a *alertAggregator) record(ctx context.Context, cause, routingKey string, body []byte, now time.Time) {
+	a.mutex.Lock()
+
+	// Add the delivery to its group.
+	key := alertKey{cause: cause, routingKey: routingKey}
+	group, ok := a.groups[key]
+	if !ok {
+		group = &alertGroup{key: key, firstSeen: now}
+		a.groups[key] = group
+	}
+	group.count++
+	group.lastSeen = now
+	if len(group.samples) < a.settings.MaxSamples {
+		group.samples = append(group.samples, truncateSample(body))
+	}
+
+	// Determine whether an immediate alert should be sent.
+	var request *messaging.EmailRequest
+	threshold := a.settings.ImmediateThreshold
+	if threshold > 0 && group.count >= threshold && !group.alerted {
+		group.alerted = true
+		request = a.immediateAlert(group, string(body))
+	}
+	a.mutex.Unlock()
+
+	if request != nil {
+		a.publish(ctx, request)
+	}
+}
+
+// immediateAlert builds the email request for an immediate alert. The caller must hold the lock.
+func (a *alertAggregator) immediateAlert(group *alertGroup, body string) *messaging.EmailRequest {
+	return &messaging.EmailRequest{
+		Subject:      "Repeated Unrecoverable Errors in the Event Recorder service",
+		ToAddress:    a.supportEmail,
+		TemplateName: "notifications_event_discarded",
+		TemplateValues: map[string]interface{}{
+			"error":        group.key.cause,
+			"routing_key":  group.key.routingKey,
+			"message_body": body,
+			"count":        group.count,
+			"since":        group.firstSeen.UTC().Format(time.RFC3339),
+		},
+	}
+}
+
+// flush sends a summary of the deliveries discarded since the previous summary, if there were any, and starts a new
+// summary interval.
+func (a *alertAggregator) flush(ctx context.Context, now time.Time) {
+	a.mutex.Lock()
+	groups := make([]*alertGroup, 0, len(a.groups))
+	for _, group := range a.groups {
+		groups = append(groups, group)
+	}
+	windowStart := a.windowStart
+	a.groups = make(map[alertKey]*alertGroup)
+	a.windowStart = now
+	a.mutex.Unlock()
+
+	if len(groups) == 0 {
+		return
+	}
+
+	// List the most frequent errors first.
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		if groups[i].key.cause != groups[j].key.cause {
+			return groups[i].key.cause < groups[j].key.cause
+		}
+		return groups[i].key.routingKey < groups[j].key.routingKey
+	})
+
+	// Build the summary of each group.
+	total := 0
+	summaries := make([]map[string]interface{}, len(groups))
+	for i, group := range groups {
+		total += group.count
+		summaries[i] = map[string]interface{}{
+			"error":       group.key.cause,
+			"routing_key": group.key.routingKey,
+			"count":       group.count,
+			"samples":     group.samples,
+			"first_seen":  group.firstSeen.UTC().Format(time.RFC3339),
+			"last_seen":   group.lastSeen.UTC().Format(time.RFC3339),
+		}
+	}
+
+	// Send the summary.
+	a.publish(ctx, &messaging.EmailRequest{
+		Subject:      "Summary of Unrecoverable Errors in the Event Recorder service",
+		ToAddress:    a.supportEmail,
+		TemplateName: "notifications_events_discarded_summary",
+		TemplateValues: map[string]interface{}{
+			"start":  windowStart.UTC().Format(time.RFC3339),
+			"end":    now.UTC().Format(time.RFC3339),
+			"total":  total,
+			"groups": summaries,
+		},
+	})
+}
+
+// publish sends an alert email, logging any errors.
+func (a *alertAggregator) publish(ctx context.Context, request *messaging.EmailRequest) {
+	err := a.publisher.PublishEmailRequestContext(ctx, request)
+	if err != nil {
+		log.Errorf("unable to send the unrecoverable error alert email request: %s", err.Error())
+	}
+}
+
+// start sends a summary email at each interval until the aggregator is stopped.
+func (a *alertAggregator) start() {
+	var ctx context.Context
+	ctx, a.cancel = context.WithCancel(context.Background())
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		ticker := time.NewTicker(a.settings.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				a.flush(ctx, now)
+			}
+		}
+	}()
+}
+
+// stop stops sending periodic summaries and sends a final summary of any deliveries discarded since the previous
+// one.
+func (a *alertAggregator) stop() {
+	if a.cancel != nil {
+		a.cancel()
+		<-a.done
+	}
+	a.flush(context.Background(), time.Now())
+}
+
+// truncateSample limits the size of a discarded message body included in a summary email.
+func truncateSample(body []byte) string {
+	if len(body) <= maxSampleLength {
+		return string(body)
+	}
+	return strings.ToValidUTF8(string(body[:maxSampleLength]), "") + "..."
+}
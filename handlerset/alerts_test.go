@@ -0,0 +1,98 @@
+package handlerset
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockEmailPublisher records the email requests that were published.
+type mockEmailPublisher struct {
+	requests []*messaging.EmailRequest
+}
+
+// PublishEmailRequestContext records the email request.
+func (p *mockEmailPublisher) PublishEmailRequestContext(_ context.Context, request *messaging.EmailRequest) error {
+	p.requests = append(p.requests, request)
+	return nil
+}
+
+func TestAlertAggregatorImmediateAlerts(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	publisher := &mockEmailPublisher{}
+	settings := AlertSettings{Interval: time.Minute, ImmediateThreshold: 3, MaxSamples: 2}
+	a := newAlertAggregator(settings, "support@example.org", publisher)
+
+	// Nothing should be sent until the threshold is reached.
+	a.record(ctx, "bad timestamp", "events.notification.update.analysis", []byte("1"), now)
+	a.record(ctx, "bad timestamp", "events.notification.update.analysis", []byte("2"), now)
+	a.record(ctx, "bad timestamp", "events.notification.update.data", []byte("3"), now)
+	assert.Empty(publisher.requests)
+
+	// Exactly one alert should be sent when the threshold is reached.
+	a.record(ctx, "bad timestamp", "events.notification.update.analysis", []byte("4"), now)
+	a.record(ctx, "bad timestamp", "events.notification.update.analysis", []byte("5"), now)
+	if assert.Len(publisher.requests, 1) {
+		request := publisher.requests[0]
+		assert.Equal("support@example.org", request.ToAddress)
+		assert.Equal("notifications_event_discarded", request.TemplateName)
+		assert.Equal("bad timestamp", request.TemplateValues["error"])
+		assert.Equal("events.notification.update.analysis", request.TemplateValues["routing_key"])
+		assert.Equal("4", request.TemplateValues["message_body"])
+		assert.Equal(3, request.TemplateValues["count"])
+	}
+}
+
+func TestAlertAggregatorSummary(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	now := time.Now()
+	publisher := &mockEmailPublisher{}
+	a := newAlertAggregator(AlertSettings{Interval: time.Minute, MaxSamples: 2}, "support@example.org", publisher)
+
+	// Nothing should be sent if no deliveries were discarded.
+	a.flush(ctx, now)
+	assert.Empty(publisher.requests)
+
+	// Record some discarded deliveries.
+	a.record(ctx, "no recipients", "events.notification.update.data", []byte("1"), now)
+	a.record(ctx, "bad timestamp", "events.notification.update.analysis", []byte("2"), now)
+	a.record(ctx, "bad timestamp", "events.notification.update.analysis", []byte("3"), now)
+	a.record(ctx, "bad timestamp", "events.notification.update.analysis", []byte("4"), now.Add(time.Second))
+	a.flush(ctx, now.Add(time.Minute))
+
+	// The summary should list the most frequent errors first, with a limited number of samples.
+	if !assert.Len(publisher.requests, 1) {
+		return
+	}
+	request := publisher.requests[0]
+	assert.Equal("notifications_events_discarded_summary", request.TemplateName)
+	assert.Equal(4, request.TemplateValues["total"])
+	groups := request.TemplateValues["groups"].([]map[string]interface{})
+	if assert.Len(groups, 2) {
+		assert.Equal("bad timestamp", groups[0]["error"])
+		assert.Equal(3, groups[0]["count"])
+		assert.Equal([]string{"2", "3"}, groups[0]["samples"])
+		assert.Equal(now.Add(time.Second).UTC().Format(time.RFC3339), groups[0]["last_seen"])
+		assert.Equal("no recipients", groups[1]["error"])
+		assert.Equal(1, groups[1]["count"])
+	}
+
+	// The next summary should start from scratch.
+	a.flush(ctx, now.Add(2*time.Minute))
+	assert.Len(publisher.requests, 1)
+}
+
+func TestTruncateSample(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("{}", truncateSample([]byte("{}")))
+	sample := truncateSample([]byte(strings.Repeat("x", maxSampleLength+1)))
+	assert.Len(sample, maxSampleLength+3)
+	assert.True(strings.HasSuffix(sample, "..."))
+}
@@ -0,0 +1,18 @@
+package handlerset
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// processDelivery wraps the processing of a single delivery in a trace span.
+func (hs *HandlerSet) processDelivery(ctx context.Context, delivery amqp.Delivery) {
+	tracer := otel.GetTracerProvider().Tracer("github.com/cyverse-de/event-recorder/handlerset")
+	ctx, span := tracer.Start(ctx, hs.consumer.Name()+" process", trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	hs.handleMessage(ctx, delivery)
+}
@@ -4,51 +4,92 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/cyverse-de/event-recorder/cloudevents"
 	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/email"
 	"github.com/cyverse-de/event-recorder/handlers"
 	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/event-recorder/rules"
+	"github.com/cyverse-de/event-recorder/transport"
 	"github.com/cyverse-de/messaging/v12"
-	"github.com/pkg/errors"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
 )
 
 var log = logging.Log.WithFields(logrus.Fields{"package": "handlerset"})
 
-const queueName = "event_listener"
-const queueKey = "events.*.update.*"
-
-// HandlerSet represents a set of AMQP message handlers.
+// HandlerSet represents a set of message handlers.
 type HandlerSet struct {
-	amqpClient   *messaging.Client
-	amqpSettings *common.AMQPSettings
-	supportEmail string
-	handlerFor   map[string]handlers.MessageHandler
+	consumer         transport.Consumer
+	publisher        transport.Publisher
+	consumerSettings *common.ConsumerSettings
+	supportEmail     string
+	handlerFor       map[string]handlers.MessageHandler
+	rules            *rules.Engine
+	alertSettings    *AlertSettings
+	emailSender      email.Sender
+	emailPublisher   emailPublisher
+	alerts           *alertAggregator
+	pool             *workerPool
+	consumerDone     chan struct{}
 }
 
-// New creates a new handler set.
+// Option represents an optional setting for a handler set.
+type Option func(*HandlerSet)
+
+// WithRules causes the handler set to apply rules to each incoming event before dispatching it to a handler.
+func WithRules(engine *rules.Engine) Option {
+	return func(hs *HandlerSet) {
+		hs.rules = engine
+	}
+}
+
+// WithAlertAggregation causes the handler set to combine the alerts for deliveries discarded because of
+// unrecoverable errors into periodic summary emails rather than sending one email for each discarded delivery.
+func WithAlertAggregation(settings *AlertSettings) Option {
+	return func(hs *HandlerSet) {
+		hs.alertSettings = settings
+	}
+}
+
+// WithEmailSender causes the handler set to send alert emails directly using the given sender rather than
+// publishing them for the email service.
+func WithEmailSender(sender email.Sender) Option {
+	return func(hs *HandlerSet) {
+		hs.emailSender = sender
+	}
+}
+
+// New creates a new handler set that receives events from the consumer. The publisher is used to send alert emails
+// unless an email sender is provided. The handler set takes ownership of both the consumer and the publisher.
 func New(
-	amqpSettings *common.AMQPSettings,
+	consumer transport.Consumer,
+	publisher transport.Publisher,
+	consumerSettings *common.ConsumerSettings,
 	supportEmail string,
 	handlerFor map[string]handlers.MessageHandler,
-) (*HandlerSet, error) {
-	wrapMsg := "unable to create the message handler set"
-
-	// Create the AMQP client.
-	amqpClient, err := messaging.NewClient(amqpSettings.URI, true)
-	if err != nil {
-		return nil, errors.Wrap(err, wrapMsg)
+	opts ...Option,
+) *HandlerSet {
+	handlerSet := &HandlerSet{
+		consumer:         consumer,
+		publisher:        publisher,
+		consumerSettings: consumerSettings,
+		supportEmail:     supportEmail,
+		handlerFor:       handlerFor,
 	}
-
-	// Build and return the handler set.
-	handlerSet := HandlerSet{
-		amqpClient:   amqpClient,
-		amqpSettings: amqpSettings,
-		supportEmail: supportEmail,
-		handlerFor:   handlerFor,
+	for _, opt := range opts {
+		opt(handlerSet)
+	}
+	handlerSet.emailPublisher = publisher
+	if handlerSet.emailSender != nil {
+		handlerSet.emailPublisher = handlers.NewDirectEmailClient(publisher, handlerSet.emailSender)
 	}
-	return &handlerSet, nil
+	if handlerSet.alertSettings != nil && handlerSet.alertSettings.Interval > 0 {
+		handlerSet.alerts = newAlertAggregator(*handlerSet.alertSettings, supportEmail, handlerSet.emailPublisher)
+	}
+	return handlerSet
 }
 
 // parseRoutingKey extracts the event category and update type from the delivery tag.
@@ -94,12 +135,26 @@ func (hs *HandlerSet) sendUnrecoverableErrorEmail(ctx context.Context, delivery
 	}
 
 	// Publish the request.
-	err := hs.amqpClient.PublishEmailRequestContext(ctx, &request)
+	err := hs.emailPublisher.PublishEmailRequestContext(ctx, &request)
 	if err != nil {
 		log.Errorf("%s: %s", wrapMsg, err.Error())
 	}
 }
 
+// reportUnrecoverableError alerts the support team that a delivery couldn't be processed, either immediately or in
+// the next summary email if alerts are being aggregated.
+func (hs *HandlerSet) reportUnrecoverableError(
+	ctx context.Context,
+	delivery amqp.Delivery,
+	cause handlers.UnrecoverableError,
+) {
+	if hs.alerts != nil {
+		hs.alerts.record(ctx, cause.Error(), delivery.RoutingKey, delivery.Body, time.Now())
+		return
+	}
+	hs.sendUnrecoverableErrorEmail(ctx, delivery, cause)
+}
+
 // logDelivery logs some information about a message delivery for troubleshooting purposes.
 func (hs *HandlerSet) logDelivery(description string, delivery amqp.Delivery) {
 	log.Infof("%s: %s; %s", description, delivery.RoutingKey, delivery.Body)
@@ -122,27 +177,25 @@ func (hs *HandlerSet) handleMessage(ctx context.Context, delivery amqp.Delivery)
 		return
 	}
 
+	// Convert CloudEvents to the handler's native format before applying the rules, so that the rules see the same
+	// message body regardless of the format in which the event was published.
+	delivery, err = hs.decodeCloudEvent(handler, category, updateType, delivery)
+	if err != nil {
+		hs.handleError(ctx, delivery, err)
+		return
+	}
+
+	// Apply the rules to the delivery.
+	delivery, drop := hs.applyRules(delivery, updateType)
+	if drop {
+		hs.ack(delivery)
+		return
+	}
+
 	// Dispatch the delivery to the handler.
 	err = handler.HandleMessage(ctx, updateType, delivery)
 	if err != nil {
-		switch val := err.(type) {
-		case handlers.UnrecoverableError:
-			log.Errorf("discarding message because of an unrecoverable error: %s", val.Error())
-			hs.sendUnrecoverableErrorEmail(ctx, delivery, val)
-			hs.logDelivery("discarded delivery", delivery)
-			hs.nack(delivery, false)
-		case handlers.RecoverableError:
-			log.Errorf("requeuing message becuse of a recoverable error: %s", val.Error())
-			hs.logDelivery("requeued delivery", delivery)
-			hs.nack(delivery, true)
-		case error:
-			log.Errorf(
-				"requeuing message because of an error that is presumed to be recoverable: %s",
-				val.Error(),
-			)
-			hs.logDelivery("requeued delivery", delivery)
-			hs.nack(delivery, true)
-		}
+		hs.handleError(ctx, delivery, err)
 		return
 	}
 
@@ -150,33 +203,118 @@ func (hs *HandlerSet) handleMessage(ctx context.Context, delivery amqp.Delivery)
 	hs.ack(delivery)
 }
 
-// Listen waits for incoming AMQP messages and dispatches any messages that it recieves to a handler.
-func (hs *HandlerSet) Listen() error {
-	wrapMsg := "error encountered while listening for incoming events"
+// handleError handles an error encountered while processing a delivery. Deliveries that failed because of
+// unrecoverable errors are discarded and reported to the support team. All other deliveries are requeued.
+func (hs *HandlerSet) handleError(ctx context.Context, delivery amqp.Delivery, err error) {
+	switch val := err.(type) {
+	case handlers.UnrecoverableError:
+		log.Errorf("discarding message because of an unrecoverable error: %s", val.Error())
+		hs.reportUnrecoverableError(ctx, delivery, val)
+		hs.logDelivery("discarded delivery", delivery)
+		hs.nack(delivery, false)
+	case handlers.RecoverableError:
+		log.Errorf("requeuing message becuse of a recoverable error: %s", val.Error())
+		hs.logDelivery("requeued delivery", delivery)
+		hs.nack(delivery, true)
+	case error:
+		log.Errorf(
+			"requeuing message because of an error that is presumed to be recoverable: %s",
+			val.Error(),
+		)
+		hs.logDelivery("requeued delivery", delivery)
+		hs.nack(delivery, true)
+	}
+}
 
-	// Set up publishing on the AMQP client in case we need to publish any messages.
-	err := hs.amqpClient.SetupPublishing(hs.amqpSettings.ExchangeName)
+// decodeCloudEvent converts a delivery that carries a CloudEvent to a delivery in the native format of the handler
+// that it's dispatched to. Other deliveries are returned unchanged.
+func (hs *HandlerSet) decodeCloudEvent(
+	handler handlers.MessageHandler,
+	category, updateType string,
+	delivery amqp.Delivery,
+) (amqp.Delivery, error) {
+	event, err := cloudevents.FromDelivery(delivery)
 	if err != nil {
-		return errors.Wrap(err, wrapMsg)
+		return delivery, handlers.NewUnrecoverableError("%s", err.Error())
+	}
+	if event == nil {
+		return delivery, nil
 	}
 
-	// Start listening for incoming messages.
-	go hs.amqpClient.Listen()
+	// Convert the event.
+	decoder, ok := handler.(handlers.CloudEventDecoder)
+	if !ok {
+		return delivery, handlers.NewUnrecoverableError("the %s handler doesn't accept CloudEvents", category)
+	}
+	body, err := decoder.DecodeCloudEvent(updateType, event, delivery)
+	if err != nil {
+		return delivery, err
+	}
+
+	delivery.Body = body
+	delivery.ContentType = "application/json"
+	return delivery, nil
+}
 
-	// Listen for incoming messages.
-	hs.amqpClient.AddConsumer(
-		hs.amqpSettings.ExchangeName,
-		hs.amqpSettings.ExchangeType,
-		queueName,
-		queueKey,
-		hs.handleMessage,
-		100,
-	)
+// applyRules applies the rules to a delivery, returning the possibly modified delivery and a flag indicating
+// whether the delivery should be dropped. The original delivery is returned if the rules can't be applied.
+func (hs *HandlerSet) applyRules(delivery amqp.Delivery, updateType string) (amqp.Delivery, bool) {
+	if hs.rules == nil {
+		return delivery, false
+	}
 
-	return nil
+	// Apply the rules.
+	body, result, err := hs.rules.Apply(delivery.RoutingKey, updateType, delivery.Body)
+	if err != nil {
+		log.Errorf("unable to apply the rules; handling the delivery unchanged: %s", err.Error())
+		return delivery, false
+	}
+	if result.Drop {
+		log.Infof("dropping delivery with routing key %s because of rule %s", delivery.RoutingKey,
+			result.Matched[len(result.Matched)-1])
+		return delivery, true
+	}
+
+	delivery.Body = body
+	return delivery, false
+}
+
+// Listen waits for incoming messages and dispatches any messages that it recieves to a handler. Messages are
+// processed concurrently by a pool of workers, but messages for any single user are always processed in the order
+// in which they were received.
+func (hs *HandlerSet) Listen() {
+	// Start sending periodic summaries of discarded deliveries.
+	if hs.alerts != nil {
+		hs.alerts.start()
+	}
+
+	// Start the worker pool. Each worker can have up to one prefetched delivery waiting for it.
+	hs.pool = newWorkerPool(hs.consumerSettings.Workers, 1, hs.processDelivery)
+
+	// Start consuming incoming messages.
+	hs.consumerDone = make(chan struct{})
+	go func() {
+		defer close(hs.consumerDone)
+		hs.consumer.Consume(hs.pool.submit)
+	}()
 }
 
-// Close closes a message handler set.
+// Close closes a message handler set. Deliveries that have already been received are processed before
+// the connection to the message broker is closed.
 func (hs *HandlerSet) Close() {
-	hs.amqpClient.Close()
+	// Stop accepting new deliveries and wait for the deliveries that we've already received.
+	hs.consumer.Cancel()
+	if hs.consumerDone != nil {
+		<-hs.consumerDone
+		hs.pool.close()
+	}
+
+	// Send a final summary of the deliveries that were discarded.
+	if hs.alerts != nil {
+		hs.alerts.stop()
+	}
+
+	// Close the connections.
+	hs.consumer.Close()
+	hs.publisher.Close()
 }
@@ -0,0 +1,216 @@
+package handlerset
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/cloudevents"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/memstore"
+	"github.com/cyverse-de/event-recorder/rules"
+	"github.com/cyverse-de/event-recorder/transport"
+	"github.com/cyverse-de/messaging/v12"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyRules(t *testing.T) {
+	assert := assert.New(t)
+
+	ruleSet, err := rules.Parse([]byte(`
+rules:
+  - name: quiet
+    match: {user: ipcdev}
+    actions: {email: false}
+  - name: drop
+    match: {user: sarahr}
+    actions: {drop: true}
+`))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Deliveries should be unchanged if there are no rules.
+	hs := &HandlerSet{}
+	delivery := testDelivery("ipcdev", 1)
+	result, drop := hs.applyRules(delivery, "foo")
+	assert.False(drop)
+	assert.Equal(delivery.Body, result.Body)
+
+	// Matching rules should modify the delivery.
+	hs = &HandlerSet{}
+	WithRules(rules.NewStaticEngine(ruleSet))(hs)
+	result, drop = hs.applyRules(delivery, "foo")
+	assert.False(drop)
+	assert.JSONEq(`{"user": "ipcdev", "message": "1", "email": false}`, string(result.Body))
+
+	// Matching rules should be able to drop the delivery.
+	_, drop = hs.applyRules(testDelivery("sarahr", 1), "foo")
+	assert.True(drop)
+}
+
+// plainHandler is a message handler that doesn't accept CloudEvents.
+type plainHandler struct{}
+
+// HandleMessage does nothing.
+func (h *plainHandler) HandleMessage(context.Context, string, amqp.Delivery) error {
+	return nil
+}
+
+// decodingHandler is a message handler that accepts CloudEvents, converting them to their event IDs.
+type decodingHandler struct {
+	plainHandler
+}
+
+// DecodeCloudEvent returns the event ID.
+func (h *decodingHandler) DecodeCloudEvent(_ string, event *cloudevents.Event, _ amqp.Delivery) ([]byte, error) {
+	return []byte(event.ID), nil
+}
+
+func TestDecodeCloudEvent(t *testing.T) {
+	assert := assert.New(t)
+	hs := &HandlerSet{}
+
+	// Deliveries that don't carry CloudEvents should be unchanged.
+	delivery := testDelivery("ipcdev", 1)
+	result, err := hs.decodeCloudEvent(&plainHandler{}, "notification", "foo", delivery)
+	assert.NoError(err)
+	assert.Equal(delivery.Body, result.Body)
+
+	// CloudEvents should be converted by handlers that accept them.
+	delivery = amqp.Delivery{
+		ContentType: cloudevents.ContentType,
+		Body:        []byte(`{"specversion": "1.0", "id": "e1", "source": "/apps", "type": "t"}`),
+	}
+	result, err = hs.decodeCloudEvent(&decodingHandler{}, "notification", "foo", delivery)
+	assert.NoError(err)
+	assert.Equal("e1", string(result.Body))
+	assert.Equal("application/json", result.ContentType)
+
+	// CloudEvents should be rejected by handlers that don't accept them.
+	_, err = hs.decodeCloudEvent(&plainHandler{}, "notification", "foo", delivery)
+	assert.IsType(handlers.UnrecoverableError{}, err)
+
+	// Invalid CloudEvents should be rejected.
+	delivery.Body = []byte(`{"specversion": "1.0"}`)
+	_, err = hs.decodeCloudEvent(&decodingHandler{}, "notification", "foo", delivery)
+	assert.IsType(handlers.UnrecoverableError{}, err)
+}
+
+// flakyHandler is a message handler that fails with a recoverable error the first time that it's called. It records
+// the redelivered flag of each delivery that it receives.
+type flakyHandler struct {
+	mutex       sync.Mutex
+	redelivered []bool
+}
+
+// HandleMessage records the delivery, failing the first time that it's called.
+func (h *flakyHandler) HandleMessage(_ context.Context, _ string, delivery amqp.Delivery) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.redelivered = append(h.redelivered, delivery.Redelivered)
+	if len(h.redelivered) == 1 {
+		return handlers.NewRecoverableError("try again")
+	}
+	return nil
+}
+
+// deliveries returns the redelivered flag of each delivery received by the handler.
+func (h *flakyHandler) deliveries() []bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]bool(nil), h.redelivered...)
+}
+
+// getMessage waits for a message to arrive in a queue and returns it.
+func getMessage(t *testing.T, broker *transport.MemoryBroker, queueName string) amqp.Delivery {
+	var delivery amqp.Delivery
+	found := assert.Eventually(t, func() bool {
+		var ok bool
+		delivery, ok = broker.Get(queueName)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond, "no message in %s", queueName)
+	if !found {
+		t.FailNow()
+	}
+	return delivery
+}
+
+func TestListen(t *testing.T) {
+	assert := assert.New(t)
+
+	// Declare the queue that events are consumed from and queues to capture the messages published in response.
+	broker := transport.NewMemoryBroker()
+	assert.NoError(broker.DeclareQueue("event_listener", "events.*.update.*"))
+	assert.NoError(broker.DeclareQueue("notifications", "notification.*"))
+	assert.NoError(broker.DeclareQueue("emails", messaging.EmailRequestPublishingKey))
+
+	// Create and start the handler set.
+	consumer, err := broker.NewConsumer("event_listener", 10)
+	if !assert.NoError(err) {
+		return
+	}
+	store := memstore.New()
+	publisher := broker.NewPublisher()
+	flaky := &flakyHandler{}
+	handlerFor := map[string]handlers.MessageHandler{
+		"notification": handlers.NewLegacy(store, publisher),
+		"flaky":        flaky,
+	}
+	consumerSettings := &common.ConsumerSettings{Workers: 2, Prefetch: 10}
+	hs := New(consumer, publisher, consumerSettings, "support@cyverse.org", handlerFor)
+	hs.Listen()
+	defer hs.Close()
+
+	// A notification event should be stored and should cause a notification message and an email to be published.
+	events := broker.NewPublisher()
+	body := []byte(`{
+		"type": "analysis",
+		"user": "sarahr",
+		"subject": "some job status changed",
+		"timestamp": "2026-10-18T12:00:00Z",
+		"email": true,
+		"email_template": "analysis_status_change",
+		"payload": {"analysisname": "some job", "email_address": "sarahr@cyverse.org"}
+	}`)
+	err = events.PublishContextOpts(
+		context.Background(), "events.notification.update.sarahr", body, messaging.JSONPublishingOpts,
+	)
+	assert.NoError(err)
+	delivery := getMessage(t, broker, "notifications")
+	assert.Equal("notification.sarahr", delivery.RoutingKey)
+	var msg messaging.WrappedNotificationMessage
+	if assert.NoError(json.Unmarshal(delivery.Body, &msg)) {
+		assert.Equal("some job status changed", msg.Message.Subject)
+		assert.Equal(int64(1), msg.Total)
+	}
+	delivery = getMessage(t, broker, "emails")
+	assert.Contains(string(delivery.Body), `"to":"sarahr@cyverse.org"`)
+	records := store.Records()
+	if assert.Len(records, 1) {
+		assert.Equal("sarahr", records[0].Notification.User)
+	}
+
+	// Invalid events should be discarded, and the support team should be notified.
+	err = events.PublishContextOpts(context.Background(), "events.notification.update.sarahr", []byte("{"), nil)
+	assert.NoError(err)
+	delivery = getMessage(t, broker, "emails")
+	assert.Contains(string(delivery.Body), `"to":"support@cyverse.org"`)
+
+	// Events that fail with recoverable errors should be redelivered.
+	err = events.PublishContextOpts(context.Background(), "events.flaky.update.ipcdev", []byte(`{}`), nil)
+	assert.NoError(err)
+	assert.Eventually(func() bool { return len(flaky.deliveries()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal([]bool{false, true}, flaky.deliveries())
+
+	// Every event should have been acknowledged.
+	assert.Eventually(func() bool {
+		return broker.Ready("event_listener") == 0 && broker.Unacked("event_listener") == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(0, broker.Ready("notifications"))
+	assert.Equal(0, broker.Ready("emails"))
+}
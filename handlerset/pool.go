@@ -0,0 +1,223 @@
+package handlerset
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cyverse-de/event-recorder/cloudevents"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// job represents a single delivery waiting to be processed by a worker. Deliveries for more than one user are
+// submitted to every partition that any of the users is assigned to, and share a barrier.
+type job struct {
+	ctx      context.Context
+	delivery amqp.Delivery
+	barrier  *barrier
+}
+
+// barrier synchronizes the partitions that a delivery for more than one user was submitted to. The delivery is
+// processed by the last worker to reach it, and the other workers wait until it has been processed. Because the
+// delivery is processed only after every earlier delivery in those partitions, and before every later one, it's
+// processed in order with respect to the deliveries for each of its users.
+type barrier struct {
+	mutex   sync.Mutex
+	waiting int
+	done    chan struct{}
+}
+
+// newBarrier returns a barrier for a delivery that was submitted to the given number of partitions.
+func newBarrier(partitions int) *barrier {
+	return &barrier{waiting: partitions, done: make(chan struct{})}
+}
+
+// arrive records that a worker has reached the delivery. It returns true if the worker is the last to arrive.
+func (b *barrier) arrive() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.waiting--
+	return b.waiting == 0
+}
+
+// workerPool processes deliveries concurrently while guaranteeing that deliveries for the same user are
+// processed in the order in which they were received. Each user is assigned to a single partition, and
+// each partition is drained by exactly one worker. Deliveries for several users are synchronized with every
+// partition that those users are assigned to.
+type workerPool struct {
+	partitions []chan job
+	handle     func(context.Context, amqp.Delivery)
+	wg         sync.WaitGroup
+}
+
+// newWorkerPool creates a new worker pool with the given number of workers and starts the workers. The
+// queue depth determines how many deliveries can be waiting for each worker before submissions block.
+func newWorkerPool(workers, queueDepth int, handle func(context.Context, amqp.Delivery)) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+
+	// Create the partitions and start one worker for each of them.
+	pool := &workerPool{
+		partitions: make([]chan job, workers),
+		handle:     handle,
+	}
+	for i := range pool.partitions {
+		pool.partitions[i] = make(chan job, queueDepth)
+		pool.wg.Add(1)
+		go pool.work(pool.partitions[i])
+	}
+
+	return pool
+}
+
+// work processes deliveries from a single partition until the partition is closed.
+func (p *workerPool) work(partition chan job) {
+	defer p.wg.Done()
+	for j := range partition {
+		switch {
+		case j.barrier == nil:
+			p.handle(j.ctx, j.delivery)
+		case j.barrier.arrive():
+			p.handle(j.ctx, j.delivery)
+			close(j.barrier.done)
+		default:
+			<-j.barrier.done
+		}
+	}
+}
+
+// recipientFields contains the fields of an event that identify its recipients.
+type recipientFields struct {
+	User  string
+	Users []string
+	Group string
+}
+
+// add adds the value of a recipient field. The users field of a legacy request is a list, whereas the users
+// extension attribute of a CloudEvent is a comma-separated string.
+func (r *recipientFields) add(name string, value interface{}) {
+	switch name {
+	case "user":
+		if s, ok := value.(string); ok {
+			r.User = s
+		}
+	case "group":
+		if s, ok := value.(string); ok {
+			r.Group = s
+		}
+	case "users":
+		switch v := value.(type) {
+		case string:
+			r.Users = append(r.Users, strings.Split(v, ",")...)
+		case []interface{}:
+			for _, user := range v {
+				if s, ok := user.(string); ok {
+					r.Users = append(r.Users, s)
+				}
+			}
+		}
+	}
+}
+
+// partitionKeys determines the keys used to assign a delivery to partitions. Deliveries are partitioned by the
+// users that the event is intended for. The second return value is true if the event is intended for a group,
+// whose members could be assigned to any partition. Deliveries whose body can't be parsed or that don't identify
+// any recipients are partitioned by routing key instead; the handler will deal with any parsing errors. CloudEvents
+// identify their recipients with extension attributes, which are stored in headers in the binary content mode and
+// at the top level of the body in the structured content mode.
+func partitionKeys(delivery amqp.Delivery) ([]string, bool) {
+	var fields recipientFields
+	for _, name := range []string{"user", "users", "group"} {
+		if value, ok := cloudevents.HeaderAttribute(delivery.Headers, name); ok {
+			fields.add(name, value)
+		}
+	}
+
+	// Fall back to the body if the headers don't identify any recipients.
+	if fields.User == "" && len(fields.Users) == 0 && fields.Group == "" {
+		var body map[string]interface{}
+		if json.Unmarshal(delivery.Body, &body) == nil {
+			for _, name := range []string{"user", "users", "group"} {
+				fields.add(name, body[name])
+			}
+		}
+	}
+	if fields.Group != "" {
+		return nil, true
+	}
+
+	// Collect the distinct users.
+	var keys []string
+	seen := make(map[string]bool)
+	for _, user := range append([]string{fields.User}, fields.Users...) {
+		user = strings.TrimSpace(user)
+		if user != "" && !seen[user] {
+			seen[user] = true
+			keys = append(keys, user)
+		}
+	}
+	if len(keys) == 0 {
+		return []string{delivery.RoutingKey}, false
+	}
+	return keys, false
+}
+
+// partitionFor returns the index of the partition that a key is assigned to.
+func (p *workerPool) partitionFor(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.partitions)))
+}
+
+// partitionsFor returns the indexes of the partitions that a delivery is assigned to in ascending order.
+func (p *workerPool) partitionsFor(delivery amqp.Delivery) []int {
+	keys, everyone := partitionKeys(delivery)
+	if everyone {
+		indexes := make([]int, len(p.partitions))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes
+	}
+
+	assigned := make(map[int]bool)
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		if i := p.partitionFor(key); !assigned[i] {
+			assigned[i] = true
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// submit adds a delivery to the queue of each worker responsible for a user that the delivery is intended for.
+// Deliveries are submitted to every partition in the same order, so workers waiting at a barrier never wait for each
+// other in a cycle. This function blocks if a worker's queue is full.
+func (p *workerPool) submit(ctx context.Context, delivery amqp.Delivery) {
+	indexes := p.partitionsFor(delivery)
+	if len(indexes) == 1 {
+		p.partitions[indexes[0]] <- job{ctx: ctx, delivery: delivery}
+		return
+	}
+	b := newBarrier(len(indexes))
+	for _, i := range indexes {
+		p.partitions[i] <- job{ctx: ctx, delivery: delivery, barrier: b}
+	}
+}
+
+// close stops accepting new deliveries and waits for all queued deliveries to be processed.
+func (p *workerPool) close() {
+	for _, partition := range p.partitions {
+		close(partition)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,149 @@
+package handlerset
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDelivery returns a delivery for the given user with a message body containing a sequence number.
+func testDelivery(user string, sequence int) amqp.Delivery {
+	body := fmt.Sprintf(`{"user": "%s", "message": "%d"}`, user, sequence)
+	return amqp.Delivery{Body: []byte(body), RoutingKey: "events.notification.update.foo"}
+}
+
+func TestPartitionKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	// The user should be used if it's present.
+	keys, everyone := partitionKeys(testDelivery("ipcdev", 1))
+	assert.Equal([]string{"ipcdev"}, keys)
+	assert.False(everyone)
+
+	// The routing key should be used if the body can't be parsed.
+	delivery := amqp.Delivery{Body: []byte("not json"), RoutingKey: "events.notification.update.foo"}
+	keys, _ = partitionKeys(delivery)
+	assert.Equal([]string{"events.notification.update.foo"}, keys)
+
+	// The routing key should be used if the user isn't present.
+	delivery = amqp.Delivery{Body: []byte("{}"), RoutingKey: "events.notification.update.bar"}
+	keys, _ = partitionKeys(delivery)
+	assert.Equal([]string{"events.notification.update.bar"}, keys)
+
+	// Every distinct recipient should be used for events with several recipients.
+	delivery.Body = []byte(`{"user": "ipcdev", "users": ["sarahr", "ipcdev", ""]}`)
+	keys, everyone = partitionKeys(delivery)
+	assert.Equal([]string{"ipcdev", "sarahr"}, keys)
+	assert.False(everyone)
+
+	// Events for groups could be intended for anyone.
+	delivery.Body = []byte(`{"group": "staff"}`)
+	_, everyone = partitionKeys(delivery)
+	assert.True(everyone)
+
+	// The extension attributes should be used for CloudEvents sent in the binary content mode.
+	delivery.Headers = amqp.Table{"cloudEvents:specversion": "1.0", "cloudEvents:user": "sarahr"}
+	keys, everyone = partitionKeys(delivery)
+	assert.Equal([]string{"sarahr"}, keys)
+	assert.False(everyone)
+	delivery.Headers["cloudEvents:users"] = "tedgin, psarando"
+	keys, _ = partitionKeys(delivery)
+	assert.Equal([]string{"sarahr", "tedgin", "psarando"}, keys)
+
+	// The extension attributes should be used for CloudEvents sent in the structured content mode.
+	delivery = amqp.Delivery{Body: []byte(`{"specversion": "1.0", "users": "sarahr,ipcdev"}`)}
+	keys, _ = partitionKeys(delivery)
+	assert.Equal([]string{"sarahr", "ipcdev"}, keys)
+}
+
+func TestWorkerPoolPreservesOrderPerUser(t *testing.T) {
+	assert := assert.New(t)
+
+	users := []string{"ipcdev", "sarahr", "sriram", "tedgin", "psarando"}
+	deliveriesPerUser := 200
+
+	// Record the order in which messages were processed for each user.
+	var mutex sync.Mutex
+	processed := make(map[string][]string)
+	handle := func(_ context.Context, delivery amqp.Delivery) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		keys, _ := partitionKeys(delivery)
+		user := keys[0]
+		processed[user] = append(processed[user], string(delivery.Body))
+	}
+
+	// Submit the deliveries, interleaving the users.
+	pool := newWorkerPool(4, 1, handle)
+	for i := 0; i < deliveriesPerUser; i++ {
+		for _, user := range users {
+			pool.submit(context.Background(), testDelivery(user, i))
+		}
+	}
+	pool.close()
+
+	// Verify that every delivery was processed in order.
+	for _, user := range users {
+		if assert.Len(processed[user], deliveriesPerUser, "unexpected delivery count for %s", user) {
+			for i, body := range processed[user] {
+				assert.Equal(string(testDelivery(user, i).Body), body, "out of order delivery for %s", user)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolPreservesOrderForSeveralRecipients(t *testing.T) {
+	assert := assert.New(t)
+
+	users := []string{"ipcdev", "sarahr", "sriram", "tedgin", "psarando"}
+	rounds := 100
+
+	// Record the order in which messages were processed for each recipient. Processing is slowed down slightly so
+	// that deliveries in different partitions would overlap if they weren't synchronized.
+	var mutex sync.Mutex
+	processed := make(map[string][]string)
+	handle := func(_ context.Context, delivery amqp.Delivery) {
+		keys, _ := partitionKeys(delivery)
+		time.Sleep(10 * time.Microsecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, user := range keys {
+			processed[user] = append(processed[user], string(delivery.Body))
+		}
+	}
+
+	// Submit single-recipient deliveries interleaved with deliveries for several recipients, and determine the
+	// order in which each recipient should see them.
+	expected := make(map[string][]string)
+	pool := newWorkerPool(8, 2, handle)
+	for i := 0; i < rounds; i++ {
+		for j, user := range users {
+			delivery := testDelivery(user, i)
+			if j%2 == 0 {
+				other := users[(j+1)%len(users)]
+				body := fmt.Sprintf(`{"users": ["%s", "%s"], "message": "%d"}`, user, other, i)
+				delivery = amqp.Delivery{Body: []byte(body), RoutingKey: "events.notification.update.foo"}
+				expected[other] = append(expected[other], body)
+			}
+			expected[user] = append(expected[user], string(delivery.Body))
+			pool.submit(context.Background(), delivery)
+		}
+	}
+	pool.close()
+
+	// Verify that every recipient saw its deliveries in order.
+	for _, user := range users {
+		assert.Equal(expected[user], processed[user], "out of order delivery for %s", user)
+	}
+}
+
+func TestWorkerPoolMinimumSize(t *testing.T) {
+	pool := newWorkerPool(0, 0, func(context.Context, amqp.Delivery) {})
+	defer pool.close()
+	assert.Len(t, pool.partitions, 1)
+}
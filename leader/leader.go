@@ -0,0 +1,127 @@
+// Package leader provides leader election among replicas of the service using PostgreSQL advisory locks. Only
+// the replica that holds the lock performs work that must not be done concurrently, such as publishing scheduled
+// notifications.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "leader"})
+
+// Elector repeatedly attempts to acquire an advisory lock and runs a function while it holds the lock.
+type Elector struct {
+	db            *sql.DB
+	name          string
+	key           int64
+	retryInterval time.Duration
+}
+
+// NewElector returns a new elector. The key identifies the advisory lock, so it must be unique for each kind of
+// work that requires a leader. The name is only used in log messages.
+func NewElector(db *sql.DB, name string, key int64, retryInterval time.Duration) *Elector {
+	return &Elector{
+		db:            db,
+		name:          name,
+		key:           key,
+		retryInterval: retryInterval,
+	}
+}
+
+// tryAcquire attempts to acquire the advisory lock on a dedicated connection. The connection is returned if the
+// lock was acquired. Otherwise, the connection is released and nil is returned.
+func (e *Elector) tryAcquire(ctx context.Context) (*sql.Conn, error) {
+	wrapMsg := "unable to attempt to acquire the advisory lock"
+
+	// Advisory locks are held by database sessions, so a dedicated connection is required.
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Attempt to acquire the lock.
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", e.key).Scan(&acquired)
+	if err != nil || !acquired {
+		_ = conn.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		return nil, nil
+	}
+
+	return conn, nil
+}
+
+// release releases the advisory lock and the connection that holds it.
+func (e *Elector) release(conn *sql.Conn) {
+	_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", e.key)
+	if err != nil {
+		log.Errorf("unable to release the %s leader lock: %s", e.name, err.Error())
+	}
+
+	// Discard the connection rather than returning it to the pool in case the unlock failed.
+	_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	_ = conn.Close()
+}
+
+// lead runs the function while monitoring the connection that holds the lock. The context passed to the function
+// is canceled if the connection is lost, because the lock is released when that happens.
+func (e *Elector) lead(ctx context.Context, conn *sql.Conn, f func(context.Context)) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Monitor the connection.
+	go func() {
+		ticker := time.NewTicker(e.retryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leaderCtx.Done():
+				return
+			case <-ticker.C:
+				if err := conn.PingContext(leaderCtx); err != nil && leaderCtx.Err() == nil {
+					log.Errorf("lost the %s leader lock: %s", e.name, err.Error())
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	f(leaderCtx)
+}
+
+// Run blocks until the context is canceled. Whenever this replica holds the lock, the function is called with a
+// context that is canceled when the lock is lost or the parent context is canceled. The function should return
+// promptly once its context is canceled.
+func (e *Elector) Run(ctx context.Context, f func(context.Context)) {
+	for {
+		conn, err := e.tryAcquire(ctx)
+		if err != nil {
+			log.Error(err)
+		}
+
+		// Do the work if we acquired the lock.
+		if conn != nil {
+			log.Infof("acquired the %s leader lock", e.name)
+			e.lead(ctx, conn, f)
+			e.release(conn)
+			log.Infof("released the %s leader lock", e.name)
+		}
+
+		// Wait before trying again.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.retryInterval):
+		}
+	}
+}
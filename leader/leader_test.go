@@ -0,0 +1,148 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey int64 = 42
+
+func TestTryAcquire(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		acquired bool
+		err      error
+	}{
+		{name: "acquired", acquired: true},
+		{name: "held elsewhere", acquired: false},
+		{name: "query failure", err: errors.New("connection refused")},
+	} {
+		db, mock, err := sqlmock.New()
+		if !assert.NoError(err, "unable to open the mock database connection") {
+			return
+		}
+
+		// Set up the expectations.
+		query := mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(testKey)
+		if tc.err != nil {
+			query.WillReturnError(tc.err)
+		} else {
+			query.WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(tc.acquired))
+		}
+
+		// Attempt to acquire the lock.
+		e := NewElector(db, "test", testKey, time.Minute)
+		conn, err := e.tryAcquire(context.Background())
+		if tc.err != nil {
+			assert.Error(err, tc.name)
+		} else {
+			assert.NoError(err, tc.name)
+		}
+		assert.Equal(tc.acquired, conn != nil, tc.name)
+		if conn != nil {
+			_ = conn.Close()
+		}
+
+		assert.NoError(mock.ExpectationsWereMet(), tc.name)
+		_ = db.Close()
+	}
+}
+
+func TestRunReleasesLock(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The lock should be acquired, then released once the work is done.
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(testKey).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(true))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(testKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Stop running as soon as the function has been called.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	e := NewElector(db, "test", testKey, time.Minute)
+	e.Run(ctx, func(context.Context) {
+		calls++
+		cancel()
+	})
+	assert.Equal(1, calls)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestRunWithoutLock(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Another replica holds the lock for the first attempt.
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(testKey).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(false))
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(testKey).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(true))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(testKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// The function should only be called once the lock has been acquired.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	e := NewElector(db, "test", testKey, time.Millisecond)
+	e.Run(ctx, func(context.Context) {
+		calls++
+		cancel()
+	})
+	assert.Equal(1, calls)
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestLeadStopsWhenConnectionIsLost(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The connection holding the lock fails the first health check.
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(testKey).
+		WillReturnRows(sqlmock.NewRows([]string{"acquired"}).AddRow(true))
+	mock.ExpectPing().WillReturnError(errors.New("connection reset by peer"))
+
+	e := NewElector(db, "test", testKey, time.Millisecond)
+	conn, err := e.tryAcquire(context.Background())
+	if !assert.NoError(err) || !assert.NotNil(conn) {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The function's context should be canceled when the connection is lost.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.lead(context.Background(), conn, func(ctx context.Context) { <-ctx.Done() })
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the leader's context wasn't canceled")
+	}
+
+	assert.NoError(mock.ExpectationsWereMet())
+}
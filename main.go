@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 
 	_ "github.com/lib/pq"
@@ -10,11 +11,17 @@ import (
 
 	"github.com/DavidGamba/go-getoptions"
 	"github.com/cyverse-de/configurate"
+	"github.com/cyverse-de/event-recorder/api"
+	"github.com/cyverse-de/event-recorder/audit"
 	"github.com/cyverse-de/event-recorder/common"
 	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/directory"
 	"github.com/cyverse-de/event-recorder/handlers"
 	"github.com/cyverse-de/event-recorder/handlerset"
 	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/event-recorder/rules"
+	"github.com/cyverse-de/event-recorder/shadow"
+	"github.com/cyverse-de/event-recorder/transport"
 	"github.com/cyverse-de/go-mod/otelutils"
 )
 
@@ -26,6 +33,10 @@ var log = logging.Log.WithFields(logrus.Fields{"package": "main"})
 // this service was invoked.
 type commandLineOptionValues struct {
 	Config string
+
+	// Args contains the arguments that remain after the options have been parsed. If any remain, they describe
+	// an administrative command to run instead of the service.
+	Args []string
 }
 
 // parseCommandLine parses the command line and returns an options structure containing command-line options and
@@ -44,7 +55,7 @@ func parseCommandLine() *commandLineOptionValues {
 		opt.Description("the path to the configuration file"))
 
 	// Parse the command line, handling requests for help and usage errors.
-	_, err := opt.Parse(os.Args[1:])
+	remaining, err := opt.Parse(os.Args[1:])
 	if opt.Called("help") {
 		fmt.Fprint(os.Stderr, opt.Help())
 		os.Exit(0)
@@ -54,6 +65,7 @@ func parseCommandLine() *commandLineOptionValues {
 		fmt.Fprint(os.Stderr, opt.Help(getoptions.HelpSynopsis))
 		os.Exit(1)
 	}
+	optionValues.Args = remaining
 
 	return optionValues
 }
@@ -72,6 +84,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	setConfigDefaults(cfg)
 
 	// Retrieve the AMQP settings.
 	amqpSettings := &common.AMQPSettings{
@@ -80,6 +93,23 @@ func main() {
 		ExchangeType: cfg.GetString("amqp.exchange.type"),
 	}
 
+	// Retrieve the settings that determine how incoming events are consumed.
+	consumerSettings := &common.ConsumerSettings{
+		Workers:  cfg.GetInt("event_recorder.consumer.workers"),
+		Prefetch: cfg.GetInt("event_recorder.consumer.prefetch"),
+	}
+
+	// Retrieve the settings for the queue that incoming events are consumed from.
+	queueSettings := &common.QueueSettings{
+		Name:        cfg.GetString("event_recorder.queue.name"),
+		BindingKeys: cfg.GetStringSlice("event_recorder.queue.binding_keys"),
+		Durable:     cfg.GetBool("event_recorder.queue.durable"),
+		Type:        cfg.GetString("event_recorder.queue.type"),
+		Exclusive:   cfg.GetBool("event_recorder.queue.exclusive"),
+		AutoDelete:  cfg.GetBool("event_recorder.queue.auto_delete"),
+		Arguments:   cfg.GetStringMap("event_recorder.queue.arguments"),
+	}
+
 	// Initialize the database connection.
 	databaseURI := cfg.GetString("notifications.db.uri")
 	db, err := db.InitDatabase("postgres", databaseURI)
@@ -88,27 +118,211 @@ func main() {
 	}
 	defer func() { _ = db.Close() }()
 
+	// Run the administrative command instead of the service if one was specified.
+	if len(optionValues.Args) > 0 {
+		err = runCommand(tracerCtx, db, optionValues.Args, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			_ = db.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Get the email address to use for support requests.
 	supportEmail := cfg.GetString("email.request")
 
-	// Initialize the message handlers.
-	messageHandlers, err := handlers.InitMessageHandlers(db, amqpSettings)
+	// Determine the optional settings for the legacy message handler.
+	emailOverride := cfg.GetString("event_recorder.severity.email_override")
+	if emailOverride != "" {
+		emailOverride, err = common.ParseSeverity(emailOverride)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	legacyOpts := []handlers.LegacyOption{
+		handlers.WithFanOutLimits(
+			cfg.GetInt("event_recorder.fanout.batch_size"),
+			cfg.GetInt("event_recorder.fanout.max_recipients"),
+		),
+		handlers.WithEmailOverride(emailOverride),
+	}
+	if directoryPath := cfg.GetString("event_recorder.directory.path"); directoryPath != "" {
+		dir, err := directory.NewFileDirectory(directoryPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		legacyOpts = append(legacyOpts, handlers.WithDirectory(dir))
+	}
+	userProfiles, err := newProfiles(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if userProfiles != nil {
+		legacyOpts = append(
+			legacyOpts, handlers.WithProfiles(userProfiles, cfg.GetBool("event_recorder.profiles.payload_fallback")),
+		)
+	}
+	var apiOpts []api.Option
+	renderer, err := newTemplateRenderer(tracerCtx, cfg, db)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if renderer != nil {
+		go renderer.Watch(tracerCtx, cfg.GetDuration("event_recorder.templates.reload_interval"))
+		legacyOpts = append(legacyOpts, handlers.WithTemplates(renderer))
+		apiOpts = append(apiOpts, api.WithTemplates(renderer))
+	}
+	validator, err := newPayloadValidator(tracerCtx, cfg, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if validator != nil {
+		go validator.Watch(tracerCtx, cfg.GetDuration("event_recorder.payload_schemas.reload_interval"))
+		legacyOpts = append(legacyOpts, handlers.WithPayloadValidator(validator))
+		apiOpts = append(apiOpts, api.WithPayloadValidator(validator))
+	}
+	types, typeMode, err := newNotificationTypes(tracerCtx, cfg, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go types.Watch(tracerCtx, cfg.GetDuration("event_recorder.notification_types.reload_interval"))
+	legacyOpts = append(legacyOpts, handlers.WithNotificationTypes(types, typeMode))
+	apiOpts = append(apiOpts, api.WithNotificationTypes(types))
+	signer, err := newUnsubscribeSigner(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if signer != nil {
+		legacyOpts = append(legacyOpts, handlers.WithUnsubscribeLinks(signer))
+		apiOpts = append(apiOpts, api.WithUnsubscribe(signer))
+	}
+	if !cfg.GetBool("event_recorder.scheduler.enabled") {
+		log.Warn("the scheduler is disabled, so scheduled notifications will be delivered immediately")
+		legacyOpts = append(legacyOpts, handlers.WithImmediateDelivery())
+	}
+	if cfg.GetBool("event_recorder.rate_limit.enabled") {
+		limiter, err := newRateLimiter(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		legacyOpts = append(legacyOpts, handlers.WithRateLimiter(limiter))
+		apiOpts = append(apiOpts, api.WithRateLimiter(limiter))
+	}
 
-	// Create the message handler set.
-	handlerSet, err := handlerset.New(amqpSettings, supportEmail, messageHandlers)
+	// Determine the format of outgoing notification messages.
+	cloudEvents := cloudEventsSettings(cfg)
+
+	// Determine how email requests are delivered.
+	emailSender, err := newEmailSender(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer handlerSet.Close()
 
-	// Listen for incoming messages.
-	err = handlerSet.Listen()
+	// Determine where audit records are published. Shadow mode never publishes anything, so no sink is used.
+	var auditSink audit.Sink
+	if !cfg.GetBool("event_recorder.shadow.enabled") {
+		auditSink, err = newAuditSink(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if auditSink != nil {
+		defer func() { _ = auditSink.Close() }()
+		legacyOpts = append(legacyOpts, handlers.WithAuditSink(auditSink))
+		apiOpts = append(apiOpts, api.WithAuditSink(auditSink))
+	}
+
+	// Initialize the message handlers. The publisher is shared with the message handler set, which closes it.
+	var messageHandlers map[string]handlers.MessageHandler
+	var publisher transport.Publisher = &shadow.DiscardingPublisher{}
+	if cfg.GetBool("event_recorder.shadow.enabled") {
+		var cleanup func()
+		log.Info("running in shadow mode; no messages will be published")
+		messageHandlers, cleanup, err = initShadowMessageHandlers(tracerCtx, cfg, db, legacyOpts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cleanup()
+	} else {
+		if emailSender != nil {
+			legacyOpts = append(legacyOpts, handlers.WithEmailSender(emailSender))
+		}
+		publisher, err = newPublisher(cfg, amqpSettings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		messageHandlers = handlers.InitMessageHandlers(
+			db, publisher, cloudEvents, cfg.GetInt("event_recorder.email.bounce_threshold"), legacyOpts...,
+		)
+	}
+
+	// Start the background jobs. The background jobs publish messages, so they're not run in shadow mode.
+	if !cfg.GetBool("event_recorder.shadow.enabled") {
+		stopBackgroundJobs, err := startBackgroundJobs(
+			tracerCtx, cfg, db, amqpSettings, cloudEvents, emailSender, auditSink,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer stopBackgroundJobs()
+	}
+
+	// Determine how the support team is alerted to discarded deliveries. Alerts are only logged in shadow mode.
+	var handlerSetOpts []handlerset.Option
+	if !cfg.GetBool("event_recorder.shadow.enabled") {
+		handlerSetOpts = append(handlerSetOpts, handlerset.WithAlertAggregation(&handlerset.AlertSettings{
+			Interval:           cfg.GetDuration("event_recorder.error_alerts.interval"),
+			ImmediateThreshold: cfg.GetInt("event_recorder.error_alerts.immediate_threshold"),
+			MaxSamples:         cfg.GetInt("event_recorder.error_alerts.max_samples"),
+		}))
+		if emailSender != nil {
+			handlerSetOpts = append(handlerSetOpts, handlerset.WithEmailSender(emailSender))
+		}
+	}
+
+	// Load the rules used to route and transform incoming events.
+	if rulesPath := cfg.GetString("event_recorder.rules.path"); rulesPath != "" {
+		engine, err := rules.NewEngine(rulesPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go engine.Watch(tracerCtx, cfg.GetDuration("event_recorder.rules.reload_interval"))
+		handlerSetOpts = append(handlerSetOpts, handlerset.WithRules(engine))
+	}
+
+	// Create the message handler set.
+	consumer, err := newConsumer(cfg, amqpSettings, queueSettings, consumerSettings)
 	if err != nil {
 		log.Fatal(err)
 	}
+	handlerSet := handlerset.New(consumer, publisher, consumerSettings, supportEmail, messageHandlers, handlerSetOpts...)
+	defer handlerSet.Close()
+
+	// Start the HTTP API. The API can't modify the primary instance's database in shadow mode.
+	shadowMode := cfg.GetBool("event_recorder.shadow.enabled")
+	if shadowMode {
+		apiOpts = append(apiOpts, api.WithReadOnly())
+	}
+	httpAPI := api.New(db, apiOpts...)
+	apiListenAddress := cfg.GetString("event_recorder.api.listen")
+	go func() {
+		log.Infof("listening for HTTP requests on %s", apiListenAddress)
+		log.Fatal(http.ListenAndServe(apiListenAddress, httpAPI.Handler()))
+	}()
+
+	// Serve unsubscribe links on a separate listener so that they can be exposed publicly without exposing the rest of
+	// the API. Nobody can unsubscribe in shadow mode.
+	if signer != nil && !shadowMode {
+		unsubscribeListenAddress := cfg.GetString("event_recorder.unsubscribe.listen")
+		go func() {
+			log.Infof("listening for unsubscribe requests on %s", unsubscribeListenAddress)
+			log.Fatal(http.ListenAndServe(unsubscribeListenAddress, httpAPI.UnsubscribeHandler()))
+		}()
+	}
+
+	// Listen for incoming messages.
+	handlerSet.Listen()
 
 	// Spin until someone kills the process.
 	spinner := make(chan int)
@@ -0,0 +1,342 @@
+// Package memstore provides an in-memory implementation of the database client used by the message
+// handlers. It's intended for testing and for running handlers without touching the notifications
+// database, for example in shadow mode.
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/messaging/v12"
+)
+
+// Record represents a single notification stored in memory along with the outgoing message that was
+// generated for it, its scheduled delivery, and the notification that superseded it, if any.
+type Record struct {
+	Notification common.Notification
+	Outgoing     *messaging.NotificationMessage
+	Pending      *common.PendingDelivery
+	SupersededBy string
+}
+
+// state represents the data stored by an in-memory store.
+type state struct {
+	notificationTypes map[string]bool
+	users             map[string]bool
+	records           []*Record
+	nextID            int
+}
+
+// clone returns a copy of the state that can be used to restore the current state when a transaction
+// is rolled back.
+func (s *state) clone() *state {
+	c := &state{
+		notificationTypes: make(map[string]bool, len(s.notificationTypes)),
+		users:             make(map[string]bool, len(s.users)),
+		records:           make([]*Record, len(s.records)),
+		nextID:            s.nextID,
+	}
+	for k, v := range s.notificationTypes {
+		c.notificationTypes[k] = v
+	}
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	for i, r := range s.records {
+		record := *r
+		c.records[i] = &record
+	}
+	return c
+}
+
+// Store is an in-memory notification store. Transactions are serialized: Begin blocks until any other
+// transaction has been committed or rolled back.
+type Store struct {
+	txMutex    sync.Mutex
+	stateMutex sync.Mutex
+	current    *sql.Tx
+	snapshot   *state
+	state      *state
+}
+
+// New returns a new, empty in-memory store.
+func New() *Store {
+	return &Store{
+		state: &state{
+			notificationTypes: make(map[string]bool),
+			users:             make(map[string]bool),
+		},
+	}
+}
+
+// Begin starts a new transaction. The returned transaction is only used to identify the transaction; it
+// must not be used to interact with a database.
+func (s *Store) Begin() (*sql.Tx, error) {
+	s.txMutex.Lock()
+
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	s.current = new(sql.Tx)
+	s.snapshot = s.state.clone()
+
+	return s.current, nil
+}
+
+// end ends the current transaction, restoring the state from the snapshot if requested. It returns
+// sql.ErrTxDone if the transaction has already ended.
+func (s *Store) end(tx *sql.Tx, restore bool) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if tx == nil || tx != s.current {
+		return sql.ErrTxDone
+	}
+	if restore {
+		s.state = s.snapshot
+	}
+	s.current = nil
+	s.snapshot = nil
+	s.txMutex.Unlock()
+
+	return nil
+}
+
+// Commit commits a transaction.
+func (s *Store) Commit(tx *sql.Tx) error {
+	return s.end(tx, false)
+}
+
+// Rollback rolls back a transaction. Rolling back a transaction that has already been committed has no
+// effect.
+func (s *Store) Rollback(tx *sql.Tx) error {
+	return s.end(tx, true)
+}
+
+// RegisterNotificationType registers a notification type if it hasn't been registered already.
+func (s *Store) RegisterNotificationType(_ context.Context, _ *sql.Tx, notificationType string) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	s.state.notificationTypes[notificationType] = true
+	return nil
+}
+
+// SaveNotification stores a notification, assigning an identifier to it.
+func (s *Store) SaveNotification(_ context.Context, _ *sql.Tx, notification *common.Notification) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	// The notification type must be registered first.
+	if !s.state.notificationTypes[notification.NotificationType] {
+		return fmt.Errorf("unknown notification type: %s", notification.NotificationType)
+	}
+
+	// Register the user and store the notification.
+	s.state.users[notification.User] = true
+	s.state.nextID++
+	notification.ID = fmt.Sprintf("%08d-0000-0000-0000-000000000000", s.state.nextID)
+	s.state.records = append(s.state.records, &Record{Notification: *notification})
+
+	return nil
+}
+
+// findRecord finds the record with the given notification ID. The caller must hold the state mutex.
+func (s *Store) findRecord(id string) *Record {
+	for _, record := range s.state.records {
+		if record.Notification.ID == id {
+			return record
+		}
+	}
+	return nil
+}
+
+// SaveOutgoingNotification records the outgoing message for a notification.
+func (s *Store) SaveOutgoingNotification(
+	_ context.Context,
+	_ *sql.Tx,
+	outgoingNotification *messaging.NotificationMessage,
+) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	id, _ := outgoingNotification.Message["id"].(string)
+	record := s.findRecord(id)
+	if record == nil {
+		return fmt.Errorf("notification not found: %s", id)
+	}
+	record.Outgoing = outgoingNotification
+
+	return nil
+}
+
+// CountUnreadNotifications counts the number of notifications for the user that haven't been marked as
+// read or deleted.
+func (s *Store) CountUnreadNotifications(_ context.Context, _ *sql.Tx, user string) (int64, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	var count int64
+	for _, record := range s.state.records {
+		n := record.Notification
+		if n.User == user && !n.Seen && !n.Deleted && !record.pending() && record.SupersededBy == "" {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// pending returns true if the record's notification is waiting to be delivered.
+func (r *Record) pending() bool {
+	return r.Pending != nil && r.Pending.Status == common.PendingDeliveryStatusPending
+}
+
+// SchedulePendingDelivery records the scheduled delivery of a notification.
+func (s *Store) SchedulePendingDelivery(_ context.Context, _ *sql.Tx, pendingDelivery *common.PendingDelivery) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	record := s.findRecord(pendingDelivery.NotificationID)
+	if record == nil {
+		return fmt.Errorf("notification not found: %s", pendingDelivery.NotificationID)
+	}
+	if pendingDelivery.Status == "" {
+		pendingDelivery.Status = common.PendingDeliveryStatusPending
+	}
+	if pendingDelivery.OutgoingMessage == "" && record.Outgoing != nil {
+		outgoingJSON, err := json.Marshal(record.Outgoing)
+		if err != nil {
+			return err
+		}
+		pendingDelivery.OutgoingMessage = string(outgoingJSON)
+	}
+	s.state.nextID++
+	pendingDelivery.ID = fmt.Sprintf("%08d-0000-0000-0000-000000000000", s.state.nextID)
+	scheduled := *pendingDelivery
+	record.Pending = &scheduled
+
+	return nil
+}
+
+// SupersedeNotifications marks the user's current notification with the given grouping key as superseded by a new
+// notification, and returns the notifications that were superseded.
+func (s *Store) SupersedeNotifications(
+	_ context.Context,
+	_ *sql.Tx,
+	user, groupingKey, id string,
+) ([]*common.Notification, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	superseded := make([]*common.Notification, 0)
+	for _, record := range s.state.records {
+		n := record.Notification
+		if n.User == user && n.GroupingKey == groupingKey && n.ID != id && record.SupersededBy == "" {
+			record.SupersededBy = id
+			superseded = append(superseded, &n)
+		}
+	}
+
+	return superseded, nil
+}
+
+// EmailOptedOut always returns false; the in-memory store doesn't record email preferences.
+func (s *Store) EmailOptedOut(_ context.Context, _ *sql.Tx, _, _ string) (bool, error) {
+	return false, nil
+}
+
+// GetUserLocale always returns an empty string; the in-memory store doesn't record locale preferences.
+func (s *Store) GetUserLocale(_ context.Context, _ *sql.Tx, _ string) (string, error) {
+	return "", nil
+}
+
+// EmailSuppressed always returns false; the in-memory store doesn't record bounces.
+func (s *Store) EmailSuppressed(_ context.Context, _ *sql.Tx, _ string) (bool, error) {
+	return false, nil
+}
+
+// SaveEmailDelivery does nothing; the in-memory store doesn't record email deliveries.
+func (s *Store) SaveEmailDelivery(_ context.Context, _ *sql.Tx, _ *common.EmailDelivery) error {
+	return nil
+}
+
+// UpdateEmailDeliveryStatus always returns an empty string; the in-memory store doesn't record email deliveries.
+func (s *Store) UpdateEmailDeliveryStatus(_ context.Context, _ *sql.Tx, _, _, _ string) (string, bool, error) {
+	return "", false, nil
+}
+
+// RecordHardBounce always returns false; the in-memory store doesn't record bounces.
+func (s *Store) RecordHardBounce(_ context.Context, _ *sql.Tx, _, _ string, _ int) (bool, error) {
+	return false, nil
+}
+
+// QuarantineEvent discards the event; the in-memory store doesn't keep quarantined events.
+func (s *Store) QuarantineEvent(_ context.Context, _ *sql.Tx, _ *common.QuarantinedEvent) error {
+	return nil
+}
+
+// Records returns copies of all of the records that have been committed to the store.
+func (s *Store) Records() []Record {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	// Uncommitted changes aren't visible outside of the transaction.
+	source := s.state
+	if s.snapshot != nil {
+		source = s.snapshot
+	}
+
+	records := make([]Record, len(source.records))
+	for i, record := range source.records {
+		records[i] = *record
+	}
+
+	return records
+}
+
+// NotificationExists returns true if a notification created from an incoming message with the given routing key
+// and body has already been stored for a user.
+func (s *Store) NotificationExists(_ context.Context, _ *sql.Tx, user, routingKey, incomingJSON string) (bool, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	for _, record := range s.state.records {
+		n := record.Notification
+		if n.User == user && n.RoutingKey == routingKey && n.Message == incomingJSON {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CloudEventNotificationExists returns true if a notification created from the CloudEvent with the given source and
+// ID has already been stored for a user.
+func (s *Store) CloudEventNotificationExists(
+	_ context.Context,
+	_ *sql.Tx,
+	user, routingKey, source, id string,
+) (bool, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	for _, record := range s.state.records {
+		n := record.Notification
+		if n.User != user || n.RoutingKey != routingKey {
+			continue
+		}
+		var request struct {
+			EventSource string `json:"event_source"`
+			EventID     string `json:"event_id"`
+		}
+		if json.Unmarshal([]byte(n.Message), &request) == nil && request.EventSource == source && request.EventID == id {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
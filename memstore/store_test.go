@@ -0,0 +1,100 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestNotification returns a notification that can be stored for testing.
+func getTestNotification(user string) *common.Notification {
+	return &common.Notification{
+		NotificationType: "analysis",
+		User:             user,
+		Subject:          "some job status changed",
+		TimeCreated:      time.Now(),
+		Message:          "{}",
+		RoutingKey:       "events.notification.update.analysis",
+	}
+}
+
+// saveTestNotification stores a test notification in its own transaction, either committing or rolling back
+// the transaction.
+func saveTestNotification(t *testing.T, store *Store, user string, commit bool) *common.Notification {
+	ctx := context.Background()
+	notification := getTestNotification(user)
+
+	tx, err := store.Begin()
+	assert.NoError(t, err)
+	assert.NoError(t, store.RegisterNotificationType(ctx, tx, notification.NotificationType))
+	assert.NoError(t, store.SaveNotification(ctx, tx, notification))
+	outgoing := &messaging.NotificationMessage{Message: map[string]interface{}{"id": notification.ID}}
+	assert.NoError(t, store.SaveOutgoingNotification(ctx, tx, outgoing))
+	if commit {
+		assert.NoError(t, store.Commit(tx))
+	}
+	_ = store.Rollback(tx)
+
+	return notification
+}
+
+func TestCommit(t *testing.T) {
+	assert := assert.New(t)
+	store := New()
+
+	// Save a notification and verify that it was stored.
+	notification := saveTestNotification(t, store, "ipcdev", true)
+	records := store.Records()
+	if assert.Len(records, 1) {
+		assert.Equal(notification.ID, records[0].Notification.ID)
+		assert.Equal(notification.ID, records[0].Outgoing.Message["id"])
+	}
+
+	// Verify that the notification is counted as unread.
+	count, err := store.CountUnreadNotifications(context.Background(), nil, "ipcdev")
+	assert.NoError(err)
+	assert.Equal(int64(1), count)
+}
+
+func TestRollback(t *testing.T) {
+	assert := assert.New(t)
+	store := New()
+
+	// Save two notifications, but roll back the second one.
+	saveTestNotification(t, store, "ipcdev", true)
+	saveTestNotification(t, store, "ipcdev", false)
+
+	// Only the first notification should have been stored.
+	assert.Len(store.Records(), 1)
+}
+
+func TestRollbackAfterCommit(t *testing.T) {
+	assert := assert.New(t)
+	store := New()
+
+	// Commit one transaction and start another.
+	tx1, err := store.Begin()
+	assert.NoError(err)
+	assert.NoError(store.Commit(tx1))
+	tx2, err := store.Begin()
+	assert.NoError(err)
+
+	// Rolling back the first transaction must not affect the second one.
+	assert.Error(store.Rollback(tx1))
+	assert.NoError(store.Commit(tx2))
+}
+
+func TestUnknownNotificationType(t *testing.T) {
+	store := New()
+	tx, err := store.Begin()
+	assert.NoError(t, err)
+	defer func() { _ = store.Rollback(tx) }()
+
+	// Notification types must be registered before notifications of that type can be saved.
+	err = store.SaveNotification(context.Background(), tx, getTestNotification("ipcdev"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,157 @@
+// Package payloads validates the payloads of incoming events against the JSON Schema registered for their
+// notification type, so that malformed events are rejected before any notifications are stored. Events whose
+// notification type has no schema aren't validated.
+//
+// Schemas may not refer to external documents; every reference must resolve within the schema itself.
+package payloads
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// maxSchemaLength is the maximum length of a single schema in bytes.
+const maxSchemaLength = 256 * 1024
+
+// Problem describes a single way in which a payload fails to match its schema. The path is a JSON Pointer to the
+// offending value, relative to the payload.
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// String returns a description of the problem that includes the path.
+func (p Problem) String() string {
+	path := p.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, p.Message)
+}
+
+// ValidationError is returned when a payload doesn't match the schema for its notification type.
+type ValidationError struct {
+	NotificationType string    `json:"notification_type"`
+	Problems         []Problem `json:"problems"`
+}
+
+// Error returns the error message for a ValidationError.
+func (e *ValidationError) Error() string {
+	descriptions := make([]string, len(e.Problems))
+	for i, problem := range e.Problems {
+		descriptions[i] = problem.String()
+	}
+	return fmt.Sprintf("invalid %s payload: %s", e.NotificationType, strings.Join(descriptions, "; "))
+}
+
+// Schema is a compiled payload schema for a single notification type.
+type Schema struct {
+	notificationType string
+	schema           *jsonschema.Schema
+}
+
+// Compile parses and compiles the payload schema for a notification type.
+func Compile(ps *common.PayloadSchema) (*Schema, error) {
+	wrapMsg := fmt.Sprintf("invalid %s payload schema", ps.NotificationType)
+
+	if len(ps.Schema) > maxSchemaLength {
+		return nil, fmt.Errorf("%s: the schema exceeds the maximum length of %d bytes", wrapMsg, maxSchemaLength)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(ps.Schema))
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// External references aren't allowed, so the compiler isn't given a way to load them.
+	location := fmt.Sprintf("urn:event-recorder:payload-schema:%s", ps.NotificationType)
+	compiler := jsonschema.NewCompiler()
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+	err = compiler.AddResource(location, doc)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	schema, err := compiler.Compile(location)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return &Schema{notificationType: ps.NotificationType, schema: schema}, nil
+}
+
+// Validate checks a JSON payload against the schema. A missing payload is validated as null. A ValidationError
+// listing every problem is returned if the payload doesn't match.
+func (s *Schema) Validate(payload []byte) error {
+	if len(bytes.TrimSpace(payload)) == 0 {
+		payload = []byte("null")
+	}
+	value, err := jsonschema.UnmarshalJSON(bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse the %s payload", s.notificationType)
+	}
+
+	err = s.schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return errors.Wrapf(err, "unable to validate the %s payload", s.notificationType)
+	}
+	return &ValidationError{NotificationType: s.notificationType, Problems: problems(verr)}
+}
+
+// problems flattens a validation error into the list of its leaf problems, ordered by path.
+func problems(verr *jsonschema.ValidationError) []Problem {
+	var result []Problem
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil || len(unit.Errors) > 0 {
+			continue
+		}
+		result = append(result, Problem{Path: unit.InstanceLocation, Message: unit.Error.String()})
+	}
+	if len(result) == 0 {
+		result = append(result, Problem{Message: verr.Error()})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// Set contains the compiled payload schemas for a collection of notification types.
+type Set struct {
+	schemas map[string]*Schema
+}
+
+// NewSet compiles a collection of payload schemas. An error is returned if any of the schemas is invalid.
+func NewSet(schemas []*common.PayloadSchema) (*Set, error) {
+	set := &Set{schemas: make(map[string]*Schema, len(schemas))}
+	for _, ps := range schemas {
+		schema, err := Compile(ps)
+		if err != nil {
+			return nil, err
+		}
+		set.schemas[strings.ToLower(ps.NotificationType)] = schema
+	}
+	return set, nil
+}
+
+// Lookup returns the schema for a notification type, or nil if there's no schema for the notification type.
+func (s *Set) Lookup(notificationType string) *Schema {
+	if s == nil {
+		return nil
+	}
+	return s.schemas[strings.ToLower(notificationType)]
+}
+
+// Len returns the number of schemas in the set.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.schemas)
+}
@@ -0,0 +1,137 @@
+package payloads
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSchema is a sample payload schema for analysis notifications.
+const testSchema = `{
+	"type": "object",
+	"required": ["analysisname", "analysisstatus"],
+	"properties": {
+		"analysisname": {"type": "string"},
+		"analysisstatus": {"enum": ["Submitted", "Running", "Completed"]},
+		"outputs": {"type": "array", "items": {"type": "string"}},
+		"count": {"type": "integer"}
+	}
+}`
+
+// compileTestSchema compiles the sample payload schema.
+func compileTestSchema(t *testing.T) *Schema {
+	schema, err := Compile(&common.PayloadSchema{NotificationType: "analysis", Schema: []byte(testSchema)})
+	if err != nil {
+		t.Fatalf("unable to compile the schema: %s", err.Error())
+	}
+	return schema
+}
+
+func TestCompile(t *testing.T) {
+	assert := assert.New(t)
+
+	compile := func(schema string) error {
+		_, err := Compile(&common.PayloadSchema{NotificationType: "analysis", Schema: []byte(schema)})
+		return err
+	}
+	assert.NoError(compile(testSchema))
+	assert.NoError(compile(`true`))
+	assert.Error(compile(`{"type": "object"`), "malformed JSON should be rejected")
+	assert.Error(compile(`{"type": "widget"}`), "invalid schemas should be rejected")
+	assert.Error(compile(`{"$ref": "https://example.org/schema.json"}`), "external references should be rejected")
+	assert.Error(compile(`{"$ref": "file:///etc/passwd"}`), "external references should be rejected")
+	assert.Error(compile(`"` + strings.Repeat("x", maxSchemaLength) + `"`))
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	schema := compileTestSchema(t)
+
+	// Valid payloads should be accepted.
+	assert.NoError(schema.Validate([]byte(`{"analysisname": "word count", "analysisstatus": "Running", "count": 3}`)))
+
+	// Each problem should be reported along with the path to the offending value.
+	err := schema.Validate([]byte(`{"analysisstatus": "Done", "outputs": ["a.txt", 2], "count": 1.5}`))
+	var verr *ValidationError
+	if assert.True(errors.As(err, &verr)) {
+		assert.Equal("analysis", verr.NotificationType)
+		paths := make([]string, len(verr.Problems))
+		for i, problem := range verr.Problems {
+			paths[i] = problem.Path
+		}
+		assert.Equal([]string{"", "/analysisstatus", "/count", "/outputs/1"}, paths)
+		assert.Contains(err.Error(), "invalid analysis payload: /: ")
+		assert.Contains(err.Error(), "analysisname")
+	}
+
+	// Missing payloads should be validated as null.
+	err = schema.Validate(nil)
+	assert.True(errors.As(err, &verr))
+
+	// Malformed payloads should be rejected.
+	err = schema.Validate([]byte(`{`))
+	assert.Error(err)
+	assert.False(errors.As(err, &verr))
+}
+
+func TestSet(t *testing.T) {
+	assert := assert.New(t)
+
+	set, err := NewSet([]*common.PayloadSchema{{NotificationType: "Analysis", Schema: []byte(testSchema)}})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(1, set.Len())
+	assert.NotNil(set.Lookup("analysis"))
+	assert.NotNil(set.Lookup("ANALYSIS"))
+	assert.Nil(set.Lookup("data"))
+
+	// The set should be rejected if any of its schemas is invalid.
+	_, err = NewSet([]*common.PayloadSchema{{NotificationType: "data", Schema: []byte(`{"type": 1}`)}})
+	assert.Error(err)
+}
+
+func TestDirectorySource(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// Write the schema files.
+	dir := t.TempDir()
+	files := map[string]string{
+		"analysis.json": testSchema,
+		"README.md":     "not a schema",
+	}
+	for name, contents := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %s", name, err.Error())
+		}
+	}
+
+	// Load the schemas.
+	validator, err := NewValidator(ctx, NewDirectorySource(dir))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NotNil(validator.Lookup("analysis"))
+	assert.NoError(validator.Validate("data", []byte(`"anything"`)), "types without schemas shouldn't be validated")
+	assert.Error(validator.Validate("analysis", []byte(`{}`)))
+
+	// The current schemas should be retained if the reloaded schemas are invalid.
+	err = os.WriteFile(filepath.Join(dir, "analysis.json"), []byte(`{"type": "widget"}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to write analysis.json: %s", err.Error())
+	}
+	assert.Error(validator.Reload(ctx))
+	assert.Error(validator.Validate("analysis", []byte(`{}`)))
+
+	// The directory must exist.
+	_, err = NewValidator(ctx, NewDirectorySource(filepath.Join(dir, "missing")))
+	assert.Error(err)
+}
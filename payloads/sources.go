@@ -0,0 +1,80 @@
+package payloads
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/pkg/errors"
+)
+
+// Source loads payload schemas from a backing store.
+type Source interface {
+	Load(ctx context.Context) ([]*common.PayloadSchema, error)
+}
+
+// DirectorySource loads payload schemas from the JSON files in a directory. Each file contains the schema for a
+// single notification type, which is determined by the file name: the schema for `analysis` events is stored in
+// `analysis.json`.
+type DirectorySource struct {
+	path string
+}
+
+// NewDirectorySource returns a source that loads payload schemas from a directory.
+func NewDirectorySource(path string) *DirectorySource {
+	return &DirectorySource{path: path}
+}
+
+// Load loads the payload schemas from the directory.
+func (s *DirectorySource) Load(_ context.Context) ([]*common.PayloadSchema, error) {
+	wrapMsg := fmt.Sprintf("unable to load the payload schemas from %s", s.path)
+
+	// List the files in the directory.
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Load each schema file.
+	schemas := make([]*common.PayloadSchema, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(s.path, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, wrapMsg)
+		}
+		schemas = append(schemas, &common.PayloadSchema{
+			NotificationType: strings.ToLower(strings.TrimSuffix(entry.Name(), ".json")),
+			Schema:           contents,
+		})
+	}
+
+	return schemas, nil
+}
+
+// DatabaseSource loads payload schemas from the notifications database.
+type DatabaseSource struct {
+	db *sql.DB
+}
+
+// NewDatabaseSource returns a source that loads payload schemas from the notifications database.
+func NewDatabaseSource(db *sql.DB) *DatabaseSource {
+	return &DatabaseSource{db: db}
+}
+
+// Load loads the payload schemas from the database.
+func (s *DatabaseSource) Load(ctx context.Context) ([]*common.PayloadSchema, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to begin a database transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+	return db.ListPayloadSchemas(ctx, tx)
+}
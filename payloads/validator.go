@@ -0,0 +1,91 @@
+package payloads
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "payloads"})
+
+// Validator validates event payloads using the schemas loaded from a source, which are reloaded periodically.
+type Validator struct {
+	source Source
+	mutex  sync.RWMutex
+	set    *Set
+}
+
+// NewValidator loads the schemas from a source and returns a validator that uses them. An error is returned if the
+// schemas can't be loaded.
+func NewValidator(ctx context.Context, source Source) (*Validator, error) {
+	v := &Validator{source: source}
+	err := v.Reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// NewStaticValidator returns a validator that uses a fixed set of schemas.
+func NewStaticValidator(set *Set) *Validator {
+	return &Validator{set: set}
+}
+
+// Reload loads the schemas from the source. The current schemas are retained if the new schemas can't be loaded or
+// if any of them is invalid.
+func (v *Validator) Reload(ctx context.Context) error {
+	if v.source == nil {
+		return nil
+	}
+	schemas, err := v.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	set, err := NewSet(schemas)
+	if err != nil {
+		return err
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.set = set
+	return nil
+}
+
+// Watch reloads the schemas at the given interval until the context is canceled.
+func (v *Validator) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := v.Reload(ctx)
+			if err != nil {
+				log.Errorf("keeping the current payload schemas: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Lookup returns the schema for a notification type, or nil if there's no schema for the notification type.
+func (v *Validator) Lookup(notificationType string) *Schema {
+	v.mutex.RLock()
+	set := v.set
+	v.mutex.RUnlock()
+	return set.Lookup(notificationType)
+}
+
+// Validate checks a JSON payload against the schema for its notification type. Payloads of notification types
+// without a schema are always valid.
+func (v *Validator) Validate(notificationType string, payload []byte) error {
+	schema := v.Lookup(notificationType)
+	if schema == nil {
+		return nil
+	}
+	return schema.Validate(payload)
+}
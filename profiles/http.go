@@ -0,0 +1,93 @@
+package profiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UserPlaceholder is the placeholder replaced by the username in the URL of an HTTP profile service.
+const UserPlaceholder = "{user}"
+
+// maxResponseLength is the maximum length of a response from the profile service in bytes.
+const maxResponseLength = 1024 * 1024
+
+// HTTPProfiles is a Profiles implementation that looks up profiles using an HTTP service. The service must respond
+// to a GET request for the user's profile URL with a JSON object containing the user's email address, or with a 404
+// status if the user doesn't exist. A user whose profile has no email address is treated as a user with an empty
+// address rather than as an error, so that the lookup isn't retried.
+type HTTPProfiles struct {
+	urlTemplate string
+	emailField  string
+	client      *http.Client
+}
+
+// NewHTTPProfiles returns a Profiles implementation that uses an HTTP service. The URL template must contain the
+// placeholder `{user}`, which is replaced by the escaped username. The email address is read from the named field of
+// the response body.
+func NewHTTPProfiles(urlTemplate, emailField string, timeout time.Duration) (*HTTPProfiles, error) {
+	wrapMsg := "invalid profile service settings"
+
+	if !strings.Contains(urlTemplate, UserPlaceholder) {
+		return nil, fmt.Errorf("%s: the URL must contain %s", wrapMsg, UserPlaceholder)
+	}
+	u, err := url.Parse(strings.ReplaceAll(urlTemplate, UserPlaceholder, "user"))
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	if !u.IsAbs() {
+		return nil, fmt.Errorf("%s: the URL must be absolute", wrapMsg)
+	}
+	if emailField == "" {
+		return nil, fmt.Errorf("%s: no email field specified", wrapMsg)
+	}
+
+	return &HTTPProfiles{
+		urlTemplate: urlTemplate,
+		emailField:  emailField,
+		client:      &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Profile returns the profile of a user.
+func (p *HTTPProfiles) Profile(ctx context.Context, user string) (*Profile, error) {
+	wrapMsg := fmt.Sprintf("unable to look up the profile of %s", user)
+
+	// Send the request.
+	profileURL := strings.ReplaceAll(p.urlTemplate, UserPlaceholder, url.PathEscape(user))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, profileURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Check the response status.
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, NotFoundError{User: user}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: the profile service returned status %d", wrapMsg, resp.StatusCode)
+	}
+
+	// Parse the response body.
+	var body map[string]interface{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseLength)).Decode(&body)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	email, _ := body[p.emailField].(string)
+
+	return &Profile{Username: user, Email: email}, nil
+}
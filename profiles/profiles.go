@@ -0,0 +1,158 @@
+// Package profiles resolves usernames to the user profile information needed to send notifications, such as the
+// user's canonical email address. Resolving the address from the username means that the address in an event's
+// payload doesn't have to be trusted.
+package profiles
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// NotFoundError is returned when a user has no profile.
+type NotFoundError struct {
+	User string
+}
+
+// Error returns the error message for a NotFoundError.
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("user profile not found: %s", e.User)
+}
+
+// Profile contains the profile information for a single user.
+type Profile struct {
+	Username string `yaml:"-" json:"username"`
+	Email    string `yaml:"email" json:"email"`
+}
+
+// Profiles describes the interface used to look up user profiles.
+type Profiles interface {
+	Profile(ctx context.Context, user string) (*Profile, error)
+}
+
+// FileProfiles is a Profiles implementation whose profiles are defined in a YAML file. The file contains a single
+// map from username to profile:
+//
+//	users:
+//	  ipcdev:
+//	    email: ipcdev@example.org
+type FileProfiles struct {
+	profiles map[string]*Profile
+}
+
+// NewFileProfiles loads user profiles from a YAML file.
+func NewFileProfiles(path string) (*FileProfiles, error) {
+	wrapMsg := fmt.Sprintf("unable to load the user profiles from %s", path)
+
+	// Read the file.
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Parse the file contents.
+	var file struct {
+		Users map[string]*Profile `yaml:"users"`
+	}
+	err = yaml.Unmarshal(contents, &file)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	profiles := make(map[string]*Profile, len(file.Users))
+	for user, profile := range file.Users {
+		if profile == nil {
+			profile = &Profile{}
+		}
+		profile.Username = user
+		profiles[user] = profile
+	}
+
+	return &FileProfiles{profiles: profiles}, nil
+}
+
+// Profile returns the profile of a user.
+func (p *FileProfiles) Profile(_ context.Context, user string) (*Profile, error) {
+	profile, ok := p.profiles[user]
+	if !ok {
+		return nil, NotFoundError{User: user}
+	}
+	result := *profile
+	return &result, nil
+}
+
+// cacheEntry is a cached profile lookup. Lookups of users without profiles are cached as well.
+type cacheEntry struct {
+	profile *Profile
+	err     error
+	expires time.Time
+}
+
+// CachedProfiles caches the profiles returned by another Profiles implementation for a fixed amount of time.
+// Errors other than NotFoundError aren't cached.
+type CachedProfiles struct {
+	profiles Profiles
+	ttl      time.Duration
+	mutex    sync.Mutex
+	entries  map[string]*cacheEntry
+	pruned   time.Time
+}
+
+// NewCachedProfiles returns a cache of the profiles returned by another Profiles implementation.
+func NewCachedProfiles(profiles Profiles, ttl time.Duration) *CachedProfiles {
+	return &CachedProfiles{profiles: profiles, ttl: ttl, entries: make(map[string]*cacheEntry)}
+}
+
+// Profile returns the profile of a user, looking it up if it isn't cached.
+func (c *CachedProfiles) Profile(ctx context.Context, user string) (*Profile, error) {
+	now := time.Now()
+
+	// Return the cached result if there is one.
+	c.mutex.Lock()
+	entry, ok := c.entries[user]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expires) {
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		result := *entry.profile
+		return &result, nil
+	}
+
+	// Look up the profile.
+	profile, err := c.profiles.Profile(ctx, user)
+	var notFound NotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		return nil, err
+	}
+
+	// Cache the result.
+	c.mutex.Lock()
+	c.prune(now)
+	c.entries[user] = &cacheEntry{profile: profile, err: err, expires: now.Add(c.ttl)}
+	c.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	result := *profile
+	return &result, nil
+}
+
+// prune discards expired entries at most once per TTL so that the cache doesn't grow without bound. The caller must
+// hold the lock.
+func (c *CachedProfiles) prune(now time.Time) {
+	if now.Sub(c.pruned) < c.ttl {
+		return
+	}
+	c.pruned = now
+	for user, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, user)
+		}
+	}
+}
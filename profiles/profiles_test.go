@@ -0,0 +1,172 @@
+package profiles
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestProfiles writes a profiles file to a temporary directory and returns its path.
+func writeTestProfiles(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "users.yml")
+	err := os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the profiles file: %s", err.Error())
+	}
+	return path
+}
+
+func TestFileProfiles(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// Load the profiles.
+	path := writeTestProfiles(t, "users:\n  ipcdev:\n    email: ipcdev@example.org\n  sarahr:\n")
+	p, err := NewFileProfiles(path)
+	if err != nil {
+		t.Fatalf("unable to load the profiles: %s", err.Error())
+	}
+
+	// Look up a user with an email address.
+	profile, err := p.Profile(ctx, "ipcdev")
+	assert.NoError(err)
+	assert.Equal(&Profile{Username: "ipcdev", Email: "ipcdev@example.org"}, profile)
+
+	// Look up a user without an email address.
+	profile, err = p.Profile(ctx, "sarahr")
+	assert.NoError(err)
+	assert.Equal(&Profile{Username: "sarahr"}, profile)
+
+	// Look up a user that doesn't exist.
+	_, err = p.Profile(ctx, "nobody")
+	assert.Equal(NotFoundError{User: "nobody"}, err)
+}
+
+func TestFileProfilesErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	// The file must exist.
+	_, err := NewFileProfiles(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(err)
+
+	// The file must be valid YAML.
+	_, err = NewFileProfiles(writeTestProfiles(t, "users: ["))
+	assert.Error(err)
+}
+
+// newTestProfileService returns a stub profile service that knows about a single user.
+func newTestProfileService(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/ipcdev":
+			_, _ = w.Write([]byte(`{"id": "ipcdev", "mail": "ipcdev@example.org"}`))
+		case "/users/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPProfiles(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	server := newTestProfileService(t)
+
+	p, err := NewHTTPProfiles(server.URL+"/users/{user}", "mail", time.Second)
+	if err != nil {
+		t.Fatalf("unable to create the profile lookup: %s", err.Error())
+	}
+
+	// Look up a user that exists.
+	profile, err := p.Profile(ctx, "ipcdev")
+	assert.NoError(err)
+	assert.Equal(&Profile{Username: "ipcdev", Email: "ipcdev@example.org"}, profile)
+
+	// Look up a user that doesn't exist.
+	_, err = p.Profile(ctx, "nobody")
+	assert.Equal(NotFoundError{User: "nobody"}, err)
+
+	// Other errors shouldn't be reported as missing profiles.
+	_, err = p.Profile(ctx, "broken")
+	assert.Error(err)
+	assert.NotEqual(NotFoundError{User: "broken"}, err)
+
+	// Profiles without the email field should have no email address.
+	p, err = NewHTTPProfiles(server.URL+"/users/{user}", "email", time.Second)
+	if assert.NoError(err) {
+		profile, err = p.Profile(ctx, "ipcdev")
+		assert.NoError(err)
+		assert.Equal(&Profile{Username: "ipcdev"}, profile)
+	}
+}
+
+func TestHTTPProfilesSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewHTTPProfiles("http://profiles.example.org/users", "email", time.Second)
+	assert.Error(err, "the URL must contain the placeholder")
+	_, err = NewHTTPProfiles("/users/{user}", "email", time.Second)
+	assert.Error(err, "the URL must be absolute")
+	_, err = NewHTTPProfiles("http://profiles.example.org/users/{user}", "", time.Second)
+	assert.Error(err, "the email field must be specified")
+}
+
+// countingProfiles counts the lookups passed to another Profiles implementation.
+type countingProfiles struct {
+	profiles Profiles
+	lookups  int
+}
+
+// Profile counts the lookup and passes it to the wrapped implementation.
+func (c *countingProfiles) Profile(ctx context.Context, user string) (*Profile, error) {
+	c.lookups++
+	return c.profiles.Profile(ctx, user)
+}
+
+func TestCachedProfiles(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	server := newTestProfileService(t)
+
+	p, err := NewHTTPProfiles(server.URL+"/users/{user}", "mail", time.Second)
+	if err != nil {
+		t.Fatalf("unable to create the profile lookup: %s", err.Error())
+	}
+	counter := &countingProfiles{profiles: p}
+	cache := NewCachedProfiles(counter, time.Hour)
+
+	// Profiles and missing profiles should both be cached.
+	for i := 0; i < 2; i++ {
+		profile, err := cache.Profile(ctx, "ipcdev")
+		assert.NoError(err)
+		assert.Equal("ipcdev@example.org", profile.Email)
+		_, err = cache.Profile(ctx, "nobody")
+		assert.Equal(NotFoundError{User: "nobody"}, err)
+	}
+	assert.Equal(2, counter.lookups)
+
+	// Other errors shouldn't be cached.
+	for i := 0; i < 2; i++ {
+		_, err = cache.Profile(ctx, "broken")
+		assert.Error(err)
+	}
+	assert.Equal(4, counter.lookups)
+
+	// Entries should expire.
+	cache = NewCachedProfiles(counter, time.Nanosecond)
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Millisecond)
+		_, err = cache.Profile(ctx, "ipcdev")
+		assert.NoError(err)
+	}
+	assert.Equal(6, counter.lookups)
+}
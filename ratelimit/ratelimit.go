@@ -0,0 +1,315 @@
+// Package ratelimit provides token bucket rate limits for the notifications sent to each user. Every user has one
+// bucket shared by all notification types and one bucket for each notification type. A notification may only be
+// delivered immediately if both buckets contain a token.
+//
+// Buckets are held in memory, so each replica enforces the limits independently. The effective limits are therefore
+// multiplied by the number of replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// The actions that may be taken when a notification exceeds a rate limit.
+const (
+	OverflowCollapse = "collapse"
+	OverflowDrop     = "drop"
+	OverflowDefer    = "defer"
+)
+
+// ParseOverflow validates an overflow action. The action is case sensitive.
+func ParseOverflow(overflow string) (string, error) {
+	switch overflow {
+	case OverflowCollapse, OverflowDrop, OverflowDefer:
+		return overflow, nil
+	default:
+		return "", fmt.Errorf("unrecognized rate limit overflow action: %s", overflow)
+	}
+}
+
+// idleBucketTTL is the amount of time after which the buckets of users who haven't received any notifications are
+// discarded. Every bucket is full long before this time has passed, so discarding it doesn't change its behavior.
+const idleBucketTTL = time.Hour
+
+// Limit describes a token bucket: tokens are added at Rate tokens per second, up to a maximum of Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// enabled returns true if the limit should be enforced.
+func (l Limit) enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Settings describes the limits enforced by a limiter. A limit with a non-positive rate or burst isn't enforced.
+type Settings struct {
+	User     Limit
+	Type     Limit
+	Overflow string
+}
+
+// bucket is a single token bucket. The number of tokens may be negative if tokens have been reserved in advance.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens that have accumulated since the bucket was last updated.
+func (b *bucket) refill(limit Limit, now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*limit.Rate, float64(limit.Burst))
+		b.updated = now
+	}
+}
+
+// available returns the time at which the bucket will next contain a token.
+func (b *bucket) available(limit Limit, now time.Time) time.Time {
+	if b.tokens >= 1 {
+		return now
+	}
+	return now.Add(time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)))
+}
+
+// throttleRun records the notifications of a single type that have been throttled for a user since the last time
+// that a notification of that type was allowed.
+type throttleRun struct {
+	started time.Time
+	count   int
+}
+
+// Decision describes the outcome of checking a notification against the rate limits.
+type Decision struct {
+
+	// Allowed is true if the notification may be delivered immediately.
+	Allowed bool
+
+	// RetryAt is the time at which the notification may be delivered if it's not allowed.
+	RetryAt time.Time
+
+	// Throttled is the number of consecutive notifications of the same type, including this one, that have been
+	// throttled for the user.
+	Throttled int
+
+	// Since is the time at which the current run of throttled notifications started.
+	Since time.Time
+}
+
+// Limiter enforces per-user and per-type rate limits.
+type Limiter struct {
+	settings  Settings
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	runs      map[string]*throttleRun
+	throttled map[string]map[string]int64
+	pruned    time.Time
+	counter   metric.Int64Counter
+}
+
+// New returns a limiter that enforces the given limits.
+func New(settings Settings) *Limiter {
+	counter, err := otel.Meter("github.com/cyverse-de/event-recorder/ratelimit").Int64Counter(
+		"event_recorder.notifications.throttled",
+		metric.WithDescription("The number of notifications that exceeded a rate limit."),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return &Limiter{
+		settings:  settings,
+		buckets:   make(map[string]*bucket),
+		runs:      make(map[string]*throttleRun),
+		throttled: make(map[string]map[string]int64),
+		counter:   counter,
+	}
+}
+
+// Overflow returns the action that should be taken when a notification exceeds a rate limit.
+func (l *Limiter) Overflow() string {
+	return l.settings.Overflow
+}
+
+// userKey returns the key of the bucket shared by all of a user's notifications.
+func userKey(user string) string {
+	return "user\x00" + user
+}
+
+// typeKey returns the key of the bucket used for a user's notifications of a single type.
+func typeKey(user, notificationType string) string {
+	return "type\x00" + user + "\x00" + notificationType
+}
+
+// bucket returns the refilled bucket for a key, creating a full bucket if there isn't one. The caller must hold
+// the lock.
+func (l *Limiter) bucket(key string, limit Limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(limit, now)
+	return b
+}
+
+// limitedBuckets returns the enabled limits that apply to a notification along with their refilled buckets. The
+// caller must hold the lock.
+func (l *Limiter) limitedBuckets(user, notificationType string, now time.Time) ([]Limit, []*bucket) {
+	limits := make([]Limit, 0, 2)
+	buckets := make([]*bucket, 0, 2)
+	if l.settings.User.enabled() {
+		limits = append(limits, l.settings.User)
+		buckets = append(buckets, l.bucket(userKey(user), l.settings.User, now))
+	}
+	if l.settings.Type.enabled() {
+		limits = append(limits, l.settings.Type)
+		buckets = append(buckets, l.bucket(typeKey(user, notificationType), l.settings.Type, now))
+	}
+	return limits, buckets
+}
+
+// Allow determines whether a notification of the given type may be delivered to a user at the given time. A token
+// is taken from each of the user's buckets if the notification is allowed. Otherwise, RetryAt is set to the time at
+// which the notification would be allowed, but no tokens are reserved.
+func (l *Limiter) Allow(user, notificationType string, now time.Time) *Decision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.prune(now)
+
+	// Determine when every bucket will contain a token.
+	limits, buckets := l.limitedBuckets(user, notificationType, now)
+	retryAt := now
+	for i, b := range buckets {
+		if available := b.available(limits[i], now); available.After(retryAt) {
+			retryAt = available
+		}
+	}
+
+	// Take the tokens if the notification is allowed.
+	if !retryAt.After(now) {
+		for _, b := range buckets {
+			b.tokens--
+		}
+		delete(l.runs, typeKey(user, notificationType))
+		return &Decision{Allowed: true, RetryAt: now}
+	}
+
+	// Record the throttled notification.
+	key := typeKey(user, notificationType)
+	run, ok := l.runs[key]
+	if !ok {
+		run = &throttleRun{started: now}
+		l.runs[key] = run
+	}
+	run.count++
+
+	return &Decision{Allowed: false, RetryAt: retryAt, Throttled: run.count, Since: run.started}
+}
+
+// Reserve reserves a token from each of a user's buckets and returns the time at which a notification of the given
+// type may be delivered. Tokens are reserved even if the buckets are empty, so each successive reservation is
+// delivered later than the one before it.
+func (l *Limiter) Reserve(user, notificationType string, now time.Time) time.Time {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.prune(now)
+
+	// Determine when every bucket will contain a token, then take the tokens.
+	limits, buckets := l.limitedBuckets(user, notificationType, now)
+	deliverAt := now
+	for i, b := range buckets {
+		if available := b.available(limits[i], now); available.After(deliverAt) {
+			deliverAt = available
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+
+	return deliverAt
+}
+
+// Refund returns the tokens taken by Allow or Reserve for a notification that was never delivered, such as a
+// notification whose database transaction was rolled back. A bucket never holds more than its burst.
+func (l *Limiter) Refund(user, notificationType string, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limits, buckets := l.limitedBuckets(user, notificationType, now)
+	for i, b := range buckets {
+		b.tokens = min(b.tokens+1, float64(limits[i].Burst))
+	}
+}
+
+// prune discards buckets and throttle runs that have been idle long enough that discarding them doesn't change the
+// limiter's behavior. The caller must hold the lock.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < idleBucketTTL {
+		return
+	}
+	l.pruned = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= idleBucketTTL {
+			delete(l.buckets, key)
+		}
+	}
+	for key, run := range l.runs {
+		if now.Sub(run.started) >= idleBucketTTL {
+			delete(l.runs, key)
+		}
+	}
+}
+
+// RecordThrottled records the action taken for a notification that exceeded a rate limit.
+func (l *Limiter) RecordThrottled(ctx context.Context, notificationType, action string) {
+	l.mutex.Lock()
+	if l.throttled[notificationType] == nil {
+		l.throttled[notificationType] = make(map[string]int64)
+	}
+	l.throttled[notificationType][action]++
+	l.mutex.Unlock()
+
+	if l.counter != nil {
+		l.counter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("notification_type", notificationType),
+			attribute.String("action", action),
+		))
+	}
+}
+
+// ThrottledCount is the number of notifications of one type for which an overflow action was taken.
+type ThrottledCount struct {
+	NotificationType string `json:"notification_type"`
+	Action           string `json:"action"`
+	Count            int64  `json:"count"`
+}
+
+// Throttled returns the number of throttled notifications recorded by this limiter since it was created, sorted by
+// notification type and action.
+func (l *Limiter) Throttled() []*ThrottledCount {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	counts := make([]*ThrottledCount, 0)
+	for notificationType, actions := range l.throttled {
+		for action, count := range actions {
+			counts = append(counts, &ThrottledCount{NotificationType: notificationType, Action: action, Count: count})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].NotificationType != counts[j].NotificationType {
+			return counts[i].NotificationType < counts[j].NotificationType
+		}
+		return counts[i].Action < counts[j].Action
+	})
+
+	return counts
+}
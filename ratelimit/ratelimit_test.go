@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOverflow(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, overflow := range []string{OverflowCollapse, OverflowDrop, OverflowDefer} {
+		actual, err := ParseOverflow(overflow)
+		assert.NoError(err)
+		assert.Equal(overflow, actual)
+	}
+	_, err := ParseOverflow("explode")
+	assert.Error(err)
+}
+
+func TestAllow(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	limiter := New(Settings{
+		User: Limit{Rate: 1, Burst: 3},
+		Type: Limit{Rate: 0.5, Burst: 2},
+	})
+
+	// The type limit should be reached first.
+	assert.True(limiter.Allow("ipcdev", "analysis", now).Allowed)
+	assert.True(limiter.Allow("ipcdev", "analysis", now).Allowed)
+	decision := limiter.Allow("ipcdev", "analysis", now)
+	assert.False(decision.Allowed)
+	assert.Equal(now.Add(2*time.Second), decision.RetryAt)
+	assert.Equal(1, decision.Throttled)
+	assert.Equal(now, decision.Since)
+
+	// Other notification types should still be allowed until the user limit is reached.
+	assert.True(limiter.Allow("ipcdev", "data", now).Allowed)
+	assert.False(limiter.Allow("ipcdev", "data", now).Allowed)
+
+	// Other users shouldn't be affected.
+	assert.True(limiter.Allow("ipctest", "analysis", now).Allowed)
+
+	// Consecutive throttled notifications should be counted.
+	decision = limiter.Allow("ipcdev", "analysis", now.Add(time.Second))
+	assert.False(decision.Allowed)
+	assert.Equal(2, decision.Throttled)
+	assert.Equal(now, decision.Since)
+
+	// The count should be reset once a notification is allowed.
+	assert.True(limiter.Allow("ipcdev", "analysis", now.Add(2*time.Second)).Allowed)
+	decision = limiter.Allow("ipcdev", "analysis", now.Add(2*time.Second))
+	assert.False(decision.Allowed)
+	assert.Equal(1, decision.Throttled)
+}
+
+func TestAllowWithoutLimits(t *testing.T) {
+	limiter := New(Settings{})
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.True(t, limiter.Allow("ipcdev", "analysis", now).Allowed)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	limiter := New(Settings{User: Limit{Rate: 0.1, Burst: 2}})
+
+	// Each reservation after the burst should be ten seconds after the previous one.
+	assert.Equal(now, limiter.Reserve("ipcdev", "analysis", now))
+	assert.Equal(now, limiter.Reserve("ipcdev", "analysis", now))
+	assert.Equal(now.Add(10*time.Second), limiter.Reserve("ipcdev", "analysis", now))
+	assert.Equal(now.Add(20*time.Second), limiter.Reserve("ipcdev", "analysis", now))
+}
+
+func TestRefund(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	limiter := New(Settings{User: Limit{Rate: 0.1, Burst: 1}, Type: Limit{Rate: 0.1, Burst: 1}})
+
+	// A refunded token should allow another notification.
+	assert.True(limiter.Allow("ipcdev", "analysis", now).Allowed)
+	assert.False(limiter.Allow("ipcdev", "analysis", now).Allowed)
+	limiter.Refund("ipcdev", "analysis", now)
+	assert.True(limiter.Allow("ipcdev", "analysis", now).Allowed)
+
+	// Refunds should never raise a bucket above its burst.
+	limiter.Refund("ipcdev", "analysis", now)
+	limiter.Refund("ipcdev", "analysis", now)
+	assert.True(limiter.Allow("ipcdev", "analysis", now).Allowed)
+	assert.False(limiter.Allow("ipcdev", "analysis", now).Allowed)
+}
+
+func TestPrune(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	limiter := New(Settings{User: Limit{Rate: 1, Burst: 1}})
+
+	limiter.Allow("ipcdev", "analysis", now)
+	limiter.Allow("ipcdev", "analysis", now)
+	assert.Len(limiter.buckets, 1)
+	assert.Len(limiter.runs, 1)
+
+	// Idle buckets and throttle runs should be discarded.
+	limiter.Allow("ipctest", "analysis", now.Add(2*idleBucketTTL))
+	assert.Len(limiter.buckets, 1)
+	assert.Empty(limiter.runs)
+}
+
+func TestThrottled(t *testing.T) {
+	limiter := New(Settings{Overflow: OverflowDrop})
+	ctx := context.Background()
+	limiter.RecordThrottled(ctx, "data", OverflowDrop)
+	limiter.RecordThrottled(ctx, "analysis", OverflowDrop)
+	limiter.RecordThrottled(ctx, "analysis", OverflowDrop)
+
+	expected := []*ThrottledCount{
+		{NotificationType: "analysis", Action: OverflowDrop, Count: 2},
+		{NotificationType: "data", Action: OverflowDrop, Count: 1},
+	}
+	assert.Equal(t, expected, limiter.Throttled())
+}
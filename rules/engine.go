@@ -0,0 +1,94 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "rules"})
+
+// Engine applies the rules defined in a file, reloading them whenever the file changes.
+type Engine struct {
+	path    string
+	mutex   sync.RWMutex
+	ruleSet *RuleSet
+	modTime time.Time
+}
+
+// NewEngine loads the rules from a file and returns an engine that applies them. An error is returned if the
+// rules can't be loaded.
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	_, err := e.reload()
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// NewStaticEngine returns an engine that applies a fixed rule set.
+func NewStaticEngine(ruleSet *RuleSet) *Engine {
+	return &Engine{ruleSet: ruleSet}
+}
+
+// reload loads the rules if the file has been modified since they were last loaded. It returns true if the rules
+// were reloaded. The current rules are retained if the new rules can't be loaded.
+func (e *Engine) reload() (bool, error) {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return false, err
+	}
+
+	// Don't reload the rules if the file hasn't changed.
+	e.mutex.RLock()
+	unchanged := e.ruleSet != nil && info.ModTime().Equal(e.modTime)
+	e.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	// Load the new rules.
+	ruleSet, err := Load(e.path)
+	if err != nil {
+		return false, err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.ruleSet = ruleSet
+	e.modTime = info.ModTime()
+
+	return true, nil
+}
+
+// Watch checks the rules file for changes at the given interval until the context is canceled.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := e.reload()
+			if err != nil {
+				log.Errorf("keeping the current rules: %s", err.Error())
+			} else if reloaded {
+				log.Infof("reloaded the rules from %s", e.path)
+			}
+		}
+	}
+}
+
+// Apply applies the current rules to an event whose body is encoded as JSON. See RuleSet.ApplyToBody.
+func (e *Engine) Apply(routingKey, updateType string, body []byte) ([]byte, *Result, error) {
+	e.mutex.RLock()
+	ruleSet := e.ruleSet
+	e.mutex.RUnlock()
+	return ruleSet.ApplyToBody(routingKey, updateType, body)
+}
@@ -0,0 +1,312 @@
+// Package rules provides declarative rules that route and transform incoming events before they're dispatched
+// to a message handler. Rules are defined in a YAML file:
+//
+//	rules:
+//	  - name: quiet-running-analyses
+//	    match:
+//	      update_type: analysis
+//	      payload:
+//	        analysisstatus: Running
+//	    actions:
+//	      email: false
+//	      tags: [in-progress]
+//	  - name: drop-test-events
+//	    match:
+//	      routing_key: events.test.#
+//	    actions:
+//	      drop: true
+//
+// Every rule that matches an event is applied, in the order in which the rules are defined, until a rule drops the
+// event.
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Match describes the events that a rule applies to. Every condition that is specified must be satisfied. The
+// routing key is matched using AMQP topic patterns, in which `*` matches exactly one word and `#` matches zero or
+// more words. The user is compared with the `user` field and each entry in the `users` field of the event body;
+// group recipients aren't expanded until after the rules are applied, so they're never matched. Payload fields are
+// identified by dot-separated paths and compared as strings.
+type Match struct {
+	RoutingKey string                 `yaml:"routing_key"`
+	UpdateType string                 `yaml:"update_type"`
+	User       string                 `yaml:"user"`
+	Payload    map[string]interface{} `yaml:"payload"`
+}
+
+// Actions describes the changes that a rule makes to the events that it matches. The subject may be a Go template,
+// which is executed with the event body as its data.
+type Actions struct {
+	Drop          bool     `yaml:"drop"`
+	Subject       string   `yaml:"subject"`
+	Email         *bool    `yaml:"email"`
+	EmailTemplate string   `yaml:"email_template"`
+	Priority      string   `yaml:"priority"`
+	Tags          []string `yaml:"tags"`
+}
+
+// Rule is a single named rule.
+type Rule struct {
+	Name    string  `yaml:"name"`
+	Match   Match   `yaml:"match"`
+	Actions Actions `yaml:"actions"`
+
+	subject *template.Template
+}
+
+// RuleSet is an ordered list of rules.
+type RuleSet struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Event represents an incoming event that rules are evaluated against.
+type Event struct {
+	RoutingKey string
+	UpdateType string
+	Body       map[string]interface{}
+}
+
+// Result describes the outcome of applying a rule set to an event.
+type Result struct {
+	Drop    bool
+	Changed bool
+	Matched []string
+}
+
+// Parse parses and validates a rule set.
+func Parse(contents []byte) (*RuleSet, error) {
+	var ruleSet RuleSet
+	err := yaml.Unmarshal(contents, &ruleSet)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate the rules and compile the subject templates.
+	names := make(map[string]bool, len(ruleSet.Rules))
+	for i, rule := range ruleSet.Rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rule %d is empty", i)
+		}
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule name: %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Actions.Subject != "" {
+			rule.subject, err = template.New(rule.Name).Option("missingkey=zero").Parse(rule.Actions.Subject)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid subject template in rule %s", rule.Name)
+			}
+		}
+	}
+
+	return &ruleSet, nil
+}
+
+// Load loads a rule set from a YAML file.
+func Load(path string) (*RuleSet, error) {
+	wrapMsg := fmt.Sprintf("unable to load rules from %s", path)
+
+	// Read the file.
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Parse the file contents.
+	ruleSet, err := Parse(contents)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	return ruleSet, nil
+}
+
+// matches returns true if the rule applies to an event.
+func (r *Rule) matches(event *Event) bool {
+	m := r.Match
+	if m.RoutingKey != "" && !topicMatches(m.RoutingKey, event.RoutingKey) {
+		return false
+	}
+	if m.UpdateType != "" && !strings.EqualFold(m.UpdateType, event.UpdateType) {
+		return false
+	}
+	if m.User != "" && !addressedTo(event.Body, m.User) {
+		return false
+	}
+	for path, expected := range m.Payload {
+		actual, ok := lookup(event.Body["payload"], path)
+		if !ok || stringValue(actual) != stringValue(expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply applies the rule's actions to an event.
+func (r *Rule) apply(event *Event) error {
+	a := r.Actions
+	if r.subject != nil {
+		var subject bytes.Buffer
+		err := r.subject.Execute(&subject, event.Body)
+		if err != nil {
+			return errors.Wrapf(err, "unable to rewrite the subject using rule %s", r.Name)
+		}
+		event.Body["subject"] = subject.String()
+	}
+	if a.Email != nil {
+		event.Body["email"] = *a.Email
+	}
+	if a.EmailTemplate != "" {
+		event.Body["email_template"] = a.EmailTemplate
+	}
+	if a.Priority != "" {
+		event.Body["priority"] = a.Priority
+	}
+	if len(a.Tags) > 0 {
+		event.Body["tags"] = addTags(event.Body["tags"], a.Tags)
+	}
+	return nil
+}
+
+// Apply applies every matching rule to an event, stopping if a rule drops the event.
+func (rs *RuleSet) Apply(event *Event) (*Result, error) {
+	result := &Result{}
+	for _, rule := range rs.Rules {
+		if !rule.matches(event) {
+			continue
+		}
+		result.Matched = append(result.Matched, rule.Name)
+		if rule.Actions.Drop {
+			result.Drop = true
+			return result, nil
+		}
+		err := rule.apply(event)
+		if err != nil {
+			return nil, err
+		}
+		result.Changed = true
+	}
+	return result, nil
+}
+
+// ApplyToBody applies the rule set to an event whose body is encoded as JSON. The body is returned unchanged if it
+// isn't a JSON object so that the message handler can report the problem.
+func (rs *RuleSet) ApplyToBody(routingKey, updateType string, body []byte) ([]byte, *Result, error) {
+	event := &Event{RoutingKey: routingKey, UpdateType: updateType}
+
+	// Decode numbers as json.Number so that large integers survive being encoded again.
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if decoder.Decode(&event.Body) != nil || decoder.More() || event.Body == nil {
+		return body, &Result{}, nil
+	}
+
+	// Apply the rules.
+	result, err := rs.Apply(event)
+	if err != nil || !result.Changed || result.Drop {
+		return body, result, err
+	}
+
+	// Encode the modified event body.
+	newBody, err := json.Marshal(event.Body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to encode the modified event body")
+	}
+
+	return newBody, result, nil
+}
+
+// topicMatches returns true if a routing key matches an AMQP topic pattern.
+func topicMatches(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+// matchWords matches the words in a routing key against the words in a topic pattern.
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
+
+// addressedTo returns true if an event body lists a user as one of its recipients.
+func addressedTo(body map[string]interface{}, user string) bool {
+	if stringValue(body["user"]) == user {
+		return true
+	}
+	if users, ok := body["users"].([]interface{}); ok {
+		for _, recipient := range users {
+			if stringValue(recipient) == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup finds the value at a dot-separated path within a nested map.
+func lookup(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// stringValue converts a value to a string for comparison.
+func stringValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// addTags adds tags to an existing list of tags, omitting duplicates.
+func addTags(existing interface{}, tags []string) []interface{} {
+	result := make([]interface{}, 0)
+	seen := make(map[string]bool)
+	if list, ok := existing.([]interface{}); ok {
+		for _, tag := range list {
+			seen[stringValue(tag)] = true
+			result = append(result, tag)
+		}
+	}
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result
+}
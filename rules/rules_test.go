@@ -0,0 +1,177 @@
+package rules
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `
+rules:
+  - name: quiet-running-analyses
+    match:
+      update_type: analysis
+      payload:
+        analysisstatus: Running
+    actions:
+      email: false
+      subject: "{{.subject}} (in progress)"
+      tags: [in-progress]
+  - name: tag-admins
+    match:
+      user: ipcdev
+    actions:
+      priority: high
+      tags: [admin, in-progress]
+  - name: drop-test-events
+    match:
+      routing_key: events.test.#
+    actions:
+      drop: true
+`
+
+// testBody returns an event body for testing.
+func testBody(user, status string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"user":    user,
+		"subject": "analysis status changed",
+		"email":   true,
+		"payload": map[string]interface{}{"analysisstatus": status},
+	})
+	return body
+}
+
+func TestTopicMatches(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(topicMatches("events.*.update.*", "events.notification.update.analysis"))
+	assert.False(topicMatches("events.*.update.*", "events.notification.update"))
+	assert.True(topicMatches("events.#", "events"))
+	assert.True(topicMatches("events.#.analysis", "events.notification.update.analysis"))
+	assert.False(topicMatches("events.#.analysis", "events.notification.update.data"))
+	assert.True(topicMatches("#", "anything.at.all"))
+}
+
+func TestParseValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	// Rules must be named uniquely and templates must be valid.
+	_, err := Parse([]byte("rules:\n  - match: {user: ipcdev}\n"))
+	assert.Error(err)
+	_, err = Parse([]byte("rules:\n  - name: a\n  - name: a\n"))
+	assert.Error(err)
+	_, err = Parse([]byte("rules:\n  - name: a\n    actions: {subject: '{{.subject'}\n"))
+	assert.Error(err)
+}
+
+func TestApplyToBody(t *testing.T) {
+	assert := assert.New(t)
+
+	ruleSet, err := Parse([]byte(testRules))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Both of the first two rules should apply.
+	body, result, err := ruleSet.ApplyToBody("events.notification.update.analysis", "analysis", testBody("ipcdev", "Running"))
+	assert.NoError(err)
+	assert.False(result.Drop)
+	assert.Equal([]string{"quiet-running-analyses", "tag-admins"}, result.Matched)
+	var event map[string]interface{}
+	assert.NoError(json.Unmarshal(body, &event))
+	assert.Equal("analysis status changed (in progress)", event["subject"])
+	assert.Equal(false, event["email"])
+	assert.Equal("high", event["priority"])
+	assert.Equal([]interface{}{"in-progress", "admin"}, event["tags"])
+
+	// Bodies that don't match any rules should be returned unchanged.
+	original := testBody("sarahr", "Completed")
+	body, result, err = ruleSet.ApplyToBody("events.notification.update.analysis", "analysis", original)
+	assert.NoError(err)
+	assert.Empty(result.Matched)
+	assert.Equal(original, body)
+
+	// Events can be dropped.
+	_, result, err = ruleSet.ApplyToBody("events.test.update.analysis", "analysis", testBody("sarahr", "Completed"))
+	assert.NoError(err)
+	assert.True(result.Drop)
+
+	// Bodies that can't be parsed should be returned unchanged.
+	body, _, err = ruleSet.ApplyToBody("events.test.update.analysis", "analysis", []byte("not json"))
+	assert.NoError(err)
+	assert.Equal("not json", string(body))
+}
+
+func TestApplyToBodyLargeIntegers(t *testing.T) {
+	assert := assert.New(t)
+
+	ruleSet, err := Parse([]byte(testRules))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Large integers in the body must be preserved exactly when the body is encoded again.
+	original := `{"user":"ipcdev","subject":"s","payload":{"analysisstatus":"Running","size":9007199254740993}}`
+	body, result, err := ruleSet.ApplyToBody("events.notification.update.analysis", "analysis", []byte(original))
+	assert.NoError(err)
+	assert.True(result.Changed)
+	assert.Contains(string(body), `"size":9007199254740993`)
+}
+
+func TestApplyToBodyUsers(t *testing.T) {
+	assert := assert.New(t)
+
+	ruleSet, err := Parse([]byte(testRules))
+	if !assert.NoError(err) {
+		return
+	}
+
+	// Rules that match a user should also match events addressed to several users.
+	original := `{"users":["sarahr","ipcdev"],"subject":"s","payload":{"analysisstatus":"Completed"}}`
+	_, result, err := ruleSet.ApplyToBody("events.notification.update.analysis", "analysis", []byte(original))
+	assert.NoError(err)
+	assert.Equal([]string{"tag-admins"}, result.Matched)
+
+	// Rules that match a user shouldn't match events addressed to other users.
+	original = `{"users":["sarahr"],"subject":"s","payload":{"analysisstatus":"Completed"}}`
+	_, result, err = ruleSet.ApplyToBody("events.notification.update.analysis", "analysis", []byte(original))
+	assert.NoError(err)
+	assert.Empty(result.Matched)
+}
+
+func TestEngineReload(t *testing.T) {
+	assert := assert.New(t)
+
+	// Create the rules file.
+	path := filepath.Join(t.TempDir(), "rules.yml")
+	assert.NoError(os.WriteFile(path, []byte(testRules), 0644))
+	engine, err := NewEngine(path)
+	if !assert.NoError(err) {
+		return
+	}
+	_, result, err := engine.Apply("events.test.update.foo", "foo", []byte("{}"))
+	assert.NoError(err)
+	assert.True(result.Drop)
+
+	// Replace the rules and make sure that the new rules are loaded.
+	assert.NoError(os.WriteFile(path, []byte("rules: []\n"), 0644))
+	assert.NoError(os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	reloaded, err := engine.reload()
+	assert.NoError(err)
+	assert.True(reloaded)
+	_, result, err = engine.Apply("events.test.update.foo", "foo", []byte("{}"))
+	assert.NoError(err)
+	assert.False(result.Drop)
+
+	// Invalid rules should be rejected, and the current rules should be retained.
+	assert.NoError(os.WriteFile(path, []byte("rules: [{}]\n"), 0644))
+	assert.NoError(os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	_, err = engine.reload()
+	assert.Error(err)
+	_, result, err = engine.Apply("events.test.update.foo", "foo", []byte("{}"))
+	assert.NoError(err)
+	assert.False(result.Drop)
+}
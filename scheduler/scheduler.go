@@ -0,0 +1,256 @@
+// Package scheduler publishes notifications whose delivery was deferred until a later time. Only one replica
+// of the service should run the scheduler at any given time; see the leader package.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "scheduler"})
+
+// Settings represents the settings that determine how often the scheduler looks for due deliveries and how
+// many deliveries are published in each batch.
+type Settings struct {
+	PollInterval time.Duration
+	BatchSize    uint64
+}
+
+// Scheduler periodically publishes pending deliveries that have become due.
+type Scheduler struct {
+	db              *sql.DB
+	messagingClient handlers.MessagingClient
+	settings        *Settings
+}
+
+// New returns a new scheduler.
+func New(db *sql.DB, messagingClient handlers.MessagingClient, settings *Settings) *Scheduler {
+	return &Scheduler{
+		db:              db,
+		messagingClient: messagingClient,
+		settings:        settings,
+	}
+}
+
+// Run publishes due deliveries every poll interval until the context is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.settings.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverAllDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverAllDue publishes batches of deliveries that are due at the given time until none remain.
+func (s *Scheduler) deliverAllDue(ctx context.Context, at time.Time) {
+	for ctx.Err() == nil {
+		count, err := s.deliverDue(ctx, at)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if count < s.settings.BatchSize {
+			return
+		}
+	}
+}
+
+// deliverDue publishes up to one batch of deliveries that are due at the given time and returns the number of
+// deliveries that were processed. Each delivery is claimed and published in its own transaction, so a failure only
+// affects the delivery that failed. Processing stops at the first delivery that can't be published so that it can
+// be retried during the next poll.
+func (s *Scheduler) deliverDue(ctx context.Context, at time.Time) (uint64, error) {
+	var count uint64
+	for count < s.settings.BatchSize {
+		found, err := s.deliverNext(ctx, at)
+		if err != nil {
+			return count, errors.Wrap(err, "unable to publish scheduled deliveries")
+		}
+		if !found {
+			break
+		}
+		count++
+	}
+	return count, nil
+}
+
+// deliverNext claims and publishes the next delivery that is due at the given time, and reports whether a due
+// delivery was found. The delivery is marked as delivered in the same transaction that claims it, so it may be
+// published more than once if the transaction can't be committed, but it will never be lost. A delivery whose
+// stored messages can't be decoded will never succeed, so it's marked as failed instead.
+func (s *Scheduler) deliverNext(ctx context.Context, at time.Time) (bool, error) {
+	// Begin a database transaction.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Claim the next delivery that is due.
+	deliveries, err := db.ClaimDuePendingDeliveries(ctx, tx, at, 1)
+	if err != nil {
+		return false, err
+	}
+	if len(deliveries) == 0 {
+		return false, nil
+	}
+	delivery := deliveries[0]
+
+	// Decode the stored messages and publish them, or mark the delivery as failed if they can't be decoded.
+	var directEmail *handlers.DirectEmail
+	notificationMessage, emailRequest, err := decodeDelivery(delivery)
+	if err != nil {
+		log.Errorf("marking pending delivery %s as failed: %s", delivery.ID, err.Error())
+		err = db.SetPendingDeliveryStatus(ctx, tx, delivery.ID, common.PendingDeliveryStatusFailed)
+	} else {
+		directEmail, err = s.deliver(ctx, tx, delivery, notificationMessage, emailRequest)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Commit the transaction.
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	// Send the email message now that the delivery has been committed if email is sent directly.
+	if directEmail != nil {
+		s.sendDirectEmail(ctx, directEmail)
+	}
+
+	return true, nil
+}
+
+// sendDirectEmail sends an email message whose delivery was recorded in a committed transaction and records the
+// outcome of the attempt.
+func (s *Scheduler) sendDirectEmail(ctx context.Context, directEmail *handlers.DirectEmail) {
+	status, detail := directEmail.Send(ctx, s.messagingClient)
+
+	// Record the outcome.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err == nil {
+		defer func() { _ = tx.Rollback() }()
+		_, _, err = db.UpdateEmailDeliveryStatus(ctx, tx, directEmail.Delivery.ID, status, detail)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Errorf("unable to record the status of email delivery %s: %s", directEmail.Delivery.ID, err.Error())
+	}
+}
+
+// decodeDelivery decodes the notification message and email request that were saved when a notification was
+// scheduled. The email request is nil if the notification shouldn't be emailed.
+func decodeDelivery(
+	delivery *common.PendingDelivery,
+) (*messaging.NotificationMessage, *messaging.EmailRequest, error) {
+	var notificationMessage messaging.NotificationMessage
+	err := json.Unmarshal([]byte(delivery.OutgoingMessage), &notificationMessage)
+	if err != nil {
+		return nil, nil, errors.Wrapf(
+			err, "unable to parse the outgoing message for notification %s", delivery.NotificationID,
+		)
+	}
+
+	if delivery.EmailRequest == "" {
+		return &notificationMessage, nil, nil
+	}
+	var emailRequest messaging.EmailRequest
+	err = json.Unmarshal([]byte(delivery.EmailRequest), &emailRequest)
+	if err != nil {
+		return nil, nil, errors.Wrapf(
+			err, "unable to parse the email request for notification %s", delivery.NotificationID,
+		)
+	}
+
+	return &notificationMessage, &emailRequest, nil
+}
+
+// deliverEmailRequest publishes the email request for a scheduled notification and records the attempt. Email isn't
+// sent to addresses that have been suppressed because of repeated hard bounces. If email is sent directly, the
+// message that should be sent once the transaction has been committed is returned.
+func (s *Scheduler) deliverEmailRequest(
+	ctx context.Context,
+	tx *sql.Tx,
+	notificationID string,
+	emailRequest *messaging.EmailRequest,
+) (*handlers.DirectEmail, error) {
+	suppressed, err := db.EmailSuppressed(ctx, tx, emailRequest.ToAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the email request unless the address has been suppressed.
+	var emailDelivery *common.EmailDelivery
+	var directEmail *handlers.DirectEmail
+	if suppressed {
+		emailDelivery = handlers.SuppressedEmailDelivery(notificationID, emailRequest)
+	} else {
+		emailDelivery, directEmail, err = handlers.DeliverEmailRequest(ctx, s.messagingClient, notificationID, emailRequest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return directEmail, db.SaveEmailDelivery(ctx, tx, emailDelivery)
+}
+
+// deliver marks a single delivery as delivered and publishes its notification message and email request. If email is
+// sent directly, the message that should be sent once the transaction has been committed is returned.
+func (s *Scheduler) deliver(
+	ctx context.Context,
+	tx *sql.Tx,
+	delivery *common.PendingDelivery,
+	notificationMessage *messaging.NotificationMessage,
+	emailRequest *messaging.EmailRequest,
+) (*handlers.DirectEmail, error) {
+	var err error
+
+	// Mark the delivery as delivered so that the notification is included in the unread count.
+	err = db.SetPendingDeliveryStatus(ctx, tx, delivery.ID, common.PendingDeliveryStatusDelivered)
+	if err != nil {
+		return nil, err
+	}
+
+	// Publish the email request if there is one.
+	var directEmail *handlers.DirectEmail
+	if emailRequest != nil {
+		directEmail, err = s.deliverEmailRequest(ctx, tx, delivery.NotificationID, emailRequest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Count the number of unread notifications.
+	unreadNotificationCount, err := db.CountUnreadNotifications(ctx, tx, delivery.User)
+	if err != nil {
+		return nil, err
+	}
+
+	// Publish the notification message.
+	wrappedNotificationMessage := &messaging.WrappedNotificationMessage{
+		Message: notificationMessage,
+		Total:   unreadNotificationCount,
+	}
+	return directEmail, s.messagingClient.PublishNotificationMessageContext(ctx, wrappedNotificationMessage)
+}
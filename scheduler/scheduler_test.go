@@ -0,0 +1,266 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockMessagingClient records the messages that were published.
+type mockMessagingClient struct {
+	emailRequests        []*messaging.EmailRequest
+	notificationMessages []*messaging.WrappedNotificationMessage
+}
+
+// PublishEmailRequestContext records the email request.
+func (c *mockMessagingClient) PublishEmailRequestContext(_ context.Context, r *messaging.EmailRequest) error {
+	c.emailRequests = append(c.emailRequests, r)
+	return nil
+}
+
+// PublishNotificationMessageContext records the notification message.
+func (c *mockMessagingClient) PublishNotificationMessageContext(
+	_ context.Context,
+	m *messaging.WrappedNotificationMessage,
+) error {
+	c.notificationMessages = append(c.notificationMessages, m)
+	return nil
+}
+
+// deliveryRows returns an empty set of rows in the format returned by the query for due deliveries.
+func deliveryRows() *sqlmock.Rows {
+	return sqlmock.NewRows(
+		[]string{"id", "schedule_id", "notification_id", "username", "deliver_at", "status", "email", "outgoing"},
+	)
+}
+
+// failingMessagingClient records notification messages until a given number have been published and fails after
+// that.
+type failingMessagingClient struct {
+	mockMessagingClient
+	failAfter int
+}
+
+// PublishNotificationMessageContext records the notification message or fails.
+func (c *failingMessagingClient) PublishNotificationMessageContext(
+	ctx context.Context,
+	m *messaging.WrappedNotificationMessage,
+) error {
+	if len(c.notificationMessages) >= c.failAfter {
+		return errors.New("connection closed")
+	}
+	return c.mockMessagingClient.PublishNotificationMessageContext(ctx, m)
+}
+
+func TestDeliverDue(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	now := time.Now()
+	rows := deliveryRows().AddRow(
+		"p1", "s1", "n1", "ipcdev", now, common.PendingDeliveryStatusPending,
+		`{"to": "ipcdev@example.org", "template": "analysis_status_change"}`,
+		`{"type": "analysis", "message": {"id": "n1"}}`,
+	)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM pending_deliveries p").WillReturnRows(rows)
+	mock.ExpectExec("UPDATE pending_deliveries SET status").
+		WithArgs(common.PendingDeliveryStatusDelivered, "p1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM email_suppressions").
+		WithArgs("ipcdev@example.org").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO email_deliveries").
+		WithArgs(sqlmock.AnyArg(), "n1", "ipcdev@example.org", "analysis_status_change", common.EmailStatusQueued, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"time_created", "time_updated"}).AddRow(now, now))
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM pending_deliveries p").WillReturnRows(deliveryRows())
+	mock.ExpectRollback()
+
+	// Publish the due deliveries.
+	mc := &mockMessagingClient{}
+	s := New(db, mc, &Settings{PollInterval: time.Minute, BatchSize: 10})
+	count, err := s.deliverDue(context.Background(), now)
+	assert.NoError(err, "unexpected error occurred while publishing deliveries")
+	assert.Equal(uint64(1), count)
+
+	// Verify that the messages were published.
+	if assert.Len(mc.emailRequests, 1) {
+		assert.Equal("ipcdev@example.org", mc.emailRequests[0].ToAddress)
+		assert.NotEmpty(mc.emailRequests[0].TemplateValues["email_delivery_id"])
+	}
+	if assert.Len(mc.notificationMessages, 1) {
+		assert.Equal(int64(4), mc.notificationMessages[0].Total)
+		assert.Equal("n1", mc.notificationMessages[0].Message.Message["id"])
+	}
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestDeliverDueMarksUndecodableDeliveriesAsFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The first delivery can't be decoded, so it should be marked as failed in its own transaction.
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM pending_deliveries p").
+		WillReturnRows(deliveryRows().AddRow("p1", "s1", "n1", "ipcdev", now, common.PendingDeliveryStatusPending, "", "{"))
+	mock.ExpectExec("UPDATE pending_deliveries SET status").
+		WithArgs(common.PendingDeliveryStatusFailed, "p1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// The second delivery should still be published.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM pending_deliveries p").
+		WillReturnRows(deliveryRows().AddRow(
+			"p2", "s1", "n2", "ipcdev", now, common.PendingDeliveryStatusPending, "",
+			`{"type": "analysis", "message": {"id": "n2"}}`,
+		))
+	mock.ExpectExec("UPDATE pending_deliveries SET status").
+		WithArgs(common.PendingDeliveryStatusDelivered, "p2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+
+	// Process the due deliveries.
+	mc := &mockMessagingClient{}
+	s := New(db, mc, &Settings{PollInterval: time.Minute, BatchSize: 2})
+	count, err := s.deliverDue(context.Background(), now)
+	assert.NoError(err, "unexpected error occurred while publishing deliveries")
+	assert.Equal(uint64(2), count)
+
+	// Only the second notification should have been published.
+	assert.Empty(mc.emailRequests)
+	if assert.Len(mc.notificationMessages, 1) {
+		assert.Equal("n2", mc.notificationMessages[0].Message.Message["id"])
+	}
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+func TestDeliverDueStopsAtPublishingFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The first delivery is published and committed on its own.
+	now := time.Now()
+	for _, id := range []string{"p1", "p2"} {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT .* FROM pending_deliveries p").
+			WillReturnRows(deliveryRows().AddRow(
+				id, "s1", "n1", "ipcdev", now, common.PendingDeliveryStatusPending, "",
+				`{"type": "analysis", "message": {"id": "n1"}}`,
+			))
+		mock.ExpectExec("UPDATE pending_deliveries SET status").
+			WithArgs(common.PendingDeliveryStatusDelivered, id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		if id == "p1" {
+			mock.ExpectCommit()
+		}
+	}
+
+	// The second delivery can't be published, so only its transaction should be rolled back.
+	mock.ExpectRollback()
+
+	mc := &failingMessagingClient{failAfter: 1}
+	s := New(db, mc, &Settings{PollInterval: time.Minute, BatchSize: 10})
+	count, err := s.deliverDue(context.Background(), now)
+	assert.Error(err)
+	assert.Equal(uint64(1), count)
+	assert.Len(mc.notificationMessages, 1)
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
+
+// fakeEmailSender records the email requests that it's asked to send.
+type fakeEmailSender struct {
+	sent []*messaging.EmailRequest
+}
+
+// Send records an email request.
+func (s *fakeEmailSender) Send(_ context.Context, request *messaging.EmailRequest) error {
+	s.sent = append(s.sent, request)
+	return nil
+}
+
+func TestDeliverDueSendsDirectEmailAfterCommit(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// The delivery should be recorded as queued in the transaction that claims it.
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM pending_deliveries p").
+		WillReturnRows(deliveryRows().AddRow(
+			"p1", "s1", "n1", "ipcdev", now, common.PendingDeliveryStatusPending,
+			`{"to": "ipcdev@example.org", "template": "analysis_status_change"}`,
+			`{"type": "analysis", "message": {"id": "n1"}}`,
+		))
+	mock.ExpectExec("UPDATE pending_deliveries SET status").
+		WithArgs(common.PendingDeliveryStatusDelivered, "p1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM email_suppressions").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO email_deliveries").
+		WithArgs(sqlmock.AnyArg(), "n1", "ipcdev@example.org", "analysis_status_change", common.EmailStatusQueued, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"time_created", "time_updated"}).AddRow(now, now))
+	mock.ExpectQuery("SELECT \\(SELECT count\\(\\*\\) FROM notifications n").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+
+	// The message should be sent once the transaction has been committed, and its status recorded afterwards.
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE email_deliveries SET status").
+		WithArgs(common.EmailStatusSent, nil, sqlmock.AnyArg(), common.EmailStatusQueued).
+		WillReturnRows(sqlmock.NewRows([]string{"address"}).AddRow("ipcdev@example.org"))
+	mock.ExpectCommit()
+
+	// Publish the due delivery.
+	sender := &fakeEmailSender{}
+	mc := &mockMessagingClient{}
+	s := New(db, handlers.NewDirectEmailClient(mc, sender), &Settings{PollInterval: time.Minute, BatchSize: 1})
+	count, err := s.deliverDue(context.Background(), now)
+	assert.NoError(err, "unexpected error occurred while publishing deliveries")
+	assert.Equal(uint64(1), count)
+	assert.Len(sender.sent, 1)
+	assert.Empty(mc.emailRequests)
+	assert.Len(mc.notificationMessages, 1)
+
+	// Verify that all mock expectations were met.
+	err = mock.ExpectationsWereMet()
+	assert.NoError(err, "not all mock expectations were met")
+}
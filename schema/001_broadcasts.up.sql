@@ -0,0 +1,21 @@
+-- System-wide broadcast announcements, stored once rather than once per user.
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id uuid NOT NULL DEFAULT uuid_generate_v1() PRIMARY KEY,
+    subject text NOT NULL,
+    message text NOT NULL,
+    created_by text NOT NULL,
+    time_created timestamp with time zone NOT NULL DEFAULT now(),
+    start_time timestamp with time zone NOT NULL DEFAULT now(),
+    end_time timestamp with time zone,
+    deleted boolean NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS broadcasts_visibility_index ON broadcasts (start_time, end_time) WHERE NOT deleted;
+
+-- Records the broadcasts that each user has dismissed.
+CREATE TABLE IF NOT EXISTS broadcast_dismissals (
+    broadcast_id uuid NOT NULL REFERENCES broadcasts(id) ON DELETE CASCADE,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    time_dismissed timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (broadcast_id, user_id)
+);
@@ -0,0 +1,18 @@
+-- Notifications whose UI messages and emails won't be published until a later time.
+CREATE TABLE IF NOT EXISTS pending_deliveries (
+    id uuid NOT NULL DEFAULT uuid_generate_v1() PRIMARY KEY,
+    schedule_id text NOT NULL,
+    notification_id uuid NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    deliver_at timestamp with time zone NOT NULL,
+    email_request json,
+    status text NOT NULL DEFAULT 'pending',
+    time_created timestamp with time zone NOT NULL DEFAULT now(),
+    time_updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS pending_deliveries_due_index
+    ON pending_deliveries (deliver_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS pending_deliveries_notification_index
+    ON pending_deliveries (notification_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS pending_deliveries_schedule_index
+    ON pending_deliveries (schedule_id);
@@ -0,0 +1,5 @@
+-- The time after which a notification is stale and should be deleted automatically.
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS expires_at timestamp with time zone;
+
+CREATE INDEX IF NOT EXISTS notifications_expiry_index
+    ON notifications (expires_at) WHERE expires_at IS NOT NULL AND deleted = false;
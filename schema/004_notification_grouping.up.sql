@@ -0,0 +1,7 @@
+-- Notifications with the same grouping key for the same user form a single evolving notification. Only the most
+-- recent notification in each group is listed; older notifications are retained as the group's history.
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS grouping_key text;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS superseded_by uuid REFERENCES notifications(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS notifications_grouping_index
+    ON notifications (user_id, grouping_key) WHERE grouping_key IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Notifications with the same thread ID for the same user belong to a single conversation thread.
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS thread_id text;
+
+CREATE INDEX IF NOT EXISTS notifications_thread_index
+    ON notifications (user_id, thread_id) WHERE thread_id IS NOT NULL;
@@ -0,0 +1,11 @@
+-- The severity of each notification: info, warning, error or critical.
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS severity text NOT NULL DEFAULT 'info';
+
+-- The notification types for which users have opted out of email. The notification type `*` opts the user out of
+-- email for every notification type.
+CREATE TABLE IF NOT EXISTS email_opt_outs (
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    notification_type text NOT NULL,
+    time_created timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, notification_type)
+);
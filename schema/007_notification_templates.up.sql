@@ -0,0 +1,10 @@
+-- The templates used to render the subject and message text of notifications from the event payload, keyed by
+-- notification type and locale.
+CREATE TABLE IF NOT EXISTS notification_templates (
+    notification_type text NOT NULL,
+    locale text NOT NULL,
+    subject text NOT NULL DEFAULT '',
+    body text NOT NULL DEFAULT '',
+    time_updated timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (notification_type, locale)
+);
@@ -0,0 +1,7 @@
+-- The locale that each user prefers to receive notifications in, such as `es` or `pt-br`. Users without a preferred
+-- locale receive notifications in the locale requested by the event or in English.
+CREATE TABLE IF NOT EXISTS user_locales (
+    user_id uuid PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    locale text NOT NULL,
+    time_updated timestamp with time zone NOT NULL DEFAULT now()
+);
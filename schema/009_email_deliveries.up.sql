@@ -0,0 +1,24 @@
+-- The attempts to send the email for each notification and their outcomes: queued, sent, bounced or failed.
+CREATE TABLE IF NOT EXISTS email_deliveries (
+    id uuid NOT NULL PRIMARY KEY,
+    notification_id uuid NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    address text NOT NULL,
+    template text NOT NULL,
+    status text NOT NULL DEFAULT 'queued',
+    detail text,
+    time_created timestamp with time zone NOT NULL DEFAULT now(),
+    time_updated timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS email_deliveries_notification_index
+    ON email_deliveries (notification_id);
+
+-- The number of hard bounces for each email address. Email is no longer sent to suppressed addresses. Addresses are
+-- stored in lower case.
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    address text NOT NULL PRIMARY KEY,
+    hard_bounces integer NOT NULL DEFAULT 0,
+    suppressed boolean NOT NULL DEFAULT false,
+    last_bounce timestamp with time zone NOT NULL DEFAULT now(),
+    reason text
+);
@@ -0,0 +1,6 @@
+-- The JSON Schemas used to validate the payloads of incoming events, keyed by notification type.
+CREATE TABLE IF NOT EXISTS payload_schemas (
+    notification_type text NOT NULL PRIMARY KEY,
+    schema jsonb NOT NULL,
+    time_updated timestamp with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,22 @@
+-- Metadata describing each notification type. Types that are registered automatically when an event of that type is
+-- first received have no metadata. Default channels are stored as an array of channel names: ui and email.
+ALTER TABLE notification_types ADD COLUMN IF NOT EXISTS display_name text NOT NULL DEFAULT '';
+ALTER TABLE notification_types ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+ALTER TABLE notification_types ADD COLUMN IF NOT EXISTS default_channels text[] NOT NULL DEFAULT '{}';
+ALTER TABLE notification_types ADD COLUMN IF NOT EXISTS default_severity text NOT NULL DEFAULT '';
+ALTER TABLE notification_types ADD COLUMN IF NOT EXISTS retention_days integer NOT NULL DEFAULT 0;
+ALTER TABLE notification_types ADD COLUMN IF NOT EXISTS owner text NOT NULL DEFAULT '';
+ALTER TABLE notification_types ADD COLUMN IF NOT EXISTS time_updated timestamp with time zone;
+
+-- Incoming events that were set aside because their notification type isn't registered.
+CREATE TABLE IF NOT EXISTS quarantined_events (
+    id uuid NOT NULL PRIMARY KEY,
+    notification_type text NOT NULL,
+    routing_key text NOT NULL,
+    body text NOT NULL,
+    reason text NOT NULL,
+    time_received timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS quarantined_events_time_received_index
+    ON quarantined_events (time_received);
@@ -0,0 +1,4 @@
+-- Notifications created from CloudEvents are looked up by the source and ID of the event to detect duplicates.
+CREATE INDEX IF NOT EXISTS notifications_cloudevent_index
+    ON notifications (((incoming_json::jsonb) ->> 'event_source'), ((incoming_json::jsonb) ->> 'event_id'))
+    WHERE (incoming_json::jsonb) ->> 'event_id' IS NOT NULL;
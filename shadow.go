@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/memstore"
+	"github.com/cyverse-de/event-recorder/shadow"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// initShadowMessageHandlers returns the message handlers to use in shadow mode. The handlers store notifications
+// in a scratch database if one is configured or in memory otherwise, and never publish any messages. The returned
+// function releases any resources used by the shadow mode handlers.
+func initShadowMessageHandlers(
+	ctx context.Context,
+	cfg *viper.Viper,
+	primaryDB *sql.DB,
+	legacyOpts []handlers.LegacyOption,
+) (map[string]handlers.MessageHandler, func(), error) {
+	wrapMsg := "unable to initialize the shadow mode message handlers"
+	var cleanupFuncs []func()
+	cleanup := func() {
+		for i := len(cleanupFuncs) - 1; i >= 0; i-- {
+			cleanupFuncs[i]()
+		}
+	}
+
+	// Refuse to consume from the primary queue, which would steal events from the primary instance.
+	primaryQueue := cfg.GetString("event_recorder.shadow.primary_queue")
+	if cfg.GetString("event_recorder.queue.name") == primaryQueue {
+		return nil, nil, errors.Errorf("%s: shadow mode requires a mirrored queue, not %s", wrapMsg, primaryQueue)
+	}
+
+	// Determine where notifications produced in shadow mode should be stored.
+	newStore := func() handlers.DatabaseClient { return memstore.New() }
+	scratchDatabaseURI := cfg.GetString("event_recorder.shadow.scratch_db.uri")
+	if scratchDatabaseURI != "" {
+		scratchDB, err := db.InitDatabase("postgres", scratchDatabaseURI)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, wrapMsg)
+		}
+		cleanupFuncs = append(cleanupFuncs, func() { _ = scratchDB.Close() })
+		scratchClient := handlers.NewDatabaseClient(scratchDB)
+		newStore = func() handlers.DatabaseClient { return scratchClient }
+	}
+
+	// Open the report file.
+	reportFile, err := os.OpenFile(
+		cfg.GetString("event_recorder.shadow.report_path"),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		cleanup()
+		return nil, nil, errors.Wrap(err, wrapMsg)
+	}
+	cleanupFuncs = append(cleanupFuncs, func() { _ = reportFile.Close() })
+
+	// Periodically log a summary of the shadow mode results.
+	reporter := shadow.NewReporter(reportFile)
+	summaryCtx, cancel := context.WithCancel(ctx)
+	go shadow.LogSummaries(summaryCtx, reporter, cfg.GetDuration("event_recorder.shadow.summary_interval"))
+	cleanupFuncs = append(cleanupFuncs, cancel)
+
+	// Create the shadow mode message handlers.
+	settings := &shadow.Settings{
+		LookupAttempts: cfg.GetInt("event_recorder.shadow.lookup_attempts"),
+		LookupDelay:    cfg.GetDuration("event_recorder.shadow.lookup_delay"),
+	}
+	newLegacy := func(dbc handlers.DatabaseClient, mc handlers.MessagingClient) handlers.MessageHandler {
+		return handlers.NewLegacy(dbc, mc, legacyOpts...)
+	}
+	primary := shadow.NewPrimaryDatabase(primaryDB)
+	messageHandlers := map[string]handlers.MessageHandler{
+		"notification": shadow.NewHandler(newLegacy, newStore, primary, reporter, settings),
+	}
+
+	return messageHandlers, cleanup, nil
+}
@@ -0,0 +1,93 @@
+package shadow
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Difference describes a single difference between the outgoing notification stored by the primary
+// instance and the outgoing notification produced in shadow mode.
+type Difference struct {
+	Path    string      `json:"path"`
+	Primary interface{} `json:"primary"`
+	Shadow  interface{} `json:"shadow"`
+}
+
+// ignoredPaths contains the paths that are expected to differ between the primary and shadow instances.
+// The notification ID is assigned by the database, so it will never match.
+var ignoredPaths = map[string]bool{
+	"message.id": true,
+}
+
+// joinPath appends a component to a path in a generic JSON document.
+func joinPath(path, component string) string {
+	if path == "" {
+		return component
+	}
+	return path + "." + component
+}
+
+// sortedKeys returns the union of the keys in two maps in sorted order.
+func sortedKeys(a, b map[string]interface{}) []string {
+	keySet := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keySet[k] = true
+	}
+	for k := range b {
+		keySet[k] = true
+	}
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compare finds the differences between two generic JSON documents, which must have been produced by
+// unmarshaling JSON into an empty interface. The differences are reported at the deepest path at which
+// the documents can be compared structurally.
+func compare(path string, primary, shadow interface{}) []Difference {
+	if ignoredPaths[path] {
+		return nil
+	}
+
+	switch p := primary.(type) {
+	case map[string]interface{}:
+		s, ok := shadow.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var differences []Difference
+		for _, k := range sortedKeys(p, s) {
+			pv, inPrimary := p[k]
+			sv, inShadow := s[k]
+			if !inPrimary || !inShadow {
+				if !ignoredPaths[joinPath(path, k)] {
+					differences = append(differences, Difference{Path: joinPath(path, k), Primary: pv, Shadow: sv})
+				}
+				continue
+			}
+			differences = append(differences, compare(joinPath(path, k), pv, sv)...)
+		}
+		return differences
+
+	case []interface{}:
+		s, ok := shadow.([]interface{})
+		if !ok || len(p) != len(s) {
+			break
+		}
+		var differences []Difference
+		for i := range p {
+			differences = append(differences, compare(joinPath(path, fmt.Sprintf("%d", i)), p[i], s[i])...)
+		}
+		return differences
+	}
+
+	// Anything else can be compared directly.
+	if reflect.DeepEqual(primary, shadow) {
+		return nil
+	}
+	return []Difference{{Path: path, Primary: primary, Shadow: shadow}}
+}
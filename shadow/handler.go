@@ -0,0 +1,253 @@
+// Package shadow implements shadow mode, in which a second instance of the service consumes a mirrored
+// queue and runs the message handlers without publishing anything, comparing the outgoing notifications
+// that it produces with the ones that the primary instance stored in the notifications database.
+package shadow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/cloudevents"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "shadow"})
+
+// HandlerFactory creates the message handler that is being evaluated in shadow mode.
+type HandlerFactory func(handlers.DatabaseClient, handlers.MessagingClient) handlers.MessageHandler
+
+// StoreFactory returns the database client that the handler being evaluated should store notifications
+// in. This can be either a scratch database or an in-memory store.
+type StoreFactory func() handlers.DatabaseClient
+
+// PrimaryLookup looks up outgoing notifications that were stored by the primary instance.
+type PrimaryLookup interface {
+	OutgoingNotificationJSON(ctx context.Context, routingKey, body string) ([]byte, error)
+}
+
+// PrimaryDatabase looks up outgoing notifications in the primary notifications database.
+type PrimaryDatabase struct {
+	db *sql.DB
+}
+
+// NewPrimaryDatabase returns a new PrimaryLookup that uses the primary notifications database.
+func NewPrimaryDatabase(db *sql.DB) *PrimaryDatabase {
+	return &PrimaryDatabase{db: db}
+}
+
+// OutgoingNotificationJSON returns the outgoing notification JSON for the notification that was created from
+// the incoming message with the given routing key and body. A nil slice is returned if no matching notification
+// was found.
+func (p *PrimaryDatabase) OutgoingNotificationJSON(ctx context.Context, routingKey, body string) ([]byte, error) {
+	tx, err := p.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	return db.GetOutgoingNotificationJSON(ctx, tx, routingKey, body)
+}
+
+// Settings represents the settings that control shadow mode.
+type Settings struct {
+	// LookupAttempts is the number of times to look for the notification stored by the primary instance,
+	// which may not have processed the same delivery yet.
+	LookupAttempts int
+
+	// LookupDelay is the amount of time to wait between lookup attempts.
+	LookupDelay time.Duration
+}
+
+// capturingDatabaseClient wraps a database client, capturing the outgoing notification message so that
+// it can be compared with the one stored by the primary instance.
+type capturingDatabaseClient struct {
+	handlers.DatabaseClient
+	outgoing  *messaging.NotificationMessage
+	committed bool
+}
+
+// SaveOutgoingNotification captures the outgoing notification before saving it.
+func (c *capturingDatabaseClient) SaveOutgoingNotification(
+	ctx context.Context,
+	tx *sql.Tx,
+	outgoingNotification *messaging.NotificationMessage,
+) error {
+	c.outgoing = outgoingNotification
+	return c.DatabaseClient.SaveOutgoingNotification(ctx, tx, outgoingNotification)
+}
+
+// Commit records whether or not the transaction was committed successfully.
+func (c *capturingDatabaseClient) Commit(tx *sql.Tx) error {
+	err := c.DatabaseClient.Commit(tx)
+	c.committed = err == nil
+	return err
+}
+
+// Handler is a message handler that runs another message handler in shadow mode.
+type Handler struct {
+	newHandler HandlerFactory
+	newStore   StoreFactory
+	primary    PrimaryLookup
+	reporter   *Reporter
+	settings   *Settings
+}
+
+// NewHandler returns a new shadow mode message handler.
+func NewHandler(
+	newHandler HandlerFactory,
+	newStore StoreFactory,
+	primary PrimaryLookup,
+	reporter *Reporter,
+	settings *Settings,
+) *Handler {
+	return &Handler{
+		newHandler: newHandler,
+		newStore:   newStore,
+		primary:    primary,
+		reporter:   reporter,
+		settings:   settings,
+	}
+}
+
+// lookUpPrimary looks up the outgoing notification stored by the primary instance, retrying if it can't
+// be found yet.
+func (h *Handler) lookUpPrimary(ctx context.Context, delivery amqp.Delivery) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		primaryJSON, err := h.primary.OutgoingNotificationJSON(ctx, delivery.RoutingKey, string(delivery.Body))
+		if err != nil || primaryJSON != nil || attempt >= h.settings.LookupAttempts {
+			return primaryJSON, err
+		}
+
+		// Wait before trying again.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(h.settings.LookupDelay):
+		}
+	}
+}
+
+// toGeneric converts a value to a generic JSON document so that it can be compared with another document.
+func toGeneric(v interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	err = json.Unmarshal(encoded, &generic)
+	return generic, err
+}
+
+// buildEntry compares the outgoing notification produced by the shadow handler with the one stored by
+// the primary instance.
+func buildEntry(
+	delivery amqp.Delivery,
+	produced *messaging.NotificationMessage,
+	handlerErr error,
+	primaryJSON []byte,
+) (*Entry, error) {
+	wrapMsg := "unable to compare outgoing notifications"
+
+	entry := &Entry{
+		Time:       time.Now(),
+		RoutingKey: delivery.RoutingKey,
+		Body:       string(delivery.Body),
+	}
+	if handlerErr != nil {
+		entry.Error = handlerErr.Error()
+	}
+
+	// Handle the cases where at least one of the instances didn't produce an outgoing notification.
+	switch {
+	case produced == nil && primaryJSON == nil:
+		entry.Outcome = OutcomeBothFailed
+		return entry, nil
+	case produced == nil:
+		entry.Outcome = OutcomeShadowFailed
+		return entry, nil
+	case primaryJSON == nil:
+		entry.Outcome = OutcomePrimaryMissing
+		return entry, nil
+	}
+
+	// Compare the outgoing notifications.
+	var primary interface{}
+	err := json.Unmarshal(primaryJSON, &primary)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	shadow, err := toGeneric(produced)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	entry.Differences = compare("", primary, shadow)
+	if len(entry.Differences) == 0 {
+		entry.Outcome = OutcomeMatch
+		entry.Body = ""
+	} else {
+		entry.Outcome = OutcomeMismatch
+	}
+
+	return entry, nil
+}
+
+// DecodeCloudEvent converts a CloudEvent using the handler being evaluated, so that CloudEvents are converted the
+// same way in shadow mode as they are by the primary instance.
+func (h *Handler) DecodeCloudEvent(
+	updateType string,
+	event *cloudevents.Event,
+	delivery amqp.Delivery,
+) ([]byte, error) {
+	decoder, ok := h.newHandler(h.newStore(), &discardingMessagingClient{}).(handlers.CloudEventDecoder)
+	if !ok {
+		return nil, handlers.NewUnrecoverableError("the handler being evaluated doesn't accept CloudEvents")
+	}
+	return decoder.DecodeCloudEvent(updateType, event, delivery)
+}
+
+// HandleMessage runs the handler being evaluated against a single delivery and records the differences
+// between the outgoing notification that it produced and the one stored by the primary instance. Errors
+// returned by the handler being evaluated are recorded rather than returned, so the delivery is always
+// acknowledged unless the primary database can't be queried.
+func (h *Handler) HandleMessage(ctx context.Context, updateType string, delivery amqp.Delivery) error {
+	store := &capturingDatabaseClient{DatabaseClient: h.newStore()}
+	messagingClient := &discardingMessagingClient{}
+
+	// Run the handler being evaluated.
+	handlerErr := h.newHandler(store, messagingClient).HandleMessage(ctx, updateType, delivery)
+	var produced *messaging.NotificationMessage
+	if handlerErr == nil && store.committed {
+		produced = store.outgoing
+	}
+
+	// Look up the outgoing notification stored by the primary instance.
+	primaryJSON, err := h.lookUpPrimary(ctx, delivery)
+	if err != nil {
+		return handlers.NewRecoverableError("unable to look up the primary notification: %s", err.Error())
+	}
+
+	// Compare the outgoing notifications and record the result.
+	entry, err := buildEntry(delivery, produced, handlerErr, primaryJSON)
+	if err != nil {
+		log.Errorf("unable to evaluate the shadow mode result: %s", err.Error())
+		return nil
+	}
+	entry.WouldEmail = messagingClient.emailRequests > 0
+	err = h.reporter.Record(entry)
+	if err != nil {
+		log.Error(err)
+	}
+	if entry.Outcome != OutcomeMatch {
+		log.Warnf("shadow mode outcome for delivery with routing key %s: %s", delivery.RoutingKey, entry.Outcome)
+	}
+
+	return nil
+}
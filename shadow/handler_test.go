@@ -0,0 +1,164 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cyverse-de/event-recorder/handlers"
+	"github.com/cyverse-de/event-recorder/memstore"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePrimary provides canned outgoing notification JSON for testing.
+type fakePrimary struct {
+	outgoingJSON []byte
+	lookups      int
+}
+
+// OutgoingNotificationJSON returns the canned outgoing notification JSON.
+func (p *fakePrimary) OutgoingNotificationJSON(context.Context, string, string) ([]byte, error) {
+	p.lookups++
+	return p.outgoingJSON, nil
+}
+
+// getTestDelivery returns a delivery containing a legacy notification request.
+func getTestDelivery(t *testing.T) amqp.Delivery {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":      "analysis",
+		"user":      "ipcdev",
+		"subject":   "some job status changed",
+		"message":   "This is a test message",
+		"timestamp": "2020-07-07T17:59:59-07:00",
+		"payload": map[string]interface{}{
+			"startdate": "2020-07-07T17:59:59-07:00",
+			"status":    "Completed",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal the notification request: %s", err.Error())
+	}
+	return amqp.Delivery{Body: body, RoutingKey: "events.notification.update.analysis"}
+}
+
+// getPrimaryJSON runs the legacy handler against a delivery to obtain the outgoing JSON that the primary
+// instance would have stored.
+func getPrimaryJSON(t *testing.T, delivery amqp.Delivery) map[string]interface{} {
+	store := memstore.New()
+	handler := handlers.NewLegacy(store, &discardingMessagingClient{})
+	err := handler.HandleMessage(context.Background(), "analysis", delivery)
+	if err != nil {
+		t.Fatalf("unexpected error returned by the legacy handler: %s", err.Error())
+	}
+
+	// Convert the outgoing message to a generic JSON document and replace the ID.
+	generic, err := toGeneric(store.Records()[0].Outgoing)
+	if err != nil {
+		t.Fatalf("unable to convert the outgoing message: %s", err.Error())
+	}
+	document := generic.(map[string]interface{})
+	document["message"].(map[string]interface{})["id"] = "46ae63be-7030-4cdd-8eb9-66aa49fcf38b"
+
+	return document
+}
+
+// runShadowHandler runs a shadow mode handler for a single delivery and returns the report output.
+func runShadowHandler(t *testing.T, primary *fakePrimary, delivery amqp.Delivery) (*Reporter, string) {
+	var out bytes.Buffer
+	reporter := NewReporter(&out)
+	newLegacy := func(dbc handlers.DatabaseClient, mc handlers.MessagingClient) handlers.MessageHandler {
+		return handlers.NewLegacy(dbc, mc)
+	}
+	newStore := func() handlers.DatabaseClient { return memstore.New() }
+	handler := NewHandler(newLegacy, newStore, primary, reporter, &Settings{LookupAttempts: 2})
+
+	err := handler.HandleMessage(context.Background(), "analysis", delivery)
+	assert.NoError(t, err)
+
+	return reporter, out.String()
+}
+
+func TestShadowMatch(t *testing.T) {
+	assert := assert.New(t)
+	delivery := getTestDelivery(t)
+
+	// The primary notification matches what the handler will produce, apart from the ID.
+	primaryJSON, err := json.Marshal(getPrimaryJSON(t, delivery))
+	assert.NoError(err)
+	primary := &fakePrimary{outgoingJSON: primaryJSON}
+
+	// Matches should be counted but not reported.
+	reporter, out := runShadowHandler(t, primary, delivery)
+	assert.Equal(map[string]int64{OutcomeMatch: 1}, reporter.Summary())
+	assert.Empty(out)
+}
+
+func TestShadowMismatch(t *testing.T) {
+	assert := assert.New(t)
+	delivery := getTestDelivery(t)
+
+	// Change the message text in the primary notification.
+	document := getPrimaryJSON(t, delivery)
+	document["message"].(map[string]interface{})["text"] = "something else"
+	primaryJSON, err := json.Marshal(document)
+	assert.NoError(err)
+	primary := &fakePrimary{outgoingJSON: primaryJSON}
+
+	// The mismatch should be reported.
+	reporter, out := runShadowHandler(t, primary, delivery)
+	assert.Equal(map[string]int64{OutcomeMismatch: 1}, reporter.Summary())
+	var entry Entry
+	assert.NoError(json.Unmarshal([]byte(out), &entry))
+	assert.Equal(OutcomeMismatch, entry.Outcome)
+	assert.Equal(string(delivery.Body), entry.Body)
+	assert.Equal(
+		[]Difference{{Path: "message.text", Primary: "something else", Shadow: "This is a test message"}},
+		entry.Differences,
+	)
+}
+
+func TestShadowPrimaryMissing(t *testing.T) {
+	assert := assert.New(t)
+	primary := &fakePrimary{}
+
+	// The handler should retry the lookup before reporting the notification as missing.
+	reporter, out := runShadowHandler(t, primary, getTestDelivery(t))
+	assert.Equal(map[string]int64{OutcomePrimaryMissing: 1}, reporter.Summary())
+	assert.Equal(2, primary.lookups)
+	assert.Contains(out, OutcomePrimaryMissing)
+}
+
+func TestShadowBothFailed(t *testing.T) {
+	assert := assert.New(t)
+	delivery := amqp.Delivery{Body: []byte("not json"), RoutingKey: "events.notification.update.analysis"}
+
+	// The handler fails and there's no primary notification.
+	reporter, out := runShadowHandler(t, &fakePrimary{}, delivery)
+	assert.Equal(map[string]int64{OutcomeBothFailed: 1}, reporter.Summary())
+	var entry Entry
+	assert.NoError(json.Unmarshal([]byte(out), &entry))
+	assert.Contains(entry.Error, "unable to parse message body")
+}
+
+func TestCompare(t *testing.T) {
+	assert := assert.New(t)
+
+	primary := map[string]interface{}{
+		"message": map[string]interface{}{"id": "1", "text": "foo"},
+		"list":    []interface{}{"a", "b"},
+		"removed": true,
+	}
+	shadow := map[string]interface{}{
+		"message": map[string]interface{}{"id": "2", "text": "foo"},
+		"list":    []interface{}{"a", "c"},
+		"added":   1.0,
+	}
+	expected := []Difference{
+		{Path: "added", Primary: nil, Shadow: 1.0},
+		{Path: "list.1", Primary: "b", Shadow: "c"},
+		{Path: "removed", Primary: true, Shadow: nil},
+	}
+	assert.Equal(expected, compare("", primary, shadow))
+}
@@ -0,0 +1,61 @@
+package shadow
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cyverse-de/messaging/v12"
+)
+
+// discardingMessagingClient implements handlers.MessagingClient. Instead of publishing messages, it
+// counts the messages that would have been published.
+type discardingMessagingClient struct {
+	mutex                sync.Mutex
+	emailRequests        int
+	notificationMessages int
+}
+
+// PublishEmailRequestContext counts an email request without publishing it.
+func (c *discardingMessagingClient) PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.emailRequests++
+	return nil
+}
+
+// PublishNotificationMessageContext counts a notification message without publishing it.
+func (c *discardingMessagingClient) PublishNotificationMessageContext(
+	context.Context,
+	*messaging.WrappedNotificationMessage,
+) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.notificationMessages++
+	return nil
+}
+
+// DiscardingPublisher implements transport.Publisher for the handler set in shadow mode. Messages are never
+// published; the alert emails that the handler set sends when it discards a delivery are logged instead.
+type DiscardingPublisher struct{}
+
+// PublishContextOpts discards a message.
+func (p *DiscardingPublisher) PublishContextOpts(context.Context, string, []byte, *messaging.PublishingOpts) error {
+	return nil
+}
+
+// PublishEmailRequestContext logs an email request without publishing it.
+func (p *DiscardingPublisher) PublishEmailRequestContext(_ context.Context, request *messaging.EmailRequest) error {
+	log.Warnf("not sending an email to %s in shadow mode: %s", request.ToAddress, request.Subject)
+	return nil
+}
+
+// PublishNotificationMessageContext discards a notification message.
+func (p *DiscardingPublisher) PublishNotificationMessageContext(
+	context.Context,
+	*messaging.WrappedNotificationMessage,
+) error {
+	return nil
+}
+
+// Close does nothing.
+func (p *DiscardingPublisher) Close() {}
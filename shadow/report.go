@@ -0,0 +1,113 @@
+package shadow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The possible outcomes of processing a delivery in shadow mode.
+const (
+	// OutcomeMatch indicates that the shadow instance produced the same outgoing notification as the
+	// primary instance.
+	OutcomeMatch = "match"
+
+	// OutcomeMismatch indicates that the outgoing notifications differed.
+	OutcomeMismatch = "mismatch"
+
+	// OutcomePrimaryMissing indicates that the shadow instance produced an outgoing notification, but no
+	// outgoing notification stored by the primary instance could be found.
+	OutcomePrimaryMissing = "primary_missing"
+
+	// OutcomeShadowFailed indicates that the primary instance stored an outgoing notification, but the
+	// shadow instance failed to produce one.
+	OutcomeShadowFailed = "shadow_failed"
+
+	// OutcomeBothFailed indicates that neither instance produced an outgoing notification.
+	OutcomeBothFailed = "both_failed"
+)
+
+// Entry represents the result of processing a single delivery in shadow mode.
+type Entry struct {
+	Time        time.Time    `json:"time"`
+	RoutingKey  string       `json:"routing_key"`
+	Outcome     string       `json:"outcome"`
+	Error       string       `json:"error,omitempty"`
+	WouldEmail  bool         `json:"would_email"`
+	Differences []Difference `json:"differences,omitempty"`
+	Body        string       `json:"body,omitempty"`
+}
+
+// Reporter records the results of processing deliveries in shadow mode. Every entry is counted, and
+// entries for deliveries that didn't match are written to the report as JSON lines.
+type Reporter struct {
+	mutex  sync.Mutex
+	out    io.Writer
+	counts map[string]int64
+}
+
+// NewReporter returns a new reporter that writes entries to the given writer.
+func NewReporter(out io.Writer) *Reporter {
+	return &Reporter{
+		out:    out,
+		counts: make(map[string]int64),
+	}
+}
+
+// Record records a single entry.
+func (r *Reporter) Record(entry *Entry) error {
+	wrapMsg := "unable to record the shadow mode report entry"
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Count the entry.
+	r.counts[entry.Outcome]++
+	if entry.Outcome == OutcomeMatch {
+		return nil
+	}
+
+	// Write the entry to the report.
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+	_, err = r.out.Write(append(entryJSON, '\n'))
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	return nil
+}
+
+// Summary returns the number of deliveries that have been recorded for each outcome.
+func (r *Reporter) Summary() map[string]int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	summary := make(map[string]int64, len(r.counts))
+	for k, v := range r.counts {
+		summary[k] = v
+	}
+
+	return summary
+}
+
+// LogSummaries periodically logs a summary of the deliveries that have been recorded until the context is
+// canceled.
+func LogSummaries(ctx context.Context, reporter *Reporter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Infof("shadow mode summary: %v", reporter.Summary())
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+)
+
+// localePattern matches normalized locale identifiers such as `en`, `pt-br` or `zh-hant-tw`.
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// ParseLocale normalizes and validates a locale identifier. The default locale is returned if the locale is empty.
+func ParseLocale(locale string) (string, error) {
+	normalized := NormalizeLocale(locale)
+	if !localePattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid locale: %s", locale)
+	}
+	return normalized, nil
+}
+
+// CatalogEntry contains the translated subject and body templates for a single notification type.
+type CatalogEntry struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Catalog contains the templates for every notification type in a single locale. Catalogs are stored as JSON so that
+// translators can work on one language at a time:
+//
+//	{
+//	  "locale": "es",
+//	  "templates": {
+//	    "analysis": {
+//	      "subject": "{{.payload.analysisname}}: {{lower .payload.analysisstatus}}",
+//	      "body": "Su análisis, {{.payload.analysisname}}, ha cambiado de estado."
+//	    }
+//	  }
+//	}
+type Catalog struct {
+	Locale    string                   `json:"locale"`
+	Templates map[string]*CatalogEntry `json:"templates"`
+}
+
+// NewCatalog builds the catalog for a locale from the templates for that locale.
+func NewCatalog(locale string, templates []*common.NotificationTemplate) *Catalog {
+	locale = NormalizeLocale(locale)
+	catalog := &Catalog{Locale: locale, Templates: make(map[string]*CatalogEntry)}
+	for _, t := range templates {
+		if NormalizeLocale(t.Locale) == locale {
+			catalog.Templates[strings.ToLower(t.NotificationType)] = &CatalogEntry{Subject: t.Subject, Body: t.Body}
+		}
+	}
+	return catalog
+}
+
+// ParseCatalog parses a JSON catalog. The locale in the catalog is used if it's present. Otherwise, the given
+// default locale is used.
+func ParseCatalog(contents []byte, defaultLocale string) (*Catalog, error) {
+	var catalog Catalog
+	err := json.Unmarshal(contents, &catalog)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse the catalog")
+	}
+	if catalog.Locale == "" {
+		catalog.Locale = defaultLocale
+	}
+	catalog.Locale, err = ParseLocale(catalog.Locale)
+	if err != nil {
+		return nil, err
+	}
+	return &catalog, nil
+}
+
+// NotificationTemplates returns the templates in the catalog, sorted by notification type.
+func (c *Catalog) NotificationTemplates() []*common.NotificationTemplate {
+	templates := make([]*common.NotificationTemplate, 0, len(c.Templates))
+	for notificationType, entry := range c.Templates {
+		if entry == nil {
+			continue
+		}
+		templates = append(templates, &common.NotificationTemplate{
+			NotificationType: strings.ToLower(notificationType),
+			Locale:           c.Locale,
+			Subject:          entry.Subject,
+			Body:             entry.Body,
+		})
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].NotificationType < templates[j].NotificationType
+	})
+	return templates
+}
+
+// Validate returns an error if any of the templates in the catalog is invalid.
+func (c *Catalog) Validate() error {
+	for _, t := range c.NotificationTemplates() {
+		_, err := Compile(t)
+		if err != nil {
+			return errors.Wrapf(err, "invalid template for %s", t.NotificationType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package templates
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLocale(t *testing.T) {
+	assert := assert.New(t)
+
+	for input, expected := range map[string]string{"": "en", "es": "es", "pt_BR": "pt-br", " zh-Hant-TW ": "zh-hant-tw"} {
+		actual, err := ParseLocale(input)
+		assert.NoError(err)
+		assert.Equal(expected, actual)
+	}
+	for _, input := range []string{"e", "english!", "../en", "en--us"} {
+		_, err := ParseLocale(input)
+		assert.Error(err, input)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	assert := assert.New(t)
+
+	// The locale in the catalog should take precedence over the default locale.
+	catalog, err := ParseCatalog([]byte(`{
+		"locale": "es",
+		"templates": {
+			"data": {"subject": "datos"},
+			"Analysis": {"subject": "análisis", "body": "{{.payload.analysisname}}"}
+		}
+	}`), "fr")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(catalog.Validate())
+	expected := []*common.NotificationTemplate{
+		{NotificationType: "analysis", Locale: "es", Subject: "análisis", Body: "{{.payload.analysisname}}"},
+		{NotificationType: "data", Locale: "es", Subject: "datos"},
+	}
+	assert.Equal(expected, catalog.NotificationTemplates())
+
+	// The default locale should be used if the catalog doesn't specify one.
+	catalog, err = ParseCatalog([]byte(`{"templates": {"data": {"subject": "{{"}}}`), "fr")
+	if assert.NoError(err) {
+		assert.Equal("fr", catalog.Locale)
+		assert.Error(catalog.Validate())
+	}
+
+	// Catalogs should be built from the templates for a single locale.
+	catalog = NewCatalog("ES", expected)
+	assert.Len(catalog.Templates, 2)
+	assert.Equal("datos", catalog.Templates["data"].Subject)
+	assert.Empty(NewCatalog("fr", expected).Templates)
+}
+
+func TestDirectorySourceCatalogs(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"analysis.yaml": "subject: analysis\n",
+		"es.json":       `{"templates": {"analysis": {"subject": "análisis"}}}`,
+		"pt-BR.json":    `{"templates": {"analysis": {"subject": "análise"}}}`,
+	}
+	for name, contents := range files {
+		assert.NoError(os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644))
+	}
+
+	renderer, err := NewRenderer(context.Background(), NewDirectorySource(dir))
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("análisis", renderer.Lookup("analysis", "es").Subject)
+	assert.Equal("análise", renderer.Lookup("analysis", "pt_BR").Subject)
+	assert.Equal("analysis", renderer.Lookup("analysis", "de").Subject)
+}
@@ -0,0 +1,92 @@
+package templates
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "templates"})
+
+// Renderer renders notification text using the templates loaded from a source, which are reloaded periodically.
+type Renderer struct {
+	source Source
+	mutex  sync.RWMutex
+	set    *Set
+}
+
+// NewRenderer loads the templates from a source and returns a renderer that uses them. An error is returned if the
+// templates can't be loaded.
+func NewRenderer(ctx context.Context, source Source) (*Renderer, error) {
+	r := &Renderer{source: source}
+	err := r.Reload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewStaticRenderer returns a renderer that uses a fixed set of templates.
+func NewStaticRenderer(set *Set) *Renderer {
+	return &Renderer{set: set}
+}
+
+// Reload loads the templates from the source. The current templates are retained if the new templates can't be
+// loaded or if any of them is invalid.
+func (r *Renderer) Reload(ctx context.Context) error {
+	if r.source == nil {
+		return nil
+	}
+	templates, err := r.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	set, err := NewSet(templates)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.set = set
+	return nil
+}
+
+// Watch reloads the templates at the given interval until the context is canceled.
+func (r *Renderer) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := r.Reload(ctx)
+			if err != nil {
+				log.Errorf("keeping the current notification templates: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Lookup finds the template for a notification type in the best available locale. It returns nil if there's no
+// template for the notification type.
+func (r *Renderer) Lookup(notificationType, locale string) *Template {
+	r.mutex.RLock()
+	set := r.set
+	r.mutex.RUnlock()
+	return set.Lookup(notificationType, locale)
+}
+
+// Render renders the text of a notification using the template for its type and locale. It returns nil if there's
+// no template for the notification type.
+func (r *Renderer) Render(notificationType, locale string, data interface{}) (*Rendered, error) {
+	t := r.Lookup(notificationType, locale)
+	if t == nil {
+		return nil, nil
+	}
+	return t.Render(data)
+}
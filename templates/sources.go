@@ -0,0 +1,129 @@
+package templates
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Source loads notification templates from a backing store.
+type Source interface {
+	Load(ctx context.Context) ([]*common.NotificationTemplate, error)
+}
+
+// DirectorySource loads notification templates from the YAML files in a directory. Each file contains the subject
+// and body templates for a single notification type and locale:
+//
+//	subject: "{{.payload.analysisname}} {{lower .payload.analysisstatus}}"
+//	body: "Your analysis, {{.payload.analysisname}}, is now {{lower .payload.analysisstatus}}."
+//
+// The notification type and locale are determined by the file name, which has the form `<type>.<locale>.yaml`.
+// Files named `<type>.yaml` contain the templates for the default locale. The directory may also contain translation
+// catalogs named `<locale>.json`, each of which contains the templates for every notification type in one locale.
+// See Catalog for the catalog format.
+type DirectorySource struct {
+	path string
+}
+
+// NewDirectorySource returns a source that loads notification templates from a directory.
+func NewDirectorySource(path string) *DirectorySource {
+	return &DirectorySource{path: path}
+}
+
+// Load loads the notification templates from the directory.
+func (s *DirectorySource) Load(_ context.Context) ([]*common.NotificationTemplate, error) {
+	wrapMsg := fmt.Sprintf("unable to load the notification templates from %s", s.path)
+
+	// List the files in the directory.
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Load each template file and catalog.
+	templates := make([]*common.NotificationTemplate, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.path, entry.Name())
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml":
+			t, err := loadTemplateFile(path)
+			if err != nil {
+				return nil, errors.Wrap(err, wrapMsg)
+			}
+			templates = append(templates, t)
+		case ".json":
+			catalog, err := loadCatalogFile(path)
+			if err != nil {
+				return nil, errors.Wrap(err, wrapMsg)
+			}
+			templates = append(templates, catalog.NotificationTemplates()...)
+		}
+	}
+
+	return templates, nil
+}
+
+// loadTemplateFile loads a single notification template file.
+func loadTemplateFile(path string) (*common.NotificationTemplate, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t common.NotificationTemplate
+	err = yaml.Unmarshal(contents, &t)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse %s", filepath.Base(path))
+	}
+
+	// The file name identifies the notification type and locale.
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	t.NotificationType, t.Locale, _ = strings.Cut(name, ".")
+	t.Locale = NormalizeLocale(t.Locale)
+
+	return &t, nil
+}
+
+// loadCatalogFile loads a single translation catalog. The locale defaults to the file name.
+func loadCatalogFile(path string) (*Catalog, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	locale := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	catalog, err := ParseCatalog(contents, locale)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load %s", filepath.Base(path))
+	}
+	return catalog, nil
+}
+
+// DatabaseSource loads notification templates from the notifications database.
+type DatabaseSource struct {
+	db *sql.DB
+}
+
+// NewDatabaseSource returns a source that loads notification templates from the notifications database.
+func NewDatabaseSource(db *sql.DB) *DatabaseSource {
+	return &DatabaseSource{db: db}
+}
+
+// Load loads the notification templates from the database.
+func (s *DatabaseSource) Load(ctx context.Context) ([]*common.NotificationTemplate, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to begin a database transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+	return db.ListNotificationTemplates(ctx, tx)
+}
@@ -0,0 +1,258 @@
+// Package templates renders the subject and message text of notifications from the structured data in incoming
+// events, so that producers don't have to format the text themselves. Templates use the Go text/template syntax and
+// are executed with the incoming event body as their data, so the payload fields are available as
+// `{{.payload.field}}`. Only a small set of side-effect free functions is available to templates, and the size of
+// each template and of its output is limited.
+//
+// Templates are keyed by notification type and locale. If there's no template for the requested locale, the
+// template for the locale's base language is used, followed by the template for the default locale.
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/pkg/errors"
+)
+
+// DefaultLocale is the locale used when an event doesn't specify one and the locale whose templates are used when
+// there's no template for the requested locale.
+const DefaultLocale = "en"
+
+// maxTemplateLength is the maximum length of a single template in bytes.
+const maxTemplateLength = 16 * 1024
+
+// maxOutputLength is the maximum length of the text rendered by a single template in bytes.
+const maxOutputLength = 64 * 1024
+
+// funcs is the set of functions available to templates. Every function is free of side effects.
+var funcs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trim":       strings.TrimSpace,
+	"replace":    strings.ReplaceAll,
+	"default":    defaultValue,
+	"join":       join,
+	"truncate":   truncate,
+	"plural":     plural,
+	"formatTime": formatTime,
+}
+
+// defaultValue returns the fallback value if the value is missing or empty.
+func defaultValue(fallback, value interface{}) interface{} {
+	if value == nil || fmt.Sprint(value) == "" {
+		return fallback
+	}
+	return value
+}
+
+// join joins the elements of a list using a separator.
+func join(separator string, list interface{}) string {
+	values, ok := list.([]interface{})
+	if !ok {
+		return fmt.Sprint(list)
+	}
+	strs := make([]string, len(values))
+	for i, value := range values {
+		strs[i] = fmt.Sprint(value)
+	}
+	return strings.Join(strs, separator)
+}
+
+// truncate limits a string to the given number of characters, adding an ellipsis if the string was shortened.
+func truncate(length int, s string) string {
+	runes := []rune(s)
+	if length < 0 || len(runes) <= length {
+		return s
+	}
+	return string(runes[:length]) + "..."
+}
+
+// plural returns the singular form if the count is one and the plural form otherwise.
+func plural(count interface{}, singular, pluralForm string) string {
+	if fmt.Sprint(count) == "1" {
+		return singular
+	}
+	return pluralForm
+}
+
+// formatTime formats a timestamp, which may be expressed in milliseconds since the epoch or in any format accepted
+// by common.FixTimestamp, using a Go time layout. The value is returned unchanged if it can't be parsed.
+func formatTime(layout string, value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case float64:
+		s = fmt.Sprintf("%d", int64(v))
+	default:
+		return fmt.Sprint(value)
+	}
+	millis, err := common.FixTimestamp(s)
+	if err != nil {
+		return s
+	}
+	epochMillis, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return s
+	}
+	return time.UnixMilli(epochMillis).UTC().Format(layout)
+}
+
+// Rendered contains the text rendered from a notification template.
+type Rendered struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Template is a compiled notification template.
+type Template struct {
+	common.NotificationTemplate
+	subject *template.Template
+	body    *template.Template
+}
+
+// parse parses one of the templates in a notification template. A nil template is returned if the text is empty.
+func parse(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	if len(text) > maxTemplateLength {
+		return nil, fmt.Errorf("the %s template is longer than %d bytes", name, maxTemplateLength)
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s template", name)
+	}
+	return tmpl, nil
+}
+
+// Compile validates and compiles a notification template.
+func Compile(t *common.NotificationTemplate) (*Template, error) {
+	if t.Subject == "" && t.Body == "" {
+		return nil, fmt.Errorf("the template has neither a subject nor a body")
+	}
+	subject, err := parse("subject", t.Subject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parse("body", t.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{NotificationTemplate: *t, subject: subject, body: body}, nil
+}
+
+// limitedBuffer is a buffer that refuses to grow beyond the maximum output length.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+// Write appends data to the buffer, returning an error if the maximum output length would be exceeded.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxOutputLength {
+		return 0, fmt.Errorf("the rendered text is longer than %d bytes", maxOutputLength)
+	}
+	return b.Buffer.Write(p)
+}
+
+// execute executes one of the templates in a notification template. An empty string is returned if the template
+// is nil.
+func execute(tmpl *template.Template, data interface{}) (string, error) {
+	if tmpl == nil {
+		return "", nil
+	}
+	var buf limitedBuffer
+	err := tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to render the %s", tmpl.Name())
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// Render renders the subject and body of a notification using the given data.
+func (t *Template) Render(data interface{}) (*Rendered, error) {
+	subject, err := execute(t.subject, data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := execute(t.body, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Rendered{Subject: subject, Body: body}, nil
+}
+
+// templateKey identifies a template in a set.
+type templateKey struct {
+	notificationType string
+	locale           string
+}
+
+// NormalizeLocale converts a locale to the canonical form used as a template key: lower case, with hyphens rather
+// than underscores. The default locale is returned if the locale is empty.
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if locale == "" {
+		return DefaultLocale
+	}
+	return locale
+}
+
+// fallbackLocales returns the locales whose templates may be used for a requested locale, in order of preference.
+func fallbackLocales(locale string) []string {
+	locale = NormalizeLocale(locale)
+	locales := []string{locale}
+	if i := strings.Index(locale, "-"); i > 0 {
+		locales = append(locales, locale[:i])
+	}
+	if locales[len(locales)-1] != DefaultLocale {
+		locales = append(locales, DefaultLocale)
+	}
+	return locales
+}
+
+// Set is an immutable collection of compiled templates.
+type Set struct {
+	templates map[templateKey]*Template
+}
+
+// NewSet compiles a collection of notification templates. An error is returned if any of the templates is invalid
+// or if there's more than one template for the same notification type and locale.
+func NewSet(templates []*common.NotificationTemplate) (*Set, error) {
+	set := &Set{templates: make(map[templateKey]*Template, len(templates))}
+	for _, t := range templates {
+		key := templateKey{notificationType: strings.ToLower(t.NotificationType), locale: NormalizeLocale(t.Locale)}
+		if _, ok := set.templates[key]; ok {
+			return nil, fmt.Errorf("duplicate template for %s in locale %s", key.notificationType, key.locale)
+		}
+		compiled, err := Compile(t)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid template for %s in locale %s", key.notificationType, key.locale)
+		}
+		set.templates[key] = compiled
+	}
+	return set, nil
+}
+
+// Lookup finds the template for a notification type in the best available locale. It returns nil if there's no
+// template for the notification type.
+func (s *Set) Lookup(notificationType, locale string) *Template {
+	notificationType = strings.ToLower(notificationType)
+	for _, candidate := range fallbackLocales(locale) {
+		if t, ok := s.templates[templateKey{notificationType: notificationType, locale: candidate}]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// Len returns the number of templates in the set.
+func (s *Set) Len() int {
+	return len(s.templates)
+}
@@ -0,0 +1,137 @@
+package templates
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// testData returns sample event data for rendering templates.
+func testData() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "analysis",
+		"user": "ipcdev",
+		"payload": map[string]interface{}{
+			"analysisname":   "word count",
+			"analysisstatus": "Completed",
+			"startdate":      "2026-10-18T12:00:00Z",
+			"outputs":        []interface{}{"a.txt", "b.txt"},
+			"count":          float64(1),
+		},
+	}
+}
+
+func TestCompile(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Compile(&common.NotificationTemplate{Subject: "{{.payload.analysisname}}"})
+	assert.NoError(err)
+	_, err = Compile(&common.NotificationTemplate{})
+	assert.Error(err)
+	_, err = Compile(&common.NotificationTemplate{Subject: "{{.payload.analysisname"})
+	assert.Error(err)
+	_, err = Compile(&common.NotificationTemplate{Body: "{{env \"HOME\"}}"})
+	assert.Error(err, "unknown functions should be rejected")
+	_, err = Compile(&common.NotificationTemplate{Body: strings.Repeat("x", maxTemplateLength+1)})
+	assert.Error(err)
+}
+
+func TestRender(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpl, err := Compile(&common.NotificationTemplate{
+		Subject: "{{.payload.analysisname}} {{lower .payload.analysisstatus}}",
+		Body: `{{upper .user}} started {{formatTime "2006-01-02" .payload.startdate}}; ` +
+			`{{join ", " .payload.outputs}}; {{.payload.count}} {{plural .payload.count "file" "files"}}; ` +
+			`{{default "none" .payload.missing}}; {{truncate 4 .payload.analysisname}}`,
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	rendered, err := tmpl.Render(testData())
+	if assert.NoError(err) {
+		assert.Equal("word count completed", rendered.Subject)
+		assert.Equal("IPCDEV started 2026-10-18; a.txt, b.txt; 1 file; none; word...", rendered.Body)
+	}
+
+	// Output that's too long should be rejected.
+	tmpl, err = Compile(&common.NotificationTemplate{Body: `{{range .payload.outputs}}{{.}}{{end}}`})
+	if assert.NoError(err) {
+		data := testData()
+		outputs := make([]interface{}, maxOutputLength)
+		for i := range outputs {
+			outputs[i] = "xx"
+		}
+		data["payload"].(map[string]interface{})["outputs"] = outputs
+		_, err = tmpl.Render(data)
+		assert.Error(err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	assert := assert.New(t)
+
+	set, err := NewSet([]*common.NotificationTemplate{
+		{NotificationType: "analysis", Locale: "en", Subject: "en"},
+		{NotificationType: "analysis", Locale: "pt", Subject: "pt"},
+		{NotificationType: "analysis", Locale: "pt_BR", Subject: "pt-br"},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(3, set.Len())
+	assert.Equal("pt-br", set.Lookup("analysis", "pt-BR").Subject)
+	assert.Equal("pt", set.Lookup("analysis", "pt-PT").Subject)
+	assert.Equal("en", set.Lookup("Analysis", "fr").Subject)
+	assert.Equal("en", set.Lookup("analysis", "").Subject)
+	assert.Nil(set.Lookup("data", "en"))
+
+	// Duplicate templates should be rejected.
+	_, err = NewSet([]*common.NotificationTemplate{
+		{NotificationType: "analysis", Locale: "", Subject: "a"},
+		{NotificationType: "analysis", Locale: "en", Subject: "b"},
+	})
+	assert.Error(err)
+}
+
+func TestDirectorySource(t *testing.T) {
+	assert := assert.New(t)
+
+	// Create the template files.
+	dir := t.TempDir()
+	files := map[string]string{
+		"analysis.yaml":           "subject: analysis {{.payload.analysisname}}\n",
+		"analysis.es.yaml":        "subject: análisis {{.payload.analysisname}}\n",
+		"data.yml":                "body: some data\n",
+		"README.md":               "not a template",
+		"tool_request.pt_BR.yaml": "subject: ferramenta\n",
+	}
+	for name, contents := range files {
+		assert.NoError(os.WriteFile(filepath.Join(dir, name), []byte(contents), 0o644))
+	}
+
+	// Load the templates.
+	renderer, err := NewRenderer(context.Background(), NewDirectorySource(dir))
+	if !assert.NoError(err) {
+		return
+	}
+	rendered, err := renderer.Render("analysis", "es-MX", testData())
+	if assert.NoError(err) {
+		assert.Equal("análisis word count", rendered.Subject)
+	}
+	assert.NotNil(renderer.Lookup("data", "en"))
+	assert.NotNil(renderer.Lookup("tool_request", "pt-br"))
+	rendered, err = renderer.Render("permanent_id_request", "en", testData())
+	assert.NoError(err)
+	assert.Nil(rendered)
+
+	// Invalid templates should be rejected, and the current templates should be retained.
+	assert.NoError(os.WriteFile(filepath.Join(dir, "data.yml"), []byte("body: '{{'\n"), 0o644))
+	assert.Error(renderer.Reload(context.Background()))
+	assert.NotNil(renderer.Lookup("analysis", "es"))
+}
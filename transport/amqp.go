@@ -0,0 +1,247 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/pkg/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+)
+
+// consumerTag is the tag used to identify the AMQP consumer so that it can be canceled during shutdown.
+const consumerTag = "event-recorder"
+
+// NewAMQPPublisher creates a messaging client that publishes to the AMQP exchange. The client reconnects
+// automatically if the connection to the broker is lost.
+func NewAMQPPublisher(amqpSettings *common.AMQPSettings) (*messaging.Client, error) {
+	wrapMsg := "unable to create the AMQP publisher"
+
+	// Create the messaging client.
+	client, err := messaging.NewClient(amqpSettings.URI, true)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Set up publishing on the messaging client.
+	err = client.SetupPublishing(amqpSettings.ExchangeName)
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+
+	// Start listening for connection errors.
+	go client.Listen()
+
+	return client, nil
+}
+
+// AMQPConsumer receives incoming events from an AMQP queue, which it declares and binds to the exchange.
+type AMQPConsumer struct {
+	amqpSettings  *common.AMQPSettings
+	queueSettings *common.QueueSettings
+	prefetch      int
+	connMutex     sync.Mutex
+	conn          *amqp.Connection
+	channel       *amqp.Channel
+	canceled      bool
+}
+
+// NewAMQPConsumer returns a consumer that receives events from the queue described by the queue settings. Up to
+// prefetch unacknowledged deliveries are sent by the broker at a time.
+func NewAMQPConsumer(
+	amqpSettings *common.AMQPSettings,
+	queueSettings *common.QueueSettings,
+	prefetch int,
+) (*AMQPConsumer, error) {
+	err := queueSettings.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the AMQP consumer")
+	}
+	return &AMQPConsumer{amqpSettings: amqpSettings, queueSettings: queueSettings, prefetch: prefetch}, nil
+}
+
+// Name returns the name of the queue.
+func (c *AMQPConsumer) Name() string {
+	return c.queueSettings.Name
+}
+
+// setConnection records the current connection and channel so that they can be shut down later. It returns false
+// if the consumer has already been canceled.
+func (c *AMQPConsumer) setConnection(conn *amqp.Connection, channel *amqp.Channel) bool {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if c.canceled {
+		return false
+	}
+	c.conn = conn
+	c.channel = channel
+	return true
+}
+
+// isCanceled returns true if the consumer has been canceled.
+func (c *AMQPConsumer) isCanceled() bool {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	return c.canceled
+}
+
+// Consume passes incoming deliveries to the handler in the order in which they're received. If the connection to
+// the AMQP broker is lost, a new connection is established after a short delay. This function returns once the
+// consumer has been canceled.
+func (c *AMQPConsumer) Consume(handler Handler) {
+	for {
+		err := c.consumeUntilDisconnected(handler)
+		if c.isCanceled() {
+			return
+		}
+		log.Errorf("lost the connection to the AMQP broker; reconnecting in %s: %s", reconnectDelay, err.Error())
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// consumeUntilDisconnected establishes a connection to the AMQP broker and consumes deliveries until either the
+// connection is lost or the consumer is canceled. The connection is left open after the consumer is canceled so
+// that deliveries that are still being processed can be acknowledged.
+func (c *AMQPConsumer) consumeUntilDisconnected(handler Handler) error {
+	wrapMsg := "unable to consume incoming events"
+
+	// Establish the connection.
+	conn, err := amqp.Dial(c.amqpSettings.URI)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Consume deliveries, closing the connection unless the consumer is being canceled.
+	err = c.consumeFrom(conn, handler)
+	if !c.isCanceled() {
+		_ = conn.Close()
+	}
+
+	return err
+}
+
+// consumeFrom declares and binds the queue, and passes each delivery to the handler until the delivery channel is
+// closed.
+func (c *AMQPConsumer) consumeFrom(conn *amqp.Connection, handler Handler) error {
+	wrapMsg := "unable to consume incoming events"
+
+	// Create the channel and limit the number of unacknowledged deliveries.
+	channel, err := conn.Channel()
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+	if !c.setConnection(conn, channel) {
+		_ = conn.Close()
+		return nil
+	}
+	err = channel.Qos(c.prefetch, 0, false)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+
+	// Declare the exchange, declare the queue, and bind the queue to the exchange.
+	err = channel.ExchangeDeclare(
+		c.amqpSettings.ExchangeName,
+		c.amqpSettings.ExchangeType,
+		true,  // durable
+		false, // auto-delete
+		false, // internal
+		false, // no-wait
+		nil,   // args
+	)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+	queue := c.queueSettings
+	_, err = channel.QueueDeclare(
+		queue.Name,
+		queue.Durable,
+		queue.AutoDelete,
+		queue.Exclusive,
+		false, // no-wait
+		queueArguments(queue),
+	)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+	for _, key := range queue.BindingKeys {
+		err = channel.QueueBind(queue.Name, key, c.amqpSettings.ExchangeName, false, nil)
+		if err != nil {
+			return errors.Wrap(err, wrapMsg)
+		}
+	}
+
+	// Start consuming messages.
+	deliveries, err := channel.Consume(queue.Name, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		return errors.Wrap(err, wrapMsg)
+	}
+	for delivery := range deliveries {
+		ctx := otel.GetTextMapPropagator().Extract(context.Background(), messaging.AMQPHeaderCarrier(delivery.Headers))
+		handler(ctx, delivery)
+	}
+
+	return errors.New("the delivery channel was closed")
+}
+
+// Cancel stops receiving new deliveries.
+func (c *AMQPConsumer) Cancel() {
+	c.connMutex.Lock()
+	c.canceled = true
+	channel := c.channel
+	c.connMutex.Unlock()
+
+	if channel != nil {
+		_ = channel.Cancel(consumerTag, false)
+	}
+}
+
+// Close closes the connection to the AMQP broker.
+func (c *AMQPConsumer) Close() {
+	c.connMutex.Lock()
+	conn := c.conn
+	c.connMutex.Unlock()
+
+	if conn != nil {
+		_ = conn.Close()
+	}
+}
+
+// queueArguments builds the arguments to use when declaring the queue. The queue type is only included if
+// it's explicitly set to something other than a classic queue so that queues declared by older versions of
+// this service can still be declared without a precondition failure.
+func queueArguments(settings *common.QueueSettings) amqp.Table {
+	args := amqp.Table{}
+	for k, v := range settings.Arguments {
+		args[k] = tableValue(v)
+	}
+	if settings.Type != "" && settings.Type != common.QueueTypeClassic {
+		args["x-queue-type"] = settings.Type
+	}
+	return args
+}
+
+// tableValue converts a value read from the configuration file to a value that can be stored in an AMQP
+// table. Nested maps are converted to tables, and other values are returned unchanged.
+func tableValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		table := amqp.Table{}
+		for k, nested := range val {
+			table[k] = tableValue(nested)
+		}
+		return table
+	case []interface{}:
+		values := make([]interface{}, len(val))
+		for i, nested := range val {
+			values[i] = tableValue(nested)
+		}
+		return values
+	default:
+		return val
+	}
+}
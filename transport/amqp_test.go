@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"testing"
+
+	"github.com/cyverse-de/event-recorder/common"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueArgumentsClassic(t *testing.T) {
+	settings := &common.QueueSettings{
+		Type:      common.QueueTypeClassic,
+		Arguments: map[string]interface{}{"x-max-length": 1000},
+	}
+	assert.Equal(t, amqp.Table{"x-max-length": 1000}, queueArguments(settings))
+}
+
+func TestQueueArgumentsQuorum(t *testing.T) {
+	settings := &common.QueueSettings{
+		Type: common.QueueTypeQuorum,
+		Arguments: map[string]interface{}{
+			"x-delivery-limit": 5,
+			"x-nested":         map[string]interface{}{"foo": "bar"},
+			"x-list":           []interface{}{map[string]interface{}{"baz": "quux"}},
+		},
+	}
+	expected := amqp.Table{
+		"x-queue-type":     "quorum",
+		"x-delivery-limit": 5,
+		"x-nested":         amqp.Table{"foo": "bar"},
+		"x-list":           []interface{}{amqp.Table{"baz": "quux"}},
+	}
+	assert.Equal(t, expected, queueArguments(settings))
+}
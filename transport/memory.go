@@ -0,0 +1,433 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+)
+
+// MemoryBroker is an in-process message broker that behaves like a RabbitMQ topic exchange. It's intended for
+// tests that exercise the full path from an incoming event to the messages published in response to it without a
+// live broker.
+//
+// Messages are routed to every queue with a binding key that matches the routing key. Binding keys use the usual
+// topic exchange wildcards: `*` matches exactly one word and `#` matches zero or more words. Messages that are
+// negatively acknowledged and requeued, and messages that are still unacknowledged when their consumer is closed,
+// are returned to the front of their queue and redelivered with the redelivered flag set. Messages that don't match
+// any binding key are discarded.
+type MemoryBroker struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	queues  map[string]*memoryQueue
+	nextTag uint64
+}
+
+// memoryQueue is a queue in an in-process message broker.
+type memoryQueue struct {
+	bindingKeys []string
+	ready       []*memoryMessage
+	unacked     map[uint64]*memoryMessage
+}
+
+// memoryMessage is a message stored in a queue.
+type memoryMessage struct {
+	routingKey  string
+	publishing  amqp.Publishing
+	redelivered bool
+	consumer    *MemoryConsumer
+}
+
+// NewMemoryBroker creates a new in-process message broker with no queues.
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{queues: make(map[string]*memoryQueue)}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+// DeclareQueue declares a queue and binds it using the given binding keys. Declaring a queue that already exists
+// adds the binding keys to the existing bindings.
+func (b *MemoryBroker) DeclareQueue(name string, bindingKeys ...string) error {
+	if name == "" {
+		return errors.New("no queue name specified")
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	queue := b.queues[name]
+	if queue == nil {
+		queue = &memoryQueue{unacked: make(map[uint64]*memoryMessage)}
+		b.queues[name] = queue
+	}
+	queue.bindingKeys = append(queue.bindingKeys, bindingKeys...)
+
+	return nil
+}
+
+// TopicMatches returns true if a routing key matches a topic exchange binding key.
+func TopicMatches(bindingKey, routingKey string) bool {
+	return matchWords(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+}
+
+// matchWords returns true if the words of a routing key match the words of a binding key.
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
+
+// matches returns true if any of the queue's binding keys matches the routing key.
+func (q *memoryQueue) matches(routingKey string) bool {
+	for _, key := range q.bindingKeys {
+		if TopicMatches(key, routingKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish routes a message to every queue with a matching binding key. The publishing timestamp is set if it's
+// missing.
+func (b *MemoryBroker) Publish(routingKey string, publishing amqp.Publishing) {
+	if publishing.Timestamp.IsZero() {
+		publishing.Timestamp = time.Now()
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, queue := range b.queues {
+		if queue.matches(routingKey) {
+			queue.ready = append(queue.ready, &memoryMessage{routingKey: routingKey, publishing: publishing})
+		}
+	}
+	b.cond.Broadcast()
+}
+
+// Get removes the message at the front of a queue and returns it as an acknowledged delivery. The second return
+// value is false if the queue is empty or doesn't exist.
+func (b *MemoryBroker) Get(queueName string) (amqp.Delivery, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	queue := b.queues[queueName]
+	if queue == nil || len(queue.ready) == 0 {
+		return amqp.Delivery{}, false
+	}
+	msg := queue.ready[0]
+	queue.ready = queue.ready[1:]
+	b.nextTag++
+
+	return msg.delivery(b.nextTag, nil), true
+}
+
+// Ready returns the number of messages in a queue that are waiting to be delivered.
+func (b *MemoryBroker) Ready(queueName string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if queue := b.queues[queueName]; queue != nil {
+		return len(queue.ready)
+	}
+	return 0
+}
+
+// Unacked returns the number of messages in a queue that have been delivered but not acknowledged.
+func (b *MemoryBroker) Unacked(queueName string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if queue := b.queues[queueName]; queue != nil {
+		return len(queue.unacked)
+	}
+	return 0
+}
+
+// delivery converts a message to a delivery.
+func (m *memoryMessage) delivery(tag uint64, acknowledger amqp.Acknowledger) amqp.Delivery {
+	p := m.publishing
+	return amqp.Delivery{
+		Acknowledger:    acknowledger,
+		Headers:         p.Headers,
+		ContentType:     p.ContentType,
+		ContentEncoding: p.ContentEncoding,
+		DeliveryMode:    p.DeliveryMode,
+		Priority:        p.Priority,
+		CorrelationId:   p.CorrelationId,
+		ReplyTo:         p.ReplyTo,
+		Expiration:      p.Expiration,
+		MessageId:       p.MessageId,
+		Timestamp:       p.Timestamp,
+		Type:            p.Type,
+		UserId:          p.UserId,
+		AppId:           p.AppId,
+		DeliveryTag:     tag,
+		Redelivered:     m.redelivered,
+		RoutingKey:      m.routingKey,
+		Body:            p.Body,
+	}
+}
+
+// requeue returns messages to the front of a queue so that they're redelivered. The caller must hold the broker's
+// lock.
+func (b *MemoryBroker) requeue(queue *memoryQueue, tags []uint64) {
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	msgs := make([]*memoryMessage, len(tags))
+	for i, tag := range tags {
+		msg := queue.unacked[tag]
+		delete(queue.unacked, tag)
+		msg.consumer.outstanding--
+		msg.consumer = nil
+		msg.redelivered = true
+		msgs[i] = msg
+	}
+	queue.ready = append(msgs, queue.ready...)
+	b.cond.Broadcast()
+}
+
+// settle acknowledges or negatively acknowledges a delivery, or every outstanding delivery to the consumer up to
+// and including the delivery if multiple is true.
+func (b *MemoryBroker) settle(consumer *MemoryConsumer, tag uint64, multiple, requeue bool) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	queue := b.queues[consumer.queueName]
+	msg := queue.unacked[tag]
+	if msg == nil || msg.consumer != consumer {
+		return fmt.Errorf("unknown delivery tag: %d", tag)
+	}
+
+	// Determine which deliveries to settle.
+	tags := []uint64{tag}
+	if multiple {
+		tags = tags[:0]
+		for t, m := range queue.unacked {
+			if t <= tag && m.consumer == consumer {
+				tags = append(tags, t)
+			}
+		}
+	}
+
+	// Either requeue or remove the messages.
+	if requeue {
+		b.requeue(queue, tags)
+		return nil
+	}
+	for _, t := range tags {
+		delete(queue.unacked, t)
+		consumer.outstanding--
+	}
+	b.cond.Broadcast()
+
+	return nil
+}
+
+// memoryAcknowledger acknowledges deliveries from an in-process message broker.
+type memoryAcknowledger struct {
+	broker   *MemoryBroker
+	consumer *MemoryConsumer
+}
+
+// Ack acknowledges a delivery.
+func (a *memoryAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.broker.settle(a.consumer, tag, multiple, false)
+}
+
+// Nack negatively acknowledges a delivery.
+func (a *memoryAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	return a.broker.settle(a.consumer, tag, multiple, requeue)
+}
+
+// Reject negatively acknowledges a delivery.
+func (a *memoryAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.broker.settle(a.consumer, tag, false, requeue)
+}
+
+// MemoryConsumer receives messages from a queue in an in-process message broker.
+type MemoryConsumer struct {
+	broker      *MemoryBroker
+	queueName   string
+	prefetch    int
+	outstanding int
+	canceled    bool
+}
+
+// NewConsumer returns a consumer that receives messages from an existing queue. Up to prefetch unacknowledged
+// messages are delivered at a time; there's no limit if prefetch is zero.
+func (b *MemoryBroker) NewConsumer(queueName string, prefetch int) (*MemoryConsumer, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.queues[queueName] == nil {
+		return nil, fmt.Errorf("queue not found: %s", queueName)
+	}
+	return &MemoryConsumer{broker: b, queueName: queueName, prefetch: prefetch}, nil
+}
+
+// Name returns the name of the queue.
+func (c *MemoryConsumer) Name() string {
+	return c.queueName
+}
+
+// canDeliver returns true if a message can be delivered to the consumer. The caller must hold the broker's lock.
+func (c *MemoryConsumer) canDeliver(queue *memoryQueue) bool {
+	return len(queue.ready) > 0 && (c.prefetch <= 0 || c.outstanding < c.prefetch)
+}
+
+// next waits for the next message to deliver to the consumer. The second return value is false if the consumer has
+// been canceled.
+func (c *MemoryConsumer) next() (amqp.Delivery, bool) {
+	b := c.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	queue := b.queues[c.queueName]
+	for !c.canceled && !c.canDeliver(queue) {
+		b.cond.Wait()
+	}
+	if c.canceled {
+		return amqp.Delivery{}, false
+	}
+
+	// Move the message to the unacknowledged messages.
+	msg := queue.ready[0]
+	queue.ready = queue.ready[1:]
+	b.nextTag++
+	queue.unacked[b.nextTag] = msg
+	msg.consumer = c
+	c.outstanding++
+
+	return msg.delivery(b.nextTag, &memoryAcknowledger{broker: b, consumer: c}), true
+}
+
+// Consume passes incoming deliveries to the handler in the order in which they're received. This function returns
+// once the consumer has been canceled.
+func (c *MemoryConsumer) Consume(handler Handler) {
+	for {
+		delivery, ok := c.next()
+		if !ok {
+			return
+		}
+		ctx := otel.GetTextMapPropagator().Extract(context.Background(), messaging.AMQPHeaderCarrier(delivery.Headers))
+		handler(ctx, delivery)
+	}
+}
+
+// Cancel stops receiving new deliveries.
+func (c *MemoryConsumer) Cancel() {
+	c.broker.mutex.Lock()
+	defer c.broker.mutex.Unlock()
+
+	c.canceled = true
+	c.broker.cond.Broadcast()
+}
+
+// Close cancels the consumer and requeues any deliveries that haven't been acknowledged, as closing the connection
+// to a broker would.
+func (c *MemoryConsumer) Close() {
+	b := c.broker
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	c.canceled = true
+	queue := b.queues[c.queueName]
+	var tags []uint64
+	for tag, msg := range queue.unacked {
+		if msg.consumer == c {
+			tags = append(tags, tag)
+		}
+	}
+	b.requeue(queue, tags)
+}
+
+// MemoryPublisher publishes messages to an in-process message broker.
+type MemoryPublisher struct {
+	broker *MemoryBroker
+	mutex  sync.Mutex
+	closed bool
+}
+
+// NewPublisher returns a publisher for the broker.
+func (b *MemoryBroker) NewPublisher() *MemoryPublisher {
+	return &MemoryPublisher{broker: b}
+}
+
+// PublishContextOpts publishes a message with the given routing key. The trace context is stored in the message
+// headers.
+func (p *MemoryPublisher) PublishContextOpts(
+	ctx context.Context,
+	key string,
+	body []byte,
+	opts *messaging.PublishingOpts,
+) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return errors.New("the publisher is closed")
+	}
+
+	if opts == nil {
+		opts = messaging.DefaultPublishingOpts
+	}
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, messaging.AMQPHeaderCarrier(headers))
+	p.broker.Publish(key, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  opts.ContentType,
+		DeliveryMode: opts.DeliveryMode,
+		Body:         body,
+	})
+
+	return nil
+}
+
+// PublishEmailRequestContext publishes an email request for the email service.
+func (p *MemoryPublisher) PublishEmailRequestContext(ctx context.Context, request *messaging.EmailRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return p.PublishContextOpts(ctx, messaging.EmailRequestPublishingKey, body, messaging.JSONPublishingOpts)
+}
+
+// PublishNotificationMessageContext publishes a notification message with the routing key `notification.{user}`.
+func (p *MemoryPublisher) PublishNotificationMessageContext(
+	ctx context.Context,
+	msg *messaging.WrappedNotificationMessage,
+) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("notification.%s", msg.Message.User)
+	return p.PublishContextOpts(ctx, key, body, messaging.JSONPublishingOpts)
+}
+
+// Close prevents any more messages from being published.
+func (p *MemoryPublisher) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+}
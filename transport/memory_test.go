@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/messaging/v12"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		bindingKey string
+		routingKey string
+		expected   bool
+	}{
+		{"events.*.update.*", "events.notification.update.ipcdev", true},
+		{"events.*.update.*", "events.notification.update", false},
+		{"events.*.update.*", "events.notification.update.ipcdev.extra", false},
+		{"events.#", "events", true},
+		{"events.#", "events.notification.update.ipcdev", true},
+		{"#", "anything.at.all", true},
+		{"events.#.ipcdev", "events.ipcdev", true},
+		{"events.#.ipcdev", "events.notification.update.ipcdev", true},
+		{"events.#.ipcdev", "events.notification.update.sarahr", false},
+		{"notification.*", "notification.ipcdev", true},
+		{"email.requests", "email.requests", true},
+		{"email.requests", "email.request", false},
+	} {
+		assert.Equal(tc.expected, TopicMatches(tc.bindingKey, tc.routingKey), "%s %s", tc.bindingKey, tc.routingKey)
+	}
+}
+
+func TestMemoryBrokerRouting(t *testing.T) {
+	assert := assert.New(t)
+
+	broker := NewMemoryBroker()
+	assert.Error(broker.DeclareQueue(""))
+	assert.NoError(broker.DeclareQueue("events", "events.*.update.*"))
+	assert.NoError(broker.DeclareQueue("notifications", "notification.#"))
+	assert.NoError(broker.DeclareQueue("emails", messaging.EmailRequestPublishingKey))
+	_, err := broker.NewConsumer("missing", 1)
+	assert.Error(err)
+
+	// Messages should be routed to queues with matching binding keys.
+	publisher := broker.NewPublisher()
+	ctx := context.Background()
+	assert.NoError(publisher.PublishContextOpts(ctx, "events.notification.update.ipcdev", []byte("1"), nil))
+	assert.NoError(publisher.PublishContextOpts(ctx, "events.unmatched", []byte("2"), nil))
+	assert.NoError(publisher.PublishEmailRequestContext(ctx, &messaging.EmailRequest{ToAddress: "ipcdev@cyverse.org"}))
+	assert.NoError(publisher.PublishNotificationMessageContext(ctx, &messaging.WrappedNotificationMessage{
+		Message: &messaging.NotificationMessage{User: "ipcdev"},
+	}))
+	assert.Equal(1, broker.Ready("events"))
+	assert.Equal(1, broker.Ready("notifications"))
+	assert.Equal(1, broker.Ready("emails"))
+
+	// Messages should be retrievable.
+	delivery, ok := broker.Get("notifications")
+	if assert.True(ok) {
+		assert.Equal("notification.ipcdev", delivery.RoutingKey)
+		assert.Equal("application/json", delivery.ContentType)
+		assert.False(delivery.Timestamp.IsZero())
+	}
+	_, ok = broker.Get("notifications")
+	assert.False(ok)
+
+	// Closed publishers shouldn't publish anything.
+	publisher.Close()
+	assert.Error(publisher.PublishContextOpts(ctx, "events.notification.update.ipcdev", []byte("3"), nil))
+	assert.Equal(1, broker.Ready("events"))
+}
+
+// startMemoryConsumer starts consuming from the consumer and returns a channel that receives each delivery.
+func startMemoryConsumer(t *testing.T, consumer *MemoryConsumer) <-chan amqp.Delivery {
+	deliveries := make(chan amqp.Delivery, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Consume(func(_ context.Context, delivery amqp.Delivery) { deliveries <- delivery })
+	}()
+	t.Cleanup(func() {
+		consumer.Cancel()
+		<-done
+	})
+	return deliveries
+}
+
+// receive waits for the next delivery.
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+		return amqp.Delivery{}
+	}
+}
+
+// assertNoDelivery asserts that nothing is delivered for a short time.
+func assertNoDelivery(t *testing.T, deliveries <-chan amqp.Delivery) {
+	select {
+	case delivery := <-deliveries:
+		t.Errorf("unexpected delivery: %s", delivery.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryConsumerAcknowledgement(t *testing.T) {
+	assert := assert.New(t)
+
+	broker := NewMemoryBroker()
+	assert.NoError(broker.DeclareQueue("events", "events.#"))
+	consumer, err := broker.NewConsumer("events", 2)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("events", consumer.Name())
+	for _, body := range []string{"1", "2", "3"} {
+		broker.Publish("events.foo", amqp.Publishing{Body: []byte(body)})
+	}
+	deliveries := startMemoryConsumer(t, consumer)
+
+	// No more than the prefetch count should be unacknowledged at a time.
+	first := receive(t, deliveries)
+	second := receive(t, deliveries)
+	assertNoDelivery(t, deliveries)
+	assert.Equal("1", string(first.Body))
+	assert.Equal("2", string(second.Body))
+	assert.False(first.Redelivered)
+	assert.Equal(2, broker.Unacked("events"))
+
+	// Acknowledging a delivery should allow the next one to be delivered.
+	assert.NoError(first.Ack(false))
+	assert.Error(first.Ack(false))
+	third := receive(t, deliveries)
+	assert.Equal("3", string(third.Body))
+
+	// Requeued deliveries should be redelivered with the redelivered flag set.
+	assert.NoError(second.Nack(false, true))
+	redelivered := receive(t, deliveries)
+	assert.Equal("2", string(redelivered.Body))
+	assert.True(redelivered.Redelivered)
+
+	// Rejected deliveries should be discarded.
+	assert.NoError(redelivered.Reject(false))
+	assertNoDelivery(t, deliveries)
+
+	// Acknowledging multiple deliveries should acknowledge every earlier delivery.
+	broker.Publish("events.foo", amqp.Publishing{Body: []byte("4")})
+	fourth := receive(t, deliveries)
+	assert.NoError(fourth.Ack(true))
+	assert.Equal(0, broker.Unacked("events"))
+	assert.Equal(0, broker.Ready("events"))
+}
+
+func TestMemoryConsumerClose(t *testing.T) {
+	assert := assert.New(t)
+
+	broker := NewMemoryBroker()
+	assert.NoError(broker.DeclareQueue("events", "events.#"))
+	consumer, err := broker.NewConsumer("events", 0)
+	if !assert.NoError(err) {
+		return
+	}
+	broker.Publish("events.foo", amqp.Publishing{Body: []byte("1")})
+	deliveries := startMemoryConsumer(t, consumer)
+	receive(t, deliveries)
+
+	// Unacknowledged deliveries should be requeued when the consumer is closed.
+	consumer.Cancel()
+	consumer.Close()
+	assert.Equal(0, broker.Unacked("events"))
+	delivery, ok := broker.Get("events")
+	if assert.True(ok) {
+		assert.Equal("1", string(delivery.Body))
+		assert.True(delivery.Redelivered)
+	}
+}
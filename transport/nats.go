@@ -0,0 +1,460 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	pkgerrors "github.com/pkg/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+)
+
+// contentTypeHeader is the NATS message header that contains the content type of the message body.
+const contentTypeHeader = "Content-Type"
+
+// defaultAckWait is the time that the NATS server waits for an acknowledgement if the consumer doesn't specify one.
+const defaultAckWait = 30 * time.Second
+
+// connectNATS establishes a connection to the NATS server. The connection is re-established automatically if it's
+// lost.
+func connectNATS(settings *common.NATSSettings) (*nats.Conn, error) {
+	opts := []nats.Option{nats.Name("event-recorder"), nats.MaxReconnects(-1)}
+	if settings.Credentials != "" {
+		opts = append(opts, nats.UserCredentials(settings.Credentials))
+	}
+	return nats.Connect(settings.URL, opts...)
+}
+
+// natsHeaderCarrier adapts NATS message headers for use by OpenTelemetry propagators. Unlike HTTP headers, NATS
+// headers are case-sensitive, so the header names are used exactly as they're given.
+type natsHeaderCarrier nats.Header
+
+// Get returns the first value of a header.
+func (c natsHeaderCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+// Set sets the value of a header.
+func (c natsHeaderCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+// Keys returns the names of the headers.
+func (c natsHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// NATSPublisher publishes outgoing messages to NATS subjects named after the AMQP routing keys that would otherwise
+// be used. Messages are published to JetStream, so a stream must capture their subjects, and publishing only succeeds
+// once the server has acknowledged that the message was stored. The publisher creates the output stream if it's
+// configured and doesn't exist.
+type NATSPublisher struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+}
+
+// NewNATSPublisher creates a publisher that's connected to the NATS server.
+func NewNATSPublisher(settings *common.NATSSettings) (*NATSPublisher, error) {
+	wrapMsg := "unable to create the NATS publisher"
+
+	conn, err := connectNATS(settings)
+	if err != nil {
+		return nil, pkgerrors.Wrap(err, wrapMsg)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, pkgerrors.Wrap(err, wrapMsg)
+	}
+
+	// Create the output stream if it doesn't exist.
+	if settings.OutputStream != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_, err = js.Stream(ctx, settings.OutputStream)
+		if errors.Is(err, jetstream.ErrStreamNotFound) {
+			_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+				Name:     settings.OutputStream,
+				Subjects: settings.OutputStreamSubjects,
+			})
+		}
+		if err != nil {
+			conn.Close()
+			return nil, pkgerrors.Wrap(err, wrapMsg)
+		}
+	}
+
+	return &NATSPublisher{conn: conn, js: js}, nil
+}
+
+// PublishContextOpts publishes a message to a subject and waits for the server to acknowledge it. The content type
+// and trace context are stored in message headers.
+func (p *NATSPublisher) PublishContextOpts(
+	ctx context.Context,
+	subject string,
+	body []byte,
+	opts *messaging.PublishingOpts,
+) error {
+	msg := nats.NewMsg(subject)
+	msg.Data = body
+	if opts != nil && opts.ContentType != "" {
+		msg.Header.Set(contentTypeHeader, opts.ContentType)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, natsHeaderCarrier(msg.Header))
+	_, err := p.js.PublishMsg(ctx, msg)
+	if err != nil {
+		return pkgerrors.Wrapf(err, "unable to publish a message to %s", subject)
+	}
+	return nil
+}
+
+// PublishEmailRequestContext publishes an email request for the email service.
+func (p *NATSPublisher) PublishEmailRequestContext(ctx context.Context, request *messaging.EmailRequest) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return p.PublishContextOpts(ctx, messaging.EmailRequestPublishingKey, body, messaging.JSONPublishingOpts)
+}
+
+// PublishNotificationMessageContext publishes a notification message to the subject `notification.{user}`.
+func (p *NATSPublisher) PublishNotificationMessageContext(
+	ctx context.Context,
+	msg *messaging.WrappedNotificationMessage,
+) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("notification.%s", msg.Message.User)
+	return p.PublishContextOpts(ctx, subject, body, messaging.JSONPublishingOpts)
+}
+
+// Close flushes any buffered messages and closes the connection to the NATS server.
+func (p *NATSPublisher) Close() {
+	err := p.conn.Flush()
+	if err != nil {
+		log.Errorf("unable to flush the NATS publisher: %s", err.Error())
+	}
+	p.conn.Close()
+}
+
+// FilterSubject converts an AMQP binding key to a NATS subject filter. The AMQP `*` wildcard is the same in NATS,
+// and the AMQP `#` wildcard becomes `>`, which NATS only allows at the end of a subject. Note that `>` matches one
+// or more tokens whereas `#` matches zero or more words.
+func FilterSubject(bindingKey string) (string, error) {
+	tokens := strings.Split(bindingKey, ".")
+	for i, token := range tokens {
+		if token != "#" {
+			continue
+		}
+		if i != len(tokens)-1 {
+			return "", fmt.Errorf("the # wildcard is only supported at the end of a NATS binding key: %s", bindingKey)
+		}
+		tokens[i] = ">"
+	}
+	return strings.Join(tokens, "."), nil
+}
+
+// natsAcknowledger acknowledges deliveries created from JetStream messages. Negatively acknowledged messages that
+// are requeued are redelivered by the server; those that aren't are terminated so that they're never redelivered.
+// Until a message is acknowledged, the server is periodically told that it's still being processed, so that messages
+// waiting for a worker aren't redelivered when the acknowledgement deadline passes.
+type natsAcknowledger struct {
+	msg  jetstream.Msg
+	once sync.Once
+	done chan struct{}
+}
+
+// newNATSAcknowledger returns an acknowledger for a message that reports that the message is still being processed
+// at the given interval until it's acknowledged.
+func newNATSAcknowledger(msg jetstream.Msg, interval time.Duration) *natsAcknowledger {
+	a := &natsAcknowledger{msg: msg, done: make(chan struct{})}
+	go a.keepInProgress(interval)
+	return a
+}
+
+// keepInProgress tells the server that the message is still being processed at the given interval until the
+// message has been acknowledged or the server can't be reached.
+func (a *natsAcknowledger) keepInProgress(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+			err := a.msg.InProgress()
+			if err != nil {
+				log.Errorf("unable to extend the acknowledgement deadline of %s: %s", a.msg.Subject(), err.Error())
+				return
+			}
+		}
+	}
+}
+
+// finish stops reporting that the message is being processed.
+func (a *natsAcknowledger) finish() {
+	a.once.Do(func() { close(a.done) })
+}
+
+// Ack acknowledges the message.
+func (a *natsAcknowledger) Ack(uint64, bool) error {
+	a.finish()
+	return a.msg.Ack()
+}
+
+// Nack negatively acknowledges the message.
+func (a *natsAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	a.finish()
+	if requeue {
+		return a.msg.Nak()
+	}
+	return a.msg.Term()
+}
+
+// Reject negatively acknowledges the message.
+func (a *natsAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// natsDelivery converts a JetStream message to an AMQP delivery. The subject becomes the routing key, and the
+// message headers become delivery headers. The server is told that the message is still being processed every
+// progressInterval until the delivery is acknowledged.
+func natsDelivery(msg jetstream.Msg, progressInterval time.Duration) amqp.Delivery {
+	headers := amqp.Table{}
+	for key, values := range msg.Headers() {
+		if len(values) > 0 {
+			headers[key] = values[0]
+		}
+	}
+	delivery := amqp.Delivery{
+		Acknowledger: newNATSAcknowledger(msg, progressInterval),
+		Headers:      headers,
+		ContentType:  msg.Headers().Get(contentTypeHeader),
+		MessageId:    msg.Headers().Get(nats.MsgIdHdr),
+		RoutingKey:   msg.Subject(),
+		Body:         msg.Data(),
+	}
+	if metadata, err := msg.Metadata(); err == nil {
+		delivery.DeliveryTag = metadata.Sequence.Consumer
+		delivery.Redelivered = metadata.NumDelivered > 1
+		delivery.Timestamp = metadata.Timestamp
+	}
+	return delivery
+}
+
+// NATSConsumer receives incoming events from a durable JetStream consumer, creating the stream if it doesn't exist
+// and creating or updating the consumer.
+type NATSConsumer struct {
+	settings *common.NATSSettings
+	subjects []string
+	prefetch int
+	mutex    sync.Mutex
+	conn     *nats.Conn
+	canceled bool
+	stop     chan struct{}
+}
+
+// NewNATSConsumer returns a consumer that receives events whose subjects match the binding keys. Up to prefetch
+// unacknowledged messages are delivered at a time.
+func NewNATSConsumer(settings *common.NATSSettings, bindingKeys []string, prefetch int) (*NATSConsumer, error) {
+	wrapMsg := "unable to create the NATS consumer"
+
+	// Validate the settings.
+	if settings.Stream == "" {
+		return nil, pkgerrors.Wrap(errors.New("no stream specified"), wrapMsg)
+	}
+	if settings.Consumer == "" {
+		return nil, pkgerrors.Wrap(errors.New("no consumer name specified"), wrapMsg)
+	}
+	if len(bindingKeys) == 0 {
+		return nil, pkgerrors.Wrap(errors.New("no binding keys specified"), wrapMsg)
+	}
+
+	// Convert the binding keys to subject filters.
+	subjects := make([]string, len(bindingKeys))
+	for i, key := range bindingKeys {
+		subject, err := FilterSubject(key)
+		if err != nil {
+			return nil, pkgerrors.Wrap(err, wrapMsg)
+		}
+		subjects[i] = subject
+	}
+
+	return &NATSConsumer{
+		settings: settings,
+		subjects: subjects,
+		prefetch: prefetch,
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Name returns the name of the durable consumer.
+func (c *NATSConsumer) Name() string {
+	return c.settings.Consumer
+}
+
+// connection returns the connection to the NATS server, establishing it if necessary. It returns nil if the
+// consumer has been canceled.
+func (c *NATSConsumer) connection() (*nats.Conn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.canceled {
+		return nil, nil
+	}
+	if c.conn == nil {
+		conn, err := connectNATS(c.settings)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	return c.conn, nil
+}
+
+// isCanceled returns true if the consumer has been canceled.
+func (c *NATSConsumer) isCanceled() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.canceled
+}
+
+// Consume passes incoming deliveries to the handler in the order in which they're received. The NATS client
+// re-establishes lost connections itself; if the consumer can't be set up, another attempt is made after a short
+// delay. This function returns once the consumer has been canceled.
+func (c *NATSConsumer) Consume(handler Handler) {
+	for {
+		err := c.consumeUntilCanceled(handler)
+		if c.isCanceled() {
+			return
+		}
+		log.Errorf("unable to consume from NATS; retrying in %s: %s", reconnectDelay, err.Error())
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// streamSubjects returns the subjects captured by the stream if it has to be created.
+func (c *NATSConsumer) streamSubjects() []string {
+	if len(c.settings.StreamSubjects) > 0 {
+		return c.settings.StreamSubjects
+	}
+	return c.subjects
+}
+
+// setUp creates the stream if it doesn't exist and creates or updates the durable consumer.
+func (c *NATSConsumer) setUp(ctx context.Context, conn *nats.Conn) (jetstream.Consumer, error) {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the stream if it doesn't exist.
+	_, err = js.Stream(ctx, c.settings.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     c.settings.Stream,
+			Subjects: c.streamSubjects(),
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Create or update the consumer.
+	return js.CreateOrUpdateConsumer(ctx, c.settings.Stream, jetstream.ConsumerConfig{
+		Durable:        c.settings.Consumer,
+		FilterSubjects: c.subjects,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		AckWait:        c.settings.AckWait,
+		MaxDeliver:     c.settings.MaxDeliver,
+		MaxAckPending:  c.prefetch,
+	})
+}
+
+// consumeUntilCanceled sets up the durable consumer and passes each message to the handler until the consumer is
+// canceled.
+func (c *NATSConsumer) consumeUntilCanceled(handler Handler) error {
+	wrapMsg := "unable to consume incoming events"
+
+	// Establish the connection.
+	conn, err := c.connection()
+	if err != nil {
+		return pkgerrors.Wrap(err, wrapMsg)
+	}
+	if conn == nil {
+		return nil
+	}
+
+	// Set up the consumer.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	consumer, err := c.setUp(ctx, conn)
+	cancel()
+	if err != nil {
+		return pkgerrors.Wrap(err, wrapMsg)
+	}
+
+	// Consume messages until the consumer is canceled. Messages are passed to the handler one at a time. Deliveries
+	// may wait for a worker for longer than the acknowledgement deadline, so the deadline is extended twice per
+	// period until they're acknowledged.
+	ackWait := c.settings.AckWait
+	if ackWait <= 0 {
+		ackWait = defaultAckWait
+	}
+	consumeCtx, err := consumer.Consume(
+		func(msg jetstream.Msg) {
+			ctx := otel.GetTextMapPropagator().Extract(context.Background(), natsHeaderCarrier(msg.Headers()))
+			handler(ctx, natsDelivery(msg, ackWait/2))
+		},
+		jetstream.PullMaxMessages(max(c.prefetch, 1)),
+		jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+			log.Errorf("error encountered while consuming from NATS: %s", err.Error())
+		}),
+	)
+	if err != nil {
+		return pkgerrors.Wrap(err, wrapMsg)
+	}
+	<-c.stop
+	consumeCtx.Stop()
+	<-consumeCtx.Closed()
+
+	return nil
+}
+
+// Cancel stops receiving new deliveries.
+func (c *NATSConsumer) Cancel() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.canceled {
+		c.canceled = true
+		close(c.stop)
+	}
+}
+
+// Close closes the connection to the NATS server.
+func (c *NATSConsumer) Close() {
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
@@ -0,0 +1,263 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/messaging/v12"
+	"github.com/nats-io/nats-server/v2/server"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// startNATSServer starts an embedded NATS server with JetStream enabled. The server is shut down when the test
+// completes.
+func startNATSServer(t *testing.T) *common.NATSSettings {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("the NATS server didn't start")
+	}
+	t.Cleanup(s.Shutdown)
+
+	return &common.NATSSettings{
+		URL:                  s.ClientURL(),
+		Stream:               "EVENTS",
+		StreamSubjects:       []string{"events.>"},
+		Consumer:             "event_listener",
+		AckWait:              time.Second,
+		OutputStream:         "NOTIFICATIONS",
+		OutputStreamSubjects: []string{"notification.>", messaging.EmailRequestPublishingKey},
+	}
+}
+
+// consumeDeliveries starts consuming from the consumer and returns a channel that receives each delivery. The
+// consumer is canceled when the test completes.
+func consumeDeliveries(t *testing.T, consumer *NATSConsumer) <-chan amqp.Delivery {
+	deliveries := make(chan amqp.Delivery, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Consume(func(_ context.Context, delivery amqp.Delivery) { deliveries <- delivery })
+	}()
+	t.Cleanup(func() {
+		consumer.Cancel()
+		<-done
+		consumer.Close()
+	})
+	return deliveries
+}
+
+// nextDelivery waits for the next delivery.
+func nextDelivery(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	select {
+	case delivery := <-deliveries:
+		return delivery
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+		return amqp.Delivery{}
+	}
+}
+
+func TestFilterSubject(t *testing.T) {
+	assert := assert.New(t)
+
+	for key, expected := range map[string]string{
+		"events.*.update.*": "events.*.update.*",
+		"events.#":          "events.>",
+		"#":                 ">",
+	} {
+		subject, err := FilterSubject(key)
+		if assert.NoError(err, key) {
+			assert.Equal(expected, subject, key)
+		}
+	}
+
+	_, err := FilterSubject("events.#.update")
+	assert.Error(err)
+}
+
+func TestNewNATSConsumerValidation(t *testing.T) {
+	assert := assert.New(t)
+
+	settings := &common.NATSSettings{Stream: "EVENTS", Consumer: "event_listener"}
+	_, err := NewNATSConsumer(settings, nil, 1)
+	assert.Error(err)
+	_, err = NewNATSConsumer(settings, []string{"events.#.update"}, 1)
+	assert.Error(err)
+	_, err = NewNATSConsumer(&common.NATSSettings{Stream: "EVENTS"}, []string{"events.#"}, 1)
+	assert.Error(err)
+	_, err = NewNATSConsumer(&common.NATSSettings{Consumer: "event_listener"}, []string{"events.#"}, 1)
+	assert.Error(err)
+}
+
+func TestNATSRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	settings := startNATSServer(t)
+
+	consumer, err := NewNATSConsumer(settings, []string{"events.*.update.*"}, 10)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("event_listener", consumer.Name())
+	deliveries := consumeDeliveries(t, consumer)
+
+	publisher, err := NewNATSPublisher(settings)
+	if !assert.NoError(err) {
+		return
+	}
+	defer publisher.Close()
+
+	// Wait for the stream to be created before publishing; messages can't be published to JetStream otherwise.
+	var delivery amqp.Delivery
+	assert.Eventually(func() bool {
+		err := publisher.PublishContextOpts(
+			context.Background(), "events.notification.update.ipcdev", []byte(`{"user": "ipcdev"}`),
+			messaging.JSONPublishingOpts,
+		)
+		if err != nil {
+			return false
+		}
+		select {
+		case delivery = <-deliveries:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// The message should be converted to an AMQP delivery.
+	assert.Equal("events.notification.update.ipcdev", delivery.RoutingKey)
+	assert.Equal("application/json", delivery.ContentType)
+	assert.Equal(`{"user": "ipcdev"}`, string(delivery.Body))
+	assert.False(delivery.Redelivered)
+	assert.NoError(delivery.Ack(false))
+
+	// Messages whose subjects don't match the binding keys should be ignored.
+	err = publisher.PublishContextOpts(context.Background(), "events.notification.other", []byte(`{}`), nil)
+	assert.NoError(err)
+	err = publisher.PublishNotificationMessageContext(context.Background(), &messaging.WrappedNotificationMessage{
+		Message: &messaging.NotificationMessage{User: "ipcdev"},
+	})
+	assert.NoError(err)
+	err = publisher.PublishContextOpts(context.Background(), "events.notification.update.sarahr", []byte(`{}`), nil)
+	assert.NoError(err)
+	delivery = nextDelivery(t, deliveries)
+	assert.Equal("events.notification.update.sarahr", delivery.RoutingKey)
+	assert.NoError(delivery.Ack(false))
+}
+
+func TestNATSNegativeAcknowledgement(t *testing.T) {
+	assert := assert.New(t)
+	settings := startNATSServer(t)
+
+	// Create the stream and consumer before publishing anything.
+	consumer, err := NewNATSConsumer(settings, []string{"events.#"}, 10)
+	if !assert.NoError(err) {
+		return
+	}
+	deliveries := consumeDeliveries(t, consumer)
+	publisher, err := NewNATSPublisher(settings)
+	if !assert.NoError(err) {
+		return
+	}
+	defer publisher.Close()
+	var delivery amqp.Delivery
+	assert.Eventually(func() bool {
+		err := publisher.PublishContextOpts(context.Background(), "events.requeue", []byte(`{}`), nil)
+		if err != nil {
+			return false
+		}
+		select {
+		case delivery = <-deliveries:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// A requeued message should be redelivered with the redelivered flag set.
+	assert.NoError(delivery.Nack(false, true))
+	delivery = nextDelivery(t, deliveries)
+	assert.Equal("events.requeue", delivery.RoutingKey)
+	assert.True(delivery.Redelivered)
+
+	// A rejected message should never be redelivered.
+	assert.NoError(delivery.Reject(false))
+	err = publisher.PublishContextOpts(context.Background(), "events.next", []byte(`{}`), nil)
+	assert.NoError(err)
+	delivery = nextDelivery(t, deliveries)
+	assert.Equal("events.next", delivery.RoutingKey)
+	assert.NoError(delivery.Ack(false))
+	select {
+	case delivery := <-deliveries:
+		t.Errorf("unexpected delivery: %s", delivery.RoutingKey)
+	case <-time.After(2 * settings.AckWait):
+	}
+}
+
+func TestNATSPublisherStreams(t *testing.T) {
+	assert := assert.New(t)
+	settings := startNATSServer(t)
+
+	publisher, err := NewNATSPublisher(settings)
+	if !assert.NoError(err) {
+		return
+	}
+	defer publisher.Close()
+
+	// The output stream should have been created.
+	err = publisher.PublishNotificationMessageContext(context.Background(), &messaging.WrappedNotificationMessage{
+		Message: &messaging.NotificationMessage{User: "ipcdev"},
+	})
+	assert.NoError(err)
+	err = publisher.PublishEmailRequestContext(context.Background(), &messaging.EmailRequest{ToAddress: "a@b.org"})
+	assert.NoError(err)
+
+	// Messages that no stream captures can't be acknowledged, so publishing them should fail.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Error(publisher.PublishContextOpts(ctx, "events.notification.update.ipcdev", []byte(`{}`), nil))
+}
+
+func TestNATSInProgress(t *testing.T) {
+	assert := assert.New(t)
+	settings := startNATSServer(t)
+
+	consumer, err := NewNATSConsumer(settings, []string{"events.#"}, 10)
+	if !assert.NoError(err) {
+		return
+	}
+	deliveries := consumeDeliveries(t, consumer)
+	publisher, err := NewNATSPublisher(settings)
+	if !assert.NoError(err) {
+		return
+	}
+	defer publisher.Close()
+	var delivery amqp.Delivery
+	assert.Eventually(func() bool {
+		err := publisher.PublishContextOpts(context.Background(), "events.slow", []byte(`{}`), nil)
+		if err != nil {
+			return false
+		}
+		select {
+		case delivery = <-deliveries:
+			return true
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+
+	// A message that's still being processed shouldn't be redelivered after the acknowledgement deadline.
+	select {
+	case redelivered := <-deliveries:
+		t.Errorf("unexpected redelivery: %s", redelivered.RoutingKey)
+	case <-time.After(3 * settings.AckWait):
+	}
+	assert.NoError(delivery.Ack(false))
+}
@@ -0,0 +1,48 @@
+// Package transport provides the message broker transports used to consume incoming events and to publish outgoing
+// messages. Both AMQP (RabbitMQ) and NATS JetStream are supported, and an in-process broker is provided for tests.
+// Incoming messages are represented as AMQP deliveries regardless of the transport, so message handlers don't need to
+// know which transport is in use; routing keys and NATS subjects share the same dot-separated structure.
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/cyverse-de/event-recorder/logging"
+	"github.com/cyverse-de/messaging/v12"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+var log = logging.Log.WithFields(logrus.Fields{"package": "transport"})
+
+// reconnectDelay is the amount of time to wait before attempting to re-establish a lost connection.
+const reconnectDelay = 5 * time.Second
+
+// Publisher publishes outgoing messages. It's the subset of messaging.Client used by this service, so the AMQP
+// transport uses messaging.Client directly.
+type Publisher interface {
+	PublishContextOpts(context.Context, string, []byte, *messaging.PublishingOpts) error
+	PublishEmailRequestContext(context.Context, *messaging.EmailRequest) error
+	PublishNotificationMessageContext(context.Context, *messaging.WrappedNotificationMessage) error
+	Close()
+}
+
+// Handler processes a single incoming delivery. The context carries any trace context propagated with the message.
+type Handler func(context.Context, amqp.Delivery)
+
+// Consumer receives incoming events.
+type Consumer interface {
+	// Name identifies the queue or durable consumer that events are received from.
+	Name() string
+
+	// Consume passes each incoming delivery to the handler in the order in which the deliveries are received. Lost
+	// connections are re-established automatically. Consume returns once Cancel has been called.
+	Consume(handler Handler)
+
+	// Cancel stops receiving new deliveries. Deliveries that have already been received can still be acknowledged.
+	Cancel()
+
+	// Close closes the connection to the broker.
+	Close()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cyverse-de/event-recorder/catalog"
+	"github.com/cyverse-de/event-recorder/common"
+	"github.com/cyverse-de/event-recorder/db"
+	"gopkg.in/yaml.v3"
+)
+
+// typesUsage describes the notification type administration subcommands.
+const typesUsage = `usage:
+  event-recorder [--config PATH] types list
+  event-recorder [--config PATH] types get NAME
+  event-recorder [--config PATH] types put FILE
+  event-recorder [--config PATH] types delete NAME
+`
+
+// runCommand runs the administrative command described by the arguments that remain after the command-line options
+// have been parsed.
+func runCommand(ctx context.Context, database *sql.DB, args []string, out io.Writer) error {
+	switch args[0] {
+	case "types":
+		return runTypesCommand(ctx, database, args[1:], out)
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// runTypesCommand runs a notification type administration subcommand. Notification types are written to the output
+// as JSON. The file passed to `types put` may contain either JSON or YAML.
+func runTypesCommand(ctx context.Context, database *sql.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no subcommand specified\n\n%s", typesUsage)
+	}
+
+	// Validate the number of arguments.
+	subcommand := args[0]
+	expectedArgs := 2
+	if subcommand == "list" {
+		expectedArgs = 1
+	}
+	if len(args) != expectedArgs {
+		return fmt.Errorf("wrong number of arguments for types %s\n\n%s", subcommand, typesUsage)
+	}
+
+	switch subcommand {
+	case "list":
+		return withCommandTx(ctx, database, true, func(tx *sql.Tx) error {
+			types, err := db.ListNotificationTypes(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return writeCommandOutput(out, types)
+		})
+
+	case "get":
+		name := strings.ToLower(args[1])
+		return withCommandTx(ctx, database, true, func(tx *sql.Tx) error {
+			t, err := db.GetNotificationType(ctx, tx, name)
+			if err != nil {
+				return err
+			}
+			if t == nil {
+				return fmt.Errorf("notification type %s not found", name)
+			}
+			return writeCommandOutput(out, t)
+		})
+
+	case "put":
+		t, err := readNotificationType(args[1])
+		if err != nil {
+			return err
+		}
+		return withCommandTx(ctx, database, false, func(tx *sql.Tx) error {
+			err := db.SaveNotificationType(ctx, tx, t)
+			if err != nil {
+				return err
+			}
+			return writeCommandOutput(out, t)
+		})
+
+	case "delete":
+		name := strings.ToLower(args[1])
+		return withCommandTx(ctx, database, false, func(tx *sql.Tx) error {
+			deleted, err := db.DeleteNotificationType(ctx, tx, name)
+			if err != nil {
+				return err
+			}
+			if !deleted {
+				return fmt.Errorf("notification type %s doesn't exist or is still in use", name)
+			}
+			return nil
+		})
+
+	default:
+		return fmt.Errorf("unknown subcommand: types %s\n\n%s", subcommand, typesUsage)
+	}
+}
+
+// readNotificationType reads and validates a notification type definition from a JSON or YAML file.
+func readNotificationType(path string) (*common.NotificationType, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is a subset of YAML, so a single parser handles both formats.
+	var t common.NotificationType
+	err = yaml.Unmarshal(data, &t)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+	err = catalog.Validate(&t)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	return &t, nil
+}
+
+// withCommandTx calls a function within a database transaction. The transaction is committed if the function
+// succeeds and rolled back otherwise.
+func withCommandTx(ctx context.Context, database *sql.DB, readOnly bool, f func(*sql.Tx) error) error {
+	tx, err := database.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	err = f(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// writeCommandOutput writes the result of a command to the output as indented JSON.
+func writeCommandOutput(out io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// notificationTypeColumns lists the columns returned by notification type queries.
+var notificationTypeColumns = []string{
+	"name", "display_name", "description", "default_channels", "default_severity", "retention_days", "owner",
+	"time_updated",
+}
+
+// writeTypeFile writes a notification type definition to a temporary file and returns its path.
+func writeTypeFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "type.yaml")
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("unable to write the notification type file: %s", err.Error())
+	}
+	return path
+}
+
+func TestTypesCommandArguments(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// A subcommand is required.
+	err := runTypesCommand(ctx, nil, []string{}, &bytes.Buffer{})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "no subcommand specified")
+	}
+
+	// Unknown subcommands should be rejected.
+	err = runTypesCommand(ctx, nil, []string{"rename", "analysis"}, &bytes.Buffer{})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "unknown subcommand: types rename")
+	}
+
+	// Each subcommand accepts a fixed number of arguments.
+	err = runTypesCommand(ctx, nil, []string{"list", "analysis"}, &bytes.Buffer{})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "wrong number of arguments for types list")
+	}
+	err = runTypesCommand(ctx, nil, []string{"get"}, &bytes.Buffer{})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "wrong number of arguments for types get")
+	}
+}
+
+func TestTypesList(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notification_types ORDER BY name").
+		WillReturnRows(
+			sqlmock.NewRows(notificationTypeColumns).
+				AddRow("analysis", "Analyses", "", "{ui,email}", "info", 30, "", updated).
+				AddRow("data", "", "", "{}", "", 0, "", nil),
+		)
+	mock.ExpectCommit()
+
+	// Run the command.
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"list"}, &out)
+	assert.NoError(err)
+	assert.Contains(out.String(), `"name": "analysis"`)
+	assert.Contains(out.String(), `"name": "data"`)
+	assert.Contains(out.String(), `"email"`)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTypesListFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notification_types").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	// Run the command.
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"list"}, &out)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "unable to list the notification types")
+	}
+	assert.Empty(out.String())
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTypesGet(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notification_types WHERE name = \\$1").
+		WithArgs("analysis").
+		WillReturnRows(
+			sqlmock.NewRows(notificationTypeColumns).
+				AddRow("analysis", "Analyses", "", "{ui}", "info", 30, "", updated),
+		)
+	mock.ExpectCommit()
+
+	// Run the command. The type name should be normalized.
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"get", "Analysis"}, &out)
+	assert.NoError(err)
+	assert.Contains(out.String(), `"name": "analysis"`)
+	assert.Contains(out.String(), `"display_name": "Analyses"`)
+	assert.Contains(out.String(), `"retention_days": 30`)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTypesGetMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM notification_types").
+		WithArgs("analysis").
+		WillReturnRows(sqlmock.NewRows(notificationTypeColumns))
+	mock.ExpectRollback()
+
+	// Run the command.
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"get", "analysis"}, &out)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "notification type analysis not found")
+	}
+	assert.Empty(out.String())
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTypesPut(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	updated := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO notification_types").
+		WithArgs("analysis", "", "", sqlmock.AnyArg(), "", 30, "").
+		WillReturnRows(sqlmock.NewRows([]string{"time_updated"}).AddRow(updated))
+	mock.ExpectCommit()
+
+	// Run the command.
+	path := writeTypeFile(t, "name: Analysis\ndefault_channels: [UI, email]\nretention_days: 30\n")
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"put", path}, &out)
+	assert.NoError(err)
+	assert.Contains(out.String(), `"name": "analysis"`)
+	assert.Contains(out.String(), `"time_updated": "2026-10-18T12:00:00Z"`)
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTypesPutInvalidFile(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// Files that don't exist should be reported.
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+	err := runTypesCommand(ctx, nil, []string{"put", path}, &bytes.Buffer{})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "no such file or directory")
+	}
+
+	// Invalid notification types should be rejected before the database is used.
+	path = writeTypeFile(t, "name: analysis\ndefault_channels: [sms]\n")
+	err = runTypesCommand(ctx, nil, []string{"put", path}, &bytes.Buffer{})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "unsupported channel: sms")
+	}
+}
+
+func TestTypesPutFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO notification_types").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	// Run the command.
+	path := writeTypeFile(t, "name: analysis\n")
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"put", path}, &out)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "unable to save the notification type, `analysis`")
+	}
+	assert.Empty(out.String())
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTypesDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM notification_types").
+		WithArgs("analysis").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Run the command.
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"delete", "Analysis"}, &out)
+	assert.NoError(err)
+	assert.Empty(out.String())
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestTypesDeleteInUse(t *testing.T) {
+	assert := assert.New(t)
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(err, "unable to open the mock database connection")
+	defer func() { _ = db.Close() }()
+
+	// Set up the expectations.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM notification_types").
+		WithArgs("analysis").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Run the command.
+	var out bytes.Buffer
+	err = runTypesCommand(context.Background(), db, []string{"delete", "analysis"}, &out)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "notification type analysis doesn't exist or is still in use")
+	}
+	assert.NoError(mock.ExpectationsWereMet())
+}
+
+func TestRunCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	err := runCommand(context.Background(), nil, []string{"users"}, &bytes.Buffer{})
+	if assert.Error(err) {
+		assert.Equal("unknown command: users", err.Error())
+	}
+}
@@ -0,0 +1,131 @@
+// Package unsubscribe issues and verifies the signed tokens used in one-click unsubscribe links. A token identifies a
+// user and the notification type whose email the user wants to stop receiving, and expires after a fixed amount of
+// time. Tokens are signed using HMAC-SHA256, so they can't be forged or altered without the signing key, but they
+// aren't encrypted.
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The template values added to email requests. The links unsubscribe the recipient from email for the notification
+// type or for all notification types, respectively.
+const (
+	TokenKey    = "unsubscribe_token"
+	URLKey      = "unsubscribe_url"
+	AllTokenKey = "unsubscribe_all_token"
+	AllURLKey   = "unsubscribe_all_url"
+)
+
+// MinKeyLength is the minimum length of a signing key in bytes.
+const MinKeyLength = 32
+
+// ErrInvalidToken is returned when a token is malformed or its signature is incorrect.
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// ErrExpiredToken is returned when a token has expired.
+var ErrExpiredToken = errors.New("expired unsubscribe token")
+
+// Claims describes the request represented by an unsubscribe token.
+type Claims struct {
+	User             string `json:"u"`
+	NotificationType string `json:"t"`
+	ExpiresAt        int64  `json:"e"`
+}
+
+// Settings contains the settings used to issue unsubscribe links. The base URL is the address of the unsubscribe
+// endpoint as seen by the recipients of email messages.
+type Settings struct {
+	Key     []byte
+	TTL     time.Duration
+	BaseURL string
+}
+
+// Signer issues and verifies unsubscribe tokens.
+type Signer struct {
+	key     []byte
+	ttl     time.Duration
+	baseURL *url.URL
+}
+
+// NewSigner returns a new signer with the given settings.
+func NewSigner(settings *Settings) (*Signer, error) {
+	wrapMsg := "invalid unsubscribe settings"
+
+	if len(settings.Key) < MinKeyLength {
+		return nil, fmt.Errorf("%s: the signing key must be at least %d bytes long", wrapMsg, MinKeyLength)
+	}
+	if settings.TTL <= 0 {
+		return nil, fmt.Errorf("%s: the token lifetime must be positive", wrapMsg)
+	}
+	baseURL, err := url.Parse(settings.BaseURL)
+	if err != nil {
+		return nil, errors.Wrap(err, wrapMsg)
+	}
+	if !baseURL.IsAbs() {
+		return nil, fmt.Errorf("%s: the base URL must be absolute", wrapMsg)
+	}
+
+	return &Signer{key: settings.Key, ttl: settings.TTL, baseURL: baseURL}, nil
+}
+
+// sign returns the encoded signature of an encoded payload.
+func (s *Signer) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Token issues a token that unsubscribes a user from email for a notification type.
+func (s *Signer) Token(user, notificationType string, now time.Time) string {
+	claims := &Claims{User: user, NotificationType: notificationType, ExpiresAt: now.Add(s.ttl).Unix()}
+
+	// Encoding the claims can't fail because they contain only strings and integers.
+	encoded, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(encoded)
+	return payload + "." + s.sign(payload)
+}
+
+// Link returns an unsubscribe link containing a token.
+func (s *Signer) Link(token string) string {
+	link := *s.baseURL
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// Verify verifies a token and returns its claims.
+func (s *Signer) Verify(token string, now time.Time) (*Claims, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return nil, ErrInvalidToken
+	}
+
+	// Decode the claims.
+	encoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	err = json.Unmarshal(encoded, &claims)
+	if err != nil || claims.User == "" || claims.NotificationType == "" {
+		return nil, ErrInvalidToken
+	}
+
+	// Check the expiration time.
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
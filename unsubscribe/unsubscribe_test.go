@@ -0,0 +1,83 @@
+package unsubscribe
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSigner returns a signer with a fixed key.
+func newTestSigner(t *testing.T) *Signer {
+	signer, err := NewSigner(&Settings{
+		Key:     []byte(strings.Repeat("k", MinKeyLength)),
+		TTL:     24 * time.Hour,
+		BaseURL: "https://de.example.org/notifications/unsubscribe?source=email",
+	})
+	require.NoError(t, err)
+	return signer
+}
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	signer := newTestSigner(t)
+	now := time.Now()
+	token := signer.Token("ipcdev", "analysis", now)
+
+	// Valid tokens should be accepted until they expire.
+	claims, err := signer.Verify(token, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal("ipcdev", claims.User)
+	assert.Equal("analysis", claims.NotificationType)
+	_, err = signer.Verify(token, now.Add(25*time.Hour))
+	assert.Equal(ErrExpiredToken, err)
+
+	// Altered tokens should be rejected.
+	payload, signature, _ := strings.Cut(token, ".")
+	forged := newTestSigner(t)
+	forged.key = []byte(strings.Repeat("x", MinKeyLength))
+	for _, invalid := range []string{
+		"",
+		payload,
+		payload + "." + signature + "x",
+		strings.ToUpper(payload) + "." + signature,
+		forged.Token("ipcdev", "analysis", now),
+	} {
+		_, err = signer.Verify(invalid, now)
+		assert.Equal(ErrInvalidToken, err, invalid)
+	}
+}
+
+func TestLink(t *testing.T) {
+	assert := assert.New(t)
+
+	signer := newTestSigner(t)
+	token := signer.Token("ipcdev", "*", time.Now())
+	link, err := url.Parse(signer.Link(token))
+	require.NoError(t, err)
+	assert.Equal("de.example.org", link.Host)
+	assert.Equal("/notifications/unsubscribe", link.Path)
+	assert.Equal("email", link.Query().Get("source"))
+	assert.Equal(token, link.Query().Get("token"))
+}
+
+func TestNewSignerInvalid(t *testing.T) {
+	valid := Settings{Key: []byte(strings.Repeat("k", MinKeyLength)), TTL: time.Hour, BaseURL: "https://example.org"}
+	tests := map[string]func(*Settings){
+		"short key":    func(s *Settings) { s.Key = []byte("short") },
+		"zero TTL":     func(s *Settings) { s.TTL = 0 },
+		"relative URL": func(s *Settings) { s.BaseURL = "/unsubscribe" },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			settings := valid
+			modify(&settings)
+			_, err := NewSigner(&settings)
+			assert.Error(t, err)
+		})
+	}
+}